@@ -0,0 +1,474 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Beacons come from APRS servers listed in APRS_SERVERS, in order of
+// preference: typically the public OGN network first and the club's own
+// aprsc next to its ground station as a backup. The feed connects to the
+// first one that answers; while it runs off a backup it keeps probing the
+// preferred ones and switches back as soon as one is reachable again.
+
+const (
+	defaultAPRSServer   = "aprs.glidernet.org"
+	defaultAPRSPort     = 14580 // APRS-IS filtered port
+	defaultAPRSCallsign = "N0CALL"
+	// aprsPasscodeReadOnly logs in receive-only, which is all the bot needs.
+	aprsPasscodeReadOnly = "-1"
+	aprsDialTimeout      = 10 * time.Second
+	// aprsKeepAlive — APRS-IS drops idle clients; servers send their own
+	// "# aprsc" line every 20 s, so a read timeout means a dead link.
+	aprsKeepAlive = 240 * time.Second
+	// aprsPreferredRetry is how often a feed on a backup source probes the
+	// sources before it.
+	aprsPreferredRetry = 5 * time.Minute
+	aprsAppName        = "telegram-ogn-tracker"
+	aprsAppVersion     = "1.0"
+)
+
+// aprsSource is one APRS server: the public network or a local aprsc.
+type aprsSource struct {
+	Host string
+	Port int
+}
+
+func (s aprsSource) addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// aprsConfig is where beacons come from, read from the environment.
+type aprsConfig struct {
+	sources  []aprsSource
+	callsign string
+	passcode string
+}
+
+// parseAPRSServers parses APRS_SERVERS: comma-separated host[:port], in
+// order of preference.
+func parseAPRSServers(v string) ([]aprsSource, error) {
+	var sources []aprsSource
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		src := aprsSource{Host: part, Port: defaultAPRSPort}
+		if host, port, err := net.SplitHostPort(part); err == nil {
+			p, err := strconv.Atoi(port)
+			if err != nil || p <= 0 || p > 65535 {
+				return nil, fmt.Errorf("bad port in %q", part)
+			}
+			src = aprsSource{Host: host, Port: p}
+		}
+		if src.Host == "" {
+			return nil, fmt.Errorf("no host in %q", part)
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+func aprsConfigFromEnv() aprsConfig {
+	c := aprsConfig{
+		callsign: os.Getenv("APRS_CALLSIGN"),
+		passcode: os.Getenv("APRS_PASSCODE"),
+	}
+	if v := os.Getenv("APRS_SERVERS"); v != "" {
+		sources, err := parseAPRSServers(v)
+		if err != nil {
+			slog.Error("invalid APRS_SERVERS, using default", "value", v, "err", err, "default", defaultAPRSServer)
+		}
+		c.sources = sources
+	}
+	return c.withDefaults()
+}
+
+// withDefaults fills in the public OGN server and a receive-only login.
+func (c aprsConfig) withDefaults() aprsConfig {
+	if len(c.sources) == 0 {
+		c.sources = []aprsSource{{Host: defaultAPRSServer, Port: defaultAPRSPort}}
+	}
+	if c.callsign == "" {
+		c.callsign = defaultAPRSCallsign
+	}
+	if c.passcode == "" {
+		c.passcode = aprsPasscodeReadOnly
+	}
+	return c
+}
+
+// aprsStatus is the state of the APRS link for /aprs. It outlives the feeds:
+// updateFilter replaces the feed on every filter change.
+type aprsStatus struct {
+	mu          sync.Mutex
+	live        bool
+	source      string // address of the live (or last live) source
+	sourceIdx   int
+	server      string // server name from the login response
+	connectedAt time.Time
+	lastLine    time.Time
+	lastErr     string
+	lastErrAt   time.Time
+}
+
+// aprsStatusSnapshot is a copy of aprsStatus without the mutex.
+type aprsStatusSnapshot struct {
+	live        bool
+	source      string
+	sourceIdx   int
+	server      string
+	connectedAt time.Time
+	lastLine    time.Time
+	lastErr     string
+	lastErrAt   time.Time
+}
+
+func (st *aprsStatus) snapshot() aprsStatusSnapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return aprsStatusSnapshot{st.live, st.source, st.sourceIdx, st.server, st.connectedAt, st.lastLine, st.lastErr, st.lastErrAt}
+}
+
+// connected records a new link and reports whether the group should hear
+// about it: the source changed, or the very first link is a backup.
+func (st *aprsStatus) connected(src aprsSource, idx int) (changed bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	changed = st.source != src.addr() && (st.source != "" || idx > 0)
+	st.live, st.source, st.sourceIdx, st.server = true, src.addr(), idx, ""
+	st.connectedAt, st.lastLine = time.Now(), time.Time{}
+	return changed
+}
+
+func (st *aprsStatus) failed(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastErr, st.lastErrAt = err.Error(), time.Now()
+}
+
+func (st *aprsStatus) disconnected() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.live = false
+}
+
+// line notes traffic on the link; server is set from "# logresp".
+func (st *aprsStatus) line(server string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastLine = time.Now()
+	if server != "" {
+		st.server = server
+	}
+}
+
+// logrespServer extracts the server name from a login response such as
+// "# logresp N0CALL unverified, server GLIDERN3".
+func logrespServer(line string) string {
+	if !strings.HasPrefix(line, "# logresp ") {
+		return ""
+	}
+	_, server, ok := strings.Cut(line, "server ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(server)
+}
+
+// aprsFeed is one APRS-IS connection with failover across the configured
+// sources. Like the session's stop channel it is single-use: Disconnect
+// kills it for good, and a new one is made for every (re)start.
+type aprsFeed struct {
+	Filter string
+	cfg    aprsConfig
+	status *aprsStatus
+	// onSwitch is called (without locks) when the feed connects to a
+	// different source than the previous link, or starts on a backup.
+	onSwitch func(src aprsSource, idx int)
+
+	mu     sync.Mutex
+	conn   net.Conn
+	killed bool
+}
+
+// newAPRSFeed makes a feed for filter from the tracker's configuration.
+func (t *Tracker) newAPRSFeed(filter string) *aprsFeed {
+	return &aprsFeed{
+		Filter:   filter,
+		cfg:      t.aprsCfg.withDefaults(),
+		status:   &t.aprsStatus,
+		onSwitch: t.announceAPRSSource,
+	}
+}
+
+func (f *aprsFeed) isKilled() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.killed
+}
+
+// login is the APRS-IS login line.
+func (f *aprsFeed) login() string {
+	line := fmt.Sprintf("user %s pass %s vers %s %s", f.cfg.callsign, f.cfg.passcode, aprsAppName, aprsAppVersion)
+	if f.Filter != "" {
+		line += " filter " + f.Filter
+	}
+	return line + "\n"
+}
+
+// connect logs in to the first reachable source, starting from the most
+// preferred. Sources that fail are recorded in the status.
+func (f *aprsFeed) connect() (net.Conn, int, error) {
+	var errs []error
+	for i, src := range f.cfg.sources {
+		if f.isKilled() {
+			return nil, 0, nil
+		}
+		conn, err := net.DialTimeout("tcp", src.addr(), aprsDialTimeout)
+		if err == nil {
+			_ = conn.SetWriteDeadline(time.Now().Add(aprsDialTimeout))
+			if _, err = conn.Write([]byte(f.login())); err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			slog.Warn("aprs source unavailable", "source", src.addr(), "err", err)
+			f.status.failed(err)
+			errs = append(errs, err)
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Time{})
+		f.mu.Lock()
+		if f.killed {
+			f.mu.Unlock()
+			conn.Close()
+			return nil, 0, nil
+		}
+		f.conn = conn
+		f.mu.Unlock()
+		return conn, i, nil
+	}
+	return nil, 0, errors.Join(errs...)
+}
+
+// probePreferred reports whether any source before idx accepts connections.
+func (f *aprsFeed) probePreferred(idx int) bool {
+	for _, src := range f.cfg.sources[:idx] {
+		if conn, err := net.DialTimeout("tcp", src.addr(), aprsDialTimeout); err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// Run connects and feeds every received line to callback until the link
+// fails (error), or Disconnect is called or a preferred source comes back
+// (nil). The caller reconnects by calling Run again.
+func (f *aprsFeed) Run(callback func(string)) error {
+	if f.isKilled() {
+		return nil
+	}
+	conn, idx, err := f.connect()
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		return nil
+	}
+	src := f.cfg.sources[idx]
+	slog.Info("aprs connected", "source", src.addr(), "backup", idx > 0, "filter", f.Filter)
+	if f.status.connected(src, idx) && f.onSwitch != nil {
+		f.onSwitch(src, idx)
+	}
+	defer func() {
+		f.status.disconnected()
+		f.mu.Lock()
+		if f.conn == conn {
+			f.conn = nil
+		}
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	errCh := make(chan error, 1)
+	lineCh := make(chan string, 64)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(aprsKeepAlive + 30*time.Second))
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			select {
+			case lineCh <- line:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(aprsKeepAlive)
+	defer keepAlive.Stop()
+	var probe <-chan time.Time
+	if idx > 0 {
+		ticker := time.NewTicker(aprsPreferredRetry)
+		defer ticker.Stop()
+		probe = ticker.C
+	}
+	probeCh := make(chan bool, 1)
+	// probing skips ticks while a probe is still dialling: with several dead
+	// preferred sources one probe can outlast aprsPreferredRetry.
+	probing := false
+	for {
+		select {
+		case line := <-lineCh:
+			f.status.line(logrespServer(line))
+			callback(line)
+		case <-keepAlive.C:
+			if _, err := conn.Write([]byte("#keepalive\n")); err != nil {
+				err = fmt.Errorf("%s: keepalive: %w", src.addr(), err)
+				f.status.failed(err)
+				return err
+			}
+		case <-probe:
+			if probing {
+				continue
+			}
+			probing = true
+			go func() { probeCh <- f.probePreferred(idx) }()
+		case ok := <-probeCh:
+			probing = false
+			if ok {
+				slog.Info("aprs preferred source reachable again, switching", "from", src.addr())
+				return nil
+			}
+		case err := <-errCh:
+			if f.isKilled() {
+				return nil
+			}
+			err = fmt.Errorf("%s: %w", src.addr(), err)
+			f.status.failed(err)
+			return err
+		}
+	}
+}
+
+// Disconnect stops the feed for good.
+func (f *aprsFeed) Disconnect() error {
+	f.mu.Lock()
+	f.killed = true
+	conn := f.conn
+	f.conn = nil
+	f.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// announceAPRSSource tells the group the feed moved to another source or
+// started on a backup, so the club knows when it runs off its own station.
+func (t *Tracker) announceAPRSSource(src aprsSource, idx int) {
+	b := t.bot
+	if b == nil {
+		return
+	}
+	t.mu.Lock()
+	s := t.session
+	if s == nil || (!s.TrackingOn && !s.RadarOn) {
+		t.mu.Unlock()
+		return
+	}
+	chatID := s.ChatID
+	t.mu.Unlock()
+
+	text := fmt.Sprintf("📡 APRS: основной источник снова доступен, работаю от %s.", src.addr())
+	if idx > 0 {
+		text = fmt.Sprintf("📡 APRS: основной источник недоступен, работаю от резервного %s.", src.addr())
+	}
+	if _, err := b.SendMessage(context.Background(), &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	}); err != nil {
+		slog.Error("failed to announce aprs source", "err", err)
+	}
+}
+
+// formatAPRSStatus renders the /aprs reply: the configured sources with the
+// live one marked.
+func formatAPRSStatus(cfg aprsConfig, st aprsStatusSnapshot, now time.Time) string {
+	var sb strings.Builder
+	sb.WriteString("📡 Источники APRS (по приоритету):")
+	for i, src := range cfg.sources {
+		mark := "▫️"
+		if st.live && st.source == src.addr() {
+			mark = "✅"
+		}
+		fmt.Fprintf(&sb, "\n%s %s", mark, src.addr())
+		if i > 0 {
+			sb.WriteString(" (резерв)")
+		}
+		if !st.live || st.source != src.addr() {
+			continue
+		}
+		fmt.Fprintf(&sb, " — подключён %s", formatAge(now.Sub(st.connectedAt)))
+		if st.server != "" {
+			fmt.Fprintf(&sb, ", сервер %s", st.server)
+		}
+		if !st.lastLine.IsZero() {
+			fmt.Fprintf(&sb, ", данные %s назад", formatAge(now.Sub(st.lastLine)))
+		}
+	}
+	if !st.live {
+		sb.WriteString("\n❌ Сейчас нет соединения (трекинг и радар выключены или все источники недоступны).")
+	}
+	if st.lastErr != "" {
+		fmt.Fprintf(&sb, "\n⚠️ Последняя ошибка (%s назад): %s", formatAge(now.Sub(st.lastErrAt)), st.lastErr)
+	}
+	login := cfg.callsign
+	if cfg.passcode == aprsPasscodeReadOnly {
+		login += " (только приём)"
+	}
+	fmt.Fprintf(&sb, "\nПозывной: %s", login)
+	return sb.String()
+}
+
+// cmdAPRS is the service command that shows which APRS source is live.
+func (t *Tracker) cmdAPRS(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if isGroupChat(m.Chat) && !t.isAllowedChat(m.Chat.ID) {
+		return
+	}
+	text := formatAPRSStatus(t.aprsCfg.withDefaults(), t.aprsStatus.snapshot(), time.Now())
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   text,
+	}); err != nil {
+		slog.Error("failed to send aprs status", "err", err)
+	}
+}
@@ -3,9 +3,7 @@ package tracker
 import (
 	"context"
 	"fmt"
-	"log"
 	"log/slog"
-	"sort"
 	"strings"
 	"time"
 
@@ -130,7 +128,17 @@ func (t *Tracker) refreshDashboard(ctx context.Context, chatID int64) int {
 		c := *s.TrackArea
 		areaCopy = &c
 	}
+	// The task is replaced wholesale by /task and never mutated in place, so
+	// sharing the pointer is safe. Race progress is appended to by runClient
+	// and needs a real copy.
+	race := make(map[string]*RaceProgress, len(s.Race))
+	for id, p := range s.Race {
+		cp := *p
+		cp.Tagged = append([]time.Time(nil), p.Tagged...)
+		race[id] = &cp
+	}
 	devices := t.devices
+	places := t.places
 	tz := s.tz()
 	// Snapshot every primitive used by the renderer; rebuild a session shell
 	// whose pointer/map fields all point at the deep copies above.
@@ -145,10 +153,14 @@ func (t *Tracker) refreshDashboard(ctx context.Context, chatID int64) int {
 		Drivers:            make(map[int64]*DriverInfo, driverCount), // only len() is read; entries don't need to be live
 		DashboardMsgID:     s.DashboardMsgID,
 		DashboardPinned:    s.DashboardPinned,
+		Task:               s.Task,
+		Race:               race,
+		Zones:              append([]Zone(nil), s.Zones...),
 		InactivityWarnedAt: s.InactivityWarnedAt,
 		RadarOn:            s.RadarOn,
 		RadarRadius:        s.RadarRadius,
 		RadarEntries:       radarEntries,
+		RadarOptions:       s.RadarOptions, // replaced wholesale, never mutated
 	}
 	// Fill placeholder entries so len(sCopy.Drivers) is correct in the
 	// renderer's `🚗 N водитель(ей)` line. The renderer never reads driver
@@ -162,7 +174,7 @@ func (t *Tracker) refreshDashboard(ctx context.Context, chatID int64) int {
 		return dashID
 	}
 
-	text := buildDashboard(&sCopy, devices, tz)
+	text := buildDashboard(&sCopy, devices, places, tz)
 	kb := dashboardButtons(&sCopy)
 	// Pilot-specific buttons (nav, pickup) live on the dashboard too, below the
 	// action row, so the chat has one place for everything.
@@ -311,15 +323,21 @@ func buildFilter(s *GroupSession) (filter string, callsigns []string, trackedIDs
 		if info.AutoDiscovered {
 			continue
 		}
-		trackedIDs = append(trackedIDs, id)
-		// APRS budlist requires full callsigns (e.g. FLRFD0E8D).
-		// Short 6-char IDs are expanded with all known OGN prefixes.
-		if len(id) <= 6 {
-			for _, prefix := range ognPrefixes {
-				callsigns = append(callsigns, prefix+id)
+		for _, dev := range append([]string{id}, info.Devices...) {
+			if isUserKey(dev) {
+				// No OGN device; positions come from other sources.
+				continue
+			}
+			trackedIDs = append(trackedIDs, dev)
+			// APRS budlist requires full callsigns (e.g. FLRFD0E8D).
+			// Short 6-char IDs are expanded with all known OGN prefixes.
+			if len(dev) <= 6 {
+				for _, prefix := range ognPrefixes {
+					callsigns = append(callsigns, prefix+dev)
+				}
+			} else {
+				callsigns = append(callsigns, dev)
 			}
-		} else {
-			callsigns = append(callsigns, id)
 		}
 	}
 	var parts []string
@@ -329,6 +347,14 @@ func buildFilter(s *GroupSession) (filter string, callsigns []string, trackedIDs
 	if s.TrackArea != nil {
 		parts = append(parts, client.RangeFilter(s.TrackArea.Latitude, s.TrackArea.Longitude, s.TrackAreaRadius))
 	}
+	for i := range s.Zones {
+		if s.Zones[i].Role != ZoneDiscover {
+			continue
+		}
+		if term := s.Zones[i].filterTerm(); term != "" {
+			parts = append(parts, term)
+		}
+	}
 	if len(parts) > 0 {
 		filter = client.CombineFilters(parts...)
 	}
@@ -362,8 +388,7 @@ func (t *Tracker) updateFilter() {
 	// to avoid holding t.mu across Disconnect — the APRS callback also takes t.mu.
 	oldStopCh := s.StopCh
 	oldAprs := t.aprs
-	newAprs := client.New("N0CALL", filter)
-	newAprs.Logger = log.Default()
+	newAprs := t.newAPRSFeed(filter)
 	t.aprs = newAprs
 	newStopCh := make(chan struct{})
 	s.StopCh = newStopCh
@@ -472,12 +497,29 @@ func (t *Tracker) stopRadarAsync() {
 	}()
 }
 
+// startRadarLocked switches the session into radar mode with the given
+// radius and launches the radar APRS client and summary loop. RadarMsgID is
+// left alone so a resumed radar keeps editing its old message. Caller must
+// hold t.mu and have checked that an area is set and tracking is off.
+func (t *Tracker) startRadarLocked(radiusKm int) {
+	s := t.session
+	s.RadarOn = true
+	s.RadarRadius = radiusKm
+	s.RadarEntries = make(map[string]*RadarEntry)
+
+	filter := client.RangeFilter(s.TrackArea.Latitude, s.TrackArea.Longitude, radiusKm)
+	t.aprs = t.newAPRSFeed(filter)
+	s.RadarStopCh = make(chan struct{})
+	go t.runRadarClient(s.RadarStopCh, t.aprs)
+	go t.sendRadarUpdates(s.RadarStopCh)
+}
+
 // runClient connects to the OGN APRS server and processes position messages
 // in an infinite reconnect loop until stopCh is closed.
 // The aprs client is passed explicitly so the goroutine binds to the client it
 // was launched with; the Tracker.aprs field can be reassigned by other goroutines
 // without racing on this read path.
-func (t *Tracker) runClient(stopCh <-chan struct{}, aprs *client.Client) {
+func (t *Tracker) runClient(stopCh <-chan struct{}, aprs *aprsFeed) {
 	slog.Info("OGN client started")
 	delay := reconnectDelay
 	for {
@@ -517,7 +559,7 @@ func (t *Tracker) runClient(stopCh <-chan struct{}, aprs *client.Client) {
 				"real_addr", msg.RealAddress, "stealth", msg.Stealth,
 				"no_tracking", msg.NoTracking, "comment", msg.UserComment)
 
-			var alert *landingEvent
+			var ev fixEvents
 
 			t.mu.Lock()
 			s := t.session
@@ -525,13 +567,21 @@ func (t *Tracker) runClient(stopCh <-chan struct{}, aprs *client.Client) {
 				t.mu.Unlock()
 				return
 			}
+			// A pilot's secondary devices update the pilot's entry (see
+			// devices.go); from here on id is the session key.
+			if key, linked := s.pilotKey(id); linked {
+				id = key
+			}
 			info, ok := s.Tracking[id]
-			// Auto-discover aircraft from area tracking.
-			if !ok && s.TrackArea != nil {
-				info = &TrackInfo{AutoDiscovered: true}
-				s.Tracking[id] = info
-				ok = true
-				slog.Info("auto-discovered aircraft in area", "id", id)
+			// Auto-discovery: admit new aircraft inside the area or a discover
+			// zone, drop auto-discovered ones that left or stopped matching the
+			// /discover rules.
+			if !ok || info.AutoDiscovered {
+				info = discoverLocked(s, t.devices, id, info, msg)
+				ok = info != nil
+			}
+			if ok {
+				recordSignalLocked(s, id, info, msg, time.Now())
 			}
 			if !ok {
 				// Beacon passed the upstream filter but is not currently tracked.
@@ -543,6 +593,16 @@ func (t *Tracker) runClient(stopCh <-chan struct{}, aprs *client.Client) {
 				// — silently drop. Logging every beacon here adds hundreds of
 				// debug lines per session for no diagnostic value; the status
 				// transition itself is already logged when it happens.
+			} else if reason := checkBeacon(info, msg, t.weightBeacons); reason != "" {
+				// Relayed duplicate, stale or teleporting fix — keep the last
+				// good position.
+				slog.Debug("ogn beacon rejected",
+					"id", id, "reason", reason, "receiver", msg.ReceiverName,
+					"ts", msg.Timestamp.Format("15:04:05"), "last_ts", info.LastFixTime.Format("15:04:05"),
+					"lat", msg.Latitude, "lon", msg.Longitude, "alt", msg.Altitude,
+					"snr", msg.SignalQuality, "err_count", msg.ErrorCount,
+					"duplicates", info.Rejects.Duplicate, "out_of_order", info.Rejects.OutOfOrder,
+					"jumps", info.Rejects.Jump)
 			} else {
 				slog.Debug("ogn beacon matched",
 					"id", id, "callsign", origID,
@@ -550,34 +610,15 @@ func (t *Tracker) runClient(stopCh <-chan struct{}, aprs *client.Client) {
 					"speed", msg.GroundSpeed, "climb", msg.ClimbRate,
 					"course", msg.Course, "alt", msg.Altitude,
 					"status", info.Status)
-				info.Position = msg
-				info.LastUpdate = time.Now()
-				if msg.Course > 0 {
-					info.LastHeading = msg.Course
-				}
-				if updateLandingState(info, msg, time.Now()) {
-					alert = &landingEvent{
-						id:   id,
-						name: info.DisplayName(),
-						lat:  msg.Latitude,
-						lon:  msg.Longitude,
-						alt:  msg.Altitude,
-						time: info.LandingTime,
-						tz:   s.tz(),
-					}
-					slog.Info("landing detected", "id", id, "lat", msg.Latitude, "lon", msg.Longitude)
-				}
+				ev = t.applyFixLocked(s, id, info, msg, true, time.Now())
 			}
 			chatID := s.ChatID
-			if alert != nil {
+			if ev.needsSave() {
 				t.saveState()
 			}
 			t.mu.Unlock()
-
-			if alert != nil {
-				t.sendLandingAlert(alert, chatID)
-			}
-		}, false)
+			t.deliverFixEvents(ev, chatID)
+		})
 		if err != nil {
 			slog.Error("ogn client error", "retry_in", delay, "err", err)
 			select {
@@ -594,8 +635,13 @@ func (t *Tracker) runClient(stopCh <-chan struct{}, aprs *client.Client) {
 }
 
 // sendLandingAlert sends a notification to the group when a pilot lands,
-// with navigation and pickup buttons.
+// with navigation and pickup buttons. The recorded track is scored first so
+// the alert can carry the XC result.
 func (t *Tracker) sendLandingAlert(e *landingEvent, chatID int64) {
+	var score xcResult
+	if len(e.track) > 1 {
+		score = t.scoreLanding(e, chatID)
+	}
 	b := t.bot
 	if b == nil {
 		return
@@ -607,6 +653,10 @@ func (t *Tracker) sendLandingAlert(e *landingEvent, chatID int64) {
 	}
 	text := fmt.Sprintf("🪂 %s сел!", label)
 	text += fmt.Sprintf("\nВысота: %.0fм  ⏱ %s", e.alt, e.time.In(e.tz).Format("15:04:05"))
+	text += "\n📍 " + describeLocation(e.places, e.lat, e.lon)
+	if line := formatXCScore(score.Best); line != "" {
+		text += "\n🏆 " + line
+	}
 
 	kb := &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{
@@ -654,11 +704,24 @@ func (t *Tracker) sendUpdates(stopCh <-chan struct{}) {
 			t.mu.Unlock()
 			continue
 		}
+		if n := pruneDiscoveredLocked(s, time.Now()); n > 0 {
+			t.saveState()
+		}
 		chatID := s.ChatID
 		b := t.bot
+		// Dead-reckon pilots whose last fix is getting old. Done under the
+		// lock: predictPosition reads the velocity history runClient appends
+		// to.
+		now := time.Now()
+		predict := s.predictEnabled()
 		local := make(map[string]*TrackInfo)
 		for id, info := range s.Tracking {
+			info.Predicted = nil
+			if predict && !info.AutoDiscovered {
+				info.Predicted = predictPosition(info, now)
+			}
 			cp := *info
+			cp.Velocity = nil
 			local[id] = &cp
 		}
 		t.mu.Unlock()
@@ -692,6 +755,15 @@ func (t *Tracker) sendUpdates(stopCh <-chan struct{}) {
 			if !latest.IsZero() {
 				stats = append(stats, "last_beacon_age", time.Since(latest).Round(time.Second))
 			}
+			rejected := 0
+			for id, info := range local {
+				if r := info.Rejects; r.Total() > 0 {
+					rejected += r.Total()
+					slog.Debug("ogn beacon rejects", "id", id,
+						"duplicates", r.Duplicate, "out_of_order", r.OutOfOrder, "jumps", r.Jump)
+				}
+			}
+			stats = append(stats, "rejected_fixes", rejected)
 			slog.Debug("ogn cycle stats", stats...)
 			lastStats = cur
 		}
@@ -711,6 +783,7 @@ func (t *Tracker) sendUpdates(stopCh <-chan struct{}) {
 				return
 			}
 		}
+		t.checkSunset(ctx, time.Now())
 
 		// Update per-pilot live locations on the map (skip auto-discovered).
 		// Each pilot has a paired text label that names them; the label is
@@ -723,6 +796,35 @@ func (t *Tracker) sendUpdates(stopCh <-chan struct{}) {
 				continue
 			}
 
+			// Replace pins nearing the 24h live-period limit (or already
+			// dead) for pilots still in the air. The renewal is saved before
+			// the new pin is posted, so a restart in between re-posts
+			// nothing twice; the old pair is deleted once the new pin is up.
+			if pinNeedsRenewal(info, time.Now()) {
+				t.mu.Lock()
+				if t.session != nil {
+					if ti, ok := t.session.Tracking[id]; ok {
+						renewPinLocked(id, ti)
+						t.saveState()
+					}
+				}
+				t.mu.Unlock()
+				info.MessageID, info.LabelMsgID = 0, 0
+				info.LiveLocationDead, info.LabelDead = false, false
+			} else if len(info.RetiredMsgIDs) > 0 {
+				var retired []int
+				t.mu.Lock()
+				if t.session != nil {
+					if ti, ok := t.session.Tracking[id]; ok {
+						if retired = retiredPinsLocked(ti); len(retired) > 0 {
+							t.saveState()
+						}
+					}
+				}
+				t.mu.Unlock()
+				t.deleteMessagesAsync(chatID, retired...)
+			}
+
 			// Reflect status changes on the label even after we stop touching
 			// the live-location pin (picked up / confirmed landing). Without
 			// this the emoji would be frozen at whatever it was at the last
@@ -775,6 +877,12 @@ func (t *Tracker) sendUpdates(stopCh <-chan struct{}) {
 				if heading > 0 {
 					editParams.Heading = heading
 				}
+				// A predicted fix moves the pin and widens its accuracy
+				// circle so the map shows it's an estimate.
+				if p := info.Predicted; p != nil {
+					editParams.Latitude, editParams.Longitude = p.Lat, p.Lon
+					editParams.HorizontalAccuracy = p.AccuracyM
+				}
 				_, err := b.EditMessageLiveLocation(ctx, editParams)
 				switch {
 				case err == nil, isMessageNotModified(err):
@@ -845,6 +953,10 @@ func (t *Tracker) sendUpdates(stopCh <-chan struct{}) {
 			if t.session != nil {
 				if ti, ok := t.session.Tracking[id]; ok {
 					ti.MessageID = locMsg.ID
+					ti.MessageSentAt = time.Now()
+					// Saved right away: a restart that forgot this pin would
+					// post a duplicate.
+					t.saveState()
 				}
 			}
 			t.mu.Unlock()
@@ -862,7 +974,7 @@ func (t *Tracker) sendUpdates(stopCh <-chan struct{}) {
 // runRadarClient connects to OGN APRS and collects all positions in the area.
 // Unlike runClient, it does not do landing detection or modify session.Tracking.
 // See runClient for why aprs is passed explicitly.
-func (t *Tracker) runRadarClient(stopCh <-chan struct{}, aprs *client.Client) {
+func (t *Tracker) runRadarClient(stopCh <-chan struct{}, aprs *aprsFeed) {
 	slog.Info("Radar client started")
 	delay := reconnectDelay
 	for {
@@ -886,16 +998,23 @@ func (t *Tracker) runRadarClient(stopCh <-chan struct{}, aprs *client.Client) {
 				t.mu.Unlock()
 				return
 			}
+			if privacyHidden(t.devices, id, msg) {
+				delete(s.RadarEntries, id)
+				t.mu.Unlock()
+				return
+			}
 			entry, ok := s.RadarEntries[id]
 			if !ok {
-				entry = &RadarEntry{DDBInfo: formatDDBInfo(t.devices, id)}
+				entry = &RadarEntry{}
 				s.RadarEntries[id] = entry
 			}
+			// Recomputed per beacon: the stealth bit may change mid-flight.
+			entry.DDBInfo = publicDDBInfo(t.devices, id, msg)
 			entry.Position = msg
 			entry.LastSeen = time.Now()
 			entry.AircraftType = msg.AircraftType
 			t.mu.Unlock()
-		}, false)
+		})
 		if err != nil {
 			slog.Error("radar client error", "retry_in", delay, "err", err)
 			select {
@@ -930,43 +1049,13 @@ func (t *Tracker) sendRadarUpdates(stopCh <-chan struct{}) {
 		}
 		chatID := s.ChatID
 		radarMsgID := s.RadarMsgID
-		center := s.TrackArea
-		radius := s.RadarRadius
-		tz := s.tz()
-
-		// Prune stale entries.
-		now := time.Now()
-		for id, e := range s.RadarEntries {
-			if now.Sub(e.LastSeen) > staleThreshold {
-				delete(s.RadarEntries, id)
-			}
-		}
-
-		// Snapshot entries.
-		lines := make([]radarLine, 0, len(s.RadarEntries))
-		for id, e := range s.RadarEntries {
-			lines = append(lines, radarLine{id, *e})
-		}
+		summary, kb := radarMessageLocked(s, time.Now())
 		b := t.bot
 		t.mu.Unlock()
 
 		if b == nil {
 			continue
 		}
-
-		// Sort by altitude descending.
-		sort.Slice(lines, func(i, j int) bool {
-			if lines[i].entry.Position == nil {
-				return false
-			}
-			if lines[j].entry.Position == nil {
-				return true
-			}
-			return lines[i].entry.Position.Altitude > lines[j].entry.Position.Altitude
-		})
-
-		summary := buildRadarSummary(lines, center, radius, tz)
-		kb := radarButtons(lines)
 		ctx := context.Background()
 
 		if radarMsgID != 0 {
@@ -987,6 +1076,7 @@ func (t *Tracker) sendRadarUpdates(stopCh <-chan struct{}) {
 				t.mu.Lock()
 				if t.session != nil {
 					t.session.RadarMsgID = 0
+					t.saveState()
 				}
 				t.mu.Unlock()
 				radarMsgID = 0
@@ -1009,6 +1099,7 @@ func (t *Tracker) sendRadarUpdates(stopCh <-chan struct{}) {
 				t.mu.Lock()
 				if t.session != nil {
 					t.session.RadarMsgID = msg.ID
+					t.saveState()
 				}
 				t.mu.Unlock()
 			}
@@ -242,6 +242,10 @@ func (t *Tracker) cmdRemove(ctx context.Context, b *bot.Bot, update *models.Upda
 	t.mu.Lock()
 	s := t.session
 	s.ChatID = m.Chat.ID
+	// Any of a pilot's devices removes the pilot.
+	if key, ok := s.pilotKey(id); ok {
+		id = key
+	}
 	// Capture the pilot's label + live-loc message IDs before deleting the
 	// entry so we can clean them out of the chat — otherwise they orphan
 	// until the 24h live-location TTL expires.
@@ -253,6 +257,7 @@ func (t *Tracker) cmdRemove(ctx context.Context, b *bot.Bot, update *models.Upda
 		if info.MessageID != 0 {
 			orphanIDs = append(orphanIDs, info.MessageID)
 		}
+		orphanIDs = append(orphanIDs, info.RetiredMsgIDs...)
 	}
 	delete(s.Tracking, id)
 	t.updateFilter()
@@ -399,7 +404,7 @@ func (t *Tracker) cmdHelp(ctx context.Context, b *bot.Bot, update *models.Update
 	text := strings.Join([]string{
 		"Групповые команды:",
 		"/start — запуск / сброс бота",
-		"/add <id> [имя] — добавить OGN адрес",
+		"/add <id|регистрация|CN> [имя] — добавить OGN адрес",
 		"/add — добавить себя через личку",
 		"/remove <id> — удалить из отслеживания",
 		"/track_on — включить трекинг",
@@ -410,6 +415,16 @@ func (t *Tracker) cmdHelp(ctx context.Context, b *bot.Bot, update *models.Update
 		"/area [радиус] — зона отслеживания (по умолчанию 100км)",
 		"/area_off — отключить зону",
 		"/tz [зона] — часовой пояс (например Europe/Kyiv)",
+		"/leaderboard — XC-рейтинг дня и сезона",
+		"/task — задача соревнования (старт, ТП, цель)",
+		"/milestones — вехи высоты/дистанции (вкл/выкл, пороги)",
+		"/sunset [on|off|60 30 0] — напоминания о закате",
+		"/predict [on|off] — прогноз позиции между биконами",
+		"/zone — зоны: авто-поиск, граница, запретные (круг, полигон, KML/GeoJSON)",
+		"/discover — правила авто-поиска: типы, потолок, лимит, удаление",
+		"/signal [id] — приём пилота станциями OGN / сводка покрытия",
+		"/ddb [refresh] — возраст и размер базы устройств OGN",
+		"/aprs — какой источник APRS сейчас работает",
 		"/list — список отслеживаемых",
 		"/status — текущее состояние",
 		"/session_reset — остановить и очистить всё",
@@ -417,6 +432,9 @@ func (t *Tracker) cmdHelp(ctx context.Context, b *bot.Bot, update *models.Update
 		"Личные команды:",
 		"/myid [id] — показать / задать свой OGN ID",
 		"/confirm — подтвердить добавление текущего ID в группу",
+		"/buddy on|off — DM, когда рядом летит другой пилот",
+		"/token [new|off] — токен для приложения-трекера (OsmAnd/Traccar)",
+		"/feed [ссылка|off] — спутниковый трекер (inReach MapShare / SPOT)",
 		"",
 		"/help — эта справка",
 	}, "\n")
@@ -549,3 +567,453 @@ func (t *Tracker) cmdRadar(ctx context.Context, b *bot.Bot, update *models.Updat
 		t.scheduleEphemeralDelete(m.Chat.ID, m.ID, ackID)
 	}
 }
+
+// taskUsage is shown for /task without a task and on parse errors.
+const taskUsage = `Использование — по точке на строку:
+/task
+start <lat> <lon> [радиус_м] [имя]
+tp <lat> <lon> [радиус_м] [имя]
+goal <lat> <lon> [радиус_м] [имя]   (или line … — линия финиша)
+Вместо координат можно написать landing — точка из /landing.
+/task off — снять задачу`
+
+// cmdTask sets, shows or clears the competition task. Setting a new task
+// resets everyone's race progress. The task description is an info reply and
+// stays in the chat so pilots can refer back to it.
+func (t *Tracker) cmdTask(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupSession(ctx, b, m) {
+		return
+	}
+
+	// commandArgs splits on the first space, but a multi-line task usually
+	// starts on the line after "/task".
+	body := ""
+	if i := strings.IndexAny(m.Text, " \n"); i != -1 {
+		body = strings.TrimSpace(m.Text[i+1:])
+	}
+
+	switch {
+	case body == "":
+		t.mu.Lock()
+		var text string
+		if task := t.session.Task; task != nil {
+			text = describeTask(task)
+		}
+		t.mu.Unlock()
+		if text == "" {
+			text = "Задача не задана.\n\n" + taskUsage
+		}
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: m.Chat.ID, Text: text}); err != nil {
+			slog.Error("failed to send task", "err", err)
+		}
+		return
+	case strings.EqualFold(body, "off"):
+		t.mu.Lock()
+		t.session.Task = nil
+		t.session.Race = nil
+		t.saveState()
+		t.mu.Unlock()
+		slog.Info("task cleared", "chat_id", m.Chat.ID)
+		t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   "🏁 Задача снята",
+		}, "failed to confirm task off")
+		t.refreshDashboard(ctx, m.Chat.ID)
+		return
+	}
+
+	t.mu.Lock()
+	var landing *Coordinates
+	if t.session.Landing != nil {
+		c := *t.session.Landing
+		landing = &c
+	}
+	t.mu.Unlock()
+
+	task, err := parseTask(body, landing)
+	if err != nil {
+		t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   "Не удалось разобрать задачу: " + strings.TrimPrefix(err.Error(), errTaskSyntax.Error()+": ") + "\n\n" + taskUsage,
+		}, "failed to send task error")
+		return
+	}
+
+	t.mu.Lock()
+	t.session.Task = task
+	t.session.Race = make(map[string]*RaceProgress)
+	t.saveState()
+	t.mu.Unlock()
+	slog.Info("task set", "chat_id", m.Chat.ID, "turnpoints", len(task.Turnpoints), "goal_line", task.GoalLine, "km", task.legLengthKm())
+
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   describeTask(task),
+	}); err != nil {
+		slog.Error("failed to send task", "err", err)
+	}
+	t.refreshDashboard(ctx, m.Chat.ID)
+}
+
+// cmdMilestones shows or changes the chat's milestone announcements.
+func (t *Tracker) cmdMilestones(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupSession(ctx, b, m) {
+		return
+	}
+
+	args := strings.Fields(commandArgs(m.Text))
+	t.mu.Lock()
+	cfg := t.session.milestones()
+	if len(args) > 0 {
+		if !applyMilestoneArgs(&cfg, args) {
+			t.mu.Unlock()
+			t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+				ChatID: m.Chat.ID,
+				Text:   "Использование: /milestones [on|off] | alt <м…>|off | dist <км…>|off | pb on|off",
+			}, "failed to send milestones usage")
+			return
+		}
+		t.session.Milestones = &cfg
+		t.saveState()
+		slog.Info("milestones updated", "chat_id", m.Chat.ID, "off", cfg.Off, "alt", cfg.AltM, "dist", cfg.DistKm, "no_pb", cfg.NoPB)
+	}
+	t.mu.Unlock()
+
+	t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   describeMilestones(cfg),
+	}, "failed to send milestones")
+}
+
+// cmdPredict shows or toggles dead-reckoned positions between beacons.
+func (t *Tracker) cmdPredict(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupSession(ctx, b, m) {
+		return
+	}
+
+	arg := strings.ToLower(commandArgs(m.Text))
+	t.mu.Lock()
+	switch arg {
+	case "":
+	case "on", "off":
+		t.session.PredictOff = arg == "off"
+		t.saveState()
+		slog.Info("prediction toggled", "chat_id", m.Chat.ID, "off", t.session.PredictOff)
+	default:
+		t.mu.Unlock()
+		t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   "Использование: /predict [on|off]",
+		}, "failed to send predict usage")
+		return
+	}
+	on := t.session.predictEnabled()
+	t.mu.Unlock()
+
+	text := "🔮 Прогноз позиции выключен"
+	if on {
+		text = fmt.Sprintf("🔮 Прогноз позиции включён: если бикона нет дольше %.0f с, пин сдвигается по курсу и скорости (в спирали — по ветру) до %.0f мин после последней точки",
+			predictAfter.Seconds(), predictMaxAge.Minutes())
+	}
+	t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   text,
+	}, "failed to send predict")
+}
+
+// cmdSunset shows today's sunset and the reminder settings, or changes them.
+func (t *Tracker) cmdSunset(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupSession(ctx, b, m) {
+		return
+	}
+
+	args := strings.Fields(commandArgs(m.Text))
+	t.mu.Lock()
+	cfg := t.session.sunset()
+	if len(args) > 0 {
+		if !applySunsetArgs(&cfg, args) {
+			t.mu.Unlock()
+			t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+				ChatID: m.Chat.ID,
+				Text:   "Использование: /sunset [on|off] | /sunset <мин до заката…>, например /sunset 60 30 0",
+			}, "failed to send sunset usage")
+			return
+		}
+		t.session.Sunset = &cfg
+		t.saveState()
+		slog.Info("sunset reminders updated", "chat_id", m.Chat.ID, "off", cfg.Off, "warn_at", cfg.WarnAt)
+	}
+	var anchor *Coordinates
+	if a := t.session.sunAnchor(); a != nil {
+		c := *a
+		anchor = &c
+	}
+	tz := t.session.tz()
+	t.mu.Unlock()
+
+	t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   describeSunset(cfg, anchor, time.Now(), tz),
+	}, "failed to send sunset")
+}
+
+// cmdDiscover shows or changes the auto-discovery rules.
+func (t *Tracker) cmdDiscover(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupSession(ctx, b, m) {
+		return
+	}
+
+	args := strings.Fields(commandArgs(m.Text))
+	t.mu.Lock()
+	rules := t.session.discovery()
+	if len(args) > 0 {
+		if !applyDiscoveryArgs(&rules, args) {
+			t.mu.Unlock()
+			t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+				ChatID: m.Chat.ID,
+				Text:   "Использование: /discover [types pg hg glider…|all] [alt <м>|off] [cap <N>] [stale <мин>|off]",
+			}, "failed to send discover usage")
+			return
+		}
+		t.session.Discovery = &rules
+		t.saveState()
+		slog.Info("discovery rules updated", "chat_id", m.Chat.ID, "types", rules.Types, "max_alt", rules.MaxAltM, "cap", rules.Cap, "stale_min", rules.StaleAfter)
+	}
+	discovered := countDiscovered(t.session.Tracking)
+	t.mu.Unlock()
+
+	t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   describeDiscovery(rules, discovered),
+	}, "failed to send discover")
+}
+
+// zoneUsage is shown for malformed /zone commands and with an empty list.
+const zoneUsage = `Использование:
+/zone — список зон
+/zone add <имя> <роль> <км> — круг, затем отправьте центр геолокацией
+/zone add <имя> <роль> <lat> <lon> <км> — круг сразу
+/zone draw <имя> <роль> — полигон: шлите точки геолокацией, затем /zone done
+/zone import <роль> — затем пришлите KML/KMZ/GeoJSON (или файл с подписью /zone import <роль>)
+/zone rm <имя> — удалить, /zone cancel — отменить ввод
+Роли: discover (авто-поиск), boundary (вылет = ретрив), nofly (запретная)`
+
+// cmdZone lists, adds, draws, imports and removes the session's zones.
+func (t *Tracker) cmdZone(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupSession(ctx, b, m) {
+		return
+	}
+	args := strings.Fields(commandArgs(m.Text))
+	usage := func() {
+		t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   zoneUsage,
+		}, "failed to send zone usage")
+	}
+	reply := func(text string) {
+		t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   text,
+		}, "failed to send zone reply")
+	}
+	// startDraft stores the draft, prompts for input and arms the timeout.
+	startDraft := func(d *zoneDraft, prompt string) {
+		t.mu.Lock()
+		t.session.ZoneDraft = d
+		t.mu.Unlock()
+		promptID := t.sendAck(ctx, &bot.SendMessageParams{ChatID: m.Chat.ID, Text: prompt}, "failed to send zone prompt")
+		if promptID != 0 {
+			t.mu.Lock()
+			t.appendPendingCleanup(m.From.ID, m.ID, promptID)
+			t.mu.Unlock()
+		}
+		go t.zoneDraftTimeout(d, m.Chat.ID)
+	}
+
+	if len(args) == 0 {
+		t.mu.Lock()
+		text := describeZones(t.session.Zones)
+		t.mu.Unlock()
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: m.Chat.ID, Text: text}); err != nil {
+			slog.Error("failed to send zones", "err", err)
+		}
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		if len(args) != 4 && len(args) != 6 {
+			usage()
+			return
+		}
+		role, ok := parseZoneRole(args[2])
+		km, err := strconv.ParseFloat(args[len(args)-1], 64)
+		if !ok || err != nil || km <= 0 || km > maxAreaRadius {
+			usage()
+			return
+		}
+		if len(args) == 4 {
+			startDraft(&zoneDraft{
+				userID: m.From.ID, name: args[1], role: role, radiusKm: km,
+				expiry: time.Now().Add(zoneCenterTimeout),
+			}, fmt.Sprintf("Отправьте центр зоны «%s» геолокацией в течение 2 минут", args[1]))
+			return
+		}
+		lat, err1 := strconv.ParseFloat(args[3], 64)
+		lon, err2 := strconv.ParseFloat(args[4], 64)
+		if err1 != nil || err2 != nil {
+			usage()
+			return
+		}
+		z := Zone{Name: args[1], Role: role, Center: &Coordinates{Latitude: lat, Longitude: lon}, RadiusKm: km}
+		t.mu.Lock()
+		err = t.addZonesLocked(t.session, []Zone{z})
+		t.mu.Unlock()
+		if err != nil {
+			reply("Не удалось сохранить зону: " + err.Error())
+			return
+		}
+		reply("🗺 Зона сохранена: " + z.describe())
+		t.refreshDashboard(ctx, m.Chat.ID)
+
+	case "draw":
+		if len(args) != 3 {
+			usage()
+			return
+		}
+		role, ok := parseZoneRole(args[2])
+		if !ok {
+			usage()
+			return
+		}
+		startDraft(&zoneDraft{
+			userID: m.From.ID, name: args[1], role: role,
+			expiry: time.Now().Add(zoneDrawTimeout),
+		}, fmt.Sprintf("Рисуем «%s»: отправляйте точки контура геолокацией по порядку, затем /zone done", args[1]))
+
+	case "done":
+		t.mu.Lock()
+		s := t.session
+		d := s.ZoneDraft
+		if d == nil || d.userID != m.From.ID || d.radiusKm > 0 || d.importing {
+			t.mu.Unlock()
+			reply("Нет рисуемой зоны. /zone draw <имя> <роль>")
+			return
+		}
+		z := Zone{Name: d.name, Role: d.role, Polygon: d.points}
+		err := t.addZonesLocked(s, []Zone{z})
+		if err == nil {
+			s.ZoneDraft = nil
+		}
+		t.mu.Unlock()
+		if err != nil {
+			reply("Не удалось сохранить зону: " + err.Error())
+			return
+		}
+		ackID := t.sendAck(ctx, &bot.SendMessageParams{ChatID: m.Chat.ID, Text: "🗺 Зона сохранена: " + z.describe()}, "failed to confirm zone")
+		t.finalizePendingCleanup(m.From.ID, m.Chat.ID, m.ID, ackID)
+		t.refreshDashboard(ctx, m.Chat.ID)
+
+	case "import":
+		role := ZoneDiscover
+		if len(args) > 1 {
+			r, ok := parseZoneRole(args[1])
+			if !ok {
+				usage()
+				return
+			}
+			role = r
+		}
+		startDraft(&zoneDraft{
+			userID: m.From.ID, role: role, importing: true,
+			expiry: time.Now().Add(zoneImportTimeout),
+		}, "Пришлите файл KML, KMZ или GeoJSON в течение 5 минут")
+
+	case "rm", "del", "remove":
+		if len(args) != 2 {
+			usage()
+			return
+		}
+		t.mu.Lock()
+		s := t.session
+		i := zoneIndex(s.Zones, args[1])
+		if i >= 0 {
+			slog.Info("zone removed", "name", s.Zones[i].Name)
+			s.Zones = append(s.Zones[:i], s.Zones[i+1:]...)
+			t.updateFilter()
+			t.saveState()
+		}
+		t.mu.Unlock()
+		if i < 0 {
+			reply("Нет зоны «" + args[1] + "»")
+			return
+		}
+		reply("🗺 Зона «" + args[1] + "» удалена")
+		t.refreshDashboard(ctx, m.Chat.ID)
+
+	case "cancel":
+		t.mu.Lock()
+		had := t.session.ZoneDraft != nil && t.session.ZoneDraft.userID == m.From.ID
+		if had {
+			t.session.ZoneDraft = nil
+		}
+		t.mu.Unlock()
+		if had {
+			ackID := t.sendAck(ctx, &bot.SendMessageParams{ChatID: m.Chat.ID, Text: "Ввод зоны отменён"}, "failed to confirm zone cancel")
+			t.finalizePendingCleanup(m.From.ID, m.Chat.ID, m.ID, ackID)
+			return
+		}
+		reply("Нечего отменять")
+
+	default:
+		usage()
+	}
+}
+
+// cmdLeaderboard shows the XC leaderboard for today and the current season.
+// Info reply — stays in the chat like /list.
+func (t *Tracker) cmdLeaderboard(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupChat(ctx, b, m) {
+		return
+	}
+
+	t.mu.Lock()
+	flights := append([]FlightRecord(nil), t.flights...)
+	tz := t.tz()
+	t.mu.Unlock()
+
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   buildLeaderboard(flights, m.Chat.ID, time.Now(), tz),
+	}); err != nil {
+		slog.Error("failed to send leaderboard", "err", err)
+	}
+}
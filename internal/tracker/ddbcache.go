@@ -0,0 +1,328 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ogn/ddb"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// The OGN DDB is downloaded once a day and kept in a local cache file, which
+// is loaded first on startup: a restart during a DDB outage still shows
+// models and registrations. DDB_FILE replaces the download with a local copy
+// for offline setups.
+
+const (
+	defaultDDBCachePath = "data/ddb.json"
+	// defaultDDBRefresh is how often the DDB is re-downloaded; DDB_REFRESH
+	// overrides it ("0" disables the refresh after the first load).
+	defaultDDBRefresh = 24 * time.Hour
+	// ddbRetryAfter is the retry delay after a failed refresh.
+	ddbRetryAfter = 30 * time.Minute
+	// ddbManualCooldown rate-limits /ddb refresh so the public DDB isn't
+	// hammered from the chat.
+	ddbManualCooldown = 10 * time.Minute
+	ddbSourceNetwork  = "ddb.glidernet.org"
+)
+
+// ddbCacheFile is the on-disk cache: the device list and when it was
+// downloaded.
+type ddbCacheFile struct {
+	FetchedAt time.Time    `json:"fetched_at"`
+	Devices   []ddb.Device `json:"devices"`
+}
+
+// ddbStatus describes the loaded DDB for /ddb. Guarded by t.mu.
+type ddbStatus struct {
+	fetchedAt time.Time // when the loaded data was downloaded (or the file's mtime)
+	source    string    // ddbSourceNetwork, the cache or the DDB_FILE path
+	lastErr   string    // last failed refresh, cleared on success
+	lastErrAt time.Time
+	lastTry   time.Time
+	nextAt    time.Time
+}
+
+// ddbConfig is where the DDB comes from, read from the environment.
+type ddbConfig struct {
+	cachePath string
+	file      string // DDB_FILE — offline source instead of the download
+	refresh   time.Duration
+}
+
+func ddbConfigFromEnv() ddbConfig {
+	c := ddbConfig{
+		cachePath: os.Getenv("DDB_CACHE"),
+		file:      os.Getenv("DDB_FILE"),
+		refresh:   defaultDDBRefresh,
+	}
+	if c.cachePath == "" {
+		c.cachePath = defaultDDBCachePath
+	}
+	if v := os.Getenv("DDB_REFRESH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if v == "0" {
+			d, err = 0, nil
+		}
+		if err != nil || d < 0 {
+			slog.Error("invalid DDB_REFRESH, using default", "value", v, "default", defaultDDBRefresh)
+		} else {
+			c.refresh = d
+		}
+	}
+	return c
+}
+
+// readDDBCache loads the cache file written by writeDDBCache.
+func readDDBCache(path string) (ddbCacheFile, error) {
+	var c ddbCacheFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("decode ddb cache: %w", err)
+	}
+	return c, nil
+}
+
+// writeDDBCache atomically replaces the cache file.
+func writeDDBCache(path string, c ddbCacheFile) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// parseDDBExport decodes the DDB's own JSON export (the
+// ddb.glidernet.org/download/?j=1 format, "Y"/"N" flags), as used for
+// DDB_FILE. A file in the cache format is accepted too.
+func parseDDBExport(r io.Reader) ([]ddb.Device, error) {
+	var raw struct {
+		Devices []struct {
+			DeviceType    string          `json:"device_type"`
+			DeviceID      string          `json:"device_id"`
+			AircraftModel string          `json:"aircraft_model"`
+			Registration  string          `json:"registration"`
+			CN            string          `json:"cn"`
+			Tracked       json.RawMessage `json:"tracked"`
+			Identified    json.RawMessage `json:"identified"`
+		} `json:"devices"`
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode ddb file: %w", err)
+	}
+	flag := func(v json.RawMessage) bool {
+		s := strings.Trim(string(v), `"`)
+		return s == "Y" || s == "true"
+	}
+	devices := make([]ddb.Device, 0, len(raw.Devices))
+	for _, d := range raw.Devices {
+		devices = append(devices, ddb.Device{
+			DeviceType:    d.DeviceType,
+			DeviceID:      d.DeviceID,
+			AircraftModel: d.AircraftModel,
+			Registration:  d.Registration,
+			CN:            d.CN,
+			Tracked:       flag(d.Tracked),
+			Identified:    flag(d.Identified),
+		})
+	}
+	return devices, nil
+}
+
+// fetchDDB gets a fresh device list from the configured source.
+func fetchDDB(cfg ddbConfig) (devices []ddb.Device, fetchedAt time.Time, source string, err error) {
+	if cfg.file == "" {
+		devices, err = ddb.GetDevices()
+		return devices, time.Now(), ddbSourceNetwork, err
+	}
+	f, err := os.Open(cfg.file)
+	if err != nil {
+		return nil, time.Time{}, cfg.file, err
+	}
+	defer f.Close()
+	fetchedAt = time.Now()
+	if st, err := f.Stat(); err == nil {
+		fetchedAt = st.ModTime()
+	}
+	devices, err = parseDDBExport(f)
+	return devices, fetchedAt, cfg.file, err
+}
+
+// swapDevicesLocked installs a new device map. Readers take t.devices under
+// t.mu and never mutate it, so replacing the map is enough. Caller must hold
+// t.mu.
+func (t *Tracker) swapDevicesLocked(devices []ddb.Device, fetchedAt time.Time, source string) {
+	t.devices = ddb.LookupByID(devices)
+	t.ddb.fetchedAt = fetchedAt
+	t.ddb.source = source
+}
+
+// refreshDevices downloads (or re-reads) the DDB and swaps it in. An empty
+// or failed result keeps the current data.
+func (t *Tracker) refreshDevices(cfg ddbConfig) error {
+	// One refresh at a time: the scheduled one and /ddb refresh share the
+	// cache's temp file.
+	t.ddbRefreshMu.Lock()
+	defer t.ddbRefreshMu.Unlock()
+
+	t.mu.Lock()
+	t.ddb.lastTry = time.Now()
+	t.mu.Unlock()
+
+	devices, fetchedAt, source, err := fetchDDB(cfg)
+	if err == nil && len(devices) == 0 {
+		err = errors.New("device database is empty")
+	}
+	t.mu.Lock()
+	if err != nil {
+		t.ddb.lastErr = err.Error()
+		t.ddb.lastErrAt = time.Now()
+		t.mu.Unlock()
+		return err
+	}
+	t.swapDevicesLocked(devices, fetchedAt, source)
+	t.ddb.lastErr = ""
+	t.mu.Unlock()
+	slog.Info("loaded devices from OGN database", "count", len(devices), "source", source)
+	if cfg.file == "" {
+		if err := writeDDBCache(cfg.cachePath, ddbCacheFile{FetchedAt: fetchedAt, Devices: devices}); err != nil {
+			slog.Error("failed to write ddb cache", "path", cfg.cachePath, "err", err)
+		}
+	}
+	return nil
+}
+
+// loadDevices loads the OGN DDB (device database) in the background: the
+// local cache first, then a fresh copy, then again every cfg.refresh. The
+// result is used to render aircraft model and registration next to the OGN
+// ID.
+func (t *Tracker) loadDevices() {
+	cfg := ddbConfigFromEnv()
+	next := time.Now()
+	if cfg.file == "" {
+		c, err := readDDBCache(cfg.cachePath)
+		switch {
+		case err == nil && len(c.Devices) > 0:
+			t.mu.Lock()
+			t.swapDevicesLocked(c.Devices, c.FetchedAt, "кэш "+cfg.cachePath)
+			t.mu.Unlock()
+			slog.Info("loaded devices from ddb cache", "count", len(c.Devices), "age", time.Since(c.FetchedAt).Round(time.Minute))
+			if cfg.refresh > 0 {
+				next = c.FetchedAt.Add(cfg.refresh)
+			}
+		case err != nil && !os.IsNotExist(err):
+			slog.Error("failed to read ddb cache", "path", cfg.cachePath, "err", err)
+		}
+	}
+
+	for {
+		t.mu.Lock()
+		t.ddb.nextAt = next
+		stop := t.shuttingDown
+		t.mu.Unlock()
+		if stop {
+			return
+		}
+		time.Sleep(time.Until(next))
+		err := t.refreshDevices(cfg)
+		switch {
+		case err != nil:
+			slog.Error("failed to load OGN device database", "err", err)
+			next = time.Now().Add(ddbRetryAfter)
+		case cfg.refresh == 0:
+			t.mu.Lock()
+			t.ddb.nextAt = time.Time{}
+			t.mu.Unlock()
+			return
+		default:
+			next = time.Now().Add(cfg.refresh)
+		}
+	}
+}
+
+// formatDDBStatus renders the /ddb reply.
+func formatDDBStatus(st ddbStatus, count int, now time.Time) string {
+	var sb strings.Builder
+	if count == 0 {
+		sb.WriteString("📚 OGN DDB не загружена — модели и регистрации не показываются.")
+	} else {
+		fmt.Fprintf(&sb, "📚 OGN DDB: %d устройств, данные от %s (%s назад), источник: %s.",
+			count, st.fetchedAt.UTC().Format("2006-01-02 15:04 UTC"), formatAge(now.Sub(st.fetchedAt)), st.source)
+	}
+	if !st.nextAt.IsZero() {
+		if d := st.nextAt.Sub(now); d > 0 {
+			fmt.Fprintf(&sb, "\nСледующее обновление через %s.", formatAge(d))
+		} else {
+			sb.WriteString("\nОбновление идёт.")
+		}
+	}
+	if st.lastErr != "" {
+		fmt.Fprintf(&sb, "\n⚠️ Последнее обновление не удалось (%s назад): %s", formatAge(now.Sub(st.lastErrAt)), st.lastErr)
+	}
+	return sb.String()
+}
+
+// cmdDDB is the service command: /ddb shows the age and size of the loaded
+// device database, /ddb refresh reloads it now. The status works in DM too;
+// the refresh only from an allowed group, since DMs are open to anyone.
+func (t *Tracker) cmdDDB(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if isGroupChat(m.Chat) && !t.isAllowedChat(m.Chat.ID) {
+		return
+	}
+	_, arg, _ := strings.Cut(m.Text, " ")
+	now := time.Now()
+
+	t.mu.Lock()
+	st, count := t.ddb, len(t.devices)
+	t.mu.Unlock()
+
+	text := formatDDBStatus(st, count, now)
+	if strings.EqualFold(strings.TrimSpace(arg), "refresh") {
+		switch {
+		case !isGroupChat(m.Chat):
+			text = "Обновить базу можно только из группы.\n\n" + text
+		case now.Sub(st.lastTry) < ddbManualCooldown:
+			text = fmt.Sprintf("Обновление запрашивали %s назад — не чаще раза в %s.\n\n%s",
+				formatAge(now.Sub(st.lastTry)), formatAge(ddbManualCooldown), text)
+		default:
+			slog.Info("cmd /ddb refresh", "user_id", m.From.ID)
+			if err := t.refreshDevices(ddbConfigFromEnv()); err != nil {
+				slog.Error("manual ddb refresh failed", "err", err)
+			}
+			t.mu.Lock()
+			st, count = t.ddb, len(t.devices)
+			t.mu.Unlock()
+			text = formatDDBStatus(st, count, time.Now())
+		}
+	}
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   text,
+	}); err != nil {
+		slog.Error("failed to send ddb status", "err", err)
+	}
+}
@@ -0,0 +1,239 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"ogn/ddb"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Pilots know their glider's registration ("D-1234") or competition number,
+// rarely the FLARM address. /add and /myid accept those too and resolve them
+// through the DDB cache; an ambiguous match is offered as an inline picker.
+
+const (
+	// maxDevicePicks caps the inline picker; a broader match asks the user
+	// to refine the query.
+	maxDevicePicks = 8
+	// devicePickTTL is how long a picker stays answerable.
+	devicePickTTL = 5 * time.Minute
+)
+
+// devicePick is an open picker: what to do with the chosen device. Keyed by
+// the Telegram user who asked, one per user. Runtime-only.
+type devicePick struct {
+	chatID int64
+	// dm marks a /myid picker; args is then the /myid verb ("add" or "").
+	// invite marks a DM picker answering a group /add invite instead.
+	// For a group /add picker args are the remaining /add arguments (name,
+	// @username) and userMsgID the /add command to clean up.
+	dm        bool
+	invite    bool
+	args      []string
+	userMsgID int
+	// pickerID is the picker message, removed with the /add command if
+	// nobody picks in time.
+	pickerID int
+	expires  time.Time
+}
+
+// normalizeDDB folds a registration, CN or model for matching: upper case
+// without spaces, dashes, dots or underscores, so "d-1234", "D1234" and
+// "LS 4" / "LS-4" compare equal.
+func normalizeDDB(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '_':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(s)))
+}
+
+// searchDDB finds the devices a registration, CN or model query refers to.
+// The most specific kind of match wins: exact registration, then exact CN,
+// then registration prefix, then model substring. Devices whose owners opted
+// out of tracking or identification are never returned. Results are sorted
+// by registration.
+func searchDDB(devices map[string]ddb.Device, query string) []ddb.Device {
+	q := normalizeDDB(query)
+	if q == "" {
+		return nil
+	}
+	tiers := make([][]ddb.Device, 4)
+	for _, d := range devices {
+		if !d.Tracked || !d.Identified {
+			continue
+		}
+		reg := normalizeDDB(d.Registration)
+		switch {
+		case reg != "" && reg == q:
+			tiers[0] = append(tiers[0], d)
+		case d.CN != "" && normalizeDDB(d.CN) == q:
+			tiers[1] = append(tiers[1], d)
+		case reg != "" && strings.HasPrefix(reg, q):
+			tiers[2] = append(tiers[2], d)
+		case len(q) >= 2 && strings.Contains(normalizeDDB(d.AircraftModel), q):
+			tiers[3] = append(tiers[3], d)
+		}
+	}
+	for _, tier := range tiers {
+		if len(tier) == 0 {
+			continue
+		}
+		sort.Slice(tier, func(i, j int) bool {
+			if tier[i].Registration != tier[j].Registration {
+				return tier[i].Registration < tier[j].Registration
+			}
+			return tier[i].DeviceID < tier[j].DeviceID
+		})
+		return tier
+	}
+	return nil
+}
+
+// devicePickLabel is a picker button: "D-1234 · LS 4 · CN 12 (DD1234)".
+func devicePickLabel(d ddb.Device) string {
+	var parts []string
+	for _, p := range []string{d.Registration, d.AircraftModel} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if d.CN != "" {
+		parts = append(parts, "CN "+d.CN)
+	}
+	return strings.Join(parts, " · ") + " (" + d.DeviceID + ")"
+}
+
+// devicePickerText and devicePickerMarkup render the picker for an
+// ambiguous query.
+func devicePickerText(query string, matches []ddb.Device) string {
+	text := fmt.Sprintf("По «%s» в OGN DDB найдено %d. Выберите:", query, len(matches))
+	if len(matches) > maxDevicePicks {
+		text = fmt.Sprintf("По «%s» в OGN DDB найдено %d — показаны первые %d. Уточните запрос или выберите:", query, len(matches), maxDevicePicks)
+	}
+	return text
+}
+
+func devicePickerMarkup(matches []ddb.Device) *models.InlineKeyboardMarkup {
+	var rows [][]models.InlineKeyboardButton
+	for i, d := range matches {
+		if i == maxDevicePicks {
+			break
+		}
+		rows = append(rows, []models.InlineKeyboardButton{{Text: devicePickLabel(d), CallbackData: "ddb_pick:" + d.DeviceID}})
+	}
+	rows = append(rows, []models.InlineKeyboardButton{{Text: "Отмена", CallbackData: "ddb_pick:cancel"}})
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// lookupDeviceLocked resolves a DDB query. With exactly one match it returns
+// the device ID; with several it opens a picker for userID and returns the
+// matches to show; with none both are empty. Caller must hold t.mu.
+func (t *Tracker) lookupDeviceLocked(userID int64, query string, pick devicePick) (id string, matches []ddb.Device) {
+	matches = searchDDB(t.devices, query)
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		return matches[0].DeviceID, nil
+	}
+	if t.devicePicks == nil {
+		t.devicePicks = make(map[int64]*devicePick)
+	}
+	pick.expires = time.Now().Add(devicePickTTL)
+	t.devicePicks[userID] = &pick
+	return "", matches
+}
+
+// sendDevicePicker posts the picker for an open pick and remembers its
+// message. A group picker nobody answers is cleaned up after devicePickTTL
+// together with the /add command.
+func (t *Tracker) sendDevicePicker(ctx context.Context, userID int64, query string, matches []ddb.Device) {
+	t.mu.Lock()
+	pick := t.devicePicks[userID]
+	t.mu.Unlock()
+	if pick == nil {
+		return
+	}
+	pickerID := t.sendAck(ctx, &bot.SendMessageParams{
+		ChatID:      pick.chatID,
+		Text:        devicePickerText(query, matches),
+		ReplyMarkup: devicePickerMarkup(matches),
+	}, "failed to send device picker")
+	if pickerID == 0 || pick.dm {
+		return
+	}
+	t.mu.Lock()
+	pick.pickerID = pickerID
+	t.mu.Unlock()
+	time.AfterFunc(devicePickTTL, func() {
+		t.mu.Lock()
+		if t.devicePicks[userID] != pick {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.devicePicks, userID)
+		t.mu.Unlock()
+		t.deleteMessagesAsync(pick.chatID, pickerID, pick.userMsgID)
+	})
+}
+
+// notFoundInDDB is the reply to a query that matches nothing.
+func notFoundInDDB(query string) string {
+	return fmt.Sprintf("%q — не OGN ID, и в OGN DDB по регистрации, CN или модели ничего не найдено. Нужен 6-символьный hex-адрес трекера (0-9, A-F).", query)
+}
+
+// cbDevicePick completes an /add, /myid or invite-reply picker.
+func (t *Tracker) cbDevicePick(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil {
+		return
+	}
+	t.answerCallback(ctx, b, cq)
+	msg := cq.Message.Message
+	if msg == nil || !t.isTrusted(cq.From.ID) {
+		return
+	}
+	choice := strings.TrimPrefix(cq.Data, "ddb_pick:")
+
+	t.mu.Lock()
+	pick := t.devicePicks[cq.From.ID]
+	if pick == nil || pick.chatID != msg.Chat.ID {
+		// Someone else's picker, or an old one.
+		t.mu.Unlock()
+		return
+	}
+	delete(t.devicePicks, cq.From.ID)
+	t.mu.Unlock()
+	deleteCallbackMessage(ctx, b, cq)
+	if choice == "cancel" || time.Now().After(pick.expires) {
+		if !pick.dm && pick.userMsgID != 0 {
+			t.deleteMessagesAsync(msg.Chat.ID, pick.userMsgID)
+		}
+		return
+	}
+	slog.Info("ddb device picked", "id", choice, "user_id", cq.From.ID, "dm", pick.dm)
+
+	if pick.invite {
+		t.handleDMText(ctx, b, &models.Message{Chat: msg.Chat, From: &cq.From, Text: choice})
+		return
+	}
+	if pick.dm {
+		t.execSetMyID(ctx, b, msg.Chat.ID, &cq.From, strings.TrimSpace(strings.Join(pick.args, " ")+" "+choice))
+		return
+	}
+	// Replay the /add with the chosen device.
+	m := &models.Message{ID: pick.userMsgID, Chat: msg.Chat, From: &cq.From}
+	if ackID := t.execAddDirect(ctx, b, m, append([]string{choice}, pick.args...)); ackID != 0 {
+		t.scheduleEphemeralDelete(msg.Chat.ID, pick.userMsgID, ackID)
+	}
+}
@@ -0,0 +1,194 @@
+package tracker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A pilot often carries more than one OGN device — a FLARM plus a phone app
+// such as OGN Tracker or SkyTraxx, each with its own address. The pilot's
+// entry in GroupSession.Tracking is keyed by the first (primary) ID and
+// TrackInfo.Devices lists the others. Beacons from any of them update the
+// same entry, so the dashboard shows one pilot and one live-location pin;
+// the quality stage (quality.go) decides which fix wins.
+
+// maxDevicesPerPilot caps how many OGN IDs one pilot may link.
+const maxDevicesPerPilot = 4
+
+// primaryID is the user's primary OGN ID (the session key), or "".
+func (u *UserInfo) primaryID() string {
+	if len(u.OGNIDs) == 0 {
+		return ""
+	}
+	return u.OGNIDs[0]
+}
+
+// pilotKey resolves an OGN device ID to the key of the session entry it
+// belongs to: the ID itself, or the primary ID of the pilot who linked it.
+func (s *GroupSession) pilotKey(device string) (string, bool) {
+	if _, ok := s.Tracking[device]; ok {
+		return device, true
+	}
+	for key, info := range s.Tracking {
+		for _, d := range info.Devices {
+			if d == device {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseDeviceIDs splits a list of OGN IDs separated by spaces, commas or
+// "+" and normalises them. On bad input it returns the reply explaining
+// what's wrong instead.
+func parseDeviceIDs(text string) (ids []string, errText string) {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == '+' || r == ' ' || r == '\n' || r == '\t'
+	})
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		id := shortID(f)
+		if !isValidShortID(id) {
+			return nil, fmt.Sprintf("OGN ID %q некорректен. Нужно 6 hex-символов (0-9, A-F).", f)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	switch {
+	case len(ids) == 0:
+		return nil, "Укажите OGN ID: 6 hex-символов (0-9, A-F)."
+	case len(ids) > maxDevicesPerPilot:
+		return nil, fmt.Sprintf("Слишком много устройств: не больше %d.", maxDevicesPerPilot)
+	}
+	return ids, ""
+}
+
+// deviceLabel is the pilot's IDs for lists and acks: "AABBCC" or
+// "AABBCC+DDEEFF".
+func deviceLabel(key string, info *TrackInfo) string {
+	if info == nil || len(info.Devices) == 0 {
+		return key
+	}
+	return key + "+" + strings.Join(info.Devices, "+")
+}
+
+// linkDevicesLocked makes devices the secondary IDs of the pilot at key.
+// Standalone entries for those IDs (added on their own or auto-discovered)
+// are merged away, and an ID linked to another pilot moves here. Returns the
+// chat messages of the removed entries, for the caller to delete. Caller
+// must hold t.mu and refresh the APRS filter.
+func linkDevicesLocked(s *GroupSession, key string, devices []string) []int {
+	info, ok := s.Tracking[key]
+	if !ok {
+		return nil
+	}
+	var orphans []int
+	linked := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		linked[d] = true
+		other, ok := s.Tracking[d]
+		if !ok || d == key {
+			continue
+		}
+		if other.LabelMsgID != 0 {
+			orphans = append(orphans, other.LabelMsgID)
+		}
+		if other.MessageID != 0 {
+			orphans = append(orphans, other.MessageID)
+		}
+		orphans = append(orphans, other.RetiredMsgIDs...)
+		delete(s.Tracking, d)
+	}
+	for k, other := range s.Tracking {
+		if k == key || len(other.Devices) == 0 {
+			continue
+		}
+		kept := other.Devices[:0:0]
+		for _, d := range other.Devices {
+			if !linked[d] {
+				kept = append(kept, d)
+			}
+		}
+		other.Devices = kept
+	}
+	info.Devices = nil
+	for _, d := range devices {
+		if d != key {
+			info.Devices = append(info.Devices, d)
+		}
+	}
+	return orphans
+}
+
+// rekeyPilotLocked moves the entry owned by userID from oldKey to newKey
+// when a pilot's primary ID changes. Reports whether anything moved. Caller
+// must hold t.mu.
+func rekeyPilotLocked(s *GroupSession, oldKey, newKey string, userID int64) bool {
+	if oldKey == "" || oldKey == newKey {
+		return false
+	}
+	info, ok := s.Tracking[oldKey]
+	if !ok || info.OwnerUserID != userID {
+		return false
+	}
+	delete(s.Tracking, oldKey)
+	s.Tracking[newKey] = info
+	if p, ok := s.Race[oldKey]; ok {
+		delete(s.Race, oldKey)
+		s.Race[newKey] = p
+	}
+	return true
+}
+
+// gpsAccuracy is the horizontal accuracy (m) from a beacon's "gps3x5"
+// field, or 0 when unknown.
+func gpsAccuracy(q string) float64 {
+	h, _, ok := strings.Cut(q, "x")
+	if !ok {
+		return 0
+	}
+	v, err := strconv.ParseFloat(h, 64)
+	if err != nil || v <= 0 {
+		return 0
+	}
+	return v
+}
+
+// editDeviceList applies /myid arguments to a user's device list:
+//
+//	A B        — replace the list (primary first)
+//	add C      — link another device
+//	remove B   — unlink a device (not the last one)
+//
+// Returns the new list, or the reply explaining what's wrong.
+func editDeviceList(current []string, arg string) ([]string, string) {
+	verb, rest, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	switch strings.ToLower(verb) {
+	case "add":
+		return parseDeviceIDs(strings.Join(current, " ") + " " + rest)
+	case "remove", "rm":
+		drop, errText := parseDeviceIDs(rest)
+		if errText != "" {
+			return nil, errText
+		}
+		var ids []string
+		for _, id := range current {
+			keep := true
+			for _, d := range drop {
+				keep = keep && id != d
+			}
+			if keep {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, "Нельзя удалить последнее устройство. Задайте новое: /myid <id>"
+		}
+		return ids, ""
+	}
+	return parseDeviceIDs(arg)
+}
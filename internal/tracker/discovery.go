@@ -0,0 +1,281 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ogn/ddb"
+	"ogn/parser"
+
+	"github.com/go-telegram/bot"
+)
+
+// Auto-discovery defaults used until a chat configures its own with
+// /discover.
+const (
+	defaultDiscoverCap   = 30
+	defaultDiscoverStale = 15 // minutes
+	maxDiscoverCap       = 100
+)
+
+// aircraftTypeAliases maps /discover keywords to OGN aircraft type codes
+// (see aircraftTypes).
+var aircraftTypeAliases = map[string]int{
+	"unknown": 0, "glider": 1, "tow": 2, "heli": 3, "para": 4, "drop": 5,
+	"hg": 6, "pg": 7, "powered": 8, "jet": 9, "ufo": 10, "balloon": 11,
+	"airship": 12, "drone": 13, "static": 15,
+}
+
+// DiscoveryRules limit what auto-discovery adds to the session. A nil rules
+// pointer on the session means "defaults".
+type DiscoveryRules struct {
+	Types      []int `json:"types,omitempty"`     // allowed AircraftType codes; empty = all
+	MaxAltM    int   `json:"max_alt_m,omitempty"` // ceiling, metres MSL; 0 = none
+	Cap        int   `json:"cap"`                 // max auto-discovered entries
+	StaleAfter int   `json:"stale_after_min"`     // minutes without a beacon before removal
+}
+
+// discovery returns the session's effective auto-discovery rules.
+func (s *GroupSession) discovery() DiscoveryRules {
+	if s == nil || s.Discovery == nil {
+		return DiscoveryRules{Cap: defaultDiscoverCap, StaleAfter: defaultDiscoverStale}
+	}
+	return *s.Discovery
+}
+
+// admits reports whether a beacon passes the type and altitude rules.
+func (r DiscoveryRules) admits(msg *parser.PositionMessage) bool {
+	if len(r.Types) > 0 {
+		ok := false
+		for _, t := range r.Types {
+			if t == msg.AircraftType {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return r.MaxAltM <= 0 || msg.Altitude <= float64(r.MaxAltM)
+}
+
+// countDiscovered returns the number of auto-discovered entries.
+func countDiscovered(tracking map[string]*TrackInfo) int {
+	n := 0
+	for _, info := range tracking {
+		if info.AutoDiscovered {
+			n++
+		}
+	}
+	return n
+}
+
+// discoverLocked decides what to do with a beacon from an untracked or
+// auto-discovered aircraft. For a new aircraft it creates the entry if the
+// rules, the area and the cap allow it. For an existing auto-discovered
+// entry it drops the entry once the aircraft leaves the area or stops
+// matching the rules or opts out of tracking. Returns the entry to update,
+// or nil to ignore the beacon. Caller must hold t.mu.
+func discoverLocked(s *GroupSession, devices map[string]ddb.Device, id string, info *TrackInfo, msg *parser.PositionMessage) *TrackInfo {
+	rules := s.discovery()
+	inside := s.discoverable(msg.Latitude, msg.Longitude) && rules.admits(msg) &&
+		!privacyHidden(devices, id, msg)
+	if info != nil {
+		if !info.AutoDiscovered || inside {
+			return info
+		}
+		delete(s.Tracking, id)
+		slog.Info("auto-discovered aircraft dropped", "id", id, "lat", msg.Latitude, "lon", msg.Longitude, "alt", msg.Altitude, "type", msg.AircraftType)
+		return nil
+	}
+	if !inside {
+		return nil
+	}
+	if countDiscovered(s.Tracking) >= rules.Cap {
+		slog.Debug("auto-discovery cap reached", "id", id, "cap", rules.Cap)
+		return nil
+	}
+	info = &TrackInfo{AutoDiscovered: true}
+	s.Tracking[id] = info
+	slog.Info("auto-discovered aircraft in area", "id", id, "type", msg.AircraftType)
+	return info
+}
+
+// pruneDiscoveredLocked removes auto-discovered entries that haven't sent a
+// beacon for the configured stale period. Returns how many were removed.
+// Caller must hold t.mu.
+func pruneDiscoveredLocked(s *GroupSession, now time.Time) int {
+	stale := time.Duration(s.discovery().StaleAfter) * time.Minute
+	if stale <= 0 {
+		return 0
+	}
+	n := 0
+	for id, info := range s.Tracking {
+		if !info.AutoDiscovered || now.Sub(info.LastUpdate) < stale {
+			continue
+		}
+		delete(s.Tracking, id)
+		n++
+		slog.Info("auto-discovered aircraft expired", "id", id, "last_update", info.LastUpdate)
+	}
+	return n
+}
+
+// ddbName picks a short display name for a device from the DDB: the
+// competition number, then the registration, then the model.
+func ddbName(devices map[string]ddb.Device, id string) string {
+	dev, ok := devices[id]
+	if !ok {
+		return ""
+	}
+	for _, s := range []string{dev.CN, dev.Registration, dev.AircraftModel} {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// execAdopt turns an auto-discovered aircraft into a permanently tracked
+// pilot, named from the DDB when the owner allows identification. Triggered
+// by the dashboard's "📌" button.
+func (t *Tracker) execAdopt(ctx context.Context, b *bot.Bot, id string) {
+	t.mu.Lock()
+	s := t.session
+	if s == nil {
+		t.mu.Unlock()
+		return
+	}
+	chatID := s.ChatID
+	info, ok := s.Tracking[id]
+	if !ok || !info.AutoDiscovered {
+		t.mu.Unlock()
+		return
+	}
+	info.AutoDiscovered = false
+	if info.Name == "" && !privacyAnonymous(t.devices, id, info.Position) {
+		info.Name = ddbName(t.devices, id)
+	}
+	name := info.Name
+	t.updateFilter()
+	t.saveState()
+	t.mu.Unlock()
+	slog.Info("auto-discovered aircraft adopted", "id", id, "name", name)
+
+	label := id
+	if name != "" {
+		label += " (" + name + ")"
+	}
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("📌 %s теперь отслеживается постоянно. Имя: /add %s <имя>", label, id),
+	}); err != nil {
+		slog.Error("failed to confirm adopt", "id", id, "err", err)
+	}
+	t.refreshDashboard(ctx, chatID)
+}
+
+// typeName is the /discover keyword for an aircraft type code.
+func typeName(code int) string {
+	for k, v := range aircraftTypeAliases {
+		if v == code {
+			return k
+		}
+	}
+	return strconv.Itoa(code)
+}
+
+// describeDiscovery renders the /discover status reply.
+func describeDiscovery(r DiscoveryRules, discovered int) string {
+	types := "все"
+	if len(r.Types) > 0 {
+		names := make([]string, len(r.Types))
+		for i, c := range r.Types {
+			names[i] = typeName(c)
+		}
+		types = strings.Join(names, ", ")
+	}
+	alt := "нет"
+	if r.MaxAltM > 0 {
+		alt = fmt.Sprintf("%d м", r.MaxAltM)
+	}
+	stale := "никогда"
+	if r.StaleAfter > 0 {
+		stale = fmt.Sprintf("через %d мин без биконов", r.StaleAfter)
+	}
+	return fmt.Sprintf("🔍 Авто-поиск: %d из %d\nТипы: %s\nПотолок: %s\nУдаление: %s или при выходе из зоны",
+		discovered, r.Cap, types, alt, stale)
+}
+
+// applyDiscoveryArgs updates r from /discover arguments:
+//
+//	types pg hg glider | types all — aircraft type allow-list
+//	alt 3000 | alt off             — altitude ceiling
+//	cap 20                         — max auto-discovered entries
+//	stale 10 | stale off           — minutes without beacons before removal
+//
+// Returns false on malformed input.
+func applyDiscoveryArgs(r *DiscoveryRules, args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	vals := args[1:]
+	number := func(max int) (int, bool) {
+		if len(vals) != 1 {
+			return 0, false
+		}
+		if strings.EqualFold(vals[0], "off") {
+			return 0, true
+		}
+		n, err := strconv.Atoi(vals[0])
+		return n, err == nil && n > 0 && n <= max
+	}
+	switch strings.ToLower(args[0]) {
+	case "types", "type":
+		if len(vals) == 1 && strings.EqualFold(vals[0], "all") {
+			r.Types = nil
+			return true
+		}
+		var types []int
+		for _, v := range vals {
+			code, ok := aircraftTypeAliases[strings.ToLower(v)]
+			if !ok {
+				n, err := strconv.Atoi(v)
+				if _, known := aircraftTypes[n]; err != nil || !known {
+					return false
+				}
+				code = n
+			}
+			types = append(types, code)
+		}
+		sort.Ints(types)
+		r.Types = types
+	case "alt":
+		n, ok := number(20000)
+		if !ok {
+			return false
+		}
+		r.MaxAltM = n
+	case "cap":
+		n, ok := number(maxDiscoverCap)
+		if !ok || n == 0 {
+			return false
+		}
+		r.Cap = n
+	case "stale":
+		n, ok := number(24 * 60)
+		if !ok {
+			return false
+		}
+		r.StaleAfter = n
+	default:
+		return false
+	}
+	return true
+}
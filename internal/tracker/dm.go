@@ -37,13 +37,13 @@ func (t *Tracker) cmdStartPrivate(ctx context.Context, b *bot.Bot, m *models.Mes
 		}
 
 		u.PendingGroup = groupChatID
-		hasOGNID := u.OGNID != ""
-		ognID := u.OGNID
+		hasOGNID := len(u.OGNIDs) > 0
+		ognID := strings.Join(u.OGNIDs, ", ")
 		t.saveState()
 		t.mu.Unlock()
 
 		if hasOGNID {
-			text := fmt.Sprintf("Ваш OGN ID: %s\nОтправьте новый ID или /confirm чтобы использовать текущий.", ognID)
+			text := fmt.Sprintf("Ваш OGN ID: %s\nОтправьте новый ID (несколько устройств — через пробел) или /confirm чтобы использовать текущий.", ognID)
 			if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: m.Chat.ID,
 				Text:   text,
@@ -53,7 +53,7 @@ func (t *Tracker) cmdStartPrivate(ctx context.Context, b *bot.Bot, m *models.Mes
 		} else {
 			if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: m.Chat.ID,
-				Text:   "Отправьте ваш OGN ID (6-значный адрес трекера):",
+				Text:   "Отправьте ваш OGN ID (6-значный адрес трекера, или регистрацию / CN из OGN DDB; если устройств несколько — все через пробел):",
 			}); err != nil {
 				slog.Error("failed to send DM ask for OGN ID", "err", err)
 			}
@@ -98,13 +98,13 @@ func (t *Tracker) cmdMyID(ctx context.Context, b *bot.Bot, update *models.Update
 	u.DMChatID = m.Chat.ID
 
 	if arg == "" {
-		// Show current OGN ID.
-		ognID := u.OGNID
+		// Show current OGN ID(s).
+		ognID := strings.Join(u.OGNIDs, ", ")
 		t.mu.Unlock()
 		if ognID == "" {
 			if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: m.Chat.ID,
-				Text:   "OGN ID не задан. Используйте /myid <id>",
+				Text:   "OGN ID не задан. Используйте /myid <id> [id2 …]",
 			}); err != nil {
 				slog.Error("failed to send myid empty", "err", err)
 			}
@@ -119,38 +119,86 @@ func (t *Tracker) cmdMyID(ctx context.Context, b *bot.Bot, update *models.Update
 		return
 	}
 
-	// Set new OGN ID.
-	newID := shortID(arg)
-	if !isValidShortID(newID) {
+	t.mu.Unlock()
+	t.execSetMyID(ctx, b, m.Chat.ID, m.From, arg)
+}
+
+// execSetMyID applies /myid arguments in DM: "/myid A B" replaces the
+// device list, "/myid add C" and "/myid remove B" edit it. A registration,
+// CN or model in place of the ID is looked up in the DDB.
+func (t *Tracker) execSetMyID(ctx context.Context, b *bot.Bot, chatID int64, from *models.User, arg string) {
+	t.mu.Lock()
+	u := t.ensureUser(from)
+	u.DMChatID = chatID
+	ids, errText := editDeviceList(u.OGNIDs, arg)
+	if errText != "" {
+		verb, query := "", arg
+		if v, rest, ok := strings.Cut(arg, " "); ok && strings.EqualFold(v, "add") {
+			verb, query = "add", rest
+		}
+		if first, _, _ := strings.Cut(arg, " "); query != "" && !strings.EqualFold(first, "remove") && !strings.EqualFold(first, "rm") {
+			found, matches := t.lookupDeviceLocked(from.ID, query, devicePick{chatID: chatID, dm: true, args: []string{verb}})
+			switch {
+			case len(matches) > 0:
+				t.mu.Unlock()
+				t.sendDevicePicker(ctx, from.ID, query, matches)
+				return
+			case found != "":
+				slog.Info("cmd /myid resolved via ddb", "query", query, "id", found)
+				ids, errText = editDeviceList(u.OGNIDs, strings.TrimSpace(verb+" "+found))
+			case !strings.ContainsAny(query, " ,+"):
+				// A list of IDs keeps the more specific parse error.
+				errText = notFoundInDDB(query)
+			}
+		}
+	}
+	if errText != "" {
 		t.mu.Unlock()
 		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: m.Chat.ID,
-			Text:   fmt.Sprintf("OGN ID %q некорректен. Нужно 6 hex-символов (0-9, A-F).", arg),
+			ChatID: chatID,
+			Text:   errText,
 		}); err != nil {
 			slog.Error("failed to send invalid ognid message", "err", err)
 		}
 		return
 	}
-	oldID := u.OGNID
-	u.OGNID = newID
+	oldID := u.primaryID()
+	u.OGNIDs = ids
+	newID := u.primaryID()
 
-	// Update any TrackInfo entries owned by this user.
+	// Update any TrackInfo entry owned by this user: re-key it to the new
+	// primary ID and link the other devices.
 	s := t.session
-	if s != nil && oldID != "" && oldID != newID {
-		if info, ok := s.Tracking[oldID]; ok && info.OwnerUserID == u.UserID {
-			delete(s.Tracking, oldID)
+	var orphanIDs []int
+	var groupChatID int64
+	if s != nil {
+		if rekeyPilotLocked(s, oldID, newID, u.UserID) {
+			info := s.Tracking[newID]
 			info.Name = u.DisplayName
 			info.Username = u.Username
-			s.Tracking[newID] = info
+		}
+		if info, ok := s.Tracking[newID]; ok && info.OwnerUserID == u.UserID {
+			orphanIDs = linkDevicesLocked(s, newID, ids[1:])
+			groupChatID = s.ChatID
 			t.updateFilter()
 		}
 	}
 	t.saveState()
 	t.mu.Unlock()
+	slog.Info("cmd /myid", "user_id", u.UserID, "ids", ids)
+	t.deleteMessagesAsync(groupChatID, orphanIDs...)
 
+	text := "OGN ID обновлён: " + strings.Join(ids, ", ")
+	t.mu.Lock()
+	for _, id := range ids {
+		if info := formatDDBInfo(t.devices, id); info != "" {
+			text += "\n📋 " + id + ": " + info
+		}
+	}
+	t.mu.Unlock()
 	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: m.Chat.ID,
-		Text:   fmt.Sprintf("OGN ID обновлён: %s", newID),
+		ChatID: chatID,
+		Text:   text,
 	}); err != nil {
 		slog.Error("failed to confirm myid update", "err", err)
 	}
@@ -187,7 +235,7 @@ func (t *Tracker) cmdConfirm(ctx context.Context, b *bot.Bot, update *models.Upd
 		}
 		return
 	}
-	if u.OGNID == "" {
+	if len(u.OGNIDs) == 0 {
 		t.mu.Unlock()
 		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Chat.ID,
@@ -212,7 +260,7 @@ func (t *Tracker) cmdConfirm(ctx context.Context, b *bot.Bot, update *models.Upd
 		return
 	}
 
-	id := u.OGNID
+	id := u.primaryID()
 	name := u.DisplayName
 	groupChatID := s.ChatID
 
@@ -228,17 +276,20 @@ func (t *Tracker) cmdConfirm(ctx context.Context, b *bot.Bot, update *models.Upd
 			OwnerUserID: u.UserID,
 		}
 	}
+	orphanIDs := linkDevicesLocked(s, id, u.OGNIDs[1:])
+	label := deviceLabel(id, s.Tracking[id])
 
 	u.PendingGroup = 0
 	t.updateFilter()
 	dmKb := t.dmReplyKeyboard(u.UserID)
 	t.saveState()
 	t.mu.Unlock()
+	t.deleteMessagesAsync(groupChatID, orphanIDs...)
 
 	// Confirm in DM.
 	dmParams := &bot.SendMessageParams{
 		ChatID: m.Chat.ID,
-		Text:   fmt.Sprintf("Добавлен %s в группу", id),
+		Text:   fmt.Sprintf("Добавлен %s в группу", label),
 	}
 	if dmKb != nil {
 		dmParams.ReplyMarkup = dmKb
@@ -248,9 +299,8 @@ func (t *Tracker) cmdConfirm(ctx context.Context, b *bot.Bot, update *models.Upd
 	}
 
 	// Confirm in group.
-	label := id
 	if name != "" {
-		label = id + " (" + name + ")"
+		label += " (" + name + ")"
 	}
 	groupAckID := t.sendAck(ctx, &bot.SendMessageParams{
 		ChatID: groupChatID,
@@ -270,7 +320,11 @@ func (t *Tracker) execDMLanding(ctx context.Context, b *bot.Bot, m *models.Messa
 	u.DMChatID = m.Chat.ID
 
 	s := t.session
-	if s == nil || !s.TrackingOn || u.OGNID == "" {
+	key, tracked := "", false
+	if s != nil {
+		key, tracked = t.userPilotKeyLocked(s, u.UserID)
+	}
+	if s == nil || !s.TrackingOn || !tracked {
 		t.mu.Unlock()
 		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Chat.ID,
@@ -281,8 +335,8 @@ func (t *Tracker) execDMLanding(ctx context.Context, b *bot.Bot, m *models.Messa
 		return
 	}
 
-	info, ok := s.Tracking[u.OGNID]
-	if !ok || info.Status != StatusFlying {
+	info := s.Tracking[key]
+	if info.Status != StatusFlying {
 		t.mu.Unlock()
 		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Chat.ID,
@@ -313,7 +367,11 @@ func (t *Tracker) execDMConfirmLanding(ctx context.Context, b *bot.Bot, m *model
 	u.DMChatID = m.Chat.ID
 
 	s := t.session
-	if s == nil || !s.TrackingOn || u.OGNID == "" {
+	key, tracked := "", false
+	if s != nil {
+		key, tracked = t.userPilotKeyLocked(s, u.UserID)
+	}
+	if s == nil || !s.TrackingOn || !tracked {
 		t.mu.Unlock()
 		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Chat.ID,
@@ -324,8 +382,8 @@ func (t *Tracker) execDMConfirmLanding(ctx context.Context, b *bot.Bot, m *model
 		return
 	}
 
-	info, ok := s.Tracking[u.OGNID]
-	if !ok || info.Status != StatusFlying {
+	info := s.Tracking[key]
+	if info.Status != StatusFlying {
 		t.mu.Unlock()
 		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Chat.ID,
@@ -343,13 +401,16 @@ func (t *Tracker) execDMConfirmLanding(ctx context.Context, b *bot.Bot, m *model
 	var alert *landingEvent
 	if info.Position != nil {
 		alert = &landingEvent{
-			id:   u.OGNID,
-			name: info.DisplayName(),
-			lat:  info.Position.Latitude,
-			lon:  info.Position.Longitude,
-			alt:  info.Position.Altitude,
-			time: info.LandingTime,
-			tz:   s.tz(),
+			id:     key,
+			name:   info.DisplayName(),
+			lat:    info.Position.Latitude,
+			lon:    info.Position.Longitude,
+			alt:    info.Position.Altitude,
+			time:   info.LandingTime,
+			tz:     s.tz(),
+			owner:  info.OwnerUserID,
+			track:  append([]TrackPoint(nil), info.Track...),
+			places: t.places,
 		}
 	}
 	chatID := s.ChatID
@@ -376,7 +437,7 @@ func (t *Tracker) execDMConfirmLanding(ctx context.Context, b *bot.Bot, m *model
 		t.sendLandingAlert(alert, chatID)
 	} else {
 		// No position data — send a simple text notification.
-		label := u.OGNID
+		label := key
 		if name := info.DisplayName(); name != "" {
 			label = name
 		}
@@ -384,7 +445,7 @@ func (t *Tracker) execDMConfirmLanding(ctx context.Context, b *bot.Bot, m *model
 			ChatID: chatID,
 			Text:   fmt.Sprintf("🪂 %s сел! (подтверждено пилотом)", label),
 		}); err != nil {
-			slog.Error("failed to send landing notification", "ogn_id", u.OGNID, "err", err)
+			slog.Error("failed to send landing notification", "ogn_id", key, "err", err)
 		}
 	}
 }
@@ -403,22 +464,28 @@ func (t *Tracker) handleDMLanding(ctx context.Context, b *bot.Bot, m *models.Mes
 
 	if s.WaitingDMLandingFor != m.From.ID || !time.Now().Before(s.DMLandingExpiry) {
 		t.mu.Unlock()
+		// Not a landing pin: a pilot sharing their live location with the
+		// bot (see livelocation.go).
+		if loc.LivePeriod > 0 {
+			t.handleLiveLocation(ctx, b, m, false)
+		}
 		return
 	}
 
 	slog.Info("dm landing location set", "lat", loc.Latitude, "lon", loc.Longitude, "user_id", m.From.ID)
 	s.Landing = &Coordinates{Latitude: loc.Latitude, Longitude: loc.Longitude}
 	s.WaitingDMLandingFor = 0
+	tzNote := autoTimezone(s, loc.Latitude, loc.Longitude, time.Now())
 
 	// Mark the sender as landed.
 	var landedName string
-	if u, ok := t.users[m.From.ID]; ok && u.OGNID != "" {
-		if info, ok := s.Tracking[u.OGNID]; ok && info.Status == StatusFlying {
+	if key, ok := t.userPilotKeyLocked(s, m.From.ID); ok {
+		if info := s.Tracking[key]; info.Status == StatusFlying {
 			info.Status = StatusLanded
 			info.LandingTime = time.Now()
 			info.LandingConfirmed = true
 			landedName = info.DisplayName()
-			slog.Info("dm landing marked", "ogn_id", u.OGNID, "user_id", m.From.ID)
+			slog.Info("dm landing marked", "ogn_id", key, "user_id", m.From.ID)
 		}
 	}
 
@@ -456,6 +523,51 @@ func (t *Tracker) handleDMLanding(ctx context.Context, b *bot.Bot, m *models.Mes
 	}); err != nil {
 		slog.Error("failed to notify group about DM landing", "err", err)
 	}
+	t.announceTimezone(groupChatID, tzNote)
 
 	t.refreshDashboard(ctx, groupChatID)
 }
+
+// cmdBuddy toggles the buddy-nearby DM: when another tracked pilot flies
+// within pairing range, the user gets a short DM naming them.
+// "/buddy on" / "/buddy off" set it explicitly; bare "/buddy" shows the state.
+func (t *Tracker) cmdBuddy(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !isPrivateChat(m.Chat) {
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   "Эта команда работает только в личке.",
+		}); err != nil {
+			slog.Error("failed to send private-only message", "err", err)
+		}
+		return
+	}
+
+	arg := strings.ToLower(commandArgs(m.Text))
+	t.mu.Lock()
+	u := t.ensureUser(m.From)
+	u.DMChatID = m.Chat.ID
+	switch arg {
+	case "on":
+		u.BuddyAlerts = true
+	case "off":
+		u.BuddyAlerts = false
+	}
+	enabled := u.BuddyAlerts
+	t.saveState()
+	t.mu.Unlock()
+
+	text := "🤝 Уведомления «рядом напарник»: выкл. /buddy on — включить"
+	if enabled {
+		text = "🤝 Уведомления «рядом напарник»: вкл. /buddy off — выключить"
+	}
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   text,
+	}); err != nil {
+		slog.Error("failed to send buddy state", "err", err)
+	}
+}
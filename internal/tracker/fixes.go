@@ -0,0 +1,225 @@
+package tracker
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"ogn/parser"
+)
+
+// Positions reach a pilot's entry from OGN beacons and from secondary
+// sources such as the pilot's Telegram live location. Every accepted fix
+// goes through applyFixLocked, so tracks, zones, races, milestones and the
+// landing detector work the same whatever the source. Sources are merged by
+// freshness: a fix older than the last accepted one, from any source, is
+// dropped.
+
+// Position sources besides OGN, stored in TrackInfo.Source.
+const (
+	sourceTelegram = "telegram"
+	sourceApp      = "app"     // phone tracker app over HTTP (ingest.go)
+	sourceInReach  = "inreach" // satellite feeds (satfeed.go)
+	sourceSpot     = "spot"
+)
+
+// sourceLabels are the dashboard lines for non-OGN sources.
+var sourceLabels = map[string]string{
+	sourceTelegram: "📱 Telegram-геолокация",
+	sourceApp:      "📲 Приложение-трекер",
+	sourceInReach:  "🛰 inReach",
+	sourceSpot:     "🛰 SPOT",
+}
+
+// externalDerivedMax — speed, course and climb are derived from the previous
+// fix when a source doesn't report them, if that fix is at most this old.
+const externalDerivedMax = 5 * time.Minute
+
+// userKeyPrefix marks the session key of a pilot tracked without any OGN
+// device. Hex OGN IDs never contain "T" or "G".
+const userKeyPrefix = "TG"
+
+// userKey is the session key for the Telegram user's entry when they have
+// no OGN ID.
+func userKey(userID int64) string {
+	return userKeyPrefix + strconv.FormatInt(userID, 10)
+}
+
+// isUserKey reports whether a session key belongs to a pilot without OGN
+// hardware; such keys stay out of the APRS filter.
+func isUserKey(id string) bool {
+	rest, ok := strings.CutPrefix(id, userKeyPrefix)
+	if !ok || rest == "" {
+		return false
+	}
+	_, err := strconv.ParseInt(rest, 10, 64)
+	return err == nil
+}
+
+// externalFix is one position from a non-OGN source.
+type externalFix struct {
+	Source   string
+	At       time.Time
+	Lat, Lon float64
+	// Alt (m) and Speed (km/h) are only meaningful with HasAlt / HasSpeed.
+	Alt      float64
+	HasAlt   bool
+	Speed    float64
+	HasSpeed bool
+	Course   int     // degrees, 0 = unknown
+	Accuracy float64 // horizontal, m; 0 = unknown
+}
+
+// fixEvents are the announcements one accepted fix produced. Built under
+// t.mu; deliverFixEvents sends them after unlocking.
+type fixEvents struct {
+	alert       *landingEvent
+	finish      *raceFinishEvent
+	raceChanged bool
+	prox        []proximityEvent
+	milestones  []milestoneEvent
+	tzNote      string
+	zoneEvents  []string
+}
+
+// needsSave reports whether the fix changed persisted state.
+func (ev fixEvents) needsSave() bool {
+	return ev.alert != nil || ev.raceChanged || len(ev.milestones) > 0 || ev.tzNote != ""
+}
+
+// applyFixLocked moves the pilot at id to an accepted fix and runs
+// everything that follows a new position. at is when the fix was taken:
+// arrival time for live OGN beacons, the source's own timestamp for fixes
+// that arrive late or in batches (app queues, satellite feeds), so tracks
+// and landing timing follow the flight rather than the upload. smooth feeds
+// the fix to the Kalman filter; sources without altitude and vertical speed
+// skip it. Caller must hold t.mu.
+func (t *Tracker) applyFixLocked(s *GroupSession, id string, info *TrackInfo, msg *parser.PositionMessage, smooth bool, at time.Time) fixEvents {
+	var ev fixEvents
+	var prevFix TrackPoint
+	if info.Position != nil {
+		prevFix = TrackPoint{Time: info.LastUpdate, Lat: info.Position.Latitude, Lon: info.Position.Longitude, Alt: info.Position.Altitude}
+	}
+	info.Position = msg
+	info.LastUpdate = at
+	info.Predicted = nil
+	if msg.Course > 0 {
+		info.LastHeading = msg.Course
+	}
+	recordVelocity(info, msg, info.LastUpdate)
+	if smooth {
+		smoothBeacon(info, msg, info.LastUpdate)
+	} else if info.Smooth != nil && info.LastUpdate.Sub(info.Smooth.At) > smoothResetAfter {
+		// The filtered state is from an OGN fix long gone; show this
+		// source's own values instead.
+		info.Smooth = nil
+	}
+	recordTrackPoint(info, msg.Latitude, msg.Longitude, msg.Altitude, info.LastUpdate)
+	ev.tzNote = autoTimezone(s, msg.Latitude, msg.Longitude, info.LastUpdate)
+	ev.zoneEvents = zoneEventsLocked(s, id, info, msg.Latitude, msg.Longitude)
+	var step raceStep
+	step, ev.finish = stepRaceLocked(s, id, info, prevFix, TrackPoint{Time: info.LastUpdate, Lat: msg.Latitude, Lon: msg.Longitude, Alt: msg.Altitude})
+	ev.raceChanged = step != raceNone
+	if time.Since(at) <= proximityFreshness {
+		// Backfilled fixes are history; proximity is about now.
+		ev.prox = t.checkProximityLocked(s, id, info, info.LastUpdate)
+	}
+	ev.milestones = t.milestonesLocked(s, id, info)
+	if updateLandingState(info, msg, at) {
+		ev.alert = &landingEvent{
+			id:     id,
+			name:   info.DisplayName(),
+			lat:    msg.Latitude,
+			lon:    msg.Longitude,
+			alt:    msg.Altitude,
+			time:   info.LandingTime,
+			tz:     s.tz(),
+			owner:  info.OwnerUserID,
+			track:  append([]TrackPoint(nil), info.Track...),
+			places: t.places,
+		}
+		slog.Info("landing detected", "id", id, "lat", msg.Latitude, "lon", msg.Longitude, "source", info.Source)
+	}
+	return ev
+}
+
+// deliverFixEvents sends what applyFixLocked produced. Call without t.mu.
+func (t *Tracker) deliverFixEvents(ev fixEvents, chatID int64) {
+	t.announceTimezone(chatID, ev.tzNote)
+	t.sendZoneEvents(ev.zoneEvents, chatID)
+	if ev.finish != nil {
+		t.sendRaceFinish(ev.finish, chatID)
+	}
+	t.sendProximityEvents(ev.prox)
+	t.sendMilestones(ev.milestones, chatID)
+	if ev.alert != nil {
+		t.sendLandingAlert(ev.alert, chatID)
+	}
+}
+
+// externalPosition turns a fix into the beacon shape the rest of the tracker
+// works on. What the source doesn't report is carried over from the pilot's
+// previous position (altitude) or derived from it (speed, course, climb).
+func externalPosition(info *TrackInfo, fix externalFix) *parser.PositionMessage {
+	msg := &parser.PositionMessage{
+		Timestamp:   fix.At,
+		Latitude:    fix.Lat,
+		Longitude:   fix.Lon,
+		Altitude:    fix.Alt,
+		Course:      fix.Course,
+		GroundSpeed: fix.Speed,
+	}
+	prev := info.Position
+	prevAt := info.LastFixTime
+	if prevAt.IsZero() {
+		prevAt = info.LastUpdate
+	}
+	dt := fix.At.Sub(prevAt).Seconds()
+	recent := prev != nil && dt > 0 && dt <= externalDerivedMax.Seconds()
+	if !fix.HasAlt && prev != nil {
+		msg.Altitude = prev.Altitude
+	}
+	if !recent {
+		return msg
+	}
+	distKm, bearing := distanceAndBearing(prev.Latitude, prev.Longitude, fix.Lat, fix.Lon)
+	if !fix.HasSpeed {
+		msg.GroundSpeed = distKm / dt * 3600
+	}
+	if msg.Course == 0 && distKm > 0.02 {
+		msg.Course = int(math.Round(bearing)) % 360
+		if msg.Course == 0 {
+			msg.Course = 360
+		}
+	}
+	if fix.HasAlt {
+		msg.ClimbRate = (fix.Alt - prev.Altitude) / dt
+	}
+	return msg
+}
+
+// applyExternalFixLocked feeds a fix from a non-OGN source to the pilot at
+// id. Returns the reject reason ("" if accepted) and the resulting events.
+// Caller must hold t.mu.
+func (t *Tracker) applyExternalFixLocked(s *GroupSession, id string, info *TrackInfo, fix externalFix) (fixEvents, string) {
+	if !info.LastFixTime.IsZero() {
+		switch {
+		case fix.At.Equal(info.LastFixTime):
+			info.Rejects.add(rejectDuplicate)
+			return fixEvents{}, rejectDuplicate
+		case fix.At.Before(info.LastFixTime):
+			info.Rejects.add(rejectOutOfOrder)
+			return fixEvents{}, rejectOutOfOrder
+		}
+	}
+	msg := externalPosition(info, fix)
+	info.AltitudeUnknown = !fix.HasAlt && (info.Position == nil || info.AltitudeUnknown)
+	info.JumpStreak = 0
+	info.LastFixTime = fix.At
+	info.LastFixScore = 0
+	info.LastFixAccuracy = fix.Accuracy
+	info.Source = fix.Source
+	return t.applyFixLocked(s, id, info, msg, fix.HasAlt && fix.HasSpeed, fix.At), ""
+}
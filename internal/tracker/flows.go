@@ -3,13 +3,10 @@ package tracker
 import (
 	"context"
 	"fmt"
-	"log"
 	"log/slog"
 	"strings"
 	"time"
 
-	"ogn/client"
-
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
@@ -17,14 +14,33 @@ import (
 // execAddDirect adds a pilot by OGN ID directly from /add <id> [name] [@username] in a group.
 // If @username is provided, the bot links the pilot to that Telegram user for DM features.
 // If only a name is provided without @username, the bot cannot send DM to the pilot.
+// A pilot with several devices is added as /add <id>+<id2> [name]; the first
+// ID is the pilot's key (see devices.go).
 func (t *Tracker) execAddDirect(ctx context.Context, b *bot.Bot, m *models.Message, args []string) int {
-	id := shortID(args[0])
-	if !isValidShortID(id) {
-		return t.sendAck(ctx, &bot.SendMessageParams{
-			ChatID: m.Chat.ID,
-			Text:   fmt.Sprintf("OGN ID %q некорректен. Ожидаются 6 hex-символов (0-9, A-F).", args[0]),
-		}, "failed to send invalid ognid message")
+	ids, errText := parseDeviceIDs(args[0])
+	if errText != "" {
+		// Not an OGN ID — try it as a registration, CN or model (see
+		// ddbsearch.go).
+		t.mu.Lock()
+		found, matches := t.lookupDeviceLocked(m.From.ID, args[0], devicePick{chatID: m.Chat.ID, args: args[1:], userMsgID: m.ID})
+		t.mu.Unlock()
+		switch {
+		case len(matches) > 0:
+			t.sendDevicePicker(ctx, m.From.ID, args[0], matches)
+			return 0
+		case found == "":
+			if !strings.ContainsAny(args[0], ",+") {
+				errText = notFoundInDDB(args[0])
+			}
+			return t.sendAck(ctx, &bot.SendMessageParams{
+				ChatID: m.Chat.ID,
+				Text:   errText,
+			}, "failed to send invalid ognid message")
+		}
+		slog.Info("cmd /add resolved via ddb", "query", args[0], "id", found)
+		ids = []string{found}
 	}
+	id := ids[0]
 
 	// Parse remaining args: name tokens and optional @username.
 	var display string
@@ -50,18 +66,30 @@ func (t *Tracker) execAddDirect(ctx context.Context, b *bot.Bot, m *models.Messa
 		}
 	}
 
-	slog.Info("cmd /add", "id", id, "name", display, "username", username, "user_id", m.From.ID)
+	slog.Info("cmd /add", "id", id, "devices", ids[1:], "name", display, "username", username, "user_id", m.From.ID)
 
 	t.mu.Lock()
 	s := t.session
 	s.ChatID = m.Chat.ID
+	// A lone ID that is already one of a pilot's devices means that pilot.
+	if len(ids) == 1 {
+		if key, ok := s.pilotKey(id); ok {
+			id, ids = key, []string{key}
+		}
+	}
 
 	// Try to link to an existing user by @username.
 	var ownerUID int64
 	if pilotUsername != "" {
 		for _, u := range t.users {
 			if strings.EqualFold(u.Username, pilotUsername) {
-				u.OGNID = id
+				if len(ids) > 1 || u.primaryID() != id {
+					u.OGNIDs = ids
+				} else if len(u.OGNIDs) > 1 {
+					// "/add <primary> @user" re-adds the pilot with all
+					// the devices /myid gave.
+					ids = append([]string(nil), u.OGNIDs...)
+				}
 				ownerUID = u.UserID
 				break
 			}
@@ -78,6 +106,11 @@ func (t *Tracker) execAddDirect(ctx context.Context, b *bot.Bot, m *models.Messa
 	} else {
 		s.Tracking[id] = &TrackInfo{Name: display, Username: username, OwnerUserID: ownerUID}
 	}
+	var orphanIDs []int
+	if len(ids) > 1 {
+		orphanIDs = linkDevicesLocked(s, id, ids[1:])
+	}
+	label := deviceLabel(id, s.Tracking[id])
 	t.updateFilter()
 
 	var ddbInfo string
@@ -86,8 +119,9 @@ func (t *Tracker) execAddDirect(ctx context.Context, b *bot.Bot, m *models.Messa
 	}
 	t.saveState()
 	t.mu.Unlock()
+	t.deleteMessagesAsync(m.Chat.ID, orphanIDs...)
 
-	text := "Добавлен " + id
+	text := "Добавлен " + label
 	if display != "" {
 		text += " (" + display + ")"
 	}
@@ -136,6 +170,7 @@ func (t *Tracker) askSessionResetConfirm(ctx context.Context, b *bot.Bot, chatID
 
 // handleLocation dispatches an incoming location message to the appropriate handler:
 // driver (live location), landing point, or area center — depending on what's being awaited.
+// Any other live location is a pilot's (see livelocation.go).
 func (t *Tracker) handleLocation(ctx context.Context, b *bot.Bot, m *models.Message) {
 	loc := m.Location
 
@@ -146,6 +181,11 @@ func (t *Tracker) handleLocation(ctx context.Context, b *bot.Bot, m *models.Mess
 		return
 	}
 
+	// Zone drawing: circle centre or the next polygon point.
+	if t.handleZoneLocation(ctx, b, m) {
+		return
+	}
+
 	// Driver: check if this user is waiting.
 	if d, ok := s.Drivers[m.From.ID]; ok && d.Waiting && time.Now().Before(d.Expiry) {
 		if loc.LivePeriod > 0 {
@@ -181,15 +221,16 @@ func (t *Tracker) handleLocation(ctx context.Context, b *bot.Bot, m *models.Mess
 		slog.Info("landing location set", "lat", loc.Latitude, "lon", loc.Longitude, "user_id", m.From.ID)
 		s.Landing = &Coordinates{Latitude: loc.Latitude, Longitude: loc.Longitude}
 		s.WaitingLanding = false
+		tzNote := autoTimezone(s, loc.Latitude, loc.Longitude, time.Now())
 
-		// Mark the sender as landed if they have a tracked OGN ID.
+		// Mark the sender as landed if they are tracked.
 		var landedName string
-		if u, ok := t.users[m.From.ID]; ok && u.OGNID != "" {
-			if info, ok := s.Tracking[u.OGNID]; ok && info.Status == StatusFlying {
+		if key, ok := t.userPilotKeyLocked(s, m.From.ID); ok {
+			if info := s.Tracking[key]; info.Status == StatusFlying {
 				info.Status = StatusLanded
 				info.LandingTime = time.Now()
 				landedName = info.DisplayName()
-				slog.Info("landing marked", "ogn_id", u.OGNID, "user_id", m.From.ID)
+				slog.Info("landing marked", "ogn_id", key, "user_id", m.From.ID)
 			}
 		}
 
@@ -209,6 +250,7 @@ func (t *Tracker) handleLocation(ctx context.Context, b *bot.Bot, m *models.Mess
 		// The user's location pin itself is left in the chat as a useful
 		// artefact.
 		t.finalizePendingCleanup(m.From.ID, m.Chat.ID, ackID)
+		t.announceTimezone(m.Chat.ID, tzNote)
 		t.refreshDashboard(ctx, m.Chat.ID)
 		return
 	}
@@ -218,6 +260,7 @@ func (t *Tracker) handleLocation(ctx context.Context, b *bot.Bot, m *models.Mess
 		slog.Info("area center set", "lat", loc.Latitude, "lon", loc.Longitude, "radius_km", s.TrackAreaRadius, "user_id", m.From.ID)
 		s.TrackArea = &Coordinates{Latitude: loc.Latitude, Longitude: loc.Longitude}
 		s.WaitingArea = false
+		tzNote := autoTimezone(s, loc.Latitude, loc.Longitude, time.Now())
 		// Remove previously auto-discovered entries when area changes.
 		for id, info := range s.Tracking {
 			if info.AutoDiscovered {
@@ -234,11 +277,17 @@ func (t *Tracker) handleLocation(ctx context.Context, b *bot.Bot, m *models.Mess
 		}, "failed to confirm area location")
 		// Same pattern as landing: drain queue + clean ack, leave the pin.
 		t.finalizePendingCleanup(m.From.ID, m.Chat.ID, ackID)
+		t.announceTimezone(m.Chat.ID, tzNote)
 		t.refreshDashboard(ctx, m.Chat.ID)
 		return
 	}
 
 	t.mu.Unlock()
+
+	// Nothing awaited: a pilot sharing their live location.
+	if loc.LivePeriod > 0 {
+		t.handleLiveLocation(ctx, b, m, false)
+	}
 }
 
 // execSessionReset stops tracking and creates a new session.
@@ -267,6 +316,7 @@ func (t *Tracker) execSessionReset(ctx context.Context, b *bot.Bot, chatID int64
 			if info.MessageID != 0 {
 				orphanMsgIDs = append(orphanMsgIDs, info.MessageID)
 			}
+			orphanMsgIDs = append(orphanMsgIDs, info.RetiredMsgIDs...)
 		}
 	}
 	newSession := &GroupSession{
@@ -310,7 +360,7 @@ func (t *Tracker) execSessionReset(ctx context.Context, b *bot.Bot, chatID int64
 func (t *Tracker) execTrackOn(ctx context.Context, b *bot.Bot, chatID int64) int {
 	t.mu.Lock()
 	s := t.session
-	if len(s.Tracking) == 0 && s.TrackArea == nil {
+	if len(s.Tracking) == 0 && !s.discoveryOn() {
 		t.mu.Unlock()
 		return t.sendAck(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
@@ -343,15 +393,25 @@ func (t *Tracker) execTrackOn(ctx context.Context, b *bot.Bot, chatID int64) int
 		if info.MessageID != 0 {
 			orphanMsgIDs = append(orphanMsgIDs, info.MessageID)
 		}
+		orphanMsgIDs = append(orphanMsgIDs, info.RetiredMsgIDs...)
 		info.Status = StatusFlying
 		info.LandingTime = time.Time{}
 		info.LowSpeedSince = time.Time{}
 		info.MessageID = 0
+		info.MessageSentAt = time.Time{}
+		info.RetiredMsgIDs = nil
 		info.LabelMsgID = 0
 		info.LabelStatus = StatusFlying
 		info.Position = nil
 		info.LastUpdate = time.Time{}
-	}
+		info.Track = nil
+		info.Launch = nil
+		info.MilestonesHit = nil
+		info.Zones = nil
+		info.Signal = nil
+	}
+	s.Proximity = nil
+	s.Receivers = nil
 	// Drop the previous summary's pin (if any) before clearing its ID so the
 	// next tick sends a fresh summary and re-pins it. Unpin is fired async
 	// outside the lock to avoid blocking on a Telegram round-trip.
@@ -363,8 +423,7 @@ func (t *Tracker) execTrackOn(ctx context.Context, b *bot.Bot, chatID int64) int
 	// Set filter before enabling tracking so updateFilter doesn't restart goroutines.
 	t.updateFilter()
 	// Create a fresh APRS client — previous Disconnect() sets killed=true permanently.
-	t.aprs = client.New("N0CALL", t.aprs.Filter)
-	t.aprs.Logger = log.Default()
+	t.aprs = t.newAPRSFeed(t.aprs.Filter)
 	s.TrackingOn = true
 	s.StopCh = make(chan struct{})
 	stopCh := s.StopCh
@@ -483,7 +542,7 @@ func (t *Tracker) execList(ctx context.Context, b *bot.Bot, chatID int64) {
 	s := t.session
 	var entries []string
 	for id, info := range s.Tracking {
-		entry := info.StatusEmoji() + " " + id
+		entry := info.StatusEmoji() + " " + deviceLabel(id, info)
 		if info.Name != "" {
 			entry += " — " + info.Name
 			if info.Username != "" {
@@ -498,8 +557,12 @@ func (t *Tracker) execList(ctx context.Context, b *bot.Bot, chatID int64) {
 		if info.Status == StatusPickedUp {
 			entry += " (забран)"
 		}
-		if info := formatDDBInfo(t.devices, id); info != "" {
-			entry += " [" + info + "]"
+		ddbInfo := formatDDBInfo(t.devices, id)
+		if info.AutoDiscovered {
+			ddbInfo = publicDDBInfo(t.devices, id, info.Position)
+		}
+		if ddbInfo != "" {
+			entry += " [" + ddbInfo + "]"
 		}
 		entries = append(entries, entry)
 	}
@@ -629,8 +692,7 @@ func (t *Tracker) execAreaOff(ctx context.Context, b *bot.Bot, chatID int64) int
 	// Stop radar if it's running — radar requires an area.
 	if s.RadarOn {
 		t.stopRadarAsync()
-		t.aprs = client.New("N0CALL", "")
-		t.aprs.Logger = log.Default()
+		t.aprs = t.newAPRSFeed("")
 	}
 	s.TrackArea = nil
 	s.WaitingArea = false
@@ -691,23 +753,13 @@ func (t *Tracker) execRadarOn(ctx context.Context, b *bot.Bot, chatID int64, rad
 		radiusKm = maxAreaRadius
 	}
 
-	s.RadarOn = true
-	s.RadarRadius = radiusKm
-	s.RadarEntries = make(map[string]*RadarEntry)
 	s.RadarMsgID = 0
-
-	filter := client.RangeFilter(s.TrackArea.Latitude, s.TrackArea.Longitude, radiusKm)
-	t.aprs = client.New("N0CALL", filter)
-	t.aprs.Logger = log.Default()
-	s.RadarStopCh = make(chan struct{})
-	stopCh := s.RadarStopCh
-	aprs := t.aprs
+	t.startRadarLocked(radiusKm)
+	t.saveState()
 	areaLat, areaLon := s.TrackArea.Latitude, s.TrackArea.Longitude
 	t.mu.Unlock()
 
 	slog.Info("radar on", "lat", areaLat, "lon", areaLon, "radius_km", radiusKm, "chat_id", chatID)
-	go t.runRadarClient(stopCh, aprs)
-	go t.sendRadarUpdates(stopCh)
 
 	ackID := t.sendAck(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
@@ -726,8 +778,8 @@ func (t *Tracker) execRadarOff(ctx context.Context, b *bot.Bot, chatID int64) in
 		return 0
 	}
 	t.stopRadarAsync()
-	t.aprs = client.New("N0CALL", "")
-	t.aprs.Logger = log.Default()
+	t.aprs = t.newAPRSFeed("")
+	t.saveState()
 	t.mu.Unlock()
 
 	slog.Info("radar off", "chat_id", chatID)
@@ -782,23 +834,11 @@ func (t *Tracker) execRadarSetRadius(ctx context.Context, b *bot.Bot, chatID int
 		return 0
 	}
 	t.stopRadarAsync()
-
-	s.RadarOn = true
-	s.RadarRadius = radiusKm
-	s.RadarEntries = make(map[string]*RadarEntry)
-	s.RadarMsgID = 0
-
-	filter := client.RangeFilter(s.TrackArea.Latitude, s.TrackArea.Longitude, radiusKm)
-	t.aprs = client.New("N0CALL", filter)
-	t.aprs.Logger = log.Default()
-	s.RadarStopCh = make(chan struct{})
-	stopCh := s.RadarStopCh
-	aprs := t.aprs
+	t.startRadarLocked(radiusKm)
+	t.saveState()
 	t.mu.Unlock()
 
 	slog.Info("radar radius changed", "radius_km", radiusKm, "chat_id", chatID)
-	go t.runRadarClient(stopCh, aprs)
-	go t.sendRadarUpdates(stopCh)
 
 	ackID := t.sendAck(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
@@ -953,8 +993,8 @@ func (t *Tracker) execAddNoArgsPrompt(ctx context.Context, b *bot.Bot, chatID in
 	}
 	u := t.ensureUserByID(userID)
 	u.PendingGroup = s.ChatID
-	hasOGNID := u.OGNID != ""
-	ognID := u.OGNID
+	hasOGNID := len(u.OGNIDs) > 0
+	ognID := strings.Join(u.OGNIDs, ", ")
 	t.saveState()
 	botUsername := t.botUsername
 	groupChatID := s.ChatID
@@ -962,9 +1002,9 @@ func (t *Tracker) execAddNoArgsPrompt(ctx context.Context, b *bot.Bot, chatID in
 
 	var dmText string
 	if hasOGNID {
-		dmText = fmt.Sprintf("Ваш OGN ID: %s\nОтправьте новый ID или /confirm чтобы использовать текущий.", ognID)
+		dmText = fmt.Sprintf("Ваш OGN ID: %s\nОтправьте новый ID (несколько устройств — через пробел) или /confirm чтобы использовать текущий.", ognID)
 	} else {
-		dmText = "Отправьте ваш OGN ID (6-значный адрес трекера):"
+		dmText = "Отправьте ваш OGN ID (6-значный адрес трекера; если устройств несколько — все через пробел):"
 	}
 	_, dmErr := b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: userID,
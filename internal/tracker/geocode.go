@@ -0,0 +1,172 @@
+package tracker
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultPlacesPath is the gazetteer used when PLACES_FILE is unset. A
+// missing file is not an error: place names are simply left out.
+const defaultPlacesPath = "data/places.txt"
+
+const (
+	// maxPlaceDistKm — beyond this the nearest settlement says little about
+	// where the pilot is, so we fall back to coordinates.
+	maxPlaceDistKm = 30.0
+	// atPlaceKm — closer than this the pilot is "in" the place rather than
+	// some distance off it.
+	atPlaceKm = 0.3
+	// placeCellDeg is the size of the lookup grid cell. One degree of
+	// latitude is ~111 km, so searching the cell and its neighbours always
+	// covers maxPlaceDistKm.
+	placeCellDeg = 1.0
+)
+
+// place is one settlement from the gazetteer.
+type place struct {
+	name string
+	lat  float64
+	lon  float64
+}
+
+type placeCell struct{ lat, lon int }
+
+// gazetteer is an immutable, grid-indexed set of places for offline reverse
+// geocoding. Safe for concurrent reads once built.
+type gazetteer struct {
+	cells map[placeCell][]place
+	count int
+}
+
+func cellOf(lat, lon float64) placeCell {
+	return placeCell{int(math.Floor(lat / placeCellDeg)), int(math.Floor(lon / placeCellDeg))}
+}
+
+// parseGeoNamesLine parses one row of a GeoNames dump (cities500.txt,
+// allCountries.txt, …): tab-separated, name in column 1, latitude and
+// longitude in columns 4 and 5, feature class in column 6. Only populated
+// places (class P) are kept. Rows with just "name<TAB>lat<TAB>lon" are also
+// accepted so a hand-made list of villages works too.
+func parseGeoNamesLine(line string) (place, bool) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return place{}, false
+	}
+	f := strings.Split(line, "\t")
+	var name, latS, lonS string
+	switch {
+	case len(f) >= 7:
+		if f[6] != "P" {
+			return place{}, false
+		}
+		name, latS, lonS = f[1], f[4], f[5]
+	case len(f) == 3:
+		name, latS, lonS = f[0], f[1], f[2]
+	default:
+		return place{}, false
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(latS), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(lonS), 64)
+	name = strings.TrimSpace(name)
+	if err1 != nil || err2 != nil || name == "" {
+		return place{}, false
+	}
+	return place{name: name, lat: lat, lon: lon}, true
+}
+
+// newGazetteer indexes places for nearest-neighbour lookups.
+func newGazetteer(places []place) *gazetteer {
+	g := &gazetteer{cells: make(map[placeCell][]place)}
+	for _, p := range places {
+		c := cellOf(p.lat, p.lon)
+		g.cells[c] = append(g.cells[c], p)
+		g.count++
+	}
+	return g
+}
+
+// loadGazetteer reads a GeoNames-style file into a gazetteer.
+func loadGazetteer(path string) (*gazetteer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var places []place
+	sc := bufio.NewScanner(f)
+	// alternatenames in allCountries.txt can exceed the 64 KiB default.
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if p, ok := parseGeoNamesLine(sc.Text()); ok {
+			places = append(places, p)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return newGazetteer(places), nil
+}
+
+// nearest returns the closest place within maxPlaceDistKm of (lat, lon),
+// plus the distance and bearing from that place to the point.
+func (g *gazetteer) nearest(lat, lon float64) (p place, distKm, bearing float64, ok bool) {
+	if g == nil {
+		return place{}, 0, 0, false
+	}
+	c := cellOf(lat, lon)
+	distKm = math.MaxFloat64
+	for dLat := -1; dLat <= 1; dLat++ {
+		for dLon := -1; dLon <= 1; dLon++ {
+			for _, cand := range g.cells[placeCell{c.lat + dLat, c.lon + dLon}] {
+				d, b := distanceAndBearing(cand.lat, cand.lon, lat, lon)
+				if d < distKm {
+					p, distKm, bearing = cand, d, b
+				}
+			}
+		}
+	}
+	if distKm > maxPlaceDistKm {
+		return place{}, 0, 0, false
+	}
+	return p, distKm, bearing, true
+}
+
+// describeLocation renders a point relative to the nearest settlement, e.g.
+// "2.3км NE от Villeneuve" or "в Villeneuve". Without a gazetteer or a
+// place nearby it falls back to plain coordinates.
+func describeLocation(g *gazetteer, lat, lon float64) string {
+	p, d, b, ok := g.nearest(lat, lon)
+	if !ok {
+		return fmt.Sprintf("%.5f, %.5f", lat, lon)
+	}
+	if d < atPlaceKm {
+		return "в " + p.name
+	}
+	return fmt.Sprintf("%.1fкм %s от %s", d, bearingName(b), p.name)
+}
+
+// loadPlaces loads the gazetteer from PLACES_FILE (default
+// data/places.txt) in the background, like loadDevices.
+func (t *Tracker) loadPlaces() {
+	path := os.Getenv("PLACES_FILE")
+	if path == "" {
+		path = defaultPlacesPath
+	}
+	g, err := loadGazetteer(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			slog.Info("no places file; landing positions will be shown as coordinates", "path", path)
+		} else {
+			slog.Error("failed to load places file", "path", path, "err", err)
+		}
+		return
+	}
+	t.mu.Lock()
+	t.places = g
+	t.mu.Unlock()
+	slog.Info("loaded places for reverse geocoding", "path", path, "count", g.count)
+}
@@ -0,0 +1,352 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Phone tracker apps that can't talk to OGN can push positions to the bot
+// over HTTP, in the OsmAnd protocol Traccar Client and OsmAnd use. The
+// server runs only when INGEST_ADDR is set. Each push carries the pilot's
+// token from /token in DM as the device id and is fed to the pilot's entry
+// like any other position source (see fixes.go).
+
+const (
+	ingestTokenBytes = 12
+	maxIngestBody    = 64 << 10
+	// ingestClockSkew — a push stamped further ahead than this is taken as
+	// arriving now, so a phone with a fast clock can't block later fixes.
+	ingestClockSkew = time.Minute
+	knotsToKmh      = 1.852
+)
+
+// newIngestToken returns a fresh random token.
+func newIngestToken() string {
+	b := make([]byte, ingestTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseIngestTime accepts Unix seconds or milliseconds (OsmAnd sends the
+// latter) and RFC 3339; empty means now.
+func parseIngestTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return now, nil
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		if n > 1e11 {
+			return time.UnixMilli(int64(n)), nil
+		}
+		return time.Unix(int64(n), 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// validCoords reports whether lat, lon is a real position: finite and within
+// ±90 / ±180.
+func validCoords(lat, lon float64) bool {
+	return !math.IsNaN(lat) && !math.IsNaN(lon) && math.Abs(lat) <= 90 && math.Abs(lon) <= 180
+}
+
+// parseIngest reads a push: OsmAnd-style query or form parameters
+// (id, lat, lon, timestamp, altitude, speed in knots, bearing, accuracy or
+// hdop), or Traccar Client's JSON body (speed in m/s). Returns the token and
+// the fix.
+func parseIngest(r *http.Request, now time.Time) (string, externalFix, error) {
+	fix := externalFix{Source: sourceApp}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			DeviceID string `json:"device_id"`
+			Location struct {
+				Timestamp string `json:"timestamp"`
+				Coords    struct {
+					Latitude  *float64 `json:"latitude"`
+					Longitude *float64 `json:"longitude"`
+					Accuracy  float64  `json:"accuracy"`
+					Speed     *float64 `json:"speed"`
+					Heading   *float64 `json:"heading"`
+					Altitude  *float64 `json:"altitude"`
+				} `json:"coords"`
+			} `json:"location"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxIngestBody)).Decode(&body); err != nil {
+			return "", fix, fmt.Errorf("decode body: %w", err)
+		}
+		c := body.Location.Coords
+		if c.Latitude == nil || c.Longitude == nil {
+			return "", fix, errors.New("no coordinates")
+		}
+		if !validCoords(*c.Latitude, *c.Longitude) {
+			return "", fix, errors.New("coordinates out of range")
+		}
+		at, err := parseIngestTime(body.Location.Timestamp, now)
+		if err != nil {
+			return "", fix, fmt.Errorf("timestamp: %w", err)
+		}
+		fix.At, fix.Lat, fix.Lon, fix.Accuracy = at, *c.Latitude, *c.Longitude, c.Accuracy
+		if c.Altitude != nil {
+			fix.Alt, fix.HasAlt = *c.Altitude, true
+		}
+		if c.Speed != nil && *c.Speed >= 0 {
+			fix.Speed, fix.HasSpeed = *c.Speed*3.6, true
+		}
+		if c.Heading != nil && *c.Heading >= 0 {
+			fix.Course = headingCourse(*c.Heading)
+		}
+		return body.DeviceID, fix, nil
+	}
+
+	r.Body = http.MaxBytesReader(nil, r.Body, maxIngestBody)
+	if err := r.ParseForm(); err != nil {
+		return "", fix, err
+	}
+	num := func(names ...string) (float64, bool, error) {
+		for _, n := range names {
+			if v := r.Form.Get(n); v != "" {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return 0, false, fmt.Errorf("%s: %w", n, err)
+				}
+				if math.IsNaN(f) || math.IsInf(f, 0) {
+					return 0, false, fmt.Errorf("%s: not a finite number", n)
+				}
+				return f, true, nil
+			}
+		}
+		return 0, false, nil
+	}
+	token := r.Form.Get("id")
+	if token == "" {
+		token = r.Form.Get("deviceid")
+	}
+	lat, okLat, err1 := num("lat")
+	lon, okLon, err2 := num("lon")
+	if err := errors.Join(err1, err2); err != nil {
+		return "", fix, err
+	}
+	if !okLat || !okLon {
+		return "", fix, errors.New("no coordinates")
+	}
+	if !validCoords(lat, lon) {
+		return "", fix, errors.New("coordinates out of range")
+	}
+	at, err := parseIngestTime(r.Form.Get("timestamp"), now)
+	if err != nil {
+		return "", fix, fmt.Errorf("timestamp: %w", err)
+	}
+	fix.At, fix.Lat, fix.Lon = at, lat, lon
+	var ok bool
+	if fix.Alt, fix.HasAlt, err = num("altitude"); err != nil {
+		return "", fix, err
+	}
+	var speed float64
+	if speed, ok, err = num("speed"); err != nil {
+		return "", fix, err
+	} else if ok && speed >= 0 {
+		fix.Speed, fix.HasSpeed = speed*knotsToKmh, true
+	}
+	var heading float64
+	if heading, ok, err = num("bearing", "heading"); err != nil {
+		return "", fix, err
+	} else if ok && heading >= 0 {
+		fix.Course = headingCourse(heading)
+	}
+	// OsmAnd's {3} "hdop" placeholder is the accuracy in metres.
+	if fix.Accuracy, _, err = num("accuracy", "hdop"); err != nil {
+		return "", fix, err
+	}
+	return token, fix, nil
+}
+
+// headingCourse converts a heading in degrees to a beacon course (1–360,
+// 0 is "unknown").
+func headingCourse(h float64) int {
+	c := int(h+0.5) % 360
+	if c == 0 {
+		c = 360
+	}
+	return c
+}
+
+// userByIngestTokenLocked finds the user a push belongs to. Caller must hold
+// t.mu.
+func (t *Tracker) userByIngestTokenLocked(token string) *UserInfo {
+	if token == "" {
+		return nil
+	}
+	for _, u := range t.users {
+		if u.IngestToken != "" && subtle.ConstantTimeCompare([]byte(u.IngestToken), []byte(token)) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+// serveIngest handles one push. Once the token checks out the reply is 200
+// even if the fix is not used (no session, tracking off, stale fix, pilot
+// not in the group): apps queue and resend anything else forever.
+func (t *Tracker) serveIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	now := time.Now()
+	token, fix, err := parseIngest(r, now)
+	if err != nil {
+		slog.Debug("ingest: bad request", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fix.At.After(now.Add(ingestClockSkew)) {
+		fix.At = now
+	}
+
+	t.mu.Lock()
+	u := t.userByIngestTokenLocked(token)
+	if u == nil {
+		t.mu.Unlock()
+		slog.Warn("ingest: unknown token", "remote", r.RemoteAddr)
+		http.Error(w, "unknown device id", http.StatusForbidden)
+		return
+	}
+	s := t.session
+	if s == nil || !s.TrackingOn {
+		t.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	key, ok := t.userPilotKeyLocked(s, u.UserID)
+	var added string
+	if !ok {
+		if !invitedLocked(s, u) {
+			t.mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		key, added = t.addUserPilotLocked(s, u)
+		slog.Info("pilot added by tracker app", "key", key, "user_id", u.UserID)
+	}
+	info := s.Tracking[key]
+	var ev fixEvents
+	if info.Status != StatusPickedUp && !(info.Status == StatusLanded && info.LandingConfirmed) {
+		var reason string
+		ev, reason = t.applyExternalFixLocked(s, key, info, fix)
+		slog.Debug("ingest fix", "key", key, "user_id", u.UserID,
+			"lat", fix.Lat, "lon", fix.Lon, "alt", fix.Alt, "speed", fix.Speed,
+			"ts", fix.At.Format("15:04:05"), "rejected", reason)
+	}
+	chatID := s.ChatID
+	if ev.needsSave() || added != "" {
+		t.saveState()
+	}
+	t.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+
+	t.deliverFixEvents(ev, chatID)
+	if added != "" && t.bot != nil {
+		ctx := context.Background()
+		ackID := t.sendAck(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("📲 %s отслеживается через приложение-трекер", added),
+		}, "failed to confirm app pilot")
+		t.scheduleEphemeralDelete(chatID, ackID)
+		t.refreshDashboard(ctx, chatID)
+	}
+}
+
+// startIngest runs the HTTP ingest server on addr until Shutdown.
+func (t *Tracker) startIngest(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", t.serveIngest)
+	t.ingest = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		slog.Info("ingest server listening", "addr", addr)
+		if err := t.ingest.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("ingest server failed", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// ingestURL is the server address to give pilots: INGEST_URL, or a
+// placeholder host with the INGEST_ADDR port.
+func ingestURL() string {
+	if u := os.Getenv("INGEST_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	addr := os.Getenv("INGEST_ADDR")
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		addr = addr[i:]
+	}
+	return "http://<сервер>" + addr
+}
+
+// cmdToken is the DM command that issues the pilot's tracker-app token:
+// /token shows it (creating one on first use), /token new replaces it,
+// /token off revokes it.
+func (t *Tracker) cmdToken(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	reply := func(text string) {
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   text,
+		}); err != nil {
+			slog.Error("failed to send token reply", "err", err)
+		}
+	}
+	if !isPrivateChat(m.Chat) {
+		reply("Эта команда работает только в личке.")
+		return
+	}
+	if t.ingest == nil {
+		reply("Приём позиций от приложений на этом боте не включён (INGEST_ADDR).")
+		return
+	}
+
+	arg := strings.ToLower(commandArgs(m.Text))
+	t.mu.Lock()
+	u := t.ensureUser(m.From)
+	u.DMChatID = m.Chat.ID
+	switch {
+	case arg == "off":
+		u.IngestToken = ""
+	case arg == "new" || u.IngestToken == "":
+		u.IngestToken = newIngestToken()
+	}
+	token := u.IngestToken
+	t.saveState()
+	t.mu.Unlock()
+	slog.Info("cmd /token", "user_id", m.From.ID, "arg", arg, "active", token != "")
+
+	if token == "" {
+		reply("Токен отозван — позиции от приложения больше не принимаются. /token — выдать новый.")
+		return
+	}
+	base := ingestURL()
+	reply(fmt.Sprintf("📲 Ваш токен: %s\n\n"+
+		"Traccar Client: адрес сервера %s, идентификатор устройства — токен.\n"+
+		"OsmAnd (онлайн-трекинг), URL:\n%s/?id=%s&lat={0}&lon={1}&timestamp={2}&hdop={3}&altitude={4}&bearing={6}\n\n"+
+		"Позиции идут в вашу запись в группе, как с OGN-трекера. Не пересылайте токен другим. "+
+		"/token new — заменить, /token off — отозвать.",
+		token, base, base, token))
+}
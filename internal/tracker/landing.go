@@ -32,6 +32,15 @@ type landingEvent struct {
 	alt  float64
 	time time.Time
 	tz   *time.Location
+	// owner is the pilot's Telegram user ID (0 if unknown); stored with the
+	// scored flight.
+	owner int64
+	// track is a copy of the pilot's recorded fixes, taken under the lock so
+	// the XC scorer can run without it.
+	track []TrackPoint
+	// places is the gazetteer (may be nil) used to say where the pilot came
+	// down. Immutable, so the pointer is safe to use off-lock.
+	places *gazetteer
 }
 
 // updateLandingState advances the pilot's "on the ground" timer based on the
@@ -48,8 +57,14 @@ func updateLandingState(info *TrackInfo, msg *parser.PositionMessage, now time.T
 		return false
 	}
 
-	onGround := msg.GroundSpeed < landingSpeedThreshold &&
-		math.Abs(msg.ClimbRate) < landingClimbThreshold
+	// Smoothed values when available: a single noisy beacon shouldn't
+	// start or cancel the timer.
+	speed, climb := msg.GroundSpeed, msg.ClimbRate
+	if sm := info.Smooth; sm != nil {
+		speed, climb = sm.Speed, sm.Climb
+	}
+	onGround := speed < landingSpeedThreshold &&
+		math.Abs(climb) < landingClimbThreshold
 
 	if !onGround {
 		info.LowSpeedSince = time.Time{}
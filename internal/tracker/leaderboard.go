@@ -0,0 +1,192 @@
+package tracker
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// seasonBestFlights is how many of a pilot's best flights count toward the
+	// season total, as in XContest's ranking.
+	seasonBestFlights = 6
+	// flightRetention bounds how long flight records are kept on disk. A bit
+	// over a year so the previous season is still visible in early spring.
+	flightRetention = 400 * 24 * time.Hour
+	// leaderboardSize caps the number of rows per table.
+	leaderboardSize = 10
+)
+
+// FlightRecord is one scored flight in the club logbook. Persisted so the
+// season leaderboard survives restarts.
+type FlightRecord struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	OwnerUserID int64     `json:"owner_user_id,omitempty"`
+	ChatID      int64     `json:"chat_id"`
+	Landed      time.Time `json:"landed"`
+	Kind        xcKind    `json:"kind"`
+	DistKm      float64   `json:"dist_km"`
+	Points      float64   `json:"points"`
+	MaxAlt      float64   `json:"max_alt,omitempty"`
+}
+
+// label returns the human-readable pilot label for leaderboard rows.
+func (f *FlightRecord) label() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.ID
+}
+
+// scoreLanding scores the track attached to a landing event and stores the
+// result in the flight log. Returns the score so the landing alert can show
+// it. Locks t.mu internally; the scoring itself runs outside the lock.
+func (t *Tracker) scoreLanding(e *landingEvent, chatID int64) xcResult {
+	res := scoreFlight(e.track)
+	if res.Best.Points <= 0 {
+		return res
+	}
+	var maxAlt float64
+	for _, p := range e.track {
+		if p.Alt > maxAlt {
+			maxAlt = p.Alt
+		}
+	}
+	rec := FlightRecord{
+		ID:          e.id,
+		Name:        e.name,
+		OwnerUserID: e.owner,
+		ChatID:      chatID,
+		Landed:      e.time,
+		Kind:        res.Best.Kind,
+		DistKm:      res.Best.DistKm,
+		Points:      res.Best.Points,
+		MaxAlt:      maxAlt,
+	}
+	t.mu.Lock()
+	t.flights = appendFlight(t.flights, rec, time.Now())
+	t.saveState()
+	t.mu.Unlock()
+	slog.Info("flight scored", "id", e.id, "kind", res.Best.Kind.String(), "dist_km", res.Best.DistKm, "points", res.Best.Points)
+	return res
+}
+
+// appendFlight adds rec to the log and drops records older than
+// flightRetention relative to now. Pure helper.
+func appendFlight(flights []FlightRecord, rec FlightRecord, now time.Time) []FlightRecord {
+	out := flights[:0:0]
+	for _, f := range flights {
+		if now.Sub(f.Landed) <= flightRetention {
+			out = append(out, f)
+		}
+	}
+	return append(out, rec)
+}
+
+// leaderboardRow is one aggregated line of a leaderboard table.
+type leaderboardRow struct {
+	label   string
+	points  float64
+	flights int
+	best    FlightRecord
+}
+
+// dayLeaderboard ranks pilots by their single best flight on the calendar day
+// of now (in tz) for the given chat.
+func dayLeaderboard(flights []FlightRecord, chatID int64, now time.Time, tz *time.Location) []leaderboardRow {
+	y, m, d := now.In(tz).Date()
+	best := make(map[string]*leaderboardRow)
+	for _, f := range flights {
+		if f.ChatID != chatID {
+			continue
+		}
+		fy, fm, fd := f.Landed.In(tz).Date()
+		if fy != y || fm != m || fd != d {
+			continue
+		}
+		row, ok := best[f.ID]
+		if !ok {
+			row = &leaderboardRow{}
+			best[f.ID] = row
+		}
+		row.flights++
+		if f.Points > row.points {
+			row.points = f.Points
+			row.best = f
+			row.label = f.label()
+		}
+	}
+	return sortLeaderboard(best)
+}
+
+// seasonLeaderboard ranks pilots by the sum of their seasonBestFlights best
+// flights within the calendar year of now (in tz) for the given chat.
+func seasonLeaderboard(flights []FlightRecord, chatID int64, now time.Time, tz *time.Location) []leaderboardRow {
+	year := now.In(tz).Year()
+	byPilot := make(map[string][]FlightRecord)
+	for _, f := range flights {
+		if f.ChatID != chatID || f.Landed.In(tz).Year() != year {
+			continue
+		}
+		byPilot[f.ID] = append(byPilot[f.ID], f)
+	}
+	rows := make(map[string]*leaderboardRow, len(byPilot))
+	for id, fs := range byPilot {
+		sort.Slice(fs, func(i, j int) bool { return fs[i].Points > fs[j].Points })
+		row := &leaderboardRow{label: fs[0].label(), best: fs[0], flights: len(fs)}
+		for i, f := range fs {
+			if i >= seasonBestFlights {
+				break
+			}
+			row.points += f.Points
+		}
+		rows[id] = row
+	}
+	return sortLeaderboard(rows)
+}
+
+// sortLeaderboard flattens and orders rows by points (descending), breaking
+// ties by label so the output is stable.
+func sortLeaderboard(m map[string]*leaderboardRow) []leaderboardRow {
+	rows := make([]leaderboardRow, 0, len(m))
+	for _, r := range m {
+		rows = append(rows, *r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].points != rows[j].points {
+			return rows[i].points > rows[j].points
+		}
+		return rows[i].label < rows[j].label
+	})
+	if len(rows) > leaderboardSize {
+		rows = rows[:leaderboardSize]
+	}
+	return rows
+}
+
+// buildLeaderboard renders the /leaderboard message: today's best flights
+// followed by the season ranking.
+func buildLeaderboard(flights []FlightRecord, chatID int64, now time.Time, tz *time.Location) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🏆 Сегодня (%s)", now.In(tz).Format("02.01"))
+	day := dayLeaderboard(flights, chatID, now, tz)
+	if len(day) == 0 {
+		sb.WriteString("\nПока нет засчитанных полётов")
+	}
+	for i, r := range day {
+		fmt.Fprintf(&sb, "\n%d. %s — %s", i+1, r.label, formatXCScore(xcScore{Kind: r.best.Kind, DistKm: r.best.DistKm, Points: r.best.Points}))
+	}
+
+	fmt.Fprintf(&sb, "\n\n🏆 Сезон %d (лучшие %d полётов)", now.In(tz).Year(), seasonBestFlights)
+	season := seasonLeaderboard(flights, chatID, now, tz)
+	if len(season) == 0 {
+		sb.WriteString("\nПока нет засчитанных полётов")
+	}
+	for i, r := range season {
+		fmt.Fprintf(&sb, "\n%d. %s — %.1f балла (%d пол.)", i+1, r.label, r.points, r.flights)
+	}
+	return sb.String()
+}
@@ -0,0 +1,135 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// A pilot's Telegram live location — shared in the group or to the bot in
+// DM — is a secondary position source for their entry: in valleys without
+// OGN receivers it keeps the pilot on the dashboard, and a pilot without any
+// OGN device can be tracked on it alone (keyed by userKey).
+
+// userPilotKeyLocked finds the session entry of a Telegram user: their OGN
+// ID (or a device linked to it), their OGN-less entry, or an entry /add
+// linked to them. Caller must hold t.mu.
+func (t *Tracker) userPilotKeyLocked(s *GroupSession, userID int64) (string, bool) {
+	if u, ok := t.users[userID]; ok && u.primaryID() != "" {
+		if key, ok := s.pilotKey(u.primaryID()); ok {
+			return key, true
+		}
+	}
+	if _, ok := s.Tracking[userKey(userID)]; ok {
+		return userKey(userID), true
+	}
+	for key, info := range s.Tracking {
+		if info.OwnerUserID == userID && !info.AutoDiscovered {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// addUserPilotLocked adds a user who isn't tracked yet to the session, under
+// their OGN ID or, without one, userKey. Returns the key and the name to
+// announce. Caller must hold t.mu.
+func (t *Tracker) addUserPilotLocked(s *GroupSession, u *UserInfo) (key, name string) {
+	key = u.primaryID()
+	if key == "" {
+		key = userKey(u.UserID)
+	}
+	info := &TrackInfo{Name: u.DisplayName, Username: u.Username, OwnerUserID: u.UserID}
+	s.Tracking[key] = info
+	if !isUserKey(key) {
+		t.updateFilter()
+	}
+	name = info.DisplayName()
+	if name == "" {
+		name = key
+	}
+	return key, name
+}
+
+// invitedLocked reports whether a user without an entry may be added to the
+// session by a position that doesn't come through the group itself (tracker
+// app, satellite feed): only one the group invited with /add, which leaves
+// PendingGroup set. Anyone can DM the bot, so nothing else vouches for them.
+// Caller must hold t.mu.
+func invitedLocked(s *GroupSession, u *UserInfo) bool {
+	return u.PendingGroup == s.ChatID
+}
+
+// handleLiveLocation feeds a live-location message, or an edit of one, to
+// the sender's entry while tracking is on; a pilot who isn't tracked yet is
+// added by their first live location in the group. Drivers' locations are handled
+// elsewhere and ignored here.
+func (t *Tracker) handleLiveLocation(ctx context.Context, b *bot.Bot, m *models.Message, edited bool) {
+	if m.From == nil || m.Location == nil {
+		return
+	}
+	loc := m.Location
+	at := time.Unix(int64(m.Date), 0)
+	if edited && m.EditDate != 0 {
+		at = time.Unix(int64(m.EditDate), 0)
+	}
+
+	t.mu.Lock()
+	s := t.session
+	if s == nil || !s.TrackingOn || (!isPrivateChat(m.Chat) && m.Chat.ID != s.ChatID) {
+		t.mu.Unlock()
+		return
+	}
+	if _, ok := s.Drivers[m.From.ID]; ok {
+		t.mu.Unlock()
+		return
+	}
+	key, ok := t.userPilotKeyLocked(s, m.From.ID)
+	var added string
+	if !ok {
+		// Only the group's own chat vouches for a new pilot: anyone can DM
+		// the bot, so a DM location only updates an existing entry.
+		if edited || isPrivateChat(m.Chat) {
+			t.mu.Unlock()
+			return
+		}
+		key, added = t.addUserPilotLocked(s, t.ensureUser(m.From))
+		slog.Info("pilot added by telegram live location", "key", key, "user_id", m.From.ID)
+	}
+	info := s.Tracking[key]
+	var ev fixEvents
+	if info.Status != StatusPickedUp && !(info.Status == StatusLanded && info.LandingConfirmed) {
+		var reason string
+		ev, reason = t.applyExternalFixLocked(s, key, info, externalFix{
+			Source:   sourceTelegram,
+			At:       at,
+			Lat:      loc.Latitude,
+			Lon:      loc.Longitude,
+			Course:   loc.Heading,
+			Accuracy: loc.HorizontalAccuracy,
+		})
+		slog.Debug("telegram live location", "key", key, "user_id", m.From.ID,
+			"lat", loc.Latitude, "lon", loc.Longitude, "acc", loc.HorizontalAccuracy,
+			"edited", edited, "rejected", reason)
+	}
+	chatID := s.ChatID
+	if ev.needsSave() || added != "" {
+		t.saveState()
+	}
+	t.mu.Unlock()
+
+	t.deliverFixEvents(ev, chatID)
+	if added == "" {
+		return
+	}
+	ackID := t.sendAck(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("📱 %s отслеживается по Telegram-геолокации", added),
+	}, "failed to confirm live-location pilot")
+	t.scheduleEphemeralDelete(chatID, ackID)
+	t.refreshDashboard(ctx, chatID)
+}
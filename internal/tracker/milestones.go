@@ -0,0 +1,256 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+)
+
+// Default milestone thresholds used until a chat configures its own with
+// /milestones.
+var (
+	defaultAltMilestones  = []int{2000, 3000, 4000}
+	defaultDistMilestones = []int{10, 25, 50, 100}
+)
+
+// maxMilestones bounds how many thresholds a list may hold.
+const maxMilestones = 10
+
+// MilestoneConfig is the per-chat milestone configuration. A nil config on
+// the session means "defaults".
+type MilestoneConfig struct {
+	Off    bool  `json:"off,omitempty"`   // announcements muted
+	AltM   []int `json:"alt_m"`           // altitude thresholds, metres MSL
+	DistKm []int `json:"dist_km"`         // distance-from-launch thresholds, km
+	NoPB   bool  `json:"no_pb,omitempty"` // skip personal-best altitude
+}
+
+// defaultMilestoneConfig returns a fresh copy of the defaults.
+func defaultMilestoneConfig() MilestoneConfig {
+	return MilestoneConfig{
+		AltM:   append([]int(nil), defaultAltMilestones...),
+		DistKm: append([]int(nil), defaultDistMilestones...),
+	}
+}
+
+// milestones returns the session's effective milestone configuration.
+func (s *GroupSession) milestones() MilestoneConfig {
+	if s == nil || s.Milestones == nil {
+		return defaultMilestoneConfig()
+	}
+	return *s.Milestones
+}
+
+// milestoneEvent is one announcement, built under the lock and sent after.
+type milestoneEvent struct {
+	text string
+}
+
+// personalBestAlt returns the highest MaxAlt among the pilot's logged
+// flights, or 0 if none.
+func personalBestAlt(flights []FlightRecord, id string) float64 {
+	var best float64
+	for _, f := range flights {
+		if f.ID == id && f.MaxAlt > best {
+			best = f.MaxAlt
+		}
+	}
+	return best
+}
+
+// checkMilestones records which milestones the fix crosses and returns the
+// announcements due. Only the highest newly crossed threshold of each kind
+// is announced; lower ones are marked as reached silently so a pilot whose
+// fix jumps to 3100 m doesn't get "2000 m" and "3000 m" at once. The first
+// fix records the launch and marks altitudes it is already above without
+// announcing them: a 2100 m launch is not a "2000 m" milestone.
+// Mutates info.Launch and info.MilestonesHit. Pure otherwise; caller holds
+// the lock protecting info.
+func checkMilestones(cfg MilestoneConfig, label string, info *TrackInfo, lat, lon, alt, personalBest float64) []milestoneEvent {
+	if info.MilestonesHit == nil {
+		info.MilestonesHit = make(map[string]bool)
+	}
+	if info.Launch == nil {
+		info.Launch = &Coordinates{Latitude: lat, Longitude: lon}
+		for _, m := range cfg.AltM {
+			if alt >= float64(m) {
+				info.MilestonesHit["alt:"+strconv.Itoa(m)] = true
+			}
+		}
+		if personalBest > 0 && alt > personalBest {
+			info.MilestonesHit["pb"] = true
+		}
+		return nil
+	}
+	var events []milestoneEvent
+
+	best := 0
+	for _, m := range cfg.AltM {
+		key := "alt:" + strconv.Itoa(m)
+		if alt >= float64(m) && !info.MilestonesHit[key] {
+			info.MilestonesHit[key] = true
+			best = max(best, m)
+		}
+	}
+	if best > 0 {
+		events = append(events, milestoneEvent{fmt.Sprintf("🎉 %s — высота %d м!", label, best)})
+	}
+
+	dist, _ := distanceAndBearing(info.Launch.Latitude, info.Launch.Longitude, lat, lon)
+	best = 0
+	for _, m := range cfg.DistKm {
+		key := "dist:" + strconv.Itoa(m)
+		if dist >= float64(m) && !info.MilestonesHit[key] {
+			info.MilestonesHit[key] = true
+			best = max(best, m)
+		}
+	}
+	if best > 0 {
+		events = append(events, milestoneEvent{fmt.Sprintf("🎉 %s — %d км от старта!", label, best)})
+	}
+
+	if !cfg.NoPB && personalBest > 0 && alt > personalBest && !info.MilestonesHit["pb"] {
+		info.MilestonesHit["pb"] = true
+		events = append(events, milestoneEvent{fmt.Sprintf("🏅 %s — личный рекорд высоты: %.0f м (был %.0f м)", label, alt, personalBest)})
+	}
+	// Muted chats still record what was reached, so unmuting mid-flight
+	// doesn't replay old milestones.
+	if cfg.Off {
+		return nil
+	}
+	return events
+}
+
+// milestonesLocked runs the milestone checks for a fresh fix of a tracked
+// pilot. Caller must hold t.mu.
+func (t *Tracker) milestonesLocked(s *GroupSession, id string, info *TrackInfo) []milestoneEvent {
+	if info.AutoDiscovered || info.Status != StatusFlying || info.Position == nil {
+		return nil
+	}
+	label := info.DisplayName()
+	if label == "" {
+		label = id
+	}
+	pos := info.Position
+	cfg := s.milestones()
+	var pb float64
+	if !cfg.NoPB && !info.MilestonesHit["pb"] {
+		pb = personalBestAlt(t.flights, id)
+	}
+	events := checkMilestones(cfg, label, info, pos.Latitude, pos.Longitude, info.altitude(), pb)
+	for _, e := range events {
+		slog.Info("milestone", "id", id, "text", e.text)
+	}
+	return events
+}
+
+// sendMilestones posts milestone announcements to the group. Must be called
+// outside t.mu.
+func (t *Tracker) sendMilestones(events []milestoneEvent, chatID int64) {
+	b := t.bot
+	if b == nil {
+		return
+	}
+	for _, e := range events {
+		if _, err := b.SendMessage(context.Background(), &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   e.text,
+		}); err != nil {
+			slog.Error("failed to send milestone", "err", err)
+		}
+	}
+}
+
+// describeMilestones renders the /milestones status reply.
+func describeMilestones(cfg MilestoneConfig) string {
+	join := func(vals []int, unit string) string {
+		if len(vals) == 0 {
+			return "—"
+		}
+		parts := make([]string, len(vals))
+		for i, v := range vals {
+			parts[i] = strconv.Itoa(v)
+		}
+		return strings.Join(parts, ", ") + " " + unit
+	}
+	state := "вкл."
+	if cfg.Off {
+		state = "выкл."
+	}
+	pb := "вкл."
+	if cfg.NoPB {
+		pb = "выкл."
+	}
+	return fmt.Sprintf("🎉 Вехи: %s\nВысота: %s\nОт старта: %s\nЛичный рекорд высоты: %s",
+		state, join(cfg.AltM, "м"), join(cfg.DistKm, "км"), pb)
+}
+
+// applyMilestoneArgs updates cfg from /milestones arguments:
+//
+//	on | off           — unmute / mute all announcements
+//	alt <m…> | alt off — altitude thresholds
+//	dist <km…> | dist off
+//	pb on | pb off     — personal-best altitude
+//
+// Returns false on malformed input.
+func applyMilestoneArgs(cfg *MilestoneConfig, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	parseList := func(vals []string) ([]int, bool) {
+		if len(vals) == 1 && strings.EqualFold(vals[0], "off") {
+			return []int{}, true
+		}
+		if len(vals) == 0 || len(vals) > maxMilestones {
+			return nil, false
+		}
+		out := make([]int, 0, len(vals))
+		for _, v := range vals {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, false
+			}
+			out = append(out, n)
+		}
+		sort.Ints(out)
+		return out, true
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		cfg.Off = false
+	case "off":
+		cfg.Off = true
+	case "alt":
+		l, ok := parseList(args[1:])
+		if !ok {
+			return false
+		}
+		cfg.AltM = l
+	case "dist":
+		l, ok := parseList(args[1:])
+		if !ok {
+			return false
+		}
+		cfg.DistKm = l
+	case "pb":
+		if len(args) != 2 {
+			return false
+		}
+		switch strings.ToLower(args[1]) {
+		case "on":
+			cfg.NoPB = false
+		case "off":
+			cfg.NoPB = true
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
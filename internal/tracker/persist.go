@@ -14,27 +14,47 @@ const sessionFile = "data/session.json"
 type appState struct {
 	Session *sessionState        `json:"session,omitempty"`
 	Users   map[int64]*userState `json:"users,omitempty"`
+	Flights []FlightRecord       `json:"flights,omitempty"`
 }
 
 // userState is the JSON-serialisable snapshot of a user's profile.
 type userState struct {
-	Username    string `json:"username,omitempty"`
-	OGNID       string `json:"ogn_id,omitempty"`
-	DisplayName string `json:"display_name,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	OGNIDs      []string `json:"ogn_ids,omitempty"`
+	DisplayName string   `json:"display_name,omitempty"`
+	// LegacyOGNID is the single-device field used before OGNIDs. Read-only
+	// on load (see loadState); never written.
+	LegacyOGNID string `json:"ogn_id,omitempty"`
 	DMChatID    int64  `json:"dm_chat_id,omitempty"`
+	BuddyAlerts bool   `json:"buddy_alerts,omitempty"`
+	IngestToken string `json:"ingest_token,omitempty"`
+	FeedURL     string `json:"feed_url,omitempty"`
 }
 
 // sessionState is the JSON-serialisable snapshot of a group session.
 type sessionState struct {
-	ChatID          int64                  `json:"chat_id"`
-	TrackingOn      bool                   `json:"tracking_on"`
-	Tracking        map[string]*pilotState `json:"tracking,omitempty"`
-	Landing         *Coordinates           `json:"landing,omitempty"`
-	TrackArea       *Coordinates           `json:"track_area,omitempty"`
-	TrackAreaRadius int                    `json:"track_area_radius,omitempty"`
-	Timezone        string                 `json:"timezone,omitempty"`
-	DashboardMsgID  int                    `json:"dashboard_msg_id,omitempty"`
-	DashboardPinned bool                   `json:"dashboard_pinned,omitempty"`
+	ChatID          int64                    `json:"chat_id"`
+	TrackingOn      bool                     `json:"tracking_on"`
+	Tracking        map[string]*pilotState   `json:"tracking,omitempty"`
+	Landing         *Coordinates             `json:"landing,omitempty"`
+	TrackArea       *Coordinates             `json:"track_area,omitempty"`
+	TrackAreaRadius int                      `json:"track_area_radius,omitempty"`
+	Timezone        string                   `json:"timezone,omitempty"`
+	DashboardMsgID  int                      `json:"dashboard_msg_id,omitempty"`
+	DashboardPinned bool                     `json:"dashboard_pinned,omitempty"`
+	Task            *RaceTask                `json:"task,omitempty"`
+	Race            map[string]*RaceProgress `json:"race,omitempty"`
+	Milestones      *MilestoneConfig         `json:"milestones,omitempty"`
+	Sunset          *SunsetConfig            `json:"sunset,omitempty"`
+	SunsetNotified  map[string]bool          `json:"sunset_notified,omitempty"`
+	Zones           []Zone                   `json:"zones,omitempty"`
+	Discovery       *DiscoveryRules          `json:"discovery,omitempty"`
+	RadarOptions    *RadarOptions            `json:"radar_options,omitempty"`
+	PredictOff      bool                     `json:"predict_off,omitempty"`
+	// Radar mode is resumed after a restart and keeps editing RadarMsgID.
+	RadarOn     bool `json:"radar_on,omitempty"`
+	RadarRadius int  `json:"radar_radius,omitempty"`
+	RadarMsgID  int  `json:"radar_msg_id,omitempty"`
 	// Legacy field names used by deployments prior to the dashboard rename.
 	// Read-only on load (see loadState); never written.
 	LegacySummaryMsgID  int  `json:"summary_msg_id,omitempty"`
@@ -50,6 +70,7 @@ type pilotState struct {
 	LandingConfirmed bool        `json:"landing_confirmed,omitempty"`
 	AutoDiscovered   bool        `json:"auto_discovered,omitempty"`
 	OwnerUserID      int64       `json:"owner_user_id,omitempty"`
+	Devices          []string    `json:"devices,omitempty"`
 	// MessageID lets us continue editing the existing live-location message
 	// after a restart instead of orphaning it. Telegram messages live for 24h.
 	MessageID int `json:"message_id,omitempty"`
@@ -64,10 +85,17 @@ type pilotState struct {
 	// verdict so a restart doesn't waste an API round-trip rediscovering a
 	// dead message.
 	LiveLocationDead bool `json:"live_location_dead,omitempty"`
-	LabelDead        bool `json:"label_dead,omitempty"`
+	// MessageSentAt / RetiredMsgIDs are the pin-renewal bookkeeping.
+	MessageSentAt time.Time `json:"message_sent_at,omitempty"`
+	RetiredMsgIDs []int     `json:"retired_msg_ids,omitempty"`
+	LabelDead     bool      `json:"label_dead,omitempty"`
 	// LandedFinalEditDone is set after the post-landing grace edit cycle
 	// completes, so we never repeat that edit across restarts.
 	LandedFinalEditDone bool `json:"landed_final_edit_done,omitempty"`
+	// Launch and MilestonesHit keep milestone announcements once-per-session
+	// across restarts.
+	Launch        *Coordinates    `json:"launch,omitempty"`
+	MilestonesHit map[string]bool `json:"milestones_hit,omitempty"`
 }
 
 // legacySessionState represents the old format (pre-Phase 1) for migration.
@@ -102,6 +130,18 @@ func (t *Tracker) marshalStateLocked() []byte {
 			TrackAreaRadius: s.TrackAreaRadius,
 			DashboardMsgID:  s.DashboardMsgID,
 			DashboardPinned: s.DashboardPinned,
+			Task:            s.Task,
+			Race:            s.Race,
+			Milestones:      s.Milestones,
+			Sunset:          s.Sunset,
+			SunsetNotified:  s.SunsetNotified,
+			Zones:           s.Zones,
+			Discovery:       s.Discovery,
+			RadarOptions:    s.RadarOptions,
+			PredictOff:      s.PredictOff,
+			RadarOn:         s.RadarOn,
+			RadarRadius:     s.RadarRadius,
+			RadarMsgID:      s.RadarMsgID,
 		}
 		if s.Timezone != nil {
 			ss.Timezone = s.Timezone.String()
@@ -117,13 +157,18 @@ func (t *Tracker) marshalStateLocked() []byte {
 					LandingConfirmed:    info.LandingConfirmed,
 					AutoDiscovered:      info.AutoDiscovered,
 					OwnerUserID:         info.OwnerUserID,
+					Devices:             info.Devices,
 					MessageID:           info.MessageID,
 					LowSpeedSince:       info.LowSpeedSince,
 					LabelMsgID:          info.LabelMsgID,
 					LabelStatus:         info.LabelStatus,
 					LiveLocationDead:    info.LiveLocationDead,
+					MessageSentAt:       info.MessageSentAt,
+					RetiredMsgIDs:       info.RetiredMsgIDs,
 					LabelDead:           info.LabelDead,
 					LandedFinalEditDone: info.LandedFinalEditDone,
+					Launch:              info.Launch,
+					MilestonesHit:       info.MilestonesHit,
 				}
 			}
 		}
@@ -135,13 +180,18 @@ func (t *Tracker) marshalStateLocked() []byte {
 		for uid, u := range t.users {
 			state.Users[uid] = &userState{
 				Username:    u.Username,
-				OGNID:       u.OGNID,
+				OGNIDs:      u.OGNIDs,
 				DisplayName: u.DisplayName,
 				DMChatID:    u.DMChatID,
+				BuddyAlerts: u.BuddyAlerts,
+				IngestToken: u.IngestToken,
+				FeedURL:     u.FeedURL,
 			}
 		}
 	}
 
+	state.Flights = t.flights
+
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		slog.Error("failed to marshal session state", "err", err)
@@ -261,13 +311,21 @@ func (t *Tracker) loadState() bool {
 			t.users[uid] = &UserInfo{
 				UserID:      uid,
 				Username:    us.Username,
-				OGNID:       us.OGNID,
+				OGNIDs:      us.OGNIDs,
 				DisplayName: us.DisplayName,
 				DMChatID:    us.DMChatID,
+				BuddyAlerts: us.BuddyAlerts,
+				IngestToken: us.IngestToken,
+				FeedURL:     us.FeedURL,
+			}
+			if len(us.OGNIDs) == 0 && us.LegacyOGNID != "" {
+				t.users[uid].OGNIDs = []string{us.LegacyOGNID}
 			}
 		}
 	}
 
+	t.flights = state.Flights
+
 	// Restore session.
 	if state.Session == nil {
 		slog.Info("no session to restore")
@@ -285,7 +343,20 @@ func (t *Tracker) loadState() bool {
 		Drivers:         make(map[int64]*DriverInfo),
 		DashboardMsgID:  ss.DashboardMsgID,
 		DashboardPinned: ss.DashboardPinned,
+		Task:            ss.Task,
+		Race:            ss.Race,
+		Milestones:      ss.Milestones,
+		Sunset:          ss.Sunset,
+		SunsetNotified:  ss.SunsetNotified,
+		Zones:           ss.Zones,
+		Discovery:       ss.Discovery,
+		RadarOptions:    ss.RadarOptions,
+		PredictOff:      ss.PredictOff,
+		RadarOn:         false, // resumed by RegisterHandlers if resumeRadar
+		RadarRadius:     ss.RadarRadius,
+		RadarMsgID:      ss.RadarMsgID,
 	}
+	t.resumeRadar = ss.RadarOn && !ss.TrackingOn && ss.TrackArea != nil
 	// Migrate from the pre-rename field names: if the new dashboard fields are
 	// zero and the legacy ones are present, copy them across.
 	if session.DashboardMsgID == 0 && ss.LegacySummaryMsgID != 0 {
@@ -314,13 +385,18 @@ func (t *Tracker) loadState() bool {
 				LandingConfirmed:    ps.LandingConfirmed,
 				AutoDiscovered:      ps.AutoDiscovered,
 				OwnerUserID:         ps.OwnerUserID,
+				Devices:             ps.Devices,
 				MessageID:           ps.MessageID,
 				LowSpeedSince:       low,
 				LabelMsgID:          ps.LabelMsgID,
 				LabelStatus:         ps.LabelStatus,
 				LiveLocationDead:    ps.LiveLocationDead,
+				MessageSentAt:       ps.MessageSentAt,
+				RetiredMsgIDs:       ps.RetiredMsgIDs,
 				LabelDead:           ps.LabelDead,
 				LandedFinalEditDone: ps.LandedFinalEditDone,
+				Launch:              ps.Launch,
+				MilestonesHit:       ps.MilestonesHit,
 			}
 		}
 	}
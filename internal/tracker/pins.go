@@ -0,0 +1,61 @@
+package tracker
+
+import (
+	"log/slog"
+	"time"
+)
+
+// liveLocationRenewBefore — a pilot's pin is replaced this long before
+// Telegram's live period (liveLocationPeriod) runs out, so a multi-day
+// expedition or a long competition day never loses its map.
+const liveLocationRenewBefore = 30 * time.Minute
+
+// pinNeedsRenewal reports whether a pilot's live-location pin should be
+// replaced by a fresh one: the pilot is still flying and the pin is close to
+// the end of its live period or Telegram already refuses to edit it. Pins
+// saved before MessageSentAt existed have an unknown age and are only
+// renewed once they die.
+func pinNeedsRenewal(info *TrackInfo, now time.Time) bool {
+	if info.MessageID == 0 || info.Status != StatusFlying || info.Position == nil {
+		return false
+	}
+	if info.LiveLocationDead {
+		return true
+	}
+	return !info.MessageSentAt.IsZero() &&
+		now.Sub(info.MessageSentAt) >= liveLocationPeriod*time.Second-liveLocationRenewBefore
+}
+
+// renewPinLocked retires the pilot's current label and pin: their IDs move
+// to RetiredMsgIDs and the pilot goes back to the "no pin yet" state, so the
+// ticker's first-send path posts a fresh pair. The old messages are deleted
+// once the new pin is up (see retiredPinsLocked). Caller must hold t.mu and
+// save state afterwards.
+func renewPinLocked(id string, ti *TrackInfo) {
+	if ti.LabelMsgID != 0 {
+		ti.RetiredMsgIDs = append(ti.RetiredMsgIDs, ti.LabelMsgID)
+	}
+	if ti.MessageID != 0 {
+		ti.RetiredMsgIDs = append(ti.RetiredMsgIDs, ti.MessageID)
+	}
+	slog.Info("renewing live-location pin", "id", id, "msg_id", ti.MessageID,
+		"label_msg_id", ti.LabelMsgID, "dead", ti.LiveLocationDead, "sent_at", ti.MessageSentAt)
+	ti.MessageID = 0
+	ti.MessageSentAt = time.Time{}
+	ti.LiveLocationDead = false
+	ti.LabelMsgID = 0
+	ti.LabelDead = false
+}
+
+// retiredPinsLocked hands over the messages left behind by a renewal once
+// the replacement pin exists, clearing the bookkeeping. Returns nil while
+// the new pin is still pending. Caller must hold t.mu and save state if the
+// result is non-empty.
+func retiredPinsLocked(ti *TrackInfo) []int {
+	if ti.MessageID == 0 || len(ti.RetiredMsgIDs) == 0 {
+		return nil
+	}
+	ids := ti.RetiredMsgIDs
+	ti.RetiredMsgIDs = nil
+	return ids
+}
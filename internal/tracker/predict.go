@@ -0,0 +1,152 @@
+package tracker
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"ogn/parser"
+)
+
+const (
+	// predictAfter — a fix younger than this is shown as is; the ticker
+	// runs every 30s so there is nothing to gain from predicting sooner.
+	predictAfter = 40 * time.Second
+	// predictMaxAge — beyond this dead reckoning is guesswork and the pin
+	// falls back to the last real fix.
+	predictMaxAge = 3 * time.Minute
+	// predictMinSpeedKmh — slower than this the pilot is treated as
+	// stationary and nothing is extrapolated.
+	predictMinSpeedKmh = 5.0
+	// predictBaseAccuracyM and predictAccuracyPerKm size the uncertainty
+	// circle shown by Telegram: a base GPS error plus a share of the
+	// distance extrapolated. Telegram caps horizontal_accuracy at 1500 m.
+	predictBaseAccuracyM  = 50.0
+	predictAccuracyPerKm  = 300.0
+	maxHorizontalAccuracy = 1500.0
+
+	// windWindow is how much beacon history the wind estimator looks at;
+	// a thermalling circle takes 20–40 s.
+	windWindow = 90 * time.Second
+	// windMinSpread — the courses in the window must cover at least this
+	// many degrees (a near-full circle) for the drift to be a wind reading.
+	windMinSpread = 300.0
+	// windMinSamples is the minimum number of beacons in a circle.
+	windMinSamples = 6
+	// windMaxAge — older wind estimates are not trusted for prediction.
+	windMaxAge = 30 * time.Minute
+	// circlingSpread — a course spread this wide over the window means the
+	// pilot is turning, so the last course says little about where they go.
+	circlingSpread = 180.0
+)
+
+// velocitySample is one beacon's ground velocity, kept for wind estimation.
+type velocitySample struct {
+	at     time.Time
+	course float64 // degrees
+	speed  float64 // km/h
+}
+
+// Wind is a wind vector estimated from a pilot's circling drift, in km/h
+// towards east and north.
+type Wind struct {
+	East, North float64
+	At          time.Time
+}
+
+// Prediction is a dead-reckoned position between beacons. Runtime-only; set
+// by the update ticker and cleared by the next real fix.
+type Prediction struct {
+	Lat, Lon  float64
+	AccuracyM float64
+	// Age is how far past the last fix the prediction reaches.
+	Age time.Duration
+}
+
+// velocity returns the east/north components (km/h) of a course/speed pair.
+func velocity(course, speed float64) (east, north float64) {
+	rad := course * math.Pi / 180
+	return speed * math.Sin(rad), speed * math.Cos(rad)
+}
+
+// courseSpread is the smallest arc (degrees) that contains every course.
+func courseSpread(samples []velocitySample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	courses := make([]float64, len(samples))
+	for i, s := range samples {
+		courses[i] = math.Mod(s.course+360, 360)
+	}
+	sort.Float64s(courses)
+	maxGap := courses[0] + 360 - courses[len(courses)-1]
+	for i := 1; i < len(courses); i++ {
+		maxGap = math.Max(maxGap, courses[i]-courses[i-1])
+	}
+	return 360 - maxGap
+}
+
+// recordVelocity appends a beacon to the pilot's velocity history and, when
+// the window holds a full circle, refreshes the wind estimate: over a
+// circle the airspeed vectors cancel out and the mean ground velocity is the
+// wind. Caller must hold t.mu.
+func recordVelocity(info *TrackInfo, msg *parser.PositionMessage, at time.Time) {
+	cut := 0
+	for cut < len(info.Velocity) && at.Sub(info.Velocity[cut].at) > windWindow {
+		cut++
+	}
+	info.Velocity = append(info.Velocity[cut:], velocitySample{at: at, course: float64(msg.Course), speed: msg.GroundSpeed})
+
+	if len(info.Velocity) < windMinSamples || courseSpread(info.Velocity) < windMinSpread {
+		return
+	}
+	var east, north float64
+	for _, s := range info.Velocity {
+		e, n := velocity(s.course, s.speed)
+		east += e
+		north += n
+	}
+	k := float64(len(info.Velocity))
+	info.Wind = &Wind{East: east / k, North: north / k, At: at}
+}
+
+// predictPosition dead-reckons a pilot's position at now from the last fix.
+// A pilot flying straight is extrapolated along the last course and ground
+// speed; a circling pilot drifts with the estimated wind instead. Returns
+// nil when the fix is fresh, too old, or the pilot isn't moving.
+func predictPosition(info *TrackInfo, now time.Time) *Prediction {
+	pos := info.Position
+	if pos == nil || info.Status != StatusFlying {
+		return nil
+	}
+	age := now.Sub(info.LastUpdate)
+	if age < predictAfter || age > predictMaxAge {
+		return nil
+	}
+
+	var east, north float64
+	if courseSpread(info.Velocity) >= circlingSpread {
+		if info.Wind == nil || now.Sub(info.Wind.At) > windMaxAge {
+			return nil
+		}
+		east, north = info.Wind.East, info.Wind.North
+	} else {
+		course := float64(chooseHeading(pos.Course, info.LastHeading, pos.GroundSpeed))
+		east, north = velocity(course, pos.GroundSpeed)
+	}
+	if math.Hypot(east, north) < predictMinSpeedKmh {
+		return nil
+	}
+
+	hours := age.Hours()
+	dEast, dNorth := east*hours, north*hours // km
+	lat := pos.Latitude + dNorth/111.32
+	lon := pos.Longitude + dEast/(111.32*math.Cos(pos.Latitude*math.Pi/180))
+	acc := math.Min(predictBaseAccuracyM+predictAccuracyPerKm*math.Hypot(dEast, dNorth), maxHorizontalAccuracy)
+	return &Prediction{Lat: lat, Lon: lon, AccuracyM: acc, Age: age}
+}
+
+// predictEnabled reports whether the chat wants dead-reckoned positions.
+func (s *GroupSession) predictEnabled() bool {
+	return s != nil && !s.PredictOff
+}
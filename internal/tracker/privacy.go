@@ -0,0 +1,42 @@
+package tracker
+
+import (
+	"ogn/ddb"
+	"ogn/parser"
+)
+
+// OGN lets owners opt out of being tracked or identified, either in the
+// beacon itself (the no-tracking and stealth bits) or in the DDB (the
+// tracked and identified flags). Aircraft the bot picks up on its own —
+// auto-discovery and radar — honour both. Pilots added with /add or /myid
+// asked to be tracked and are shown as usual.
+
+// privacyHidden reports whether an aircraft must not be shown at all: its
+// beacon carries the no-tracking flag or its DDB entry opts out of tracking.
+// Devices missing from the DDB have not opted out.
+func privacyHidden(devices map[string]ddb.Device, id string, msg *parser.PositionMessage) bool {
+	if msg != nil && msg.NoTracking {
+		return true
+	}
+	dev, ok := devices[id]
+	return ok && !dev.Tracked
+}
+
+// privacyAnonymous reports whether an aircraft may only be shown without its
+// identity: a stealth beacon or a DDB entry that opts out of identification.
+func privacyAnonymous(devices map[string]ddb.Device, id string, msg *parser.PositionMessage) bool {
+	if msg != nil && msg.Stealth {
+		return true
+	}
+	dev, ok := devices[id]
+	return ok && !dev.Identified
+}
+
+// publicDDBInfo is formatDDBInfo for aircraft nobody asked to track:
+// anonymous ones keep the aircraft model but lose registration and CN.
+func publicDDBInfo(devices map[string]ddb.Device, id string, msg *parser.PositionMessage) string {
+	if !privacyAnonymous(devices, id, msg) {
+		return formatDDBInfo(devices, id)
+	}
+	return devices[id].AircraftModel
+}
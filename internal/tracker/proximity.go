@@ -0,0 +1,348 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+const (
+	// pairDistanceM / pairAltDiffM — two pilots closer than this horizontally
+	// and vertically count as flying together. A thermal is a few hundred
+	// metres across; pilots circling in it are typically within 100 m of
+	// each other in height.
+	pairDistanceM = 300.0
+	pairAltDiffM  = 100.0
+	// proximityFreshness — fixes older than this are ignored for pairing and
+	// collision checks so a pilot whose tracker went quiet doesn't "stay"
+	// in a gaggle.
+	proximityFreshness = 30 * time.Second
+	// buddyAlertCooldown — minimum time between two buddy-nearby DMs for the
+	// same pair, so circling in and out of range doesn't spam.
+	buddyAlertCooldown = 15 * time.Minute
+
+	// Collision risk: the pair is predicted (straight-line extrapolation of
+	// course and ground speed) to pass closer than collisionMissM within
+	// collisionHorizon, at a height difference below collisionAltBandM.
+	// collisionMaxRangeM skips pairs too far apart for the extrapolation to
+	// mean anything.
+	collisionHorizon    = 30 * time.Second
+	collisionMissM      = 80.0
+	collisionAltBandM   = 50.0
+	collisionMaxRangeM  = 1500.0
+	collisionCooldown   = 5 * time.Minute
+	collisionMinClosing = 3.0 // m/s — ignore pairs drifting together slowly
+)
+
+// pilotFix is the subset of a pilot's state the proximity checks need.
+type pilotFix struct {
+	id     string
+	label  string
+	lat    float64
+	lon    float64
+	alt    float64
+	course float64 // degrees
+	speed  float64 // km/h
+	at     time.Time
+	// altUnknown — the source reports no altitude (Telegram live location);
+	// alt is a stale or zero value and must not gate anything.
+	altUnknown bool
+}
+
+// fixOf snapshots a tracked pilot for the proximity checks. ok is false when
+// the pilot has no position or is not flying.
+func fixOf(id string, info *TrackInfo) (pilotFix, bool) {
+	if info.Position == nil || info.Status != StatusFlying || info.AutoDiscovered {
+		return pilotFix{}, false
+	}
+	label := info.DisplayName()
+	if label == "" {
+		label = id
+	}
+	course := info.Position.Course
+	if course == 0 {
+		course = info.LastHeading
+	}
+	return pilotFix{
+		id:         id,
+		label:      label,
+		lat:        info.Position.Latitude,
+		lon:        info.Position.Longitude,
+		alt:        info.Position.Altitude,
+		course:     float64(course),
+		speed:      info.Position.GroundSpeed,
+		at:         info.LastUpdate,
+		altUnknown: info.AltitudeUnknown,
+	}, true
+}
+
+// pairSeparation returns horizontal distance (m) and absolute height
+// difference (m) between two fixes.
+func pairSeparation(a, b pilotFix) (distM, altDiffM float64) {
+	d, _ := distanceAndBearing(a.lat, a.lon, b.lat, b.lon)
+	return d * 1000, math.Abs(a.alt - b.alt)
+}
+
+// together reports whether two fixes are within pairing range. Without
+// both altitudes there is no telling a pilot above from one in the same
+// thermal, so such pairs never count.
+func together(a, b pilotFix) bool {
+	if a.altUnknown || b.altUnknown {
+		return false
+	}
+	d, h := pairSeparation(a, b)
+	return d <= pairDistanceM && h <= pairAltDiffM
+}
+
+// velocityXY converts course/speed into east/north metres per second.
+func velocityXY(f pilotFix) (vx, vy float64) {
+	v := f.speed / 3.6
+	rad := f.course * math.Pi / 180
+	return v * math.Sin(rad), v * math.Cos(rad)
+}
+
+// collisionRisk extrapolates both pilots along their current course and
+// speed and reports the time and distance of closest approach. The older fix
+// is first advanced to the newer fix's time so the comparison is
+// simultaneous. risky applies the collision thresholds; a pair without
+// both altitudes is never risky, as for together.
+func collisionRisk(a, b pilotFix) (tcpa time.Duration, missM float64, risky bool) {
+	if a.altUnknown || b.altUnknown || math.Abs(a.alt-b.alt) > collisionAltBandM {
+		return 0, 0, false
+	}
+	ax, ay := 0.0, 0.0
+	bx, by := localXY(a.lat, a.lon, b.lat, b.lon)
+	avx, avy := velocityXY(a)
+	bvx, bvy := velocityXY(b)
+	// Bring both fixes to the same instant.
+	if dt := b.at.Sub(a.at).Seconds(); dt > 0 {
+		ax += avx * dt
+		ay += avy * dt
+	} else if dt < 0 {
+		bx += bvx * -dt
+		by += bvy * -dt
+	}
+	rx, ry := bx-ax, by-ay
+	if math.Hypot(rx, ry) > collisionMaxRangeM {
+		return 0, 0, false
+	}
+	wx, wy := bvx-avx, bvy-avy
+	w2 := wx*wx + wy*wy
+	if w2 == 0 {
+		return 0, math.Hypot(rx, ry), false
+	}
+	t := -(rx*wx + ry*wy) / w2
+	if t <= 0 {
+		// Already diverging.
+		return 0, math.Hypot(rx, ry), false
+	}
+	closing := -(rx*wx + ry*wy) / math.Hypot(rx, ry)
+	missM = math.Hypot(rx+wx*t, ry+wy*t)
+	tcpa = time.Duration(t * float64(time.Second))
+	risky = tcpa <= collisionHorizon && missM <= collisionMissM && closing >= collisionMinClosing
+	return tcpa, missM, risky
+}
+
+// findGaggles groups fixes into clusters of pilots flying together. Pairing
+// is transitive: if A is with B and B is with C, all three form one gaggle.
+// Only clusters of two or more are returned, each sorted by label, largest
+// cluster first.
+func findGaggles(fixes []pilotFix) [][]pilotFix {
+	parent := make([]int, len(fixes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range fixes {
+		for j := i + 1; j < len(fixes); j++ {
+			if together(fixes[i], fixes[j]) {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+	groups := make(map[int][]pilotFix)
+	for i, f := range fixes {
+		r := find(i)
+		groups[r] = append(groups[r], f)
+	}
+	var out [][]pilotFix
+	for _, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+		sort.Slice(g, func(i, j int) bool { return g[i].label < g[j].label })
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i]) != len(out[j]) {
+			return len(out[i]) > len(out[j])
+		}
+		return out[i][0].label < out[j][0].label
+	})
+	return out
+}
+
+// freshFixes collects flying pilots with a recent position.
+func freshFixes(tracking map[string]*TrackInfo, now time.Time) []pilotFix {
+	var fixes []pilotFix
+	for id, info := range tracking {
+		f, ok := fixOf(id, info)
+		if !ok || now.Sub(f.at) > proximityFreshness {
+			continue
+		}
+		fixes = append(fixes, f)
+	}
+	return fixes
+}
+
+// buildGaggleLines renders one dashboard line per gaggle, e.g.
+// "🦅 Ivan, Olga, Petr — вместе, 1850м". landing (optional) adds where the
+// group is relative to the landing field.
+func buildGaggleLines(tracking map[string]*TrackInfo, landing *Coordinates, now time.Time) string {
+	gaggles := findGaggles(freshFixes(tracking, now))
+	if len(gaggles) == 0 {
+		return ""
+	}
+	var lines []string
+	for _, g := range gaggles {
+		names := make([]string, len(g))
+		var lat, lon, alt float64
+		for i, f := range g {
+			names[i] = f.label
+			lat += f.lat
+			lon += f.lon
+			alt += f.alt
+		}
+		n := float64(len(g))
+		lat, lon, alt = lat/n, lon/n, alt/n
+		line := fmt.Sprintf("🦅 %s — вместе, %.0fм", strings.Join(names, ", "), alt)
+		if landing != nil {
+			d, br := distanceAndBearing(landing.Latitude, landing.Longitude, lat, lon)
+			line += fmt.Sprintf(", %.1fкм %s от посадки", d, bearingName(br))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pairKey is an order-independent key for a pair of pilots.
+func pairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// pairState is the runtime bookkeeping for one pair of pilots.
+type pairState struct {
+	Together       bool
+	LastBuddyAlert time.Time
+	LastCollision  time.Time
+}
+
+// proximityEvent is a DM or chat notification produced by the beacon path
+// and sent outside the mutex.
+type proximityEvent struct {
+	chatID int64 // group chat (collision) or DM chat (buddy)
+	text   string
+}
+
+// checkProximityLocked compares a fresh fix of pilot id with every other
+// flying pilot and returns the notifications due: buddy-nearby DMs for
+// opted-in owners when a pair comes together, and collision-risk warnings to
+// the chat and both pilots' DMs. Caller must hold t.mu.
+func (t *Tracker) checkProximityLocked(s *GroupSession, id string, info *TrackInfo, now time.Time) []proximityEvent {
+	me, ok := fixOf(id, info)
+	if !ok {
+		return nil
+	}
+	if s.Proximity == nil {
+		s.Proximity = make(map[string]*pairState)
+	}
+	var events []proximityEvent
+	for otherID, other := range s.Tracking {
+		if otherID == id {
+			continue
+		}
+		them, ok := fixOf(otherID, other)
+		if !ok || now.Sub(them.at) > proximityFreshness {
+			continue
+		}
+		key := pairKey(id, otherID)
+		st, ok := s.Proximity[key]
+		if !ok {
+			st = &pairState{}
+			s.Proximity[key] = st
+		}
+
+		isTogether := together(me, them)
+		if isTogether && !st.Together && now.Sub(st.LastBuddyAlert) >= buddyAlertCooldown {
+			d, h := pairSeparation(me, them)
+			if dm := t.buddyDMLocked(info.OwnerUserID); dm != 0 {
+				events = append(events, proximityEvent{dm, fmt.Sprintf("🤝 Рядом %s: %.0fм, по высоте ±%.0fм", them.label, d, h)})
+			}
+			if dm := t.buddyDMLocked(other.OwnerUserID); dm != 0 {
+				events = append(events, proximityEvent{dm, fmt.Sprintf("🤝 Рядом %s: %.0fм, по высоте ±%.0fм", me.label, d, h)})
+			}
+			st.LastBuddyAlert = now
+			slog.Info("pilots together", "a", id, "b", otherID, "dist_m", d, "alt_diff_m", h)
+		}
+		st.Together = isTogether
+
+		if tcpa, miss, risky := collisionRisk(me, them); risky && now.Sub(st.LastCollision) >= collisionCooldown {
+			st.LastCollision = now
+			text := fmt.Sprintf("⚠️ Риск сближения: %s и %s — через ~%.0fс разойдутся в %.0fм на одной высоте",
+				me.label, them.label, tcpa.Seconds(), miss)
+			events = append(events, proximityEvent{s.ChatID, text})
+			for _, owner := range []int64{info.OwnerUserID, other.OwnerUserID} {
+				if u, ok := t.users[owner]; ok && owner != 0 && u.DMChatID != 0 {
+					events = append(events, proximityEvent{u.DMChatID, text})
+				}
+			}
+			slog.Warn("collision risk", "a", id, "b", otherID, "tcpa", tcpa.Round(time.Second), "miss_m", miss)
+		}
+	}
+	return events
+}
+
+// buddyDMLocked returns the DM chat of a pilot's owner if they opted in to
+// buddy-nearby alerts, or 0. Caller must hold t.mu.
+func (t *Tracker) buddyDMLocked(owner int64) int64 {
+	if owner == 0 {
+		return 0
+	}
+	u, ok := t.users[owner]
+	if !ok || !u.BuddyAlerts {
+		return 0
+	}
+	return u.DMChatID
+}
+
+// sendProximityEvents delivers buddy and collision notifications. Must be
+// called outside t.mu.
+func (t *Tracker) sendProximityEvents(events []proximityEvent) {
+	b := t.bot
+	if b == nil {
+		return
+	}
+	for _, e := range events {
+		if _, err := b.SendMessage(context.Background(), &bot.SendMessageParams{
+			ChatID: e.chatID,
+			Text:   e.text,
+		}); err != nil {
+			slog.Error("failed to send proximity alert", "chat_id", e.chatID, "err", err)
+		}
+	}
+}
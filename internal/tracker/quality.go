@@ -0,0 +1,145 @@
+package tracker
+
+import (
+	"math"
+	"time"
+
+	"ogn/parser"
+)
+
+const (
+	// maxPlausibleSpeedKmh — an implied ground speed above this between two
+	// fixes means one of them is corrupt. Generous enough for tugs and
+	// gliders in a dive; jets and unknown objects are not speed-checked.
+	maxPlausibleSpeedKmh = 450.0
+	// maxPlausibleClimbMs — same for the implied vertical speed.
+	maxPlausibleClimbMs = 60.0
+	// jumpResetAfter — after this many consecutive "impossible" fixes the
+	// previous fix is assumed to be the bad one and the new position is
+	// accepted, so a single corrupt fix can't lock a pilot out.
+	jumpResetAfter = 3
+	// minJumpInterval floors the time between fixes when computing implied
+	// speeds; beacon timestamps have one-second resolution.
+	minJumpInterval = time.Second
+	// Weighted mode: a fix with CRC errors or an SNR below weakSignalDB is
+	// low quality and held to half the plausibility limits.
+	weakSignalDB = 5.0
+)
+
+// Reasons returned by checkBeacon.
+const (
+	rejectDuplicate  = "duplicate"
+	rejectOutOfOrder = "out_of_order"
+	rejectJump       = "jump"
+)
+
+// BeaconRejects counts a pilot's rejected fixes per reason. Runtime-only;
+// shown in debug output.
+type BeaconRejects struct {
+	Duplicate  int
+	OutOfOrder int
+	Jump       int
+}
+
+func (r *BeaconRejects) add(reason string) {
+	switch reason {
+	case rejectDuplicate:
+		r.Duplicate++
+	case rejectOutOfOrder:
+		r.OutOfOrder++
+	case rejectJump:
+		r.Jump++
+	}
+}
+
+// Total is the number of rejected fixes.
+func (r BeaconRejects) Total() int { return r.Duplicate + r.OutOfOrder + r.Jump }
+
+// beaconScore ranks copies of the same fix heard by different receivers:
+// higher SNR is better, every CRC-corrected bit error costs 3 dB.
+func beaconScore(msg *parser.PositionMessage) float64 {
+	return msg.SignalQuality - 3*float64(msg.ErrorCount)
+}
+
+// lowQuality reports whether a fix is weak enough to deserve stricter
+// plausibility limits in weighted mode.
+func lowQuality(msg *parser.PositionMessage) bool {
+	return msg.ErrorCount > 0 || (msg.SignalQuality != 0 && msg.SignalQuality < weakSignalDB)
+}
+
+// checkBeacon is the quality stage in front of the position update. It
+// returns "" if msg should replace the pilot's position, or the reason it
+// was dropped; either way the pilot's quality bookkeeping is updated.
+//
+//   - The same fix relayed by several receivers (same timestamp) is kept
+//     once. In weighted mode a better-received copy replaces the first; a
+//     fix from another of the pilot's devices with better GPS accuracy
+//     always does.
+//   - A fix older than the last accepted one is out of order.
+//   - A fix implying an impossible speed or climb from the last accepted one
+//     is a jump, unless jumpResetAfter such fixes arrive in a row.
+//
+// Beacons without a timestamp skip the time-based checks. Caller must hold
+// t.mu.
+func checkBeacon(info *TrackInfo, msg *parser.PositionMessage, weighted bool) string {
+	reason := beaconVerdict(info, msg, weighted)
+	if reason == "" {
+		info.JumpStreak = 0
+		info.LastFixTime = msg.Timestamp
+		info.LastFixScore = beaconScore(msg)
+		info.LastFixAccuracy = gpsAccuracy(msg.GPSQuality)
+		info.Source = shortID(msg.Callsign)
+		info.AltitudeUnknown = false
+		return ""
+	}
+	info.Rejects.add(reason)
+	return reason
+}
+
+func beaconVerdict(info *TrackInfo, msg *parser.PositionMessage, weighted bool) string {
+	prev := info.Position
+	if prev == nil || msg.Timestamp.IsZero() || info.LastFixTime.IsZero() {
+		return ""
+	}
+	// The parser already resolves the hhmmss timestamp against the UTC day.
+	dt := msg.Timestamp.Sub(info.LastFixTime)
+	switch {
+	case dt == 0:
+		if weighted && beaconScore(msg) > info.LastFixScore {
+			return ""
+		}
+		// Another of the pilot's devices with a better GPS fix for the same
+		// second wins.
+		acc := gpsAccuracy(msg.GPSQuality)
+		if acc > 0 && acc < info.LastFixAccuracy && shortID(msg.Callsign) != info.Source {
+			return ""
+		}
+		return rejectDuplicate
+	case dt < 0:
+		return rejectOutOfOrder
+	}
+
+	if msg.AircraftType == 9 || msg.AircraftType == 10 { // jet, UFO
+		return ""
+	}
+	maxSpeed, maxClimb := maxPlausibleSpeedKmh, maxPlausibleClimbMs
+	if weighted && lowQuality(msg) {
+		maxSpeed, maxClimb = maxSpeed/2, maxClimb/2
+	}
+	secs := math.Max(dt.Seconds(), minJumpInterval.Seconds())
+	distKm, _ := distanceAndBearing(prev.Latitude, prev.Longitude, msg.Latitude, msg.Longitude)
+	speed := distKm / secs * 3600
+	climb := math.Abs(msg.Altitude-prev.Altitude) / secs
+	if info.AltitudeUnknown {
+		// The previous fix came without altitude (see fixes.go).
+		climb = 0
+	}
+	if speed <= maxSpeed && climb <= maxClimb {
+		return ""
+	}
+	info.JumpStreak++
+	if info.JumpStreak >= jumpResetAfter {
+		return ""
+	}
+	return rejectJump
+}
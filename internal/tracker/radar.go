@@ -0,0 +1,425 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"ogn/parser"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// radarClimbMin is the vario (m/s) above which an aircraft counts as
+// climbing in the "climbers only" view.
+const radarClimbMin = 0.5
+
+// Radar sort orders.
+const (
+	radarSortAlt   = "alt"
+	radarSortDist  = "dist"
+	radarSortClimb = "climb"
+)
+
+// RadarOptions tune what the radar message lists. Toggled with the buttons
+// under the radar message and persisted, so the next /radar starts with the
+// same view.
+type RadarOptions struct {
+	Types        []int  `json:"types,omitempty"`     // allowed AircraftType codes; empty = all
+	MinAltM      int    `json:"min_alt_m,omitempty"` // 0 = no lower bound
+	MaxAltM      int    `json:"max_alt_m,omitempty"` // 0 = no upper bound
+	Sort         string `json:"sort,omitempty"`      // radarSort*; "" = altitude
+	ClimbersOnly bool   `json:"climbers_only,omitempty"`
+}
+
+// radarTypePresets are cycled by the type button.
+var radarTypePresets = []struct {
+	label string
+	types []int
+}{
+	{"все типы", nil},
+	{"PG+HG", []int{6, 7}},
+	{"планеры", []int{1}},
+	{"моторные", []int{2, 3, 8, 9}},
+}
+
+// radarAltBands are cycled by the altitude button.
+var radarAltBands = []struct {
+	label    string
+	min, max int
+}{
+	{"все высоты", 0, 0},
+	{"до 1500 м", 0, 1500},
+	{"1500–3000 м", 1500, 3000},
+	{"выше 3000 м", 3000, 0},
+}
+
+var radarSorts = []struct{ key, label string }{
+	{radarSortAlt, "↕ по высоте"},
+	{radarSortDist, "📏 по расстоянию"},
+	{radarSortClimb, "⬆ по набору"},
+}
+
+// radarOptions returns the session's radar view settings.
+func (s *GroupSession) radarOptions() RadarOptions {
+	if s == nil || s.RadarOptions == nil {
+		return RadarOptions{}
+	}
+	return *s.RadarOptions
+}
+
+func (o RadarOptions) typeIndex() int {
+	for i, p := range radarTypePresets {
+		if slices.Equal(p.types, o.Types) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o RadarOptions) altIndex() int {
+	for i, band := range radarAltBands {
+		if band.min == o.MinAltM && band.max == o.MaxAltM {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o RadarOptions) sortIndex() int {
+	for i, s := range radarSorts {
+		if s.key == o.Sort {
+			return i
+		}
+	}
+	return 0
+}
+
+// typeLabel is the short label for the current type filter.
+func (o RadarOptions) typeLabel() string {
+	if i := o.typeIndex(); i >= 0 {
+		return radarTypePresets[i].label
+	}
+	names := make([]string, len(o.Types))
+	for i, c := range o.Types {
+		names[i] = typeName(c)
+	}
+	return strings.Join(names, "+")
+}
+
+// altLabel is the short label for the current altitude band.
+func (o RadarOptions) altLabel() string {
+	if i := o.altIndex(); i >= 0 {
+		return radarAltBands[i].label
+	}
+	return fmt.Sprintf("%d–%d м", o.MinAltM, o.MaxAltM)
+}
+
+// toggle advances one option as a button press would: type, alt and sort
+// cycle through their presets, climb flips the climbers-only view. Returns
+// false for an unknown option.
+func (o *RadarOptions) toggle(option string) bool {
+	switch option {
+	case "type":
+		p := radarTypePresets[(o.typeIndex()+1)%len(radarTypePresets)]
+		o.Types = slices.Clone(p.types)
+	case "alt":
+		band := radarAltBands[(o.altIndex()+1)%len(radarAltBands)]
+		o.MinAltM, o.MaxAltM = band.min, band.max
+	case "sort":
+		o.Sort = radarSorts[(o.sortIndex()+1)%len(radarSorts)].key
+		if o.Sort == radarSortAlt {
+			o.Sort = ""
+		}
+	case "climb":
+		o.ClimbersOnly = !o.ClimbersOnly
+	default:
+		return false
+	}
+	return true
+}
+
+// admits reports whether a radar entry passes the filters.
+func (o RadarOptions) admits(e RadarEntry) bool {
+	pos := e.Position
+	if pos == nil {
+		return false
+	}
+	if len(o.Types) > 0 && !slices.Contains(o.Types, e.AircraftType) {
+		return false
+	}
+	if o.MinAltM > 0 && pos.Altitude < float64(o.MinAltM) {
+		return false
+	}
+	if o.MaxAltM > 0 && pos.Altitude >= float64(o.MaxAltM) {
+		return false
+	}
+	return !o.ClimbersOnly || pos.ClimbRate >= radarClimbMin
+}
+
+// filterRadarLines applies the filters and the sort order. The result is
+// a new slice; lines is left untouched.
+func filterRadarLines(lines []radarLine, center *Coordinates, o RadarOptions) []radarLine {
+	out := make([]radarLine, 0, len(lines))
+	for _, l := range lines {
+		if o.admits(l.entry) {
+			out = append(out, l)
+		}
+	}
+	dist := func(l radarLine) float64 {
+		d, _ := distanceAndBearing(center.Latitude, center.Longitude, l.entry.Position.Latitude, l.entry.Position.Longitude)
+		return d
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i].entry.Position, out[j].entry.Position
+		switch {
+		case o.Sort == radarSortDist && center != nil:
+			return dist(out[i]) < dist(out[j])
+		case o.Sort == radarSortClimb:
+			return a.ClimbRate > b.ClimbRate
+		default:
+			return a.Altitude > b.Altitude
+		}
+	})
+	return out
+}
+
+// radarOptionButtons are the rows of view toggles under the radar message.
+func radarOptionButtons(o RadarOptions) [][]models.InlineKeyboardButton {
+	climb := "⬆ только набор"
+	if o.ClimbersOnly {
+		climb = "✅ только набор"
+	}
+	return [][]models.InlineKeyboardButton{
+		{
+			{Text: "🛩 " + o.typeLabel(), CallbackData: "radar_opt:type"},
+			{Text: "📶 " + o.altLabel(), CallbackData: "radar_opt:alt"},
+		},
+		{
+			{Text: radarSorts[o.sortIndex()].label, CallbackData: "radar_opt:sort"},
+			{Text: climb, CallbackData: "radar_opt:climb"},
+		},
+	}
+}
+
+// radarMessageLocked renders the radar summary and keyboard from the live
+// session, pruning stale entries on the way. Caller must hold t.mu.
+func radarMessageLocked(s *GroupSession, now time.Time) (string, *models.InlineKeyboardMarkup) {
+	for id, e := range s.RadarEntries {
+		if now.Sub(e.LastSeen) > staleThreshold {
+			delete(s.RadarEntries, id)
+		}
+	}
+	lines := make([]radarLine, 0, len(s.RadarEntries))
+	for id, e := range s.RadarEntries {
+		lines = append(lines, radarLine{id, *e})
+	}
+	opts := s.radarOptions()
+	shown := filterRadarLines(lines, s.TrackArea, opts)
+	return buildRadarSummary(shown, len(lines), s.TrackArea, s.RadarRadius, opts, s.tz()), radarButtons(shown, opts)
+}
+
+// cbRadarOption handles the view toggles under the radar message and
+// re-renders it in place.
+func (t *Tracker) cbRadarOption(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil {
+		return
+	}
+	t.answerCallback(ctx, b, cq)
+	msg := cq.Message.Message
+	if msg == nil || !t.isAllowedChat(msg.Chat.ID) || !t.isTrusted(cq.From.ID) {
+		return
+	}
+	option := strings.TrimPrefix(cq.Data, "radar_opt:")
+
+	t.mu.Lock()
+	s := t.session
+	if s == nil || s.ChatID != msg.Chat.ID || !s.RadarOn || s.TrackArea == nil {
+		t.mu.Unlock()
+		return
+	}
+	opts := s.radarOptions()
+	if !opts.toggle(option) {
+		t.mu.Unlock()
+		return
+	}
+	s.RadarOptions = &opts
+	t.saveState()
+	text, kb := radarMessageLocked(s, time.Now())
+	t.mu.Unlock()
+	slog.Info("radar options changed", "option", option, "types", opts.Types,
+		"min_alt", opts.MinAltM, "max_alt", opts.MaxAltM, "sort", opts.Sort, "climbers_only", opts.ClimbersOnly)
+
+	_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      msg.Chat.ID,
+		MessageID:   msg.ID,
+		Text:        text,
+		ReplyMarkup: kb,
+	})
+	if err != nil && !isMessageNotModified(err) {
+		slog.Error("failed to edit radar summary", "err", err)
+	}
+}
+
+// buildRadarCard renders the detail card opened from a radar entry button.
+// e.DDBInfo is already privacy-filtered by runRadarClient.
+func buildRadarCard(id string, e RadarEntry, center *Coordinates, now time.Time, tz *time.Location) string {
+	pos := e.Position
+	var sb strings.Builder
+	sb.WriteString("🔍 " + id)
+	if name, ok := aircraftTypes[e.AircraftType]; ok && e.AircraftType > 0 {
+		sb.WriteString(" [" + name + "]")
+	}
+	if e.DDBInfo != "" {
+		sb.WriteString("\n📋 " + e.DDBInfo)
+	}
+	fmt.Fprintf(&sb, "\n↕ %.0fм | %+.1fм/с | %.0fкм/ч | курс %d° %s",
+		pos.Altitude, pos.ClimbRate, pos.GroundSpeed, pos.Course, bearingName(float64(pos.Course)))
+	if center != nil {
+		dist, bearing := distanceAndBearing(center.Latitude, center.Longitude, pos.Latitude, pos.Longitude)
+		fmt.Fprintf(&sb, "\n📏 %.1fкм %s от центра", dist, bearingName(bearing))
+	}
+	if pos.ReceiverName != "" {
+		fmt.Fprintf(&sb, "\n📡 %s", pos.ReceiverName)
+		if pos.SignalQuality != 0 {
+			fmt.Fprintf(&sb, " (%.1f дБ)", pos.SignalQuality)
+		}
+	}
+	fmt.Fprintf(&sb, "\n⏱ %s (%d с назад)", e.LastSeen.In(tz).Format("15:04:05"), int(now.Sub(e.LastSeen).Seconds()))
+	fmt.Fprintf(&sb, "\n📍 %.5f, %.5f", pos.Latitude, pos.Longitude)
+	return sb.String()
+}
+
+// radarCardButtons are the navigate and track actions under a radar card.
+// The track button is left out for aircraft already on the pilot list.
+func radarCardButtons(id string, pos *parser.PositionMessage, tracked bool) *models.InlineKeyboardMarkup {
+	row := []models.InlineKeyboardButton{
+		{Text: "🗺 Навигация", URL: mapsNavURL(pos.Latitude, pos.Longitude)},
+	}
+	if !tracked {
+		row = append(row, models.InlineKeyboardButton{Text: "➕ Отслеживать", CallbackData: "radar_track:" + id})
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{row}}
+}
+
+// cbRadarCard posts the detail card for one radar entry.
+func (t *Tracker) cbRadarCard(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil {
+		return
+	}
+	t.answerCallback(ctx, b, cq)
+	msg := cq.Message.Message
+	if msg == nil || !t.isAllowedChat(msg.Chat.ID) || !t.isTrusted(cq.From.ID) {
+		return
+	}
+	id := strings.TrimPrefix(cq.Data, "radar_card:")
+
+	t.mu.Lock()
+	s := t.session
+	if s == nil || s.ChatID != msg.Chat.ID {
+		t.mu.Unlock()
+		return
+	}
+	e, ok := s.RadarEntries[id]
+	if !ok || e.Position == nil {
+		t.mu.Unlock()
+		t.sendAck(ctx, &bot.SendMessageParams{
+			ChatID: msg.Chat.ID,
+			Text:   fmt.Sprintf("%s уже пропал с радара", id),
+		}, "failed to send radar card gone")
+		return
+	}
+	info, tracked := s.Tracking[id]
+	tracked = tracked && !info.AutoDiscovered
+	text := buildRadarCard(id, *e, s.TrackArea, time.Now(), s.tz())
+	kb := radarCardButtons(id, e.Position, tracked)
+	t.mu.Unlock()
+
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      msg.Chat.ID,
+		Text:        text,
+		ReplyMarkup: kb,
+	}); err != nil {
+		slog.Error("failed to send radar card", "id", id, "err", err)
+	}
+}
+
+// cbRadarTrack adds a radar aircraft to the pilot list, named from the DDB
+// when the owner allows identification. Once tracking is on it gets a
+// live-location pin and landing detection like any other pilot.
+func (t *Tracker) cbRadarTrack(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil {
+		return
+	}
+	t.answerCallback(ctx, b, cq)
+	msg := cq.Message.Message
+	if msg == nil || !t.isAllowedChat(msg.Chat.ID) || !t.isTrusted(cq.From.ID) {
+		return
+	}
+	id := strings.TrimPrefix(cq.Data, "radar_track:")
+
+	t.mu.Lock()
+	s := t.session
+	if s == nil || s.ChatID != msg.Chat.ID {
+		t.mu.Unlock()
+		return
+	}
+	var pos *parser.PositionMessage
+	if e, ok := s.RadarEntries[id]; ok {
+		pos = e.Position
+	}
+	info, ok := s.Tracking[id]
+	if ok && !info.AutoDiscovered {
+		t.mu.Unlock()
+		t.sendAck(ctx, &bot.SendMessageParams{
+			ChatID: msg.Chat.ID,
+			Text:   fmt.Sprintf("%s уже в списке", id),
+		}, "failed to send radar track duplicate")
+		return
+	}
+	if !ok {
+		info = &TrackInfo{}
+		s.Tracking[id] = info
+	}
+	info.AutoDiscovered = false
+	if info.Name == "" && !privacyAnonymous(t.devices, id, pos) {
+		info.Name = ddbName(t.devices, id)
+	}
+	name := info.Name
+	trackingOn := s.TrackingOn
+	t.updateFilter()
+	t.saveState()
+	t.mu.Unlock()
+	slog.Info("radar aircraft tracked", "id", id, "name", name, "user_id", cq.From.ID)
+
+	text := "➕ Добавлен " + id
+	if name != "" {
+		text += " (" + name + ")"
+	}
+	if !trackingOn {
+		text += ". Пин и отслеживание посадки — после /track_on"
+	}
+	t.sendAck(ctx, &bot.SendMessageParams{
+		ChatID: msg.Chat.ID,
+		Text:   text,
+	}, "failed to confirm radar track")
+	// Drop the track button from the card so it can't be pressed twice.
+	if pos != nil {
+		if _, err := b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+			ChatID:      msg.Chat.ID,
+			MessageID:   msg.ID,
+			ReplyMarkup: radarCardButtons(id, pos, true),
+		}); err != nil && !isMessageNotModified(err) {
+			slog.Error("failed to update radar card", "id", id, "err", err)
+		}
+	}
+	t.refreshDashboard(ctx, msg.Chat.ID)
+}
@@ -42,7 +42,7 @@ func nearestDriver(lat, lon float64, drivers []*Coordinates) (distKm float64, be
 // nearest driver. devices and tz are explicit dependencies so the function
 // can be called without holding the Tracker mutex (and so it's straightforward
 // to test).
-func formatTrackText(id string, info *TrackInfo, landing *Coordinates, drivers []*Coordinates, devices map[string]ddb.Device, tz *time.Location) string {
+func formatTrackText(id string, info *TrackInfo, landing *Coordinates, drivers []*Coordinates, devices map[string]ddb.Device, places *gazetteer, tz *time.Location) string {
 	pos := info.Position
 
 	// Header: status emoji + ID + name/DDB info.
@@ -52,7 +52,7 @@ func formatTrackText(id string, info *TrackInfo, landing *Coordinates, drivers [
 	} else if info.Username != "" {
 		text += " — " + info.Username
 	} else if info.AutoDiscovered {
-		if info := formatDDBInfo(devices, id); info != "" {
+		if info := publicDDBInfo(devices, id, pos); info != "" {
 			text += " — " + info
 		}
 		if name, ok := aircraftTypes[pos.AircraftType]; ok && pos.AircraftType > 0 {
@@ -77,23 +77,54 @@ func formatTrackText(id string, info *TrackInfo, landing *Coordinates, drivers [
 
 	// Flight data lines.
 	altLine := fmt.Sprintf("\nВысота: %.0fм", pos.Altitude)
-	if info.Status == StatusFlying {
-		altLine += fmt.Sprintf(" (%+.1fм/с)", pos.ClimbRate)
+	if info.AltitudeUnknown {
+		altLine = ""
+	} else if info.Status == StatusFlying {
+		altLine += fmt.Sprintf(" (%+.1fм/с)", info.climb())
+		// Averaged vario from the smoothed altitude (see smooth.go).
+		if v30, ok := info.Smooth.averageVario(30 * time.Second); ok {
+			altLine += fmt.Sprintf("\nВарио ср.: %+.1f за 30с", v30)
+			if v60, ok := info.Smooth.averageVario(time.Minute); ok {
+				altLine += fmt.Sprintf(", %+.1f за 1мин", v60)
+			}
+		}
 	}
 	text += altLine
-	if pos.GroundSpeed > 0 {
-		spdLine := fmt.Sprintf("\nСкорость: %.0fкм/ч  Курс: %s", pos.GroundSpeed, formatBearing(float64(pos.Course)))
+	if speed := info.speed(); speed > 0 {
+		spdLine := fmt.Sprintf("\nСкорость: %.0fкм/ч  Курс: %s", speed, formatBearing(float64(pos.Course)))
 		text += spdLine
 	}
 
+	// Position from a non-OGN source, or, for pilots with several devices,
+	// which one the position came from.
+	if label, ok := sourceLabels[info.Source]; ok {
+		text += "\n" + label
+		if info.LastFixAccuracy > 0 {
+			text += fmt.Sprintf(" (±%.0fм)", info.LastFixAccuracy)
+		}
+	} else if len(info.Devices) > 0 && info.Source != "" {
+		text += fmt.Sprintf("\n📡 %s (устройств: %d)", info.Source, len(info.Devices)+1)
+	}
+
 	// Distance and bearing to landing.
 	if landing != nil {
 		distKm, bearing := distanceAndBearing(pos.Latitude, pos.Longitude, landing.Latitude, landing.Longitude)
 		text += fmt.Sprintf("\n📍 %.1fкм до посадки (%s)", distKm, formatBearing(bearing))
 	}
 
-	// Distance from nearest driver to landed pilot.
+	// Dead-reckoned position between beacons (see predict.go).
+	if p := info.Predicted; p != nil && info.Status == StatusFlying {
+		distKm, bearing := distanceAndBearing(pos.Latitude, pos.Longitude, p.Lat, p.Lon)
+		text += fmt.Sprintf("\n🔮 ~прогноз: %.1fкм %s от последней точки (±%.0fм, +%.0fс)",
+			distKm, bearingName(bearing), p.AccuracyM, p.Age.Seconds())
+	}
+
+	// Where the landed pilot is, for the retrieve, then the distance from the
+	// nearest driver.
 	if info.Status == StatusLanded {
+		if places != nil {
+			text += "\n🏘 " + describeLocation(places, pos.Latitude, pos.Longitude)
+		}
 		if distKm, bearing, ok := nearestDriver(pos.Latitude, pos.Longitude, drivers); ok {
 			text += fmt.Sprintf("\n🚗 %.1fкм от водителя (%s)", distKm, formatBearing(bearing))
 		}
@@ -109,7 +140,7 @@ func formatTrackText(id string, info *TrackInfo, landing *Coordinates, drivers [
 
 // buildSummary composes the full tracking summary message with header counts
 // and per-pilot sections grouped by status (flying, landed, picked up, waiting).
-func buildSummary(local map[string]*TrackInfo, landing *Coordinates, drivers []*Coordinates, areaRadius int, devices map[string]ddb.Device, tz *time.Location) string {
+func buildSummary(local map[string]*TrackInfo, landing *Coordinates, drivers []*Coordinates, areaRadius int, devices map[string]ddb.Device, places *gazetteer, tz *time.Location) string {
 	type entry struct {
 		id   string
 		info *TrackInfo
@@ -186,10 +217,10 @@ func buildSummary(local map[string]*TrackInfo, landing *Coordinates, drivers []*
 	// Build per-pilot sections.
 	var sections []string
 	for _, e := range flying {
-		sections = append(sections, formatTrackText(e.id, e.info, landing, drivers, devices, tz))
+		sections = append(sections, formatTrackText(e.id, e.info, landing, drivers, devices, places, tz))
 	}
 	for _, e := range landed {
-		sections = append(sections, formatTrackText(e.id, e.info, landing, drivers, devices, tz))
+		sections = append(sections, formatTrackText(e.id, e.info, landing, drivers, devices, places, tz))
 	}
 	for _, e := range pickedUp {
 		label := "✅ " + e.id
@@ -214,7 +245,7 @@ func buildSummary(local map[string]*TrackInfo, landing *Coordinates, drivers []*
 // "summary" message and the reply keyboard. It is one Telegram text body that
 // folds together: a status header (always present), an inactivity warning
 // (when applicable), and either the radar summary or the pilot summary.
-func buildDashboard(s *GroupSession, devices map[string]ddb.Device, tz *time.Location) string {
+func buildDashboard(s *GroupSession, devices map[string]ddb.Device, places *gazetteer, tz *time.Location) string {
 	if s == nil {
 		return "Нет активной сессии. /start чтобы начать."
 	}
@@ -235,6 +266,9 @@ func buildDashboard(s *GroupSession, devices map[string]ddb.Device, tz *time.Loc
 	if s.TrackArea != nil {
 		meta = append(meta, fmt.Sprintf("📡 Зона: %dкм", s.TrackAreaRadius))
 	}
+	if len(s.Zones) > 0 {
+		meta = append(meta, fmt.Sprintf("🗺 Зон: %d", len(s.Zones)))
+	}
 	if len(s.Drivers) > 0 {
 		meta = append(meta, fmt.Sprintf("🚗 %d водитель(ей)", len(s.Drivers)))
 	}
@@ -242,6 +276,10 @@ func buildDashboard(s *GroupSession, devices map[string]ddb.Device, tz *time.Loc
 		sb.WriteString("\n")
 		sb.WriteString(strings.Join(meta, " · "))
 	}
+	if line := buildSunsetLine(s.sunAnchor(), time.Now(), tz); line != "" {
+		sb.WriteString("\n")
+		sb.WriteString(line)
+	}
 
 	// Inactivity warning: surface here instead of as a separate chat message
 	// so the dashboard is the single source of UI truth.
@@ -270,17 +308,10 @@ func buildDashboard(s *GroupSession, devices map[string]ddb.Device, tz *time.Loc
 		for id, e := range s.RadarEntries {
 			lines = append(lines, radarLine{id, *e})
 		}
-		sort.Slice(lines, func(i, j int) bool {
-			if lines[i].entry.Position == nil {
-				return false
-			}
-			if lines[j].entry.Position == nil {
-				return true
-			}
-			return lines[i].entry.Position.Altitude > lines[j].entry.Position.Altitude
-		})
+		opts := s.radarOptions()
+		shown := filterRadarLines(lines, s.TrackArea, opts)
 		sb.WriteString("\n\n")
-		sb.WriteString(buildRadarSummary(lines, s.TrackArea, s.RadarRadius, tz))
+		sb.WriteString(buildRadarSummary(shown, len(lines), s.TrackArea, s.RadarRadius, opts, tz))
 		return sb.String()
 	}
 
@@ -289,11 +320,22 @@ func buildDashboard(s *GroupSession, devices map[string]ddb.Device, tz *time.Loc
 		return sb.String()
 	}
 
+	// Competition task: the live race board goes above the pilot details so
+	// the standings are visible without scrolling.
+	if s.Task != nil {
+		sb.WriteString("\n\n")
+		sb.WriteString(buildRaceBoard(s.Task, s.Race, s.Tracking, time.Now()))
+	}
+	if gaggles := buildGaggleLines(s.Tracking, s.Landing, time.Now()); gaggles != "" {
+		sb.WriteString("\n\n")
+		sb.WriteString(gaggles)
+	}
+
 	// Reuse the existing pilot summary as the body. Drivers list passed empty
 	// and areaRadius=0 suppresses the in-body zone line — both are already
 	// summarised on the dashboard's meta header so showing them twice would
 	// be visual noise.
-	body := buildSummary(s.Tracking, s.Landing, nil, 0, devices, tz)
+	body := buildSummary(s.Tracking, s.Landing, nil, 0, devices, places, tz)
 	sb.WriteString("\n\n")
 	sb.WriteString(body)
 	return sb.String()
@@ -337,6 +379,7 @@ func dashboardButtons(s *GroupSession) *models.InlineKeyboardMarkup {
 
 // pilotButtons returns inline buttons for pilots with known positions.
 // Flying pilots get a navigate button; landed pilots get navigate + pickup.
+// Auto-discovered aircraft also get an adopt button to track them for good.
 func pilotButtons(local map[string]*TrackInfo) *models.InlineKeyboardMarkup {
 	type entry struct {
 		id   string
@@ -369,33 +412,70 @@ func pilotButtons(local map[string]*TrackInfo) *models.InlineKeyboardMarkup {
 		if name := e.info.DisplayName(); name != "" {
 			label = name
 		}
-		rows = append(rows, []models.InlineKeyboardButton{
+		row := []models.InlineKeyboardButton{
 			{Text: "🗺 " + label, URL: mapsNavURL(e.info.Position.Latitude, e.info.Position.Longitude)},
-		})
+		}
+		if e.info.AutoDiscovered {
+			row = append(row, models.InlineKeyboardButton{Text: "📌 Взять", CallbackData: "adopt:" + e.id})
+		}
+		rows = append(rows, row)
 	}
 	for _, e := range landed {
 		label := e.id
 		if name := e.info.DisplayName(); name != "" {
 			label = name
 		}
-		rows = append(rows, []models.InlineKeyboardButton{
+		row := []models.InlineKeyboardButton{
 			{Text: "🗺 " + label, URL: mapsNavURL(e.info.Position.Latitude, e.info.Position.Longitude)},
 			{Text: "✅ Забрал " + label, CallbackData: "pickup:" + e.id},
-		})
+		}
+		if e.info.AutoDiscovered {
+			row = append(row, models.InlineKeyboardButton{Text: "📌 Взять", CallbackData: "adopt:" + e.id})
+		}
+		rows = append(rows, row)
 	}
 	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
 }
 
-// buildRadarSummary composes the multi-line radar summary message.
-func buildRadarSummary(lines []radarLine, center *Coordinates, radius int, tz *time.Location) string {
+// buildRadarSummary composes the multi-line radar summary message. lines
+// are already filtered and sorted by the radar options; total is the number
+// of aircraft before filtering.
+func buildRadarSummary(lines []radarLine, total int, center *Coordinates, radius int, opts RadarOptions, tz *time.Location) string {
 	var sb strings.Builder
-	fmt.Fprintf(&sb, "📡 Радар: %d ВС в зоне %dкм\n", len(lines), radius)
+	if len(lines) == total {
+		fmt.Fprintf(&sb, "📡 Радар: %d ВС в зоне %dкм\n", total, radius)
+	} else {
+		fmt.Fprintf(&sb, "📡 Радар: %d из %d ВС в зоне %dкм\n", len(lines), total, radius)
+	}
+	var filters []string
+	if len(opts.Types) > 0 {
+		filters = append(filters, opts.typeLabel())
+	}
+	if opts.MinAltM > 0 || opts.MaxAltM > 0 {
+		filters = append(filters, opts.altLabel())
+	}
+	if opts.ClimbersOnly {
+		filters = append(filters, "только набор")
+	}
+	if len(filters) > 0 {
+		sb.WriteString("🔎 " + strings.Join(filters, " · ") + "\n")
+	}
 
 	for _, l := range lines {
 		pos := l.entry.Position
 		if pos == nil {
 			continue
 		}
+		if opts.ClimbersOnly {
+			// Compact view: one line per thermal-hunter.
+			dist, _ := distanceAndBearing(center.Latitude, center.Longitude, pos.Latitude, pos.Longitude)
+			fmt.Fprintf(&sb, "\n⬆ %s %+.1fм/с | %.0fм | %.1fкм", l.id, pos.ClimbRate, pos.Altitude, dist)
+			if sb.Len() > 3900 {
+				fmt.Fprintf(&sb, "\n\n…и ещё ВС")
+				break
+			}
+			continue
+		}
 		sb.WriteString("\n")
 		sb.WriteString(l.id)
 		if name, ok := aircraftTypes[l.entry.AircraftType]; ok && l.entry.AircraftType > 0 {
@@ -421,13 +501,18 @@ func buildRadarSummary(lines []radarLine, center *Coordinates, radius int, tz *t
 	}
 
 	if len(lines) == 0 {
-		sb.WriteString("\nНет ВС в зоне")
+		if total > 0 {
+			sb.WriteString("\nНет ВС под выбранные фильтры")
+		} else {
+			sb.WriteString("\nНет ВС в зоне")
+		}
 	}
 	return sb.String()
 }
 
-// radarButtons builds an inline keyboard with map links for radar entries.
-func radarButtons(lines []radarLine) *models.InlineKeyboardMarkup {
+// radarButtons builds an inline keyboard with a detail-card button per
+// radar entry, followed by the view toggles.
+func radarButtons(lines []radarLine, opts RadarOptions) *models.InlineKeyboardMarkup {
 	var rows [][]models.InlineKeyboardButton
 	for _, l := range lines {
 		if l.entry.Position == nil {
@@ -437,16 +522,13 @@ func radarButtons(lines []radarLine) *models.InlineKeyboardMarkup {
 		if name, ok := aircraftTypes[l.entry.AircraftType]; ok && l.entry.AircraftType > 0 {
 			label += " " + name
 		}
-		url := mapsNavURL(l.entry.Position.Latitude, l.entry.Position.Longitude)
 		rows = append(rows, []models.InlineKeyboardButton{
-			{Text: "🗺 " + label, URL: url},
+			{Text: "🔍 " + label, CallbackData: "radar_card:" + l.id},
 		})
 		if len(rows) >= 20 {
 			break
 		}
 	}
-	if len(rows) == 0 {
-		return nil
-	}
+	rows = append(rows, radarOptionButtons(opts)...)
 	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
 }
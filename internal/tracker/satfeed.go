@@ -0,0 +1,649 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// XC pilots often carry a Garmin inReach or a SPOT, visible by satellite far
+// beyond OGN coverage. A pilot links their public feed in DM (/feed) and,
+// while tracking is on, the bot polls it: fixes go to the pilot's entry as
+// another position source (see fixes.go), inReach SOS and messages are
+// announced in the group.
+
+const (
+	// feedPollInterval — SPOT asks clients not to poll a feed more often
+	// than every 2.5 minutes; inReach sends a fix every 2–10 minutes anyway.
+	feedPollInterval = 3 * time.Minute
+	// feedBackfill is how far back the first poll of a feed looks.
+	feedBackfill = time.Hour
+	// feedAlertMaxAge — SOS and messages older than this when first seen
+	// (e.g. right after linking the feed) are not announced.
+	feedAlertMaxAge = 30 * time.Minute
+	maxFeedBody     = 4 << 20
+)
+
+// feedAllowPrivate lets feeds live on loopback and private addresses, for a
+// local stand-in of MapShare or SPOT. Off by default: anyone can /feed a
+// URL in DM, and the bot must not fetch the host's own network for them.
+var feedAllowPrivate = os.Getenv("FEED_ALLOW_PRIVATE") == "1"
+
+var errFeedPrivate = errors.New("адрес во внутренней сети")
+
+// feedHTTPClient checks every address it connects to, after DNS and on
+// redirects, so a public name resolving to a private IP is refused too.
+// Tests swap it for their server's client.
+var feedHTTPClient = &http.Client{
+	Timeout: 20 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: feedDialControl}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// cgnat is the carrier-grade NAT range, internal like RFC 1918 space.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicIP reports whether ip is a unicast address on the internet: not
+// loopback, private, link-local (cloud metadata), CGNAT, multicast or
+// unspecified.
+func publicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnat.Contains(ip)
+}
+
+// feedDialControl refuses connections to non-public addresses unless
+// FEED_ALLOW_PRIVATE is set.
+func feedDialControl(_, address string, _ syscall.RawConn) error {
+	if feedAllowPrivate {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicIP(ap.Addr()) {
+		return fmt.Errorf("%s: %w", ap.Addr(), errFeedPrivate)
+	}
+	return nil
+}
+
+// feedHostAllowed rejects hosts that are internal by their spelling alone;
+// names are checked again on connect.
+func feedHostAllowed(host string) bool {
+	if feedAllowPrivate {
+		return true
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return publicIP(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+// feedFix is one point from a satellite feed, with what came with it.
+type feedFix struct {
+	externalFix
+	Emergency bool   // inReach "In Emergency", SPOT SOS/HELP
+	Text      string // message sent with the point
+}
+
+// feedState is the runtime poll state of a user's feed, for /feed.
+type feedState struct {
+	lastPoll  time.Time
+	lastErr   string
+	lastFix   time.Time
+	lastEvent time.Time // newest point already scanned for SOS/messages
+}
+
+var spotFeedID = regexp.MustCompile(`^[0-9A-Za-z]{20,40}$`)
+
+// normalizeFeedURL accepts what pilots paste: a MapShare page
+// (share.garmin.com/NAME) becomes its KML feed, a bare SPOT feed ID its
+// JSON feed. Anything else must be an http(s) URL.
+func normalizeFeedURL(arg string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if spotFeedID.MatchString(arg) {
+		return "https://api.findmespot.com/spot-main-web/consumer/rest-api/2.0/public/feed/" + arg + "/message.json", nil
+	}
+	u, err := url.Parse(arg)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("нужна ссылка http(s) на MapShare или SPOT-фид")
+	}
+	if !feedHostAllowed(u.Hostname()) {
+		return "", errFeedPrivate
+	}
+	if strings.HasSuffix(u.Host, "share.garmin.com") && !strings.Contains(u.Path, "/Feed/") {
+		name := strings.Trim(u.Path, "/")
+		if name == "" || strings.Contains(name, "/") {
+			return "", errors.New("не похоже на ссылку MapShare: нужна share.garmin.com/<имя>")
+		}
+		u.Path = "/Feed/Share/" + name
+	}
+	return u.String(), nil
+}
+
+// feedURLSince asks a MapShare feed for every point since t; without d1 it
+// returns only the latest one. SPOT feeds return the last messages anyway.
+func feedURLSince(raw string, since time.Time) string {
+	u, err := url.Parse(raw)
+	if err != nil || !strings.Contains(u.Path, "/Feed/Share/") {
+		return raw
+	}
+	q := u.Query()
+	if q.Get("d1") == "" {
+		q.Set("d1", since.UTC().Format("2006-01-02T15:04Z"))
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// fetchFeed downloads a feed.
+func fetchFeed(ctx context.Context, raw string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := feedHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxFeedBody))
+}
+
+// parseFeed reads a MapShare KML or SPOT JSON feed, told apart by content.
+// Fixes are returned oldest first.
+func parseFeed(data []byte) ([]feedFix, error) {
+	data = bytes.TrimSpace(data)
+	var fixes []feedFix
+	var err error
+	switch {
+	case len(data) == 0:
+		return nil, nil
+	case data[0] == '<':
+		fixes, err = parseMapShareKML(data)
+	case data[0] == '{':
+		fixes, err = parseSPOTJSON(data)
+	default:
+		return nil, errors.New("not a MapShare KML or SPOT JSON feed")
+	}
+	sort.SliceStable(fixes, func(i, j int) bool { return fixes[i].At.Before(fixes[j].At) })
+	return fixes, err
+}
+
+// leadingFloat parses the number in MapShare values like "1423.28 m from
+// MSL" or "12.0 km/h".
+func leadingFloat(s string) (float64, bool) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(f[0], 64)
+	return v, err == nil
+}
+
+// parseMapShareKML reads the Point placemarks of an inReach MapShare feed;
+// the values are in ExtendedData.
+func parseMapShareKML(data []byte) ([]feedFix, error) {
+	type placemark struct {
+		When   string `xml:"TimeStamp>when"`
+		Coords string `xml:"Point>coordinates"`
+		Data   []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value"`
+		} `xml:"ExtendedData>Data"`
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var fixes []feedFix
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fixes, fmt.Errorf("decode kml: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "Placemark" {
+			continue
+		}
+		var p placemark
+		if err := dec.DecodeElement(&p, &se); err != nil {
+			return fixes, fmt.Errorf("decode placemark: %w", err)
+		}
+		if p.Coords == "" {
+			continue // the track line
+		}
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(p.When))
+		if err != nil {
+			continue
+		}
+		c := strings.Split(strings.TrimSpace(p.Coords), ",")
+		if len(c) < 2 {
+			continue
+		}
+		lon, err1 := strconv.ParseFloat(c[0], 64)
+		lat, err2 := strconv.ParseFloat(c[1], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		fix := feedFix{externalFix: externalFix{Source: sourceInReach, At: at, Lat: lat, Lon: lon}}
+		valid := true
+		for _, d := range p.Data {
+			v := strings.TrimSpace(d.Value)
+			switch d.Name {
+			case "Elevation":
+				fix.Alt, fix.HasAlt = leadingFloat(v)
+			case "Velocity":
+				fix.Speed, fix.HasSpeed = leadingFloat(v)
+			case "Course":
+				if c, ok := leadingFloat(v); ok && fix.HasSpeed && fix.Speed > 0 {
+					fix.Course = headingCourse(c)
+				}
+			case "In Emergency":
+				fix.Emergency = strings.EqualFold(v, "true")
+			case "Text":
+				fix.Text = v
+			case "Valid GPS Fix":
+				valid = !strings.EqualFold(v, "false")
+			}
+		}
+		if !valid {
+			// No position to use, but an SOS or message still counts.
+			if !fix.Emergency && fix.Text == "" {
+				continue
+			}
+			fix.Lat, fix.Lon, fix.HasAlt, fix.HasSpeed = 0, 0, false, false
+		}
+		fixes = append(fixes, fix)
+	}
+	return fixes, nil
+}
+
+// parseSPOTJSON reads a SPOT public feed. "message" is an object when the
+// feed has a single message; an empty feed is an "errors" reply.
+func parseSPOTJSON(data []byte) ([]feedFix, error) {
+	type message struct {
+		UnixTime       int64   `json:"unixTime"`
+		MessageType    string  `json:"messageType"`
+		Latitude       float64 `json:"latitude"`
+		Longitude      float64 `json:"longitude"`
+		Altitude       float64 `json:"altitude"`
+		MessageContent string  `json:"messageContent"`
+	}
+	var feed struct {
+		Response struct {
+			Feed struct {
+				Messages struct {
+					Message json.RawMessage `json:"message"`
+				} `json:"messages"`
+			} `json:"feedMessageResponse"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("decode spot feed: %w", err)
+	}
+	raw := bytes.TrimSpace(feed.Response.Feed.Messages.Message)
+	var msgs []message
+	switch {
+	case len(raw) == 0:
+		return nil, nil
+	case raw[0] == '[':
+		if err := json.Unmarshal(raw, &msgs); err != nil {
+			return nil, fmt.Errorf("decode spot messages: %w", err)
+		}
+	default:
+		var m message
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, fmt.Errorf("decode spot message: %w", err)
+		}
+		msgs = []message{m}
+	}
+	fixes := make([]feedFix, 0, len(msgs))
+	for _, m := range msgs {
+		fix := feedFix{externalFix: externalFix{
+			Source: sourceSpot, At: time.Unix(m.UnixTime, 0), Lat: m.Latitude, Lon: m.Longitude,
+			// SPOT reports 0 when the device has no altitude.
+			Alt: m.Altitude, HasAlt: m.Altitude != 0,
+		}}
+		switch strings.ToUpper(m.MessageType) {
+		case "SOS", "HELP":
+			fix.Emergency = true
+			fix.Text = m.MessageContent
+		case "OK", "CUSTOM":
+			fix.Text = m.MessageContent
+		}
+		fixes = append(fixes, fix)
+	}
+	return fixes, nil
+}
+
+// feedAlert is a group announcement from a feed: an SOS or a message.
+type feedAlert struct {
+	name      string
+	source    string
+	fix       feedFix
+	emergency bool
+}
+
+func (a feedAlert) text() string {
+	device := strings.TrimPrefix(sourceLabels[a.source], "🛰 ")
+	var where string
+	if a.fix.Lat != 0 || a.fix.Lon != 0 {
+		where = fmt.Sprintf("\n📍 %.5f, %.5f — %s", a.fix.Lat, a.fix.Lon, mapsNavURL(a.fix.Lat, a.fix.Lon))
+	}
+	if a.emergency {
+		text := fmt.Sprintf("🆘 SOS: %s (%s), %s UTC", a.name, device, a.fix.At.UTC().Format("15:04"))
+		if a.fix.Text != "" {
+			text += "\n«" + a.fix.Text + "»"
+		}
+		return text + where
+	}
+	return fmt.Sprintf("💬 %s (%s): %s", a.name, device, a.fix.Text) + where
+}
+
+// applyFeedLocked merges a polled feed into the user's entry: every fix newer
+// than the last accepted one is applied, and SOS and messages not seen before
+// (and not older than feedAlertMaxAge) become alerts. A user without an
+// entry is added only if the group invited them (invitedLocked); otherwise
+// the feed is ignored. Caller must hold t.mu.
+func (t *Tracker) applyFeedLocked(s *GroupSession, u *UserInfo, fixes []feedFix, now time.Time) (evs []fixEvents, alerts []feedAlert, added string) {
+	if t.feeds == nil {
+		t.feeds = make(map[int64]*feedState)
+	}
+	st := t.feeds[u.UserID]
+	if st == nil {
+		st = &feedState{}
+		t.feeds[u.UserID] = st
+	}
+	st.lastPoll, st.lastErr = now, ""
+	if len(fixes) == 0 {
+		return nil, nil, ""
+	}
+
+	key, ok := t.userPilotKeyLocked(s, u.UserID)
+	if !ok {
+		if !invitedLocked(s, u) {
+			// A stranger's feed: nothing of it reaches the group, not
+			// even SOS. Mark the points seen so an invitation later
+			// doesn't announce them stale.
+			for _, fix := range fixes {
+				if fix.At.After(st.lastEvent) {
+					st.lastEvent = fix.At
+				}
+			}
+			return nil, nil, ""
+		}
+		key, added = t.addUserPilotLocked(s, u)
+		slog.Info("pilot added by satellite feed", "key", key, "user_id", u.UserID)
+	}
+	info := s.Tracking[key]
+	name := info.DisplayName()
+	if name == "" {
+		name = key
+	}
+	for _, fix := range fixes {
+		if fix.At.After(st.lastEvent) && now.Sub(fix.At) <= feedAlertMaxAge && (fix.Emergency || fix.Text != "") {
+			alerts = append(alerts, feedAlert{name: name, source: fix.Source, fix: fix, emergency: fix.Emergency})
+			slog.Warn("satellite feed alert", "key", key, "user_id", u.UserID, "sos", fix.Emergency, "text", fix.Text)
+		}
+		if fix.At.After(st.lastEvent) {
+			st.lastEvent = fix.At
+		}
+		if fix.Lat == 0 && fix.Lon == 0 {
+			continue
+		}
+		if fix.At.After(st.lastFix) {
+			st.lastFix = fix.At
+		}
+		if info.Status == StatusPickedUp || (info.Status == StatusLanded && info.LandingConfirmed) {
+			continue
+		}
+		if ev, reason := t.applyExternalFixLocked(s, key, info, fix.externalFix); reason == "" {
+			evs = append(evs, ev)
+		}
+	}
+	return evs, alerts, added
+}
+
+// pollFeeds polls the linked feeds every feedPollInterval while tracking is
+// on.
+func (t *Tracker) pollFeeds() {
+	for {
+		time.Sleep(feedPollInterval)
+		t.mu.Lock()
+		stop := t.shuttingDown
+		t.mu.Unlock()
+		if stop {
+			return
+		}
+		t.pollFeedsOnce(context.Background())
+	}
+}
+
+// pollFeedsOnce fetches every linked feed once and applies the results.
+func (t *Tracker) pollFeedsOnce(ctx context.Context) {
+	type job struct {
+		userID int64
+		url    string
+	}
+	var jobs []job
+	t.mu.Lock()
+	s := t.session
+	if s == nil || !s.TrackingOn {
+		t.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	for _, u := range t.users {
+		if u.FeedURL == "" {
+			continue
+		}
+		since := now.Add(-feedBackfill)
+		if st := t.feeds[u.UserID]; st != nil && st.lastEvent.After(since) {
+			since = st.lastEvent
+		}
+		jobs = append(jobs, job{u.UserID, feedURLSince(u.FeedURL, since)})
+	}
+	t.mu.Unlock()
+
+	for _, j := range jobs {
+		data, err := fetchFeed(ctx, j.url)
+		var fixes []feedFix
+		if err == nil {
+			fixes, err = parseFeed(data)
+		}
+
+		t.mu.Lock()
+		s := t.session
+		u := t.users[j.userID]
+		if s == nil || !s.TrackingOn || u == nil || u.FeedURL == "" {
+			t.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			if t.feeds == nil {
+				t.feeds = make(map[int64]*feedState)
+			}
+			if t.feeds[j.userID] == nil {
+				t.feeds[j.userID] = &feedState{}
+			}
+			t.feeds[j.userID].lastPoll, t.feeds[j.userID].lastErr = time.Now(), err.Error()
+			t.mu.Unlock()
+			slog.Warn("satellite feed poll failed", "user_id", j.userID, "err", err)
+			continue
+		}
+		evs, alerts, added := t.applyFeedLocked(s, u, fixes, time.Now())
+		chatID := s.ChatID
+		save := added != ""
+		for _, ev := range evs {
+			save = save || ev.needsSave()
+		}
+		if save {
+			t.saveState()
+		}
+		t.mu.Unlock()
+		slog.Debug("satellite feed polled", "user_id", j.userID, "points", len(fixes), "applied", len(evs), "alerts", len(alerts))
+
+		for _, ev := range evs {
+			t.deliverFixEvents(ev, chatID)
+		}
+		t.sendFeedAlerts(ctx, chatID, alerts, added)
+	}
+}
+
+// sendFeedAlerts posts SOS and message alerts, and the note that a pilot
+// was added by their feed.
+func (t *Tracker) sendFeedAlerts(ctx context.Context, chatID int64, alerts []feedAlert, added string) {
+	b := t.bot
+	if b == nil {
+		return
+	}
+	for _, a := range alerts {
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   a.text(),
+		}); err != nil {
+			slog.Error("failed to send satellite feed alert", "err", err)
+		}
+	}
+	if added != "" {
+		ackID := t.sendAck(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("🛰 %s отслеживается по спутниковому трекеру", added),
+		}, "failed to confirm feed pilot")
+		t.scheduleEphemeralDelete(chatID, ackID)
+		t.refreshDashboard(ctx, chatID)
+	}
+}
+
+// describeFeed renders the /feed status reply.
+func describeFeed(feedURL string, st *feedState, now time.Time) string {
+	if feedURL == "" {
+		return "🛰 Спутниковый трекер не привязан.\n/feed <ссылка> — ссылка на MapShare (share.garmin.com/<имя>) или ID/ссылка SPOT-фида."
+	}
+	text := "🛰 Фид: " + feedURL
+	switch {
+	case st == nil || st.lastPoll.IsZero():
+		text += "\nЕщё не опрашивался — опрос идёт, пока включён трекинг."
+	case st.lastErr != "":
+		text += fmt.Sprintf("\n⚠️ Опрос %s назад не удался: %s", formatAge(now.Sub(st.lastPoll)), st.lastErr)
+	default:
+		text += fmt.Sprintf("\nОпрошен %s назад.", formatAge(now.Sub(st.lastPoll)))
+	}
+	if st != nil && !st.lastFix.IsZero() {
+		text += fmt.Sprintf(" Последняя точка: %s назад.", formatAge(now.Sub(st.lastFix)))
+	}
+	return text + "\n/feed off — отвязать."
+}
+
+// cmdFeed links a satellite tracker feed in DM: /feed shows it, /feed <url>
+// links (after a test fetch), /feed off unlinks.
+func (t *Tracker) cmdFeed(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	reply := func(text string) {
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   text,
+		}); err != nil {
+			slog.Error("failed to send feed reply", "err", err)
+		}
+	}
+	if !isPrivateChat(m.Chat) {
+		reply("Эта команда работает только в личке.")
+		return
+	}
+
+	arg := commandArgs(m.Text)
+	switch {
+	case arg == "":
+		t.mu.Lock()
+		u := t.ensureUser(m.From)
+		u.DMChatID = m.Chat.ID
+		text := describeFeed(u.FeedURL, t.feeds[u.UserID], time.Now())
+		t.mu.Unlock()
+		reply(text)
+		return
+	case strings.EqualFold(arg, "off"):
+		t.mu.Lock()
+		u := t.ensureUser(m.From)
+		u.FeedURL = ""
+		delete(t.feeds, u.UserID)
+		t.saveState()
+		t.mu.Unlock()
+		slog.Info("cmd /feed off", "user_id", m.From.ID)
+		reply("🛰 Фид отвязан.")
+		return
+	}
+
+	feedURL, err := normalizeFeedURL(arg)
+	if err != nil {
+		reply("Не получилось: " + err.Error() + ".")
+		return
+	}
+	// Check the feed before linking it.
+	data, err := fetchFeed(ctx, feedURLSince(feedURL, time.Now().Add(-24*time.Hour)))
+	var fixes []feedFix
+	if err == nil {
+		fixes, err = parseFeed(data)
+	}
+	if err != nil {
+		slog.Info("cmd /feed: test fetch failed", "user_id", m.From.ID, "url", feedURL, "err", err)
+		reply(fmt.Sprintf("Фид не читается: %v\nПроверьте, что MapShare/SPOT-страница публичная.", err))
+		return
+	}
+
+	t.mu.Lock()
+	u := t.ensureUser(m.From)
+	u.DMChatID = m.Chat.ID
+	u.FeedURL = feedURL
+	if t.feeds == nil {
+		t.feeds = make(map[int64]*feedState)
+	}
+	// Points already in the feed are history: no alerts for them.
+	st := &feedState{}
+	if len(fixes) > 0 {
+		st.lastEvent = fixes[len(fixes)-1].At
+	}
+	t.feeds[u.UserID] = st
+	t.saveState()
+	t.mu.Unlock()
+	slog.Info("cmd /feed", "user_id", m.From.ID, "url", feedURL, "points", len(fixes))
+
+	text := "🛰 Фид привязан: " + feedURL
+	if len(fixes) > 0 {
+		text += fmt.Sprintf("\nПоследняя точка: %s UTC.", fixes[len(fixes)-1].At.UTC().Format("2006-01-02 15:04"))
+	} else {
+		text += "\nТочек за последние сутки нет — появятся, когда трекер начнёт слать."
+	}
+	text += fmt.Sprintf("\nПока включён трекинг, бот опрашивает фид раз в %s; SOS и сообщения с трекера объявляются в группе.", formatAge(feedPollInterval))
+	reply(text)
+}
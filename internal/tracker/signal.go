@@ -0,0 +1,348 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"ogn/parser"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// signalGap — a silence longer than this between two fixes of a pilot
+	// counts as a reception gap.
+	signalGap = time.Minute
+	// snrTrendWindow is how far back the SNR trend looks; the trend compares
+	// its older and newer halves.
+	snrTrendWindow = 10 * time.Minute
+	// snrTrendMinDB — a change of the mean SNR smaller than this is "steady".
+	snrTrendMinDB = 2.0
+	// receiverSilentAfter — a station that heard the session's pilots but
+	// has been quiet this long, while others still hear them, is flagged in
+	// the coverage summary.
+	receiverSilentAfter = 10 * time.Minute
+	// maxSignalReceivers caps the station list in /signal <id>.
+	maxSignalReceivers = 10
+)
+
+// snrSample is the best SNR a fix was received with.
+type snrSample struct {
+	at  time.Time
+	snr float64
+}
+
+// receiverHeard is what one ground station heard of one pilot.
+type receiverHeard struct {
+	Beacons  int
+	LastSeen time.Time
+	SNRSum   float64
+	BestSNR  float64
+}
+
+// SignalStats is a pilot's reception history for /signal: which stations
+// heard them, how often fixes arrived and how the signal evolved. Every copy
+// of a beacon counts, including the relayed duplicates the quality stage
+// drops. Runtime-only; reset by /track_on.
+type SignalStats struct {
+	Receivers map[string]*receiverHeard
+	// Fixes counts distinct fixes (by beacon timestamp) between FirstFix
+	// and LastFix.
+	Fixes    int
+	FirstFix time.Time
+	LastFix  time.Time
+	// Gaps counts silences longer than signalGap; MaxGap is the longest,
+	// starting at MaxGapAt.
+	Gaps     int
+	MaxGap   time.Duration
+	MaxGapAt time.Time
+	// Errors sums the CRC-corrected bit errors of every copy. FreqOffset
+	// and GPSQuality are from the latest beacon that carried them.
+	Errors     int
+	FreqOffset float64
+	GPSQuality string
+	snr        []snrSample
+}
+
+// ReceiverStats is what one ground station heard during the tracking
+// session, across all pilots. Runtime-only.
+type ReceiverStats struct {
+	Beacons   int
+	Pilots    map[string]bool
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// recordSignalLocked adds a beacon of a tracked pilot to the pilot's and the
+// session's reception statistics. Caller must hold t.mu.
+func recordSignalLocked(s *GroupSession, id string, info *TrackInfo, msg *parser.PositionMessage, now time.Time) {
+	sig := info.Signal
+	if sig == nil {
+		sig = &SignalStats{Receivers: make(map[string]*receiverHeard)}
+		info.Signal = sig
+	}
+	at := beaconTime(msg, now)
+	switch {
+	case sig.LastFix.IsZero():
+		sig.FirstFix = at
+		fallthrough
+	case at.After(sig.LastFix):
+		if gap := at.Sub(sig.LastFix); !sig.LastFix.IsZero() && gap > signalGap {
+			sig.Gaps++
+			if gap > sig.MaxGap {
+				sig.MaxGap, sig.MaxGapAt = gap, sig.LastFix
+			}
+		}
+		sig.Fixes++
+		sig.LastFix = at
+		sig.snr = append(sig.snr, snrSample{at, msg.SignalQuality})
+	case at.Equal(sig.LastFix):
+		// Another station heard the same fix; keep the best reception.
+		if last := &sig.snr[len(sig.snr)-1]; msg.SignalQuality > last.snr {
+			last.snr = msg.SignalQuality
+		}
+	}
+	cut := 0
+	for cut < len(sig.snr) && at.Sub(sig.snr[cut].at) > snrTrendWindow {
+		cut++
+	}
+	sig.snr = sig.snr[cut:]
+	sig.Errors += msg.ErrorCount
+	if msg.FreqOffset != 0 {
+		sig.FreqOffset = msg.FreqOffset
+	}
+	if msg.GPSQuality != "" {
+		sig.GPSQuality = msg.GPSQuality
+	}
+
+	if msg.ReceiverName == "" {
+		return
+	}
+	rh := sig.Receivers[msg.ReceiverName]
+	if rh == nil {
+		rh = &receiverHeard{BestSNR: msg.SignalQuality}
+		sig.Receivers[msg.ReceiverName] = rh
+	}
+	rh.Beacons++
+	rh.LastSeen = now
+	rh.SNRSum += msg.SignalQuality
+	if msg.SignalQuality > rh.BestSNR {
+		rh.BestSNR = msg.SignalQuality
+	}
+
+	if s.Receivers == nil {
+		s.Receivers = make(map[string]*ReceiverStats)
+	}
+	rs := s.Receivers[msg.ReceiverName]
+	if rs == nil {
+		rs = &ReceiverStats{Pilots: make(map[string]bool), FirstSeen: now}
+		s.Receivers[msg.ReceiverName] = rs
+	}
+	rs.Beacons++
+	rs.LastSeen = now
+	rs.Pilots[id] = true
+}
+
+// snrTrend compares the mean SNR of the older and newer halves of the trend
+// window. ok is false without samples on both sides.
+func (sig *SignalStats) snrTrend() (older, newer float64, ok bool) {
+	if len(sig.snr) < 2 {
+		return 0, 0, false
+	}
+	mid := sig.snr[len(sig.snr)-1].at.Add(-snrTrendWindow / 2)
+	var so, sn float64
+	var no, nn int
+	for _, x := range sig.snr {
+		if x.at.Before(mid) {
+			so += x.snr
+			no++
+		} else {
+			sn += x.snr
+			nn++
+		}
+	}
+	if no == 0 || nn == 0 {
+		return 0, 0, false
+	}
+	return so / float64(no), sn / float64(nn), true
+}
+
+// formatAge renders a duration as "45с", "12мин" or "1ч 05мин".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%dс", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dмин", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%dч %02dмин", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// formatSignal renders the /signal <id> reply.
+func formatSignal(id, name string, sig *SignalStats, now time.Time, tz *time.Location) string {
+	label := id
+	if name != "" {
+		label += " (" + name + ")"
+	}
+	var sb strings.Builder
+	sb.WriteString("📶 " + label)
+	if sig == nil || sig.Fixes == 0 {
+		sb.WriteString("\nБиконов за эту сессию не было — ни одна станция OGN его не слышала.")
+		return sb.String()
+	}
+
+	span := sig.LastFix.Sub(sig.FirstFix)
+	fmt.Fprintf(&sb, "\nФиксов: %d", sig.Fixes)
+	if span >= time.Minute {
+		fmt.Fprintf(&sb, " за %s (%.1f/мин)", formatAge(span), float64(sig.Fixes-1)/span.Minutes())
+	}
+	fmt.Fprintf(&sb, "\nПоследний: %s (%s назад)", sig.LastFix.In(tz).Format("15:04:05"), formatAge(now.Sub(sig.LastFix)))
+	if sig.Gaps > 0 {
+		fmt.Fprintf(&sb, "\nПропуски >%s: %d, самый длинный %s в %s",
+			formatAge(signalGap), sig.Gaps, formatAge(sig.MaxGap), sig.MaxGapAt.In(tz).Format("15:04"))
+	} else {
+		fmt.Fprintf(&sb, "\nПропусков >%s нет", formatAge(signalGap))
+	}
+	if older, newer, ok := sig.snrTrend(); ok {
+		trend := "→ ровно"
+		switch {
+		case newer-older >= snrTrendMinDB:
+			trend = "↗ растёт"
+		case older-newer >= snrTrendMinDB:
+			trend = "↘ падает"
+		}
+		fmt.Fprintf(&sb, "\nSNR: %s (%.1f → %.1f дБ за %s)", trend, older, newer, formatAge(snrTrendWindow))
+	}
+	var extra []string
+	extra = append(extra, fmt.Sprintf("ошибок %d бит", sig.Errors))
+	if sig.FreqOffset != 0 {
+		extra = append(extra, fmt.Sprintf("сдвиг частоты %+.1f кГц", sig.FreqOffset))
+	}
+	if sig.GPSQuality != "" {
+		extra = append(extra, "GPS "+sig.GPSQuality)
+	}
+	sb.WriteString("\n" + strings.Join(extra, " · "))
+
+	names := make([]string, 0, len(sig.Receivers))
+	for n := range sig.Receivers {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := sig.Receivers[names[i]], sig.Receivers[names[j]]
+		if a.Beacons != b.Beacons {
+			return a.Beacons > b.Beacons
+		}
+		return names[i] < names[j]
+	})
+	if len(names) == 0 {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "\nСтанции (%d):", len(names))
+	for i, n := range names {
+		if i == maxSignalReceivers {
+			fmt.Fprintf(&sb, "\n  … ещё %d", len(names)-i)
+			break
+		}
+		r := sig.Receivers[n]
+		fmt.Fprintf(&sb, "\n  %s — %d биконов, SNR %.1f (лучш. %.1f), %s назад",
+			n, r.Beacons, r.SNRSum/float64(r.Beacons), r.BestSNR, formatAge(now.Sub(r.LastSeen)))
+	}
+	return sb.String()
+}
+
+// formatCoverage renders the session's coverage summary (/signal without
+// arguments). Stations that went quiet while others still hear the pilots
+// are flagged.
+func formatCoverage(receivers map[string]*ReceiverStats, now time.Time) string {
+	if len(receivers) == 0 {
+		return "📡 За эту сессию ни одна станция OGN не слышала отслеживаемых пилотов."
+	}
+	var newest time.Time
+	for _, r := range receivers {
+		if r.LastSeen.After(newest) {
+			newest = r.LastSeen
+		}
+	}
+	feedAlive := now.Sub(newest) < receiverSilentAfter
+	var active, silent []string
+	for n, r := range receivers {
+		if feedAlive && now.Sub(r.LastSeen) >= receiverSilentAfter {
+			silent = append(silent, n)
+		} else {
+			active = append(active, n)
+		}
+	}
+	byBeacons := func(names []string) {
+		sort.Slice(names, func(i, j int) bool {
+			a, b := receivers[names[i]], receivers[names[j]]
+			if a.Beacons != b.Beacons {
+				return a.Beacons > b.Beacons
+			}
+			return names[i] < names[j]
+		})
+	}
+	byBeacons(active)
+	byBeacons(silent)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📡 Покрытие за сессию: %d станций", len(receivers))
+	for _, n := range active {
+		r := receivers[n]
+		fmt.Fprintf(&sb, "\n%s — %d биконов, пилотов %d, %s назад", n, r.Beacons, len(r.Pilots), formatAge(now.Sub(r.LastSeen)))
+	}
+	if len(silent) > 0 {
+		sb.WriteString("\n\n⚠️ Замолчали:")
+		for _, n := range silent {
+			r := receivers[n]
+			fmt.Fprintf(&sb, "\n%s — тишина %s (было %d биконов, пилотов %d)", n, formatAge(now.Sub(r.LastSeen)), r.Beacons, len(r.Pilots))
+		}
+	}
+	if !feedAlive {
+		fmt.Fprintf(&sb, "\n\nНи одна станция не слышала пилотов %s.", formatAge(now.Sub(newest)))
+	}
+	return sb.String()
+}
+
+// cmdSignal shows reception diagnostics: /signal <id> for one pilot, bare
+// /signal for the session's coverage summary.
+func (t *Tracker) cmdSignal(ctx context.Context, b *bot.Bot, update *models.Update) {
+	m := update.Message
+	if m.From == nil || !t.isTrusted(m.From.ID) {
+		return
+	}
+	if !t.requireGroupSession(ctx, b, m) {
+		return
+	}
+	args := commandArgs(m.Text)
+	now := time.Now()
+
+	t.mu.Lock()
+	s := t.session
+	var text string
+	if args == "" {
+		text = formatCoverage(s.Receivers, now)
+	} else {
+		id := shortID(args)
+		if key, ok := s.pilotKey(id); ok {
+			info := s.Tracking[key]
+			text = formatSignal(deviceLabel(key, info), info.DisplayName(), info.Signal, now, s.tz())
+		} else {
+			text = "Не отслеживается: " + id
+		}
+	}
+	t.mu.Unlock()
+	slog.Info("cmd /signal", "args", args, "user_id", m.From.ID)
+
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   text,
+	}); err != nil {
+		slog.Error("failed to send signal report", "err", err)
+	}
+}
@@ -0,0 +1,176 @@
+package tracker
+
+import (
+	"math"
+	"time"
+
+	"ogn/parser"
+)
+
+const (
+	// Measurement noise of a single beacon: GPS altitude, the tracker's own
+	// climb rate and ground speed.
+	smoothAltSigmaM  = 8.0
+	smoothClimbSigma = 2.0 // m/s
+	smoothSpeedSigma = 6.0 // km/h
+	// Process noise: how quickly the true vario (m/s²) and ground speed
+	// (km/h per √s) can change between beacons. Low enough to average out
+	// beacon noise, high enough that a thermal entry shows within ~10 s.
+	smoothAccelSigma = 0.3
+	smoothSpeedDrift = 2.0
+	// smoothResetAfter — after a gap this long the old estimate says nothing
+	// about the new fix and the filter starts over.
+	smoothResetAfter = 2 * time.Minute
+	// varioHistory is how much smoothed altitude history the averaged vario
+	// looks back over.
+	varioHistory = time.Minute
+)
+
+// altSample is one smoothed altitude, kept for the averaged vario.
+type altSample struct {
+	at  time.Time
+	alt float64
+}
+
+// Smoothing is a pilot's filtered flight state: a constant-velocity Kalman
+// filter over altitude and climb rate, and a scalar one over ground speed,
+// fed with every accepted beacon. The raw values stay in TrackInfo.Position
+// and the recorded track. Runtime-only.
+//
+// smoothBeacon replaces the pointer on every fix and never mutates a
+// Smoothing in place, so snapshots may share it.
+type Smoothing struct {
+	At    time.Time
+	Alt   float64 // m
+	Climb float64 // m/s
+	Speed float64 // km/h
+
+	// Covariances: altitude/climb (symmetric 2×2) and speed.
+	pAA, pAC, pCC float64
+	pSpeed        float64
+	history       []altSample
+}
+
+// beaconTime is when a fix was taken: the APRS timestamp, or the arrival time
+// for beacons without one.
+func beaconTime(msg *parser.PositionMessage, arrived time.Time) time.Time {
+	if msg.Timestamp.IsZero() {
+		return arrived
+	}
+	return msg.Timestamp
+}
+
+// smoothBeacon folds an accepted beacon into the pilot's filtered state.
+// Caller must hold t.mu.
+func smoothBeacon(info *TrackInfo, msg *parser.PositionMessage, arrived time.Time) {
+	at := beaconTime(msg, arrived)
+	prev := info.Smooth
+	if prev == nil || at.Sub(prev.At) > smoothResetAfter || at.Before(prev.At.Add(-smoothResetAfter)) {
+		info.Smooth = &Smoothing{
+			At: at, Alt: msg.Altitude, Climb: msg.ClimbRate, Speed: msg.GroundSpeed,
+			pAA:     smoothAltSigmaM * smoothAltSigmaM,
+			pCC:     smoothClimbSigma * smoothClimbSigma,
+			pSpeed:  smoothSpeedSigma * smoothSpeedSigma,
+			history: []altSample{{at, msg.Altitude}},
+		}
+		return
+	}
+
+	s := *prev
+	dt := math.Max(at.Sub(prev.At).Seconds(), 0)
+	if at.After(s.At) {
+		s.At = at
+	}
+
+	// Predict: altitude moves with the climb rate; the climb rate itself
+	// drifts with white-noise acceleration.
+	q := smoothAccelSigma * smoothAccelSigma
+	s.Alt += s.Climb * dt
+	s.pAA += 2*dt*s.pAC + dt*dt*s.pCC + q*dt*dt*dt*dt/4
+	s.pAC += dt*s.pCC + q*dt*dt*dt/2
+	s.pCC += q * dt * dt
+
+	// Update with the measured altitude…
+	r := smoothAltSigmaM * smoothAltSigmaM
+	kA, kC := s.pAA/(s.pAA+r), s.pAC/(s.pAA+r)
+	y := msg.Altitude - s.Alt
+	s.Alt += kA * y
+	s.Climb += kC * y
+	s.pAA, s.pAC, s.pCC = (1-kA)*s.pAA, (1-kA)*s.pAC, s.pCC-kC*s.pAC
+
+	// …then with the tracker's climb rate.
+	r = smoothClimbSigma * smoothClimbSigma
+	kA, kC = s.pAC/(s.pCC+r), s.pCC/(s.pCC+r)
+	y = msg.ClimbRate - s.Climb
+	s.Alt += kA * y
+	s.Climb += kC * y
+	s.pAA, s.pAC, s.pCC = s.pAA-kA*s.pAC, (1-kC)*s.pAC, (1-kC)*s.pCC
+
+	// Ground speed is a random walk.
+	s.pSpeed += smoothSpeedDrift * smoothSpeedDrift * dt
+	k := s.pSpeed / (s.pSpeed + smoothSpeedSigma*smoothSpeedSigma)
+	s.Speed = math.Max(s.Speed+k*(msg.GroundSpeed-s.Speed), 0)
+	s.pSpeed *= 1 - k
+
+	cut := 0
+	for cut < len(prev.history) && s.At.Sub(prev.history[cut].at) > varioHistory {
+		cut++
+	}
+	s.history = append(prev.history[cut:len(prev.history):len(prev.history)], altSample{s.At, s.Alt})
+	info.Smooth = &s
+}
+
+// averageVario is the mean climb rate over the last window, from the
+// smoothed altitude. ok is false until at least two thirds of the window is
+// covered.
+func (s *Smoothing) averageVario(window time.Duration) (float64, bool) {
+	if s == nil || len(s.history) < 2 {
+		return 0, false
+	}
+	last := s.history[len(s.history)-1]
+	for _, h := range s.history {
+		span := last.at.Sub(h.at)
+		if span > window {
+			continue
+		}
+		if span < window*2/3 {
+			return 0, false
+		}
+		return (last.alt - h.alt) / span.Seconds(), true
+	}
+	return 0, false
+}
+
+// climb is the pilot's climb rate for display and decisions: smoothed when
+// available, raw otherwise.
+func (ti *TrackInfo) climb() float64 {
+	if ti.Smooth != nil {
+		return ti.Smooth.Climb
+	}
+	if ti.Position != nil {
+		return ti.Position.ClimbRate
+	}
+	return 0
+}
+
+// speed is the pilot's ground speed (km/h), smoothed when available.
+func (ti *TrackInfo) speed() float64 {
+	if ti.Smooth != nil {
+		return ti.Smooth.Speed
+	}
+	if ti.Position != nil {
+		return ti.Position.GroundSpeed
+	}
+	return 0
+}
+
+// altitude is the pilot's altitude (m), smoothed when available.
+func (ti *TrackInfo) altitude() float64 {
+	if ti.Smooth != nil {
+		return ti.Smooth.Alt
+	}
+	if ti.Position != nil {
+		return ti.Position.Altitude
+	}
+	return 0
+}
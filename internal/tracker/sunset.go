@@ -0,0 +1,366 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// Solar zenith angles: official sunset includes refraction and the
+	// solar disc radius; civil dusk is the sun 6° below the horizon.
+	zenithSunset    = 90.833
+	zenithCivilDusk = 96.0
+)
+
+// defaultSunsetWarnings are the minutes-before-sunset reminders used until a
+// chat configures its own with /sunset.
+var defaultSunsetWarnings = []int{60, 30, 0}
+
+// SunsetConfig is the per-chat sunset reminder configuration. A nil config
+// on the session means "defaults".
+type SunsetConfig struct {
+	Off    bool  `json:"off,omitempty"` // reminders and roll-call muted
+	WarnAt []int `json:"warn_at"`       // minutes before sunset, descending
+}
+
+// sunset returns the session's effective sunset configuration.
+func (s *GroupSession) sunset() SunsetConfig {
+	if s == nil || s.Sunset == nil {
+		return SunsetConfig{WarnAt: append([]int(nil), defaultSunsetWarnings...)}
+	}
+	return *s.Sunset
+}
+
+// sunAnchor is the point sun times are computed for: the landing field, or
+// the area centre when no landing is set.
+func (s *GroupSession) sunAnchor() *Coordinates {
+	if s.Landing != nil {
+		return s.Landing
+	}
+	return s.TrackArea
+}
+
+// sunSetTime computes when the sun reaches zenith (degrees) in the evening of
+// the calendar day of date (in date's location), using the NOAA "Almanac for
+// Computers" approximation — accurate to a minute or two at mid latitudes.
+// ok is false when the sun doesn't get that low that day (polar summer).
+func sunSetTime(date time.Time, lat, lon, zenith float64) (time.Time, bool) {
+	rad := math.Pi / 180
+	n := float64(date.YearDay())
+	lngHour := lon / 15
+	t := n + (18-lngHour)/24
+
+	m := 0.9856*t - 3.289
+	l := math.Mod(m+1.916*math.Sin(m*rad)+0.020*math.Sin(2*m*rad)+282.634+360, 360)
+	ra := math.Mod(math.Atan(0.91764*math.Tan(l*rad))/rad+360, 360)
+	ra += math.Floor(l/90)*90 - math.Floor(ra/90)*90
+	ra /= 15
+
+	sinDec := 0.39782 * math.Sin(l*rad)
+	cosDec := math.Cos(math.Asin(sinDec))
+	cosH := (math.Cos(zenith*rad) - sinDec*math.Sin(lat*rad)) / (cosDec * math.Cos(lat*rad))
+	if cosH < -1 || cosH > 1 {
+		return time.Time{}, false
+	}
+	h := math.Acos(cosH) / rad / 15
+	ut := math.Mod(h+ra-0.06571*t-6.622-lngHour+48, 24)
+
+	// ut is a time of day in UTC; pin it to the UTC day closest to local
+	// solar evening of the requested date.
+	y, mo, d := date.Date()
+	midnight := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+	at := midnight.Add(time.Duration(ut * float64(time.Hour)))
+	expected := midnight.Add(time.Duration((12 - lngHour + h) * float64(time.Hour)))
+	for at.Sub(expected) > 12*time.Hour {
+		at = at.Add(-24 * time.Hour)
+	}
+	for expected.Sub(at) > 12*time.Hour {
+		at = at.Add(24 * time.Hour)
+	}
+	return at, true
+}
+
+// sunTimes returns official sunset and end of civil dusk for the local day
+// of now at (lat, lon).
+func sunTimes(now time.Time, lat, lon float64, tz *time.Location) (sunset, dusk time.Time, ok bool) {
+	day := now.In(tz)
+	sunset, ok1 := sunSetTime(day, lat, lon, zenithSunset)
+	dusk, ok2 := sunSetTime(day, lat, lon, zenithCivilDusk)
+	return sunset, dusk, ok1 && ok2
+}
+
+// formatCountdown renders a positive duration as "48 мин" or "2ч 05м".
+func formatCountdown(d time.Duration) string {
+	mins := int(d.Round(time.Minute).Minutes())
+	if mins < 60 {
+		return fmt.Sprintf("%d мин", mins)
+	}
+	return fmt.Sprintf("%dч %02dм", mins/60, mins%60)
+}
+
+// buildSunsetLine renders the dashboard sunset line for anchor, or "" when
+// there is nothing to show (no anchor, polar day).
+func buildSunsetLine(anchor *Coordinates, now time.Time, tz *time.Location) string {
+	if anchor == nil {
+		return ""
+	}
+	sunset, dusk, ok := sunTimes(now, anchor.Latitude, anchor.Longitude, tz)
+	if !ok {
+		return ""
+	}
+	switch {
+	case now.Before(sunset):
+		return fmt.Sprintf("🌅 Закат через %s (%s)", formatCountdown(sunset.Sub(now)), sunset.In(tz).Format("15:04"))
+	case now.Before(dusk):
+		return fmt.Sprintf("🌇 Закат был в %s, сумерки до %s", sunset.In(tz).Format("15:04"), dusk.In(tz).Format("15:04"))
+	default:
+		return fmt.Sprintf("🌃 Темно: сумерки закончились в %s", dusk.In(tz).Format("15:04"))
+	}
+}
+
+// sunsetMessage is a chat or DM notification produced by checkSunset.
+type sunsetMessage struct {
+	chatID int64
+	text   string
+	kb     *models.ReplyKeyboardMarkup // DM "🪂 Сел" keyboard for roll-call
+}
+
+// dueSunsetWarning picks the reminder to send now: the latest offset whose
+// time has come and that hasn't been sent yet. Earlier offsets that were
+// skipped (tracking started late) are returned in skipped so they can be
+// marked without spamming the chat with stale reminders.
+func dueSunsetWarning(warnAt []int, sunset, now time.Time, sent func(int) bool) (due int, skipped []int, ok bool) {
+	due = -1
+	for _, m := range warnAt {
+		if now.Before(sunset.Add(-time.Duration(m)*time.Minute)) || sent(m) {
+			continue
+		}
+		if due < 0 || m < due {
+			if due >= 0 {
+				skipped = append(skipped, due)
+			}
+			due = m
+		} else {
+			skipped = append(skipped, m)
+		}
+	}
+	return due, skipped, due >= 0
+}
+
+// sunsetEventsLocked advances the sunset reminder state for the current
+// session and returns the messages to send. Reminders before sunset go to
+// the chat and to DMs of pilots still in the air; at civil dusk a roll-call
+// lists every tracked pilot who hasn't landed. Each step fires once per day,
+// tracked in s.SunsetNotified. Caller must hold t.mu.
+func (t *Tracker) sunsetEventsLocked(s *GroupSession, now time.Time) []sunsetMessage {
+	cfg := s.sunset()
+	anchor := s.sunAnchor()
+	if cfg.Off || anchor == nil {
+		return nil
+	}
+	tz := s.tz()
+	sunset, dusk, ok := sunTimes(now, anchor.Latitude, anchor.Longitude, tz)
+	if !ok {
+		return nil
+	}
+	day := now.In(tz).Format("2006-01-02")
+	if s.SunsetNotified == nil {
+		s.SunsetNotified = make(map[string]bool)
+	}
+	key := func(suffix string) string { return day + ":" + suffix }
+	// Only today's steps matter; earlier days would pile up in the state
+	// file of a long-lived chat.
+	for k := range s.SunsetNotified {
+		if !strings.HasPrefix(k, day+":") {
+			delete(s.SunsetNotified, k)
+		}
+	}
+
+	type pilot struct {
+		id, label string
+		info      *TrackInfo
+	}
+	var airborne []pilot
+	for id, info := range s.Tracking {
+		if info.AutoDiscovered || info.Status != StatusFlying {
+			continue
+		}
+		label := info.DisplayName()
+		if label == "" {
+			label = id
+		}
+		airborne = append(airborne, pilot{id, label, info})
+	}
+	sort.Slice(airborne, func(i, j int) bool { return airborne[i].label < airborne[j].label })
+
+	dmOf := func(owner int64) int64 {
+		if u, ok := t.users[owner]; ok && owner != 0 {
+			return u.DMChatID
+		}
+		return 0
+	}
+
+	var out []sunsetMessage
+	if !now.Before(dusk) {
+		if s.SunsetNotified[key("dusk")] || len(airborne) == 0 {
+			s.SunsetNotified[key("dusk")] = true
+			return nil
+		}
+		s.SunsetNotified[key("dusk")] = true
+		var lines []string
+		for _, p := range airborne {
+			line := "• " + p.label
+			if p.info.Position == nil {
+				line += " — нет данных"
+			} else {
+				line += fmt.Sprintf(" — %.0fм, бикон %s", p.info.Position.Altitude, p.info.LastUpdate.In(tz).Format("15:04"))
+			}
+			lines = append(lines, line)
+			if dm := dmOf(p.info.OwnerUserID); dm != 0 {
+				out = append(out, sunsetMessage{
+					chatID: dm,
+					text:   "🌃 Стемнело. Ты уже сел? Нажми «🪂 Сел» или отправь точку посадки.",
+					kb:     t.dmReplyKeyboard(p.info.OwnerUserID),
+				})
+			}
+		}
+		text := fmt.Sprintf("🌃 Гражданские сумерки (%s). Перекличка — ещё не отметились:\n%s",
+			dusk.In(tz).Format("15:04"), strings.Join(lines, "\n"))
+		out = append([]sunsetMessage{{chatID: s.ChatID, text: text}}, out...)
+		slog.Info("sunset roll-call", "pilots", len(airborne))
+		return out
+	}
+
+	sent := func(m int) bool { return s.SunsetNotified[key(strconv.Itoa(m))] }
+	due, skipped, ok := dueSunsetWarning(cfg.WarnAt, sunset, now, sent)
+	if !ok {
+		return nil
+	}
+	for _, m := range append(skipped, due) {
+		s.SunsetNotified[key(strconv.Itoa(m))] = true
+	}
+	var flying []pilot
+	for _, p := range airborne {
+		if p.info.Position != nil {
+			flying = append(flying, p)
+		}
+	}
+	if len(flying) == 0 {
+		return nil
+	}
+	names := make([]string, len(flying))
+	for i, p := range flying {
+		names[i] = p.label
+	}
+	at := sunset.In(tz).Format("15:04")
+	var chatText, dmText string
+	if due == 0 {
+		chatText = fmt.Sprintf("🌇 Закат (%s). В воздухе: %s — пора садиться", at, strings.Join(names, ", "))
+		dmText = fmt.Sprintf("🌇 Закат (%s) — пора садиться", at)
+	} else {
+		chatText = fmt.Sprintf("🌅 До заката %d мин (%s). В воздухе: %s", due, at, strings.Join(names, ", "))
+		dmText = fmt.Sprintf("🌅 До заката %d мин (%s)", due, at)
+	}
+	out = append(out, sunsetMessage{chatID: s.ChatID, text: chatText})
+	for _, p := range flying {
+		if dm := dmOf(p.info.OwnerUserID); dm != 0 {
+			out = append(out, sunsetMessage{chatID: dm, text: dmText})
+		}
+	}
+	slog.Info("sunset warning", "minutes", due, "flying", len(flying))
+	return out
+}
+
+// checkSunset runs the sunset reminders for the current session. Called from
+// the sendUpdates ticker, so it only fires while tracking is on.
+func (t *Tracker) checkSunset(ctx context.Context, now time.Time) {
+	t.mu.Lock()
+	s := t.session
+	if s == nil {
+		t.mu.Unlock()
+		return
+	}
+	msgs := t.sunsetEventsLocked(s, now)
+	if len(msgs) > 0 {
+		t.saveState()
+	}
+	b := t.bot
+	t.mu.Unlock()
+
+	if b == nil {
+		return
+	}
+	for _, m := range msgs {
+		params := &bot.SendMessageParams{ChatID: m.chatID, Text: m.text}
+		if m.kb != nil {
+			params.ReplyMarkup = m.kb
+		}
+		if _, err := b.SendMessage(ctx, params); err != nil {
+			slog.Error("failed to send sunset reminder", "chat_id", m.chatID, "err", err)
+		}
+	}
+}
+
+// describeSunset renders the /sunset status reply.
+func describeSunset(cfg SunsetConfig, anchor *Coordinates, now time.Time, tz *time.Location) string {
+	state := "вкл."
+	if cfg.Off {
+		state = "выкл."
+	}
+	warn := "—"
+	if len(cfg.WarnAt) > 0 {
+		parts := make([]string, len(cfg.WarnAt))
+		for i, m := range cfg.WarnAt {
+			parts[i] = "T-" + strconv.Itoa(m)
+		}
+		warn = strings.Join(parts, ", ")
+	}
+	text := fmt.Sprintf("🌅 Напоминания о закате: %s\nПредупреждения: %s мин", state, warn)
+	if anchor == nil {
+		return text + "\nЗадайте /landing или /area, чтобы считать закат."
+	}
+	if sunset, dusk, ok := sunTimes(now, anchor.Latitude, anchor.Longitude, tz); ok {
+		text += fmt.Sprintf("\nСегодня: закат %s, конец сумерек %s", sunset.In(tz).Format("15:04"), dusk.In(tz).Format("15:04"))
+	}
+	return text
+}
+
+// applySunsetArgs updates cfg from /sunset arguments: "on", "off", or a
+// list of minutes before sunset ("60 30 0"). Returns false on malformed
+// input.
+func applySunsetArgs(cfg *SunsetConfig, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		cfg.Off = false
+		return len(args) == 1
+	case "off":
+		cfg.Off = true
+		return len(args) == 1
+	}
+	if len(args) > 6 {
+		return false
+	}
+	warn := make([]int, 0, len(args))
+	for _, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil || n < 0 || n > 240 {
+			return false
+		}
+		warn = append(warn, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(warn)))
+	cfg.WarnAt = warn
+	return true
+}
@@ -0,0 +1,536 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+const (
+	// Default cylinder radii (metres) for /task points given without one.
+	// Typical club-competition values; goal-line radius is half the line
+	// length.
+	defaultStartRadius = 2000
+	defaultTPRadius    = 400
+	defaultGoalRadius  = 400
+	// maxTaskRadius guards against typos like "40000" meant as "400".
+	maxTaskRadius = 50000
+	// maxTurnpoints bounds the task size so the dashboard block stays short.
+	maxTurnpoints = 12
+)
+
+// TaskPoint is one cylinder of a race task. For a goal line, RadiusM is half
+// the line length.
+type TaskPoint struct {
+	Name    string  `json:"name,omitempty"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	RadiusM float64 `json:"radius_m"`
+}
+
+// RaceTask is a competition task set with /task: exit the start cylinder,
+// tag every turnpoint in order, then reach goal.
+type RaceTask struct {
+	Start      TaskPoint   `json:"start"`
+	Turnpoints []TaskPoint `json:"turnpoints,omitempty"`
+	Goal       TaskPoint   `json:"goal"`
+	// GoalLine switches goal from a cylinder to a line perpendicular to the
+	// last leg, centred on Goal.
+	GoalLine bool `json:"goal_line,omitempty"`
+}
+
+// RaceProgress is one pilot's progress through the current task.
+type RaceProgress struct {
+	StartTime  time.Time   `json:"start_time,omitempty"`
+	Tagged     []time.Time `json:"tagged,omitempty"` // tag time per reached turnpoint, in task order
+	FinishTime time.Time   `json:"finish_time,omitempty"`
+}
+
+// started reports whether the pilot has crossed the start.
+func (p *RaceProgress) started() bool { return p != nil && !p.StartTime.IsZero() }
+
+// finished reports whether the pilot has reached goal.
+func (p *RaceProgress) finished() bool { return p != nil && !p.FinishTime.IsZero() }
+
+// raceStep is what a single fix changed in a pilot's race progress.
+type raceStep int
+
+const (
+	raceNone raceStep = iota
+	raceStarted
+	raceTagged
+	raceFinished
+)
+
+// raceFinishEvent captures a goal crossing so the announcement can be sent
+// outside the mutex.
+type raceFinishEvent struct {
+	id      string
+	name    string
+	elapsed time.Duration
+	rank    int
+}
+
+// legLengthKm returns the centre-to-centre task distance in km.
+func (task *RaceTask) legLengthKm() float64 {
+	pts := task.points()
+	var total float64
+	for i := 1; i < len(pts); i++ {
+		total += taskPointDistKm(pts[i-1], pts[i])
+	}
+	return total
+}
+
+// points returns start, turnpoints and goal as one ordered slice.
+func (task *RaceTask) points() []TaskPoint {
+	pts := make([]TaskPoint, 0, len(task.Turnpoints)+2)
+	pts = append(pts, task.Start)
+	pts = append(pts, task.Turnpoints...)
+	return append(pts, task.Goal)
+}
+
+func taskPointDistKm(a, b TaskPoint) float64 {
+	d, _ := distanceAndBearing(a.Lat, a.Lon, b.Lat, b.Lon)
+	return d
+}
+
+// localXY projects (lat, lon) to a flat east/north plane in metres around
+// the origin. Accurate enough for the few-kilometre geometry of cylinders
+// and goal lines.
+func localXY(originLat, originLon, lat, lon float64) (x, y float64) {
+	const mPerDegLat = 111_320.0
+	x = (lon - originLon) * mPerDegLat * math.Cos(originLat*math.Pi/180)
+	y = (lat - originLat) * mPerDegLat
+	return x, y
+}
+
+// segmentReachesCylinder reports whether the straight segment a→b passes
+// within the cylinder. Checking the segment rather than just b catches
+// fast pilots that clip a small cylinder between two beacons.
+func segmentReachesCylinder(c TaskPoint, a, b TrackPoint) bool {
+	ax, ay := localXY(c.Lat, c.Lon, a.Lat, a.Lon)
+	bx, by := localXY(c.Lat, c.Lon, b.Lat, b.Lon)
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l2))
+	}
+	px, py := ax+t*dx, ay+t*dy
+	return math.Hypot(px, py) <= c.RadiusM
+}
+
+// insideCylinder reports whether p is inside the cylinder c.
+func insideCylinder(c TaskPoint, p TrackPoint) bool {
+	x, y := localXY(c.Lat, c.Lon, p.Lat, p.Lon)
+	return math.Hypot(x, y) <= c.RadiusM
+}
+
+// crossesGoalLine reports whether a→b crosses the goal line: the segment of
+// length 2·RadiusM through the goal centre, perpendicular to the course
+// from prev (the last turnpoint) to goal. Crossing must be in the direction
+// of that course.
+func crossesGoalLine(goal, prev TaskPoint, a, b TrackPoint) bool {
+	// Unit vector of the incoming leg, in goal-centred coordinates.
+	px, py := localXY(goal.Lat, goal.Lon, prev.Lat, prev.Lon)
+	ux, uy := -px, -py
+	n := math.Hypot(ux, uy)
+	if n == 0 {
+		return false
+	}
+	ux, uy = ux/n, uy/n
+	ax, ay := localXY(goal.Lat, goal.Lon, a.Lat, a.Lon)
+	bx, by := localXY(goal.Lat, goal.Lon, b.Lat, b.Lon)
+	// Along-course coordinates: negative before the line, positive after.
+	sa := ax*ux + ay*uy
+	sb := bx*ux + by*uy
+	if sa >= 0 || sb < 0 {
+		return false
+	}
+	f := sa / (sa - sb)
+	cx, cy := ax+f*(bx-ax), ay+f*(by-ay)
+	lateral := math.Abs(cx*uy - cy*ux)
+	return lateral <= goal.RadiusM
+}
+
+// advanceRace applies one fix to a pilot's progress. prev is the previous
+// fix (zero Time when there is none). Start is an exit start: leaving the
+// start cylinder (re)starts the clock until the first turnpoint is tagged.
+// Pure function over its arguments; mutates p only.
+func advanceRace(task *RaceTask, p *RaceProgress, prev, cur TrackPoint) raceStep {
+	if task == nil || p == nil || p.finished() {
+		return raceNone
+	}
+	hasPrev := !prev.Time.IsZero()
+	if !hasPrev {
+		prev = cur
+	}
+	step := raceNone
+
+	if len(p.Tagged) == 0 && hasPrev && insideCylinder(task.Start, prev) && !insideCylinder(task.Start, cur) {
+		p.StartTime = cur.Time
+		step = raceStarted
+	}
+	if !p.started() {
+		return step
+	}
+
+	for len(p.Tagged) < len(task.Turnpoints) {
+		if !segmentReachesCylinder(task.Turnpoints[len(p.Tagged)], prev, cur) {
+			return step
+		}
+		p.Tagged = append(p.Tagged, cur.Time)
+		step = raceTagged
+	}
+
+	reached := false
+	if task.GoalLine {
+		last := task.Start
+		if n := len(task.Turnpoints); n > 0 {
+			last = task.Turnpoints[n-1]
+		}
+		reached = hasPrev && crossesGoalLine(task.Goal, last, prev, cur)
+	} else {
+		reached = segmentReachesCylinder(task.Goal, prev, cur)
+	}
+	if reached {
+		p.FinishTime = cur.Time
+		step = raceFinished
+	}
+	return step
+}
+
+// raceRemainingKm estimates the distance still to fly from (lat, lon): to
+// the edge of the next point, then centre-to-centre along the rest of the
+// task. Returns 0 once finished.
+func raceRemainingKm(task *RaceTask, p *RaceProgress, lat, lon float64) (toNext, total float64) {
+	if task == nil || p.finished() {
+		return 0, 0
+	}
+	pts := task.points()
+	next := 1 + len(p.Tagged) // index into pts; 0 is the start cylinder
+	if !p.started() {
+		next = 0
+	}
+	np := pts[next]
+	d, _ := distanceAndBearing(lat, lon, np.Lat, np.Lon)
+	toNext = math.Max(0, d-np.RadiusM/1000)
+	total = toNext
+	for i := next + 1; i < len(pts); i++ {
+		total += taskPointDistKm(pts[i-1], pts[i])
+	}
+	return toNext, total
+}
+
+// stepRaceLocked feeds a fresh fix into the session's race and returns a
+// finish event when the pilot just reached goal. prev is the pilot's
+// previous fix (zero Time if none). Caller must hold t.mu.
+func stepRaceLocked(s *GroupSession, id string, info *TrackInfo, prev, cur TrackPoint) (raceStep, *raceFinishEvent) {
+	if s.Task == nil || info.AutoDiscovered {
+		return raceNone, nil
+	}
+	if s.Race == nil {
+		s.Race = make(map[string]*RaceProgress)
+	}
+	p, ok := s.Race[id]
+	if !ok {
+		p = &RaceProgress{}
+		s.Race[id] = p
+	}
+	step := advanceRace(s.Task, p, prev, cur)
+	switch step {
+	case raceStarted:
+		slog.Info("race start", "id", id, "at", p.StartTime)
+	case raceTagged:
+		slog.Info("race turnpoint tagged", "id", id, "tagged", len(p.Tagged))
+	case raceFinished:
+		rank := 0
+		for _, other := range s.Race {
+			if other.finished() && other.FinishTime.Sub(other.StartTime) <= p.FinishTime.Sub(p.StartTime) {
+				rank++
+			}
+		}
+		slog.Info("race finish", "id", id, "elapsed", p.FinishTime.Sub(p.StartTime), "rank", rank)
+		return step, &raceFinishEvent{
+			id:      id,
+			name:    info.DisplayName(),
+			elapsed: p.FinishTime.Sub(p.StartTime),
+			rank:    rank,
+		}
+	}
+	return step, nil
+}
+
+// sendRaceFinish announces a pilot reaching goal. Must be called outside
+// t.mu.
+func (t *Tracker) sendRaceFinish(e *raceFinishEvent, chatID int64) {
+	b := t.bot
+	if b == nil {
+		return
+	}
+	label := e.id
+	if e.name != "" {
+		label = e.name
+	}
+	text := fmt.Sprintf("🏁 %s в цели! Время: %s · место %d", label, formatRaceDuration(e.elapsed), e.rank)
+	if _, err := b.SendMessage(context.Background(), &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	}); err != nil {
+		slog.Error("failed to send race finish", "id", e.id, "err", err)
+	}
+}
+
+// formatRaceDuration renders a speed-section time as h:mm:ss.
+func formatRaceDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	sec := int(d.Seconds()) % 60
+	return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+}
+
+// describeTask renders the task definition for the /task reply.
+func describeTask(task *RaceTask) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🏁 Задача: %.1f км (по центрам)", task.legLengthKm())
+	line := func(label string, p TaskPoint) {
+		fmt.Fprintf(&sb, "\n%s %.5f, %.5f · R %.0fм", label, p.Lat, p.Lon, p.RadiusM)
+		if p.Name != "" {
+			sb.WriteString(" · " + p.Name)
+		}
+	}
+	line("Старт (выход):", task.Start)
+	for i, tp := range task.Turnpoints {
+		line(fmt.Sprintf("ТП%d:", i+1), tp)
+	}
+	if task.GoalLine {
+		line("Цель (линия):", task.Goal)
+	} else {
+		line("Цель:", task.Goal)
+	}
+	return sb.String()
+}
+
+// raceRow is one pilot's line on the race board.
+type raceRow struct {
+	id      string
+	label   string
+	p       RaceProgress
+	toNext  float64
+	total   float64
+	hasPos  bool
+	elapsed time.Duration
+}
+
+// buildRaceBoard renders the live race leaderboard for the dashboard:
+// finishers by speed-section time, then pilots en route by turnpoints
+// tagged and remaining distance, then those not yet started.
+func buildRaceBoard(task *RaceTask, race map[string]*RaceProgress, tracking map[string]*TrackInfo, now time.Time) string {
+	if task == nil {
+		return ""
+	}
+	var rows []raceRow
+	for id, info := range tracking {
+		if info.AutoDiscovered {
+			continue
+		}
+		r := raceRow{id: id, label: id}
+		if name := info.DisplayName(); name != "" {
+			r.label = name
+		}
+		if p, ok := race[id]; ok {
+			r.p = *p
+		}
+		if info.Position != nil {
+			r.hasPos = true
+			r.toNext, r.total = raceRemainingKm(task, &r.p, info.Position.Latitude, info.Position.Longitude)
+		}
+		switch {
+		case r.p.finished():
+			r.elapsed = r.p.FinishTime.Sub(r.p.StartTime)
+		case r.p.started():
+			r.elapsed = now.Sub(r.p.StartTime)
+		}
+		rows = append(rows, r)
+	}
+	rank := func(r raceRow) int {
+		switch {
+		case r.p.finished():
+			return 0
+		case r.p.started():
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		switch rank(a) {
+		case 0:
+			if a.elapsed != b.elapsed {
+				return a.elapsed < b.elapsed
+			}
+		case 1:
+			if len(a.p.Tagged) != len(b.p.Tagged) {
+				return len(a.p.Tagged) > len(b.p.Tagged)
+			}
+			if a.hasPos != b.hasPos {
+				return a.hasPos
+			}
+			if a.total != b.total {
+				return a.total < b.total
+			}
+		}
+		return a.id < b.id
+	})
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🏁 Гонка · %.1f км · %d ТП", task.legLengthKm(), len(task.Turnpoints))
+	nTP := len(task.Turnpoints)
+	for i, r := range rows {
+		fmt.Fprintf(&sb, "\n%d. %s — ", i+1, r.label)
+		switch rank(r) {
+		case 0:
+			fmt.Fprintf(&sb, "в цели ⏱ %s", formatRaceDuration(r.elapsed))
+		case 1:
+			next := "цели"
+			if len(r.p.Tagged) < nTP {
+				next = fmt.Sprintf("ТП%d", len(r.p.Tagged)+1)
+			}
+			fmt.Fprintf(&sb, "ТП %d/%d", len(r.p.Tagged), nTP)
+			if r.hasPos {
+				fmt.Fprintf(&sb, " · %.1f км до %s", r.toNext, next)
+			}
+			fmt.Fprintf(&sb, " · ⏱ %s", formatRaceDuration(r.elapsed))
+		default:
+			sb.WriteString("не стартовал")
+		}
+	}
+	return sb.String()
+}
+
+// errTaskSyntax is returned by parseTask for malformed input; the caller
+// shows the usage text.
+var errTaskSyntax = errors.New("task syntax")
+
+// parseTask parses the /task body. One point per line (or separated by
+// ';'):
+//
+//	start <lat> <lon> [radius_m] [name]
+//	tp    <lat> <lon> [radius_m] [name]
+//	goal  <lat> <lon> [radius_m] [name]
+//	line  <lat> <lon> [half_length_m] [name]
+//
+// "<lat> <lon>" may also be written "lat,lon", or replaced by the word
+// "landing" to reuse the session's landing point. landing may be nil.
+func parseTask(text string, landing *Coordinates) (*RaceTask, error) {
+	task := &RaceTask{}
+	var haveStart, haveGoal bool
+	for _, raw := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ';' }) {
+		fields := strings.Fields(raw)
+		if len(fields) == 0 {
+			continue
+		}
+		kind := strings.ToLower(fields[0])
+		p, err := parseTaskPoint(fields[1:], landing)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", errTaskSyntax, strings.TrimSpace(raw), err)
+		}
+		switch kind {
+		case "start":
+			if haveStart {
+				return nil, fmt.Errorf("%w: два старта", errTaskSyntax)
+			}
+			if p.RadiusM == 0 {
+				p.RadiusM = defaultStartRadius
+			}
+			task.Start, haveStart = p, true
+		case "tp":
+			if len(task.Turnpoints) >= maxTurnpoints {
+				return nil, fmt.Errorf("%w: больше %d ТП", errTaskSyntax, maxTurnpoints)
+			}
+			if p.RadiusM == 0 {
+				p.RadiusM = defaultTPRadius
+			}
+			task.Turnpoints = append(task.Turnpoints, p)
+		case "goal", "line":
+			if haveGoal {
+				return nil, fmt.Errorf("%w: две цели", errTaskSyntax)
+			}
+			if p.RadiusM == 0 {
+				p.RadiusM = defaultGoalRadius
+			}
+			task.Goal, haveGoal = p, true
+			task.GoalLine = kind == "line"
+		default:
+			return nil, fmt.Errorf("%w: неизвестный тип точки %q", errTaskSyntax, fields[0])
+		}
+	}
+	if !haveStart || !haveGoal {
+		return nil, fmt.Errorf("%w: нужны start и goal/line", errTaskSyntax)
+	}
+	return task, nil
+}
+
+// parseTaskPoint parses "<lat> <lon> [radius] [name…]" (or "lat,lon …", or
+// "landing …").
+func parseTaskPoint(fields []string, landing *Coordinates) (TaskPoint, error) {
+	var p TaskPoint
+	if len(fields) == 0 {
+		return p, errors.New("нет координат")
+	}
+	rest := fields
+	switch {
+	case strings.EqualFold(fields[0], "landing"):
+		if landing == nil {
+			return p, errors.New("точка посадки не задана (/landing)")
+		}
+		p.Lat, p.Lon = landing.Latitude, landing.Longitude
+		rest = fields[1:]
+	case strings.Contains(fields[0], ","):
+		parts := strings.SplitN(fields[0], ",", 2)
+		lat, err1 := strconv.ParseFloat(parts[0], 64)
+		lon, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil {
+			return p, errors.New("неверные координаты")
+		}
+		p.Lat, p.Lon = lat, lon
+		rest = fields[1:]
+	default:
+		if len(fields) < 2 {
+			return p, errors.New("нет долготы")
+		}
+		lat, err1 := strconv.ParseFloat(fields[0], 64)
+		lon, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil {
+			return p, errors.New("неверные координаты")
+		}
+		p.Lat, p.Lon = lat, lon
+		rest = fields[2:]
+	}
+	if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+		return p, errors.New("координаты вне диапазона")
+	}
+	if len(rest) > 0 {
+		if r, err := strconv.ParseFloat(rest[0], 64); err == nil {
+			if r <= 0 || r > maxTaskRadius {
+				return p, fmt.Errorf("радиус должен быть от 1 до %d м", maxTaskRadius)
+			}
+			p.RadiusM = r
+			rest = rest[1:]
+		}
+	}
+	p.Name = strings.Join(rest, " ")
+	return p, nil
+}
@@ -3,15 +3,14 @@ package tracker
 import (
 	"context"
 	"fmt"
-	"log"
 	"log/slog"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"ogn/client"
 	"ogn/ddb"
 
 	"github.com/go-telegram/bot"
@@ -21,18 +20,46 @@ import (
 // Tracker is the central controller that bridges Telegram bot and OGN APRS feed.
 // It manages a single group session, user registry, and APRS client lifecycle.
 type Tracker struct {
-	bot           *bot.Bot
-	botUsername   string
-	aprs          *client.Client
-	devices       map[string]ddb.Device // OGN Device Database cache for model/registration display
-	mu            sync.Mutex            // guards session, users, devices, shuttingDown
+	bot         *bot.Bot
+	botUsername string
+	aprs        *aprsFeed
+	devices     map[string]ddb.Device // OGN Device Database cache for model/registration display
+	mu          sync.Mutex            // guards session, users, devices, ddb, places, shuttingDown
+	// aprsCfg lists the APRS sources (see aprsfeed.go), fixed after
+	// NewTracker; aprsStatus tracks the live one and has its own lock.
+	aprsCfg    aprsConfig
+	aprsStatus aprsStatus
+	// ddb describes the loaded device database for /ddb (see ddbcache.go);
+	// ddbRefreshMu serialises refreshes.
+	ddb          ddbStatus
+	ddbRefreshMu sync.Mutex
+	// ingest is the tracker-app HTTP server (see ingest.go); nil unless
+	// INGEST_ADDR is set. Fixed after NewTracker.
+	ingest *http.Server
+	// feeds is the poll state of satellite tracker feeds by user (see
+	// satfeed.go). Guarded by mu, not persisted.
+	feeds         map[int64]*feedState
 	session       *GroupSession
 	users         map[int64]*UserInfo
 	resumeOnStart bool // whether to auto-resume tracking on the next restart
+	resumeRadar   bool // whether to auto-resume radar mode (set by loadState)
+	// flights is the scored-flight log behind /leaderboard. Guarded by mu and
+	// persisted with the rest of the state.
+	flights []FlightRecord
+	// places is the offline gazetteer for "2.3км NE от X" descriptions; nil
+	// until loadPlaces finishes or when no places file is present. Replaced
+	// wholesale, never mutated, so renderers may use a copy of the pointer.
+	places *gazetteer
 	// allowedChats is a whitelist of group chat IDs allowed to use the bot.
 	// Nil means "allow all" — preserves behaviour when ALLOWED_CHATS is unset.
 	// Populated once in NewTracker and never mutated thereafter.
 	allowedChats map[int64]bool
+	// devicePicks are the open /add and /myid DDB pickers, keyed by the user
+	// who asked (see ddbsearch.go). Guarded by mu.
+	devicePicks map[int64]*devicePick
+	// weightBeacons enables reception-quality weighting in the beacon
+	// quality stage (BEACON_WEIGHTING=1). Set once in NewTracker.
+	weightBeacons bool
 	// Asynchronous persistence: saveCh delivers marshalled snapshots to a
 	// background worker so callers do not block on disk I/O. After Shutdown
 	// flips shuttingDown=true the channel is closed and saveDone signals exit.
@@ -87,16 +114,15 @@ func (t *Tracker) tz() *time.Location {
 // Shows "📍 Посадка" only if the user is actively tracked and flying.
 // Must be called with t.mu held.
 func (t *Tracker) dmReplyKeyboard(userID int64) *models.ReplyKeyboardMarkup {
-	u, ok := t.users[userID]
-	if !ok || u.OGNID == "" {
-		return nil
-	}
 	s := t.session
 	if s == nil || !s.TrackingOn {
 		return nil
 	}
-	info, ok := s.Tracking[u.OGNID]
-	if !ok || info.Status != StatusFlying {
+	key, ok := t.userPilotKeyLocked(s, userID)
+	if !ok {
+		return nil
+	}
+	if info := s.Tracking[key]; info.Status != StatusFlying {
 		return nil
 	}
 	return &models.ReplyKeyboardMarkup{
@@ -107,35 +133,26 @@ func (t *Tracker) dmReplyKeyboard(userID int64) *models.ReplyKeyboardMarkup {
 	}
 }
 
-// loadDevices fetches the OGN DDB (device database) in the background.
-// The result is used to render aircraft model and registration next to the OGN ID.
-func (t *Tracker) loadDevices() {
-	devices, err := ddb.GetDevices()
-	if err != nil {
-		slog.Error("failed to load OGN device database", "err", err)
-		return
-	}
-	m := ddb.LookupByID(devices)
-	t.mu.Lock()
-	t.devices = m
-	t.mu.Unlock()
-	slog.Info("loaded devices from OGN database", "count", len(m))
-}
-
 // NewTracker creates a Tracker, restores persisted state, fetches the bot
 // username, and loads the OGN DDB.
 // The *bot.Bot is taken at construction so the field is fixed before any
 // goroutine could read it — no further writes, no race.
 func NewTracker(b *bot.Bot) *Tracker {
 	t := &Tracker{
-		bot:          b,
-		aprs:         client.New("N0CALL", ""),
-		users:        make(map[int64]*UserInfo),
-		allowedChats: parseAllowedChats(os.Getenv("ALLOWED_CHATS")),
-		saveCh:       make(chan []byte, 1),
-		saveDone:     make(chan struct{}),
-	}
-	t.aprs.Logger = log.Default()
+		bot:           b,
+		aprsCfg:       aprsConfigFromEnv(),
+		users:         make(map[int64]*UserInfo),
+		allowedChats:  parseAllowedChats(os.Getenv("ALLOWED_CHATS")),
+		weightBeacons: os.Getenv("BEACON_WEIGHTING") == "1",
+		saveCh:        make(chan []byte, 1),
+		saveDone:      make(chan struct{}),
+	}
+	t.aprs = t.newAPRSFeed("")
+	sources := make([]string, 0, len(t.aprs.cfg.sources))
+	for _, src := range t.aprs.cfg.sources {
+		sources = append(sources, src.addr())
+	}
+	slog.Info("aprs sources", "servers", strings.Join(sources, ","), "callsign", t.aprs.cfg.callsign)
 	go t.saveWorker()
 	if t.allowedChats != nil {
 		ids := make([]string, 0, len(t.allowedChats))
@@ -164,6 +181,11 @@ func NewTracker(b *bot.Bot) *Tracker {
 	t.resumeOnStart = resumeTracking
 
 	go t.loadDevices()
+	go t.loadPlaces()
+	go t.pollFeeds()
+	if addr := os.Getenv("INGEST_ADDR"); addr != "" {
+		t.startIngest(addr)
+	}
 	return t
 }
 
@@ -171,6 +193,12 @@ func NewTracker(b *bot.Bot) *Tracker {
 // and disconnects the APRS client. Idempotent — extra calls return immediately.
 // Designed to be invoked from main() after the bot's update loop exits.
 func (t *Tracker) Shutdown() {
+	// Finish in-flight tracker-app pushes before the final snapshot.
+	if t.ingest != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = t.ingest.Shutdown(ctx)
+		cancel()
+	}
 	t.mu.Lock()
 	if t.shuttingDown {
 		t.mu.Unlock()
@@ -261,6 +289,16 @@ func (t *Tracker) RegisterHandlers(b *bot.Bot) {
 	b.RegisterHandler(bot.HandlerTypeMessageText, "area_off", bot.MatchTypeCommand, t.cmdAreaOff)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "radar", bot.MatchTypeCommand, t.cmdRadar)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "tz", bot.MatchTypeCommand, t.cmdTz)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "leaderboard", bot.MatchTypeCommand, t.cmdLeaderboard)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "task", bot.MatchTypeCommand, t.cmdTask)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "milestones", bot.MatchTypeCommand, t.cmdMilestones)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "sunset", bot.MatchTypeCommand, t.cmdSunset)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "zone", bot.MatchTypeCommand, t.cmdZone)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "discover", bot.MatchTypeCommand, t.cmdDiscover)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "predict", bot.MatchTypeCommand, t.cmdPredict)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "signal", bot.MatchTypeCommand, t.cmdSignal)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "ddb", bot.MatchTypeCommand, t.cmdDDB)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "aprs", bot.MatchTypeCommand, t.cmdAPRS)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "help", bot.MatchTypeCommand, t.cmdHelp)
 	if os.Getenv("DEBUG") == "1" {
 		b.RegisterHandler(bot.HandlerTypeMessageText, "debug_wipe", bot.MatchTypeCommand, t.cmdDebugWipe)
@@ -268,6 +306,9 @@ func (t *Tracker) RegisterHandlers(b *bot.Bot) {
 	}
 	b.RegisterHandler(bot.HandlerTypeMessageText, "myid", bot.MatchTypeCommand, t.cmdMyID)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "confirm", bot.MatchTypeCommand, t.cmdConfirm)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "buddy", bot.MatchTypeCommand, t.cmdBuddy)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "token", bot.MatchTypeCommand, t.cmdToken)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "feed", bot.MatchTypeCommand, t.cmdFeed)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "start", bot.MatchTypeCommand, t.cmdStart)
 
 	// Inline button callbacks.
@@ -288,6 +329,10 @@ func (t *Tracker) RegisterHandlers(b *bot.Bot) {
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "session_reset_wipe", bot.MatchTypeExact, t.cbSessionResetWipe)
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "session_reset_cancel", bot.MatchTypeExact, t.cbSessionResetCancel)
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "dashboard:", bot.MatchTypePrefix, t.cbDashboardAction)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "radar_opt:", bot.MatchTypePrefix, t.cbRadarOption)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "ddb_pick:", bot.MatchTypePrefix, t.cbDevicePick)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "radar_card:", bot.MatchTypePrefix, t.cbRadarCard)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "radar_track:", bot.MatchTypePrefix, t.cbRadarTrack)
 
 	// Auto-resume tracking if it was active before restart.
 	if t.resumeOnStart && t.session != nil {
@@ -305,6 +350,24 @@ func (t *Tracker) RegisterHandlers(b *bot.Bot) {
 		go t.sendUpdates(stopCh)
 		slog.Info("auto-resumed tracking from saved session")
 	}
+
+	// Auto-resume radar mode; the summary loop keeps editing the saved
+	// RadarMsgID instead of posting a new message.
+	if t.resumeRadar && t.session != nil {
+		if !t.isAllowedChat(t.session.ChatID) {
+			slog.Warn("not auto-resuming radar: chat not in ALLOWED_CHATS", "chat_id", t.session.ChatID)
+			return
+		}
+		t.mu.Lock()
+		radius := t.session.RadarRadius
+		if radius <= 0 {
+			radius = defaultAreaRadius
+		}
+		t.startRadarLocked(radius)
+		msgID := t.session.RadarMsgID
+		t.mu.Unlock()
+		slog.Info("auto-resumed radar from saved session", "radius_km", radius, "msg_id", msgID)
+	}
 }
 
 // DefaultHandler processes updates that don't match any registered command:
@@ -318,6 +381,14 @@ func (t *Tracker) DefaultHandler(ctx context.Context, b *bot.Bot, update *models
 			t.answerCallback(ctx, b, cq)
 			return
 		}
+		if strings.HasPrefix(cq.Data, "adopt:") {
+			t.answerCallback(ctx, b, cq)
+			if !t.isTrusted(cq.From.ID) {
+				return
+			}
+			t.execAdopt(ctx, b, strings.TrimPrefix(cq.Data, "adopt:"))
+			return
+		}
 		if strings.HasPrefix(cq.Data, "pickup:") {
 			t.answerCallback(ctx, b, cq)
 			if !t.isTrusted(cq.From.ID) {
@@ -330,11 +401,15 @@ func (t *Tracker) DefaultHandler(ctx context.Context, b *bot.Bot, update *models
 		return
 	}
 
-	// Handle driver live location updates (edited messages).
+	// Live location updates (edited messages): a driver's, or a pilot's
+	// secondary position source (see livelocation.go).
 	if update.EditedMessage != nil && update.EditedMessage.Location != nil {
-		if !t.isAllowedChat(update.EditedMessage.Chat.ID) {
+		// Same rule as for new messages: the allow-list is for groups, DM
+		// live locations must keep updating.
+		if isGroupChat(update.EditedMessage.Chat) && !t.isAllowedChat(update.EditedMessage.Chat.ID) {
 			return
 		}
+		driver := false
 		t.mu.Lock()
 		if t.session != nil {
 			for _, d := range t.session.Drivers {
@@ -343,11 +418,15 @@ func (t *Tracker) DefaultHandler(ctx context.Context, b *bot.Bot, update *models
 						Latitude:  update.EditedMessage.Location.Latitude,
 						Longitude: update.EditedMessage.Location.Longitude,
 					}
+					driver = true
 					break
 				}
 			}
 		}
 		t.mu.Unlock()
+		if !driver && update.EditedMessage.From != nil && t.isTrusted(update.EditedMessage.From.ID) {
+			t.handleLiveLocation(ctx, b, update.EditedMessage, true)
+		}
 		return
 	}
 
@@ -366,6 +445,12 @@ func (t *Tracker) DefaultHandler(ctx context.Context, b *bot.Bot, update *models
 		return
 	}
 
+	// KML/GeoJSON uploads for /zone import.
+	if m.Document != nil && isGroupChat(m.Chat) {
+		t.handleZoneDocument(ctx, b, m)
+		return
+	}
+
 	// Handle location messages.
 	if m.Location != nil {
 		if isPrivateChat(m.Chat) {
@@ -509,18 +594,36 @@ func (t *Tracker) handleDMText(ctx context.Context, b *bot.Bot, m *models.Messag
 		return
 	}
 
-	id := shortID(m.Text)
-	if !isValidShortID(id) {
+	ids, errText := parseDeviceIDs(m.Text)
+	if errText != "" {
+		// A registration, CN or model, as with /myid and /add (see
+		// ddbsearch.go).
+		query := strings.TrimSpace(m.Text)
+		found, matches := t.lookupDeviceLocked(u.UserID, query, devicePick{chatID: m.Chat.ID, dm: true, invite: true})
+		switch {
+		case len(matches) > 0:
+			t.mu.Unlock()
+			t.sendDevicePicker(ctx, u.UserID, query, matches)
+			return
+		case found != "":
+			slog.Info("invite reply resolved via ddb", "query", query, "id", found)
+			ids, errText = []string{found}, ""
+		}
+	}
+	if errText != "" {
 		t.mu.Unlock()
 		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: m.Chat.ID,
-			Text:   "Это не похоже на OGN ID. Пришлите 6 шестнадцатеричных символов (0-9, A-F), например FE0E4A.",
+			Text:   "Это не похоже на OGN ID, и в OGN DDB ничего не найдено. Пришлите 6 шестнадцатеричных символов (0-9, A-F), например FE0E4A, регистрацию или CN; несколько устройств — через пробел.",
 		}); err != nil {
 			slog.Error("failed to send invalid ognid message", "err", err)
 		}
 		return
 	}
 	groupChatID := s.ChatID
+	id := ids[0]
+	// The user's old entry moves to the new primary ID.
+	rekeyPilotLocked(s, u.primaryID(), id, u.UserID)
 
 	// Add to session (reset status — fresh add).
 	name := u.DisplayName
@@ -539,17 +642,21 @@ func (t *Tracker) handleDMText(ctx context.Context, b *bot.Bot, m *models.Messag
 		}
 	}
 
-	u.OGNID = id
+	orphanIDs := linkDevicesLocked(s, id, ids[1:])
+	label := deviceLabel(id, s.Tracking[id])
+
+	u.OGNIDs = ids
 	u.PendingGroup = 0
 	t.updateFilter()
 	dmKb := t.dmReplyKeyboard(u.UserID)
 	t.saveState()
 	t.mu.Unlock()
+	t.deleteMessagesAsync(groupChatID, orphanIDs...)
 
 	// Confirm in DM.
 	dmParams := &bot.SendMessageParams{
 		ChatID: m.Chat.ID,
-		Text:   fmt.Sprintf("Добавлен %s в группу", id),
+		Text:   fmt.Sprintf("Добавлен %s в группу", label),
 	}
 	if dmKb != nil {
 		dmParams.ReplyMarkup = dmKb
@@ -559,9 +666,8 @@ func (t *Tracker) handleDMText(ctx context.Context, b *bot.Bot, m *models.Messag
 	}
 
 	// Confirm in group.
-	label := id
 	if name != "" {
-		label = id + " (" + name + ")"
+		label += " (" + name + ")"
 	}
 	groupAckID := t.sendAck(ctx, &bot.SendMessageParams{
 		ChatID: groupChatID,
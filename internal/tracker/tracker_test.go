@@ -1,14 +1,27 @@
 package tracker
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"ogn/ddb"
 	"ogn/parser"
@@ -25,6 +38,8 @@ func TestShortID(t *testing.T) {
 		{"", ""},
 		{"ICA3FE0E4A", "FE0E4A"},
 		{"ognfe0e4a", "FE0E4A"},
+		{"tg123456789", "TG123456789"},
+		{"TGAB12CD", "AB12CD"},
 	}
 	for _, c := range cases {
 		if got := shortID(c.in); got != c.want {
@@ -275,7 +290,7 @@ func TestSaveStateAsync(t *testing.T) {
 			saveCh:   make(chan []byte, 1),
 			saveDone: make(chan struct{}),
 		}
-		tr.users[1] = &UserInfo{UserID: 1, OGNID: "ABC"}
+		tr.users[1] = &UserInfo{UserID: 1, OGNIDs: []string{"ABC"}}
 
 		tr.mu.Lock()
 		tr.saveState()
@@ -297,13 +312,13 @@ func TestSaveStateAsync(t *testing.T) {
 			saveCh:   make(chan []byte, 1),
 			saveDone: make(chan struct{}),
 		}
-		tr.users[1] = &UserInfo{UserID: 1, OGNID: "OLD"}
+		tr.users[1] = &UserInfo{UserID: 1, OGNIDs: []string{"OLD"}}
 
 		tr.mu.Lock()
 		tr.saveState()
 		tr.mu.Unlock()
 
-		tr.users[1].OGNID = "NEW"
+		tr.users[1].OGNIDs = []string{"NEW"}
 		tr.mu.Lock()
 		tr.saveState()
 		tr.mu.Unlock()
@@ -321,7 +336,7 @@ func TestSaveStateAsync(t *testing.T) {
 			saveDone:     make(chan struct{}),
 			shuttingDown: true,
 		}
-		tr.users[1] = &UserInfo{UserID: 1, OGNID: "ABC"}
+		tr.users[1] = &UserInfo{UserID: 1, OGNIDs: []string{"ABC"}}
 
 		tr.mu.Lock()
 		tr.saveState()
@@ -939,7 +954,7 @@ func TestBuildDashboard(t *testing.T) {
 
 	t.Run("session active, no pilots, tracking off", func(t *testing.T) {
 		s := &GroupSession{Tracking: map[string]*TrackInfo{}}
-		got := buildDashboard(s, devices, tz)
+		got := buildDashboard(s, devices, nil, tz)
 		if !strings.Contains(got, "Сессия активна") {
 			t.Errorf("missing status header in: %q", got)
 		}
@@ -957,7 +972,7 @@ func TestBuildDashboard(t *testing.T) {
 					LastUpdate: time.Now()},
 			},
 		}
-		got := buildDashboard(s, devices, tz)
+		got := buildDashboard(s, devices, nil, tz)
 		if !strings.Contains(got, "Трекинг: ✅") {
 			t.Errorf("expected tracking-on indicator, got: %q", got)
 		}
@@ -974,7 +989,7 @@ func TestBuildDashboard(t *testing.T) {
 				"AABBCC": {LastUpdate: time.Now().Add(-2*time.Hour - 30*time.Minute)},
 			},
 		}
-		got := buildDashboard(s, devices, tz)
+		got := buildDashboard(s, devices, nil, tz)
 		if !strings.Contains(got, "Нет beacon-ов") {
 			t.Errorf("expected inactivity warning in: %q", got)
 		}
@@ -1078,3 +1093,2610 @@ func TestSessionResetClearsDashboard(t *testing.T) {
 	}
 	tr.mu.Unlock()
 }
+
+// trackThrough builds a track visiting the given (lat, lon) vertices in order,
+// interpolating steps fixes per leg, one fix every trackSampleInterval.
+func trackThrough(t0 time.Time, steps int, vertices ...[2]float64) []TrackPoint {
+	var out []TrackPoint
+	at := t0
+	for v := 0; v+1 < len(vertices); v++ {
+		a, b := vertices[v], vertices[v+1]
+		for s := 0; s < steps; s++ {
+			f := float64(s) / float64(steps)
+			out = append(out, TrackPoint{
+				Time: at,
+				Lat:  a[0] + (b[0]-a[0])*f,
+				Lon:  a[1] + (b[1]-a[1])*f,
+			})
+			at = at.Add(trackSampleInterval)
+		}
+	}
+	last := vertices[len(vertices)-1]
+	return append(out, TrackPoint{Time: at, Lat: last[0], Lon: last[1]})
+}
+
+func TestScoreFlight(t *testing.T) {
+	t0 := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("empty and single-fix tracks score zero", func(t *testing.T) {
+		if r := scoreFlight(nil); r.Best.Points != 0 {
+			t.Errorf("nil track: got %+v", r.Best)
+		}
+		if r := scoreFlight([]TrackPoint{{Time: t0, Lat: 50, Lon: 30}}); r.Best.Points != 0 {
+			t.Errorf("single fix: got %+v", r.Best)
+		}
+	})
+
+	t.Run("straight line is free distance", func(t *testing.T) {
+		track := trackThrough(t0, 50, [2]float64{50, 30}, [2]float64{50, 31})
+		r := scoreFlight(track)
+		want, _ := distanceAndBearing(50, 30, 50, 31)
+		if math.Abs(r.Free.DistKm-want) > 0.5 {
+			t.Errorf("free distance = %.2f, want ~%.2f", r.Free.DistKm, want)
+		}
+		if r.Best.Kind != xcFree {
+			t.Errorf("best kind = %v, want free", r.Best.Kind)
+		}
+		if r.FAI.Points != 0 {
+			t.Errorf("straight line must not close a triangle, got %+v", r.FAI)
+		}
+	})
+
+	t.Run("out-and-return uses a turnpoint", func(t *testing.T) {
+		track := trackThrough(t0, 40, [2]float64{50, 30}, [2]float64{50, 30.5}, [2]float64{50, 30})
+		r := scoreFlight(track)
+		leg, _ := distanceAndBearing(50, 30, 50, 30.5)
+		if math.Abs(r.Free.DistKm-2*leg) > 0.5 {
+			t.Errorf("out-and-return free distance = %.2f, want ~%.2f", r.Free.DistKm, 2*leg)
+		}
+	})
+
+	t.Run("closed equilateral triangle scores as FAI", func(t *testing.T) {
+		// Roughly equilateral ~30 km triangle at lat 46.
+		a := [2]float64{46.0, 7.0}
+		b := [2]float64{46.0, 7.39}
+		c := [2]float64{46.234, 7.195}
+		track := trackThrough(t0, 30, a, b, c, a)
+		r := scoreFlight(track)
+		if r.FAI.Points == 0 {
+			t.Fatalf("expected FAI triangle, got %+v", r)
+		}
+		if r.Best.Kind != xcFAI {
+			t.Errorf("best kind = %v, want FAI", r.Best.Kind)
+		}
+		if math.Abs(r.FAI.Points-r.FAI.DistKm*xcFAIMultiplier) > 1e-9 {
+			t.Errorf("FAI points %.2f != dist %.2f × %.1f", r.FAI.Points, r.FAI.DistKm, xcFAIMultiplier)
+		}
+		if r.Flat.DistKm < r.FAI.DistKm {
+			t.Errorf("flat distance %.2f must be >= FAI %.2f", r.Flat.DistKm, r.FAI.DistKm)
+		}
+	})
+
+	t.Run("elongated triangle scores best as flat", func(t *testing.T) {
+		a := [2]float64{46.0, 7.0}
+		b := [2]float64{46.0, 7.6}
+		c := [2]float64{46.03, 7.3}
+		track := trackThrough(t0, 30, a, b, c, a)
+		r := scoreFlight(track)
+		if r.Best.Kind != xcFlat {
+			t.Fatalf("best kind = %v, want flat (%+v)", r.Best.Kind, r)
+		}
+		// Only a small FAI triangle fits inside the elongated shape.
+		if r.FAI.DistKm >= r.Flat.DistKm/2 {
+			t.Errorf("FAI %.2f km should be much shorter than flat %.2f km", r.FAI.DistKm, r.Flat.DistKm)
+		}
+	})
+}
+
+func TestRecordTrackPoint(t *testing.T) {
+	t0 := time.Now()
+	info := &TrackInfo{}
+	recordTrackPoint(info, 50, 30, 1000, t0)
+	recordTrackPoint(info, 50.001, 30, 1000, t0.Add(time.Second)) // too soon
+	recordTrackPoint(info, 50.002, 30, 1000, t0.Add(trackSampleInterval))
+	if len(info.Track) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(info.Track))
+	}
+	if info.Track[1].Lat != 50.002 {
+		t.Errorf("second sample lat = %v, want 50.002", info.Track[1].Lat)
+	}
+}
+
+func TestThinTrack(t *testing.T) {
+	track := make([]TrackPoint, 1000)
+	for i := range track {
+		track[i].Lat = float64(i)
+	}
+	got := thinTrack(track, 100)
+	if len(got) != 100 {
+		t.Fatalf("len = %d, want 100", len(got))
+	}
+	if got[0].Lat != 0 || got[99].Lat != 999 {
+		t.Errorf("endpoints not kept: first=%v last=%v", got[0].Lat, got[99].Lat)
+	}
+	if short := thinTrack(track[:10], 100); len(short) != 10 {
+		t.Errorf("short track must pass through, got %d", len(short))
+	}
+}
+
+func TestLeaderboards(t *testing.T) {
+	tz := time.UTC
+	now := time.Date(2026, 7, 15, 18, 0, 0, 0, tz)
+	const chat = -100
+	flights := []FlightRecord{
+		{ID: "AAAAAA", Name: "Olga", ChatID: chat, Landed: now.Add(-2 * time.Hour), Kind: xcFAI, DistKm: 40, Points: 56},
+		{ID: "AAAAAA", Name: "Olga", ChatID: chat, Landed: now.Add(-5 * time.Hour), Kind: xcFree, DistKm: 20, Points: 20},
+		{ID: "BBBBBB", Name: "Ivan", ChatID: chat, Landed: now.Add(-3 * time.Hour), Kind: xcFree, DistKm: 60, Points: 60},
+		{ID: "BBBBBB", Name: "Ivan", ChatID: chat, Landed: now.AddDate(0, -1, 0), Kind: xcFree, DistKm: 10, Points: 10},
+		{ID: "CCCCCC", Name: "Petr", ChatID: chat, Landed: now.AddDate(-1, 0, 0), Kind: xcFree, DistKm: 200, Points: 200},
+		{ID: "DDDDDD", Name: "Other", ChatID: -200, Landed: now, Kind: xcFree, DistKm: 300, Points: 300},
+	}
+
+	day := dayLeaderboard(flights, chat, now, tz)
+	if len(day) != 2 {
+		t.Fatalf("day rows = %d, want 2 (%+v)", len(day), day)
+	}
+	if day[0].label != "Ivan" || day[0].points != 60 {
+		t.Errorf("day leader = %+v, want Ivan 60", day[0])
+	}
+	if day[1].label != "Olga" || day[1].points != 56 {
+		t.Errorf("day second = %+v, want Olga's best flight 56", day[1])
+	}
+
+	season := seasonLeaderboard(flights, chat, now, tz)
+	if len(season) != 2 {
+		t.Fatalf("season rows = %d, want 2 (%+v)", len(season), season)
+	}
+	if season[0].label != "Olga" || season[0].points != 76 {
+		t.Errorf("season leader = %+v, want Olga 76", season[0])
+	}
+	if season[1].points != 70 || season[1].flights != 2 {
+		t.Errorf("season second = %+v, want Ivan 70 over 2 flights", season[1])
+	}
+
+	text := buildLeaderboard(flights, chat, now, tz)
+	if !strings.Contains(text, "Сезон 2026") || strings.Contains(text, "Other") {
+		t.Errorf("unexpected leaderboard text: %q", text)
+	}
+
+	pruned := appendFlight(flights, FlightRecord{ID: "EEEEEE", Landed: now}, now.AddDate(0, 2, 0))
+	for _, f := range pruned {
+		if f.ID == "CCCCCC" {
+			t.Errorf("records older than flightRetention should be dropped")
+		}
+	}
+}
+
+// saveAndReload persists tr's state through the async writer and loads it
+// into a fresh Tracker. The caller must have chdir'ed into a temp dir.
+func saveAndReload(t *testing.T, tr *Tracker) *Tracker {
+	t.Helper()
+	tr.saveCh = make(chan []byte, 1)
+	tr.saveDone = make(chan struct{})
+	go tr.saveWorker()
+	tr.mu.Lock()
+	tr.saveState()
+	tr.mu.Unlock()
+	close(tr.saveCh)
+	<-tr.saveDone
+
+	tr2 := &Tracker{users: make(map[int64]*UserInfo)}
+	tr2.mu.Lock()
+	tr2.loadState()
+	tr2.mu.Unlock()
+	return tr2
+}
+
+func TestParseTask(t *testing.T) {
+	landing := &Coordinates{Latitude: 46.0, Longitude: 7.0}
+	task, err := parseTask("start 46.1 7.1 3000 Launch\ntp 46.2,7.2 Rocher\ntp 46.3 7.3 1000; line landing 150", landing)
+	if err != nil {
+		t.Fatalf("parseTask: %v", err)
+	}
+	if task.Start.RadiusM != 3000 || task.Start.Name != "Launch" {
+		t.Errorf("start = %+v", task.Start)
+	}
+	if len(task.Turnpoints) != 2 {
+		t.Fatalf("turnpoints = %d, want 2", len(task.Turnpoints))
+	}
+	if tp := task.Turnpoints[0]; tp.Lat != 46.2 || tp.Lon != 7.2 || tp.RadiusM != defaultTPRadius || tp.Name != "Rocher" {
+		t.Errorf("tp1 = %+v", tp)
+	}
+	if task.Turnpoints[1].RadiusM != 1000 {
+		t.Errorf("tp2 radius = %v, want 1000", task.Turnpoints[1].RadiusM)
+	}
+	if !task.GoalLine || task.Goal.Lat != 46.0 || task.Goal.RadiusM != 150 {
+		t.Errorf("goal = %+v line=%v", task.Goal, task.GoalLine)
+	}
+
+	bad := []string{
+		"",
+		"start 46 7",                      // no goal
+		"goal 46 7",                       // no start
+		"start 46 7\ngoal landing",        // landing not set (nil below)
+		"start 46 7\nfoo 46 7\ngoal 46 7", // unknown kind
+		"start 95 7\ngoal 46 7",           // out of range
+		"start 46 7 0\ngoal 46 7",         // zero radius
+		"start 46 7\nstart 46 7\ngoal 46 7",
+	}
+	for _, in := range bad {
+		if _, err := parseTask(in, nil); !errors.Is(err, errTaskSyntax) {
+			t.Errorf("parseTask(%q) err = %v, want errTaskSyntax", in, err)
+		}
+	}
+}
+
+func TestAdvanceRace(t *testing.T) {
+	// Start cylinder 1 km at origin, one turnpoint ~5.5 km north, goal
+	// cylinder ~5.5 km east of the start.
+	task := &RaceTask{
+		Start:      TaskPoint{Lat: 46.0, Lon: 7.0, RadiusM: 1000},
+		Turnpoints: []TaskPoint{{Lat: 46.05, Lon: 7.0, RadiusM: 400}},
+		Goal:       TaskPoint{Lat: 46.0, Lon: 7.07, RadiusM: 400},
+	}
+	t0 := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	fix := func(min int, lat, lon float64) TrackPoint {
+		return TrackPoint{Time: t0.Add(time.Duration(min) * time.Minute), Lat: lat, Lon: lon}
+	}
+	p := &RaceProgress{}
+
+	steps := []struct {
+		prev, cur TrackPoint
+		want      raceStep
+	}{
+		{TrackPoint{}, fix(0, 46.0, 7.0), raceNone},              // first fix, inside start
+		{fix(0, 46.0, 7.0), fix(1, 46.005, 7.0), raceNone},       // still inside
+		{fix(1, 46.005, 7.0), fix(2, 46.015, 7.0), raceStarted},  // exit start
+		{fix(2, 46.015, 7.0), fix(3, 46.005, 7.0), raceNone},     // back in: no restart signal yet
+		{fix(3, 46.005, 7.0), fix(4, 46.02, 7.0), raceStarted},   // restart before TP1
+		{fix(4, 46.02, 7.0), fix(10, 46.049, 7.0), raceTagged},   // TP1
+		{fix(10, 46.049, 7.0), fix(12, 46.0, 7.0), raceNone},     // back through the start: no restart after TP1
+		{fix(12, 46.0, 7.0), fix(30, 46.0, 7.066), raceFinished}, // goal
+		{fix(30, 46.0, 7.066), fix(31, 46.0, 7.07), raceNone},    // already finished
+	}
+	for i, s := range steps {
+		if got := advanceRace(task, p, s.prev, s.cur); got != s.want {
+			t.Fatalf("step %d: got %v, want %v (progress %+v)", i, got, s.want, p)
+		}
+	}
+	if !p.StartTime.Equal(t0.Add(4 * time.Minute)) {
+		t.Errorf("start time = %v, want the restart at +4m", p.StartTime)
+	}
+	if got := p.FinishTime.Sub(p.StartTime); got != 26*time.Minute {
+		t.Errorf("speed section = %v, want 26m", got)
+	}
+}
+
+func TestGoalLine(t *testing.T) {
+	prev := TaskPoint{Lat: 46.0, Lon: 7.0}               // last TP due south
+	goal := TaskPoint{Lat: 46.1, Lon: 7.0, RadiusM: 200} // line runs east-west
+	pt := func(lat, lon float64) TrackPoint { return TrackPoint{Lat: lat, Lon: lon} }
+
+	if !crossesGoalLine(goal, prev, pt(46.099, 7.0), pt(46.101, 7.0)) {
+		t.Error("northbound crossing at the centre must count")
+	}
+	if crossesGoalLine(goal, prev, pt(46.101, 7.0), pt(46.099, 7.0)) {
+		t.Error("crossing in the wrong direction must not count")
+	}
+	if crossesGoalLine(goal, prev, pt(46.099, 7.01), pt(46.101, 7.01)) {
+		t.Error("crossing ~770 m east of centre is outside a 200 m half-line")
+	}
+	if crossesGoalLine(goal, prev, pt(46.098, 7.0), pt(46.099, 7.0)) {
+		t.Error("segment short of the line must not count")
+	}
+}
+
+func TestBuildRaceBoard(t *testing.T) {
+	task := &RaceTask{
+		Start:      TaskPoint{Lat: 46.0, Lon: 7.0, RadiusM: 1000},
+		Turnpoints: []TaskPoint{{Lat: 46.05, Lon: 7.0, RadiusM: 400}},
+		Goal:       TaskPoint{Lat: 46.0, Lon: 7.07, RadiusM: 400},
+	}
+	now := time.Date(2026, 7, 1, 14, 0, 0, 0, time.UTC)
+	start := now.Add(-time.Hour)
+	pos := func(lat, lon float64) *parser.PositionMessage {
+		return &parser.PositionMessage{Latitude: lat, Longitude: lon}
+	}
+	tracking := map[string]*TrackInfo{
+		"AAAAAA": {Name: "Slow", Position: pos(46.0, 7.07)},
+		"BBBBBB": {Name: "Fast", Position: pos(46.0, 7.07)},
+		"CCCCCC": {Name: "Enroute", Position: pos(46.03, 7.0)},
+		"DDDDDD": {Name: "Ground", Position: pos(46.0, 7.0)},
+		"EEEEEE": {AutoDiscovered: true, Position: pos(46.0, 7.0)},
+	}
+	race := map[string]*RaceProgress{
+		"AAAAAA": {StartTime: start, Tagged: []time.Time{start}, FinishTime: start.Add(50 * time.Minute)},
+		"BBBBBB": {StartTime: start, Tagged: []time.Time{start}, FinishTime: start.Add(40 * time.Minute)},
+		"CCCCCC": {StartTime: start},
+	}
+	text := buildRaceBoard(task, race, tracking, now)
+	lines := strings.Split(text, "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected header + 4 rows, got %d:\n%s", len(lines), text)
+	}
+	wantOrder := []string{"1. Fast — в цели ⏱ 0:40:00", "2. Slow — в цели ⏱ 0:50:00", "3. Enroute — ТП 0/1", "4. Ground — не стартовал"}
+	for i, want := range wantOrder {
+		if !strings.HasPrefix(lines[i+1], want) {
+			t.Errorf("row %d = %q, want prefix %q", i+1, lines[i+1], want)
+		}
+	}
+	if !strings.Contains(lines[3], "км до ТП1") {
+		t.Errorf("en-route row should show distance to TP1: %q", lines[3])
+	}
+}
+
+func TestTaskPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	start := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID:   -100,
+			Tracking: map[string]*TrackInfo{"AABBCC": {Name: "Olga"}},
+			Task: &RaceTask{
+				Start:    TaskPoint{Lat: 46, Lon: 7, RadiusM: 1000},
+				Goal:     TaskPoint{Lat: 46.1, Lon: 7, RadiusM: 200, Name: "Goal"},
+				GoalLine: true,
+			},
+			Race: map[string]*RaceProgress{"AABBCC": {StartTime: start, Tagged: []time.Time{start.Add(time.Minute)}}},
+		},
+	}
+	tr2 := saveAndReload(t, tr)
+	s := tr2.session
+	if s.Task == nil || !s.Task.GoalLine || s.Task.Goal.Name != "Goal" {
+		t.Fatalf("task not restored: %+v", s.Task)
+	}
+	p := s.Race["AABBCC"]
+	if p == nil || !p.StartTime.Equal(start) || len(p.Tagged) != 1 {
+		t.Errorf("race progress not restored: %+v", p)
+	}
+}
+
+func TestFindGaggles(t *testing.T) {
+	now := time.Now()
+	f := func(label string, lat, lon, alt float64) pilotFix {
+		return pilotFix{id: label, label: label, lat: lat, lon: lon, alt: alt, at: now}
+	}
+	fixes := []pilotFix{
+		f("Ivan", 46.0, 7.0, 1500),
+		f("Olga", 46.002, 7.0, 1550),  // ~220 m from Ivan
+		f("Petr", 46.004, 7.0, 1600),  // ~220 m from Olga, ~440 m from Ivan: joins transitively
+		f("Anna", 46.0, 7.0, 1800),    // directly above Ivan but 300 m higher
+		f("Boris", 46.1, 7.1, 1500),   // far away
+		f("Vera", 46.1005, 7.1, 1450), // pairs with Boris
+	}
+	got := findGaggles(fixes)
+	if len(got) != 2 {
+		t.Fatalf("gaggles = %d, want 2: %+v", len(got), got)
+	}
+	var names []string
+	for _, p := range got[0] {
+		names = append(names, p.label)
+	}
+	if strings.Join(names, ",") != "Ivan,Olga,Petr" {
+		t.Errorf("largest gaggle = %v, want Ivan,Olga,Petr", names)
+	}
+	if len(got[1]) != 2 || got[1][0].label != "Boris" {
+		t.Errorf("second gaggle = %+v, want Boris+Vera", got[1])
+	}
+}
+
+func TestCollisionRisk(t *testing.T) {
+	now := time.Now()
+	// Two pilots 500 m apart on the same latitude, flying straight at each
+	// other at 36 km/h (10 m/s each → 20 m/s closing).
+	a := pilotFix{lat: 46.0, lon: 7.0, alt: 1500, course: 90, speed: 36, at: now}
+	b := pilotFix{lat: 46.0, lon: 7.0065, alt: 1510, course: 270, speed: 36, at: now}
+	tcpa, miss, risky := collisionRisk(a, b)
+	if !risky {
+		t.Fatalf("head-on pair should be risky (tcpa=%v miss=%.0f)", tcpa, miss)
+	}
+	if tcpa < 20*time.Second || tcpa > 30*time.Second {
+		t.Errorf("tcpa = %v, want ~25s", tcpa)
+	}
+
+	diverging := b
+	diverging.course = 90
+	if _, _, risky := collisionRisk(a, diverging); risky {
+		t.Error("pilots flying the same way at equal speed are not converging")
+	}
+
+	stacked := b
+	stacked.alt = 1700
+	if _, _, risky := collisionRisk(a, stacked); risky {
+		t.Error("200 m height difference must not raise a collision warning")
+	}
+
+	offset := b
+	offset.lat = 46.003 // ~330 m to the side
+	if _, _, risky := collisionRisk(a, offset); risky {
+		t.Error("parallel tracks 330 m apart must not be risky")
+	}
+
+	// Telegram-only pilots carry no altitude: neither a collision nor a
+	// gaggle can be told from the ground track alone.
+	phoneA, phoneB := a, b
+	phoneA.alt, phoneB.alt = 0, 0
+	phoneA.altUnknown, phoneB.altUnknown = true, true
+	if _, _, risky := collisionRisk(phoneA, phoneB); risky {
+		t.Error("pilots without altitude must not raise a collision warning")
+	}
+	phoneB.lon = 7.0005
+	if together(phoneA, phoneB) || together(a, phoneB) {
+		t.Error("pilots without altitude paired")
+	}
+}
+
+func TestCheckProximityBuddyAlerts(t *testing.T) {
+	now := time.Now()
+	pos := func(lat float64) *parser.PositionMessage {
+		return &parser.PositionMessage{Latitude: lat, Longitude: 7.0, Altitude: 1500, GroundSpeed: 30, Course: 90}
+	}
+	tr := &Tracker{users: map[int64]*UserInfo{
+		1: {UserID: 1, DMChatID: 101, BuddyAlerts: true},
+		2: {UserID: 2, DMChatID: 102}, // not opted in
+	}}
+	s := &GroupSession{ChatID: -100, Tracking: map[string]*TrackInfo{
+		"AAAAAA": {Name: "Ivan", OwnerUserID: 1, Position: pos(46.0), LastUpdate: now},
+		"BBBBBB": {Name: "Olga", OwnerUserID: 2, Position: pos(46.002), LastUpdate: now},
+	}}
+
+	events := tr.checkProximityLocked(s, "AAAAAA", s.Tracking["AAAAAA"], now)
+	if len(events) != 1 || events[0].chatID != 101 || !strings.Contains(events[0].text, "Olga") {
+		t.Fatalf("expected one DM to Ivan about Olga, got %+v", events)
+	}
+	if again := tr.checkProximityLocked(s, "BBBBBB", s.Tracking["BBBBBB"], now.Add(time.Second)); len(again) != 0 {
+		t.Errorf("pair already together must not re-alert, got %+v", again)
+	}
+
+	// Split up and rejoin inside the cooldown: still quiet.
+	s.Tracking["BBBBBB"].Position = pos(46.02)
+	tr.checkProximityLocked(s, "BBBBBB", s.Tracking["BBBBBB"], now.Add(time.Minute))
+	s.Tracking["BBBBBB"].Position = pos(46.002)
+	if got := tr.checkProximityLocked(s, "BBBBBB", s.Tracking["BBBBBB"], now.Add(2*time.Minute)); len(got) != 0 {
+		t.Errorf("rejoin within cooldown must not alert, got %+v", got)
+	}
+}
+
+func TestBuildGaggleLines(t *testing.T) {
+	now := time.Now()
+	pos := func(lat float64) *parser.PositionMessage {
+		return &parser.PositionMessage{Latitude: lat, Longitude: 7.0, Altitude: 2000}
+	}
+	tracking := map[string]*TrackInfo{
+		"AAAAAA": {Name: "Ivan", Position: pos(46.0), LastUpdate: now},
+		"BBBBBB": {Name: "Olga", Position: pos(46.001), LastUpdate: now},
+		"CCCCCC": {Name: "Stale", Position: pos(46.0005), LastUpdate: now.Add(-time.Hour)},
+		"DDDDDD": {Name: "Landed", Position: pos(46.0005), LastUpdate: now, Status: StatusLanded},
+	}
+	got := buildGaggleLines(tracking, &Coordinates{Latitude: 45.99, Longitude: 7.0}, now)
+	if !strings.HasPrefix(got, "🦅 Ivan, Olga — вместе, 2000м") || !strings.Contains(got, "N от посадки") {
+		t.Errorf("unexpected gaggle line: %q", got)
+	}
+	if strings.Contains(got, "Stale") || strings.Contains(got, "Landed") {
+		t.Errorf("stale and landed pilots must be excluded: %q", got)
+	}
+}
+
+func TestCheckMilestones(t *testing.T) {
+	cfg := MilestoneConfig{AltM: []int{2000, 3000}, DistKm: []int{10, 25}}
+	info := &TrackInfo{}
+
+	if ev := checkMilestones(cfg, "Olga", info, 46.0, 7.0, 1200, 0); len(ev) != 0 {
+		t.Fatalf("launch fix should announce nothing, got %+v", ev)
+	}
+	if info.Launch == nil || info.Launch.Latitude != 46.0 {
+		t.Fatalf("launch not recorded: %+v", info.Launch)
+	}
+
+	// Jumping straight past both altitude thresholds announces only the top one.
+	ev := checkMilestones(cfg, "Olga", info, 46.0, 7.0, 3100, 0)
+	if len(ev) != 1 || !strings.Contains(ev[0].text, "3000 м") {
+		t.Fatalf("expected a single 3000 m announcement, got %+v", ev)
+	}
+	if ev := checkMilestones(cfg, "Olga", info, 46.0, 7.0, 3200, 0); len(ev) != 0 {
+		t.Errorf("milestones must be announced once, got %+v", ev)
+	}
+
+	// ~11 km north of launch.
+	ev = checkMilestones(cfg, "Olga", info, 46.1, 7.0, 1500, 0)
+	if len(ev) != 1 || !strings.Contains(ev[0].text, "10 км от старта") {
+		t.Errorf("expected 10 km announcement, got %+v", ev)
+	}
+
+	// Personal best: beaten once, then quiet.
+	ev = checkMilestones(cfg, "Olga", info, 46.1, 7.0, 2600, 2500)
+	if len(ev) != 1 || !strings.Contains(ev[0].text, "личный рекорд") {
+		t.Errorf("expected personal best, got %+v", ev)
+	}
+	if ev := checkMilestones(cfg, "Olga", info, 46.1, 7.0, 2700, 2500); len(ev) != 0 {
+		t.Errorf("personal best must be announced once, got %+v", ev)
+	}
+
+	t.Run("high launch is not a milestone", func(t *testing.T) {
+		info := &TrackInfo{}
+		if ev := checkMilestones(cfg, "Ivan", info, 46.0, 7.0, 2100, 1900); len(ev) != 0 {
+			t.Fatalf("launch at 2100 m announced %+v", ev)
+		}
+		if ev := checkMilestones(cfg, "Ivan", info, 46.0, 7.0, 2300, 1900); len(ev) != 0 {
+			t.Errorf("thresholds below launch announced %+v", ev)
+		}
+		ev := checkMilestones(cfg, "Ivan", info, 46.0, 7.0, 3050, 1900)
+		if len(ev) != 1 || !strings.Contains(ev[0].text, "3000 м") {
+			t.Errorf("expected 3000 m above a high launch, got %+v", ev)
+		}
+	})
+
+	t.Run("muted chat records silently", func(t *testing.T) {
+		muted := cfg
+		muted.Off = true
+		info := &TrackInfo{}
+		if ev := checkMilestones(muted, "Ivan", info, 46.0, 7.0, 2100, 0); len(ev) != 0 {
+			t.Errorf("muted: got %+v", ev)
+		}
+		if !info.MilestonesHit["alt:2000"] {
+			t.Error("muted chat should still mark the milestone as reached")
+		}
+	})
+}
+
+func TestApplyMilestoneArgs(t *testing.T) {
+	cfg := defaultMilestoneConfig()
+	if !applyMilestoneArgs(&cfg, []string{"alt", "3500", "1500"}) || len(cfg.AltM) != 2 || cfg.AltM[0] != 1500 {
+		t.Errorf("alt list: %+v", cfg.AltM)
+	}
+	if !applyMilestoneArgs(&cfg, []string{"dist", "off"}) || len(cfg.DistKm) != 0 {
+		t.Errorf("dist off: %+v", cfg.DistKm)
+	}
+	if !applyMilestoneArgs(&cfg, []string{"pb", "off"}) || !cfg.NoPB {
+		t.Error("pb off not applied")
+	}
+	if !applyMilestoneArgs(&cfg, []string{"off"}) || !cfg.Off {
+		t.Error("off not applied")
+	}
+	for _, bad := range [][]string{nil, {"alt"}, {"alt", "x"}, {"alt", "-5"}, {"pb"}, {"pb", "maybe"}, {"bogus"}} {
+		c := defaultMilestoneConfig()
+		if applyMilestoneArgs(&c, bad) {
+			t.Errorf("applyMilestoneArgs(%v) should fail", bad)
+		}
+	}
+}
+
+func TestPersonalBestAlt(t *testing.T) {
+	flights := []FlightRecord{{ID: "A", MaxAlt: 2500}, {ID: "A", MaxAlt: 3100}, {ID: "B", MaxAlt: 4000}}
+	if got := personalBestAlt(flights, "A"); got != 3100 {
+		t.Errorf("personalBestAlt = %v, want 3100", got)
+	}
+	if got := personalBestAlt(flights, "C"); got != 0 {
+		t.Errorf("unknown pilot = %v, want 0", got)
+	}
+}
+
+func TestMilestonesPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID: -100,
+			Tracking: map[string]*TrackInfo{"AABBCC": {
+				Name:          "Olga",
+				Launch:        &Coordinates{Latitude: 46, Longitude: 7},
+				MilestonesHit: map[string]bool{"alt:2000": true},
+			}},
+			Milestones: &MilestoneConfig{Off: true, AltM: []int{1500}, DistKm: []int{}},
+		},
+	}
+	tr2 := saveAndReload(t, tr)
+	s := tr2.session
+	if s.Milestones == nil || !s.Milestones.Off || len(s.Milestones.AltM) != 1 {
+		t.Fatalf("milestone config not restored: %+v", s.Milestones)
+	}
+	info := s.Tracking["AABBCC"]
+	if info == nil || info.Launch == nil || !info.MilestonesHit["alt:2000"] {
+		t.Errorf("milestone progress not restored: %+v", info)
+	}
+}
+
+func TestParseGeoNamesLine(t *testing.T) {
+	row := "2658576\tVilleneuve\tVilleneuve\tVilleneuve VD\t46.39748\t6.92775\tP\tPPL\tCH\t\tVD\t\t\t\t5000\t\t375\tEurope/Zurich\t2020-01-01"
+	p, ok := parseGeoNamesLine(row)
+	if !ok || p.name != "Villeneuve" || p.lat != 46.39748 || p.lon != 6.92775 {
+		t.Fatalf("GeoNames row: %+v ok=%v", p, ok)
+	}
+	mountain := strings.Replace(row, "\tP\tPPL\t", "\tT\tMT\t", 1)
+	if _, ok := parseGeoNamesLine(mountain); ok {
+		t.Error("non-populated features must be skipped")
+	}
+	if p, ok := parseGeoNamesLine("Rennaz\t46.377\t6.918"); !ok || p.name != "Rennaz" {
+		t.Errorf("short row: %+v ok=%v", p, ok)
+	}
+	for _, bad := range []string{"", "# comment", "Rennaz\tx\t6.9", "only\ttwo"} {
+		if _, ok := parseGeoNamesLine(bad); ok {
+			t.Errorf("parseGeoNamesLine(%q) should fail", bad)
+		}
+	}
+}
+
+func TestDescribeLocation(t *testing.T) {
+	g := newGazetteer([]place{
+		{name: "Villeneuve", lat: 46.3975, lon: 6.9278},
+		{name: "Aigle", lat: 46.3180, lon: 6.9706},
+	})
+	if got := describeLocation(g, 46.3976, 6.9279); got != "в Villeneuve" {
+		t.Errorf("in place: %q", got)
+	}
+	// ~2.2 km north of Aigle, still closer to it than to Villeneuve.
+	if got := describeLocation(g, 46.338, 6.9706); got != "2.2км N от Aigle" {
+		t.Errorf("near place: %q", got)
+	}
+	if got := describeLocation(g, 47.5, 8.0); got != "47.50000, 8.00000" {
+		t.Errorf("far away should fall back to coordinates: %q", got)
+	}
+	if got := describeLocation(nil, 46.3976, 6.9279); got != "46.39760, 6.92790" {
+		t.Errorf("no gazetteer should fall back to coordinates: %q", got)
+	}
+}
+
+func TestLoadGazetteer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "places.txt")
+	data := "# test\nRennaz\t46.377\t6.918\nbroken line\nAigle\t46.318\t6.9706\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := loadGazetteer(path)
+	if err != nil {
+		t.Fatalf("loadGazetteer: %v", err)
+	}
+	if g.count != 2 {
+		t.Errorf("count = %d, want 2", g.count)
+	}
+	if _, err := loadGazetteer(filepath.Join(t.TempDir(), "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
+
+func TestFormatTrackTextLandedPlace(t *testing.T) {
+	g := newGazetteer([]place{{name: "Aigle", lat: 46.3180, lon: 6.9706}})
+	info := &TrackInfo{
+		Status:   StatusLanded,
+		Position: &parser.PositionMessage{Latitude: 46.338, Longitude: 6.9706},
+	}
+	got := formatTrackText("AABBCC", info, nil, nil, nil, g, time.UTC)
+	if !strings.Contains(got, "🏘 2.2км N от Aigle") {
+		t.Errorf("landed pilot should show the nearest place, got:\n%s", got)
+	}
+	info.Status = StatusFlying
+	if got := formatTrackText("AABBCC", info, nil, nil, nil, g, time.UTC); strings.Contains(got, "🏘") {
+		t.Errorf("flying pilot should not show a place, got:\n%s", got)
+	}
+}
+
+func TestGuessTimezone(t *testing.T) {
+	tests := []struct {
+		lat, lon float64
+		want     string
+	}{
+		{46.40, 6.93, "Europe/Zurich"}, // Villeneuve
+		{50.45, 30.52, "Europe/Kyiv"},  // Kyiv
+		{-33.87, 151.21, "Australia/Sydney"},
+		{39.74, -104.99, "America/Denver"}, // Denver
+		// Near borders and zone edges inside a country, where a
+		// neighbour's principal location is nearer.
+		{49.84, 24.03, "Europe/Kyiv"},             // Lviv, not Warsaw
+		{48.62, 22.30, "Europe/Kyiv"},             // Uzhhorod, not Budapest
+		{49.78, 22.77, "Europe/Warsaw"},           // Przemyśl
+		{32.05, 76.72, "Asia/Kolkata"},            // Bir, not Kabul
+		{31.55, 74.34, "Asia/Karachi"},            // Lahore
+		{27.72, 85.32, "Asia/Kathmandu"},          // Kathmandu
+		{36.55, 29.12, "Europe/Istanbul"},         // Ölüdeniz, not Nicosia
+		{36.43, 28.22, "Europe/Athens"},           // Rhodes
+		{38.08, 46.29, "Asia/Tehran"},             // Tabriz
+		{48.39, -4.49, "Europe/Paris"},            // Brest, not London
+		{35.77, -5.80, "Africa/Casablanca"},       // Tangier, not Ceuta
+		{31.77, -106.44, "America/Denver"},        // El Paso, not Ojinaga
+		{31.69, -106.42, "America/Ciudad_Juarez"}, // across the river
+		{43.60, 39.73, "Europe/Moscow"},           // Sochi, not Tbilisi
+		{54.70, 20.50, "Europe/Kaliningrad"},
+		{53.20, 50.15, "Europe/Samara"},
+		{54.99, 73.37, "Asia/Omsk"},
+		{51.17, 71.43, "Asia/Almaty"}, // Astana
+		{50.28, 57.17, "Asia/Aqtobe"},
+		{-9.97, -67.81, "America/Rio_Branco"},
+		{-15.60, -56.10, "America/Cuiaba"},
+		{-23.55, -46.63, "America/Sao_Paulo"},
+		{51.05, -114.07, "America/Edmonton"}, // Calgary
+		{50.45, -104.60, "America/Regina"},
+		{33.45, -112.07, "America/Phoenix"},
+		{36.17, -115.14, "America/Los_Angeles"}, // Las Vegas
+		{46.81, -100.78, "America/Chicago"},     // Bismarck
+		{35.05, -85.31, "America/New_York"},     // Chattanooga
+		{0, -160, "Etc/GMT+11"},                 // open Pacific
+	}
+	for _, tt := range tests {
+		loc, ok := guessTimezone(tt.lat, tt.lon)
+		if !ok || loc.String() != tt.want {
+			t.Errorf("guessTimezone(%v, %v) = %v, %v; want %s", tt.lat, tt.lon, loc, ok, tt.want)
+		}
+	}
+}
+
+func TestZoneGrid(t *testing.T) {
+	g := zoneGridOnce()
+	if g == nil || g.res != 40 || len(g.names) < 400 {
+		t.Fatalf("zone grid not loaded: %v", g)
+	}
+	for _, name := range g.names {
+		if _, err := time.LoadLocation(name); err != nil {
+			t.Errorf("grid zone %q not loadable: %v", name, err)
+		}
+	}
+	// Edges of the grid and the antimeridian stay in range.
+	for _, p := range [][2]float64{{90, 180}, {-90, -180}, {0, 180}, {0, -180}, {10, 540}} {
+		if name := g.lookup(p[0], p[1]); name == "" {
+			t.Errorf("lookup(%v, %v) empty", p[0], p[1])
+		}
+	}
+	if _, err := parseZoneGrid(tzGrid[:len(tzGrid)/2]); err == nil {
+		t.Error("truncated grid accepted")
+	}
+	if _, err := parseZoneGrid([]byte("not gzip")); err == nil {
+		t.Error("garbage accepted")
+	}
+}
+
+func TestAutoTimezone(t *testing.T) {
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	s := &GroupSession{}
+	note := autoTimezone(s, 46.40, 6.93, now)
+	if s.Timezone == nil || s.Timezone.String() != "Europe/Zurich" {
+		t.Fatalf("timezone not inferred: %v", s.Timezone)
+	}
+	if !strings.Contains(note, "Europe/Zurich") || !strings.Contains(note, "14:00") {
+		t.Errorf("announcement = %q", note)
+	}
+	if note := autoTimezone(s, 50.45, 30.52, now); note != "" || s.Timezone.String() != "Europe/Zurich" {
+		t.Errorf("second guess must not override the first: %q, %v", note, s.Timezone)
+	}
+
+	kyiv, err := time.LoadLocation("Europe/Kyiv")
+	if err != nil {
+		t.Skip("tzdata unavailable")
+	}
+	explicit := &GroupSession{Timezone: kyiv}
+	if note := autoTimezone(explicit, 46.40, 6.93, now); note != "" || explicit.Timezone != kyiv {
+		t.Errorf("explicit /tz must be kept: %q, %v", note, explicit.Timezone)
+	}
+	if note := autoTimezone(&GroupSession{}, 0, 0, now); note != "" {
+		t.Errorf("0,0 must be ignored: %q", note)
+	}
+}
+
+func TestSunTimes(t *testing.T) {
+	tests := []struct {
+		tz           string
+		lat, lon     float64
+		sunset, dusk string // published values for 2026-06-21, local time
+	}{
+		{"America/Denver", 39.74, -104.99, "20:31", "21:04"},
+		{"Australia/Sydney", -33.87, 151.21, "16:54", "17:21"},
+		{"Europe/Kyiv", 50.45, 30.52, "21:12", "21:58"},
+	}
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.tz)
+		if err != nil {
+			t.Skip("tzdata unavailable")
+		}
+		now := time.Date(2026, 6, 21, 12, 0, 0, 0, loc)
+		sunset, dusk, ok := sunTimes(now, tt.lat, tt.lon, loc)
+		if !ok {
+			t.Fatalf("%s: no sunset", tt.tz)
+		}
+		check := func(what string, got time.Time, want string) {
+			w, _ := time.ParseInLocation("2006-01-02 15:04", "2026-06-21 "+want, loc)
+			if d := got.Sub(w); d < -3*time.Minute || d > 3*time.Minute {
+				t.Errorf("%s %s = %s, want ~%s", tt.tz, what, got.In(loc).Format("15:04"), want)
+			}
+		}
+		check("sunset", sunset, tt.sunset)
+		check("dusk", dusk, tt.dusk)
+	}
+
+	// Polar summer: the sun never sets.
+	if _, _, ok := sunTimes(time.Date(2026, 6, 21, 12, 0, 0, 0, time.UTC), 78.2, 15.6, time.UTC); ok {
+		t.Error("Svalbard in June should have no sunset")
+	}
+}
+
+func TestDueSunsetWarning(t *testing.T) {
+	sunset := time.Date(2026, 7, 1, 19, 30, 0, 0, time.UTC)
+	sentSet := map[int]bool{}
+	sent := func(m int) bool { return sentSet[m] }
+
+	if _, _, ok := dueSunsetWarning([]int{60, 30, 0}, sunset, sunset.Add(-61*time.Minute), sent); ok {
+		t.Error("nothing is due before T-60")
+	}
+	due, skipped, ok := dueSunsetWarning([]int{60, 30, 0}, sunset, sunset.Add(-59*time.Minute), sent)
+	if !ok || due != 60 || len(skipped) != 0 {
+		t.Errorf("at T-59: due=%d skipped=%v ok=%v", due, skipped, ok)
+	}
+	// Tracking started late: only the latest reminder goes out.
+	due, skipped, ok = dueSunsetWarning([]int{60, 30, 0}, sunset, sunset.Add(-10*time.Minute), sent)
+	if !ok || due != 30 || len(skipped) != 1 || skipped[0] != 60 {
+		t.Errorf("at T-10: due=%d skipped=%v ok=%v", due, skipped, ok)
+	}
+	sentSet[60], sentSet[30] = true, true
+	if _, _, ok := dueSunsetWarning([]int{60, 30, 0}, sunset, sunset.Add(-5*time.Minute), sent); ok {
+		t.Error("sent reminders must not repeat")
+	}
+}
+
+func TestSunsetEvents(t *testing.T) {
+	loc := time.UTC
+	landing := &Coordinates{Latitude: 46.4, Longitude: 6.93}
+	sunset, dusk, _ := sunTimes(time.Date(2026, 7, 1, 12, 0, 0, 0, loc), landing.Latitude, landing.Longitude, loc)
+
+	tr := &Tracker{
+		users: map[int64]*UserInfo{7: {UserID: 7, DMChatID: 700}},
+		session: &GroupSession{
+			ChatID:   -100,
+			Landing:  landing,
+			Timezone: loc,
+			Tracking: map[string]*TrackInfo{
+				"AABBCC": {Name: "Olga", OwnerUserID: 7, Status: StatusFlying,
+					Position: &parser.PositionMessage{Altitude: 1800}, LastUpdate: sunset.Add(-time.Hour)},
+				"DDEEFF": {Name: "Ivan", Status: StatusLanded, Position: &parser.PositionMessage{}},
+			},
+		},
+	}
+	s := tr.session
+	s.SunsetNotified = map[string]bool{"2026-06-30:30": true, "2026-06-30:dusk": true}
+
+	msgs := tr.sunsetEventsLocked(s, sunset.Add(-29*time.Minute))
+	if len(msgs) != 2 || msgs[0].chatID != -100 || msgs[1].chatID != 700 {
+		t.Fatalf("T-30 reminder: %+v", msgs)
+	}
+	if s.SunsetNotified["2026-06-30:30"] || s.SunsetNotified["2026-06-30:dusk"] {
+		t.Errorf("yesterday's reminders not pruned: %v", s.SunsetNotified)
+	}
+	if !strings.Contains(msgs[0].text, "30 мин") || !strings.Contains(msgs[0].text, "Olga") || strings.Contains(msgs[0].text, "Ivan") {
+		t.Errorf("T-30 chat text: %q", msgs[0].text)
+	}
+	if !s.SunsetNotified["2026-07-01:60"] {
+		t.Error("skipped T-60 should be marked as sent")
+	}
+	if msgs := tr.sunsetEventsLocked(s, sunset.Add(-28*time.Minute)); len(msgs) != 0 {
+		t.Errorf("reminder repeated: %+v", msgs)
+	}
+	if msgs := tr.sunsetEventsLocked(s, sunset.Add(time.Minute)); len(msgs) != 2 || !strings.Contains(msgs[0].text, "пора садиться") {
+		t.Errorf("T-0 reminder: %+v", msgs)
+	}
+
+	msgs = tr.sunsetEventsLocked(s, dusk.Add(time.Minute))
+	if len(msgs) != 2 || !strings.Contains(msgs[0].text, "Перекличка") || !strings.Contains(msgs[0].text, "Olga — 1800м") {
+		t.Fatalf("roll-call: %+v", msgs)
+	}
+	if msgs := tr.sunsetEventsLocked(s, dusk.Add(2*time.Minute)); len(msgs) != 0 {
+		t.Errorf("roll-call repeated: %+v", msgs)
+	}
+
+	t.Run("muted", func(t *testing.T) {
+		s.Sunset = &SunsetConfig{Off: true}
+		s.SunsetNotified = nil
+		if msgs := tr.sunsetEventsLocked(s, sunset.Add(-29*time.Minute)); len(msgs) != 0 {
+			t.Errorf("muted chat got %+v", msgs)
+		}
+	})
+}
+
+func TestBuildSunsetLine(t *testing.T) {
+	landing := &Coordinates{Latitude: 46.4, Longitude: 6.93}
+	sunset, dusk, _ := sunTimes(time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC), landing.Latitude, landing.Longitude, time.UTC)
+	if got := buildSunsetLine(landing, sunset.Add(-48*time.Minute), time.UTC); !strings.HasPrefix(got, "🌅 Закат через 48 мин") {
+		t.Errorf("before sunset: %q", got)
+	}
+	if got := buildSunsetLine(landing, sunset.Add(-125*time.Minute), time.UTC); !strings.Contains(got, "2ч 05м") {
+		t.Errorf("hours countdown: %q", got)
+	}
+	if got := buildSunsetLine(landing, sunset.Add(time.Minute), time.UTC); !strings.HasPrefix(got, "🌇") {
+		t.Errorf("after sunset: %q", got)
+	}
+	if got := buildSunsetLine(landing, dusk.Add(time.Minute), time.UTC); !strings.HasPrefix(got, "🌃") {
+		t.Errorf("after dusk: %q", got)
+	}
+	if got := buildSunsetLine(nil, sunset, time.UTC); got != "" {
+		t.Errorf("no anchor: %q", got)
+	}
+}
+
+func TestApplySunsetArgs(t *testing.T) {
+	cfg := SunsetConfig{}
+	if !applySunsetArgs(&cfg, []string{"0", "45", "15"}) || len(cfg.WarnAt) != 3 || cfg.WarnAt[0] != 45 || cfg.WarnAt[2] != 0 {
+		t.Errorf("minutes list: %+v", cfg.WarnAt)
+	}
+	if !applySunsetArgs(&cfg, []string{"off"}) || !cfg.Off {
+		t.Error("off not applied")
+	}
+	for _, bad := range [][]string{nil, {"-5"}, {"soon"}, {"off", "30"}, {"999"}} {
+		c := SunsetConfig{}
+		if applySunsetArgs(&c, bad) {
+			t.Errorf("applySunsetArgs(%v) should fail", bad)
+		}
+	}
+}
+
+func TestSunsetPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID:         -100,
+			Tracking:       map[string]*TrackInfo{},
+			Sunset:         &SunsetConfig{WarnAt: []int{45, 0}},
+			SunsetNotified: map[string]bool{"2026-07-01:45": true},
+		},
+	}
+	s := saveAndReload(t, tr).session
+	if s.Sunset == nil || len(s.Sunset.WarnAt) != 2 || !s.SunsetNotified["2026-07-01:45"] {
+		t.Errorf("sunset state not restored: %+v %v", s.Sunset, s.SunsetNotified)
+	}
+}
+
+func TestZoneContains(t *testing.T) {
+	square := Zone{Name: "Sq", Polygon: []Coordinates{
+		{Latitude: 46.0, Longitude: 7.0}, {Latitude: 46.0, Longitude: 7.1},
+		{Latitude: 46.1, Longitude: 7.1}, {Latitude: 46.1, Longitude: 7.0},
+	}}
+	if !square.contains(46.05, 7.05) || square.contains(46.05, 7.15) || square.contains(45.99, 7.05) {
+		t.Error("polygon containment wrong")
+	}
+	circle := Zone{Name: "C", Center: &Coordinates{Latitude: 46.0, Longitude: 7.0}, RadiusKm: 2}
+	if !circle.contains(46.01, 7.0) || circle.contains(46.03, 7.0) {
+		t.Error("circle containment wrong")
+	}
+	if got := square.filterTerm(); !strings.HasPrefix(got, "a/46.100000/7.000000/46.000000/7.100000") {
+		t.Errorf("polygon filter = %q", got)
+	}
+	if got := circle.filterTerm(); got != "r/46.000000/7.000000/2" {
+		t.Errorf("circle filter = %q", got)
+	}
+}
+
+func TestParseZoneFileKML(t *testing.T) {
+	kml := `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2"><Document>
+ <Placemark><name>CTR</name><Polygon><outerBoundaryIs><LinearRing><coordinates>
+  7.0,46.0,0 7.1,46.0,0 7.1,46.1,0 7.0,46.0,0
+ </coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark>
+ <Placemark><name>Pin</name><Point><coordinates>7.0,46.0</coordinates></Point></Placemark>
+ <Placemark><MultiGeometry>
+  <Polygon><outerBoundaryIs><LinearRing><coordinates>8,47 8.1,47 8.1,47.1</coordinates></LinearRing></outerBoundaryIs></Polygon>
+ </MultiGeometry></Placemark>
+</Document></kml>`
+	zones, err := parseZoneFile("site.kml", []byte(kml))
+	if err != nil {
+		t.Fatalf("parseZoneFile: %v", err)
+	}
+	if len(zones) != 2 {
+		t.Fatalf("got %d zones, want 2: %+v", len(zones), zones)
+	}
+	if zones[0].Name != "CTR" || len(zones[0].Polygon) != 3 {
+		t.Errorf("closing vertex should be dropped: %+v", zones[0])
+	}
+	if zones[0].Polygon[1].Latitude != 46.0 || zones[0].Polygon[1].Longitude != 7.1 {
+		t.Errorf("KML is lon,lat: %+v", zones[0].Polygon[1])
+	}
+	if zones[1].Name != "Зона 2" {
+		t.Errorf("unnamed placemark name = %q", zones[1].Name)
+	}
+
+	// The same document zipped as KMZ.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("doc.kml")
+	w.Write([]byte(kml))
+	zw.Close()
+	if zones, err := parseZoneFile("site.kmz", buf.Bytes()); err != nil || len(zones) != 2 {
+		t.Errorf("KMZ: %v, %d zones", err, len(zones))
+	}
+}
+
+func TestParseZoneFileGeoJSON(t *testing.T) {
+	gj := `{"type":"FeatureCollection","features":[
+	 {"type":"Feature","properties":{"name":"LZ"},"geometry":{"type":"Polygon","coordinates":[[[7,46],[7.1,46],[7.1,46.1],[7,46]]]}},
+	 {"type":"Feature","properties":{"name":"Castle","radius":500},"geometry":{"type":"Point","coordinates":[7.2,46.2]}},
+	 {"type":"Feature","properties":{"name":"Twin"},"geometry":{"type":"MultiPolygon","coordinates":[
+	   [[[8,47],[8.1,47],[8.1,47.1]]], [[[9,48],[9.1,48],[9.1,48.1]]]]}},
+	 {"type":"Feature","properties":{"name":"Pin"},"geometry":{"type":"Point","coordinates":[7,46]}}
+	]}`
+	zones, err := parseZoneFile("zones.geojson", []byte(gj))
+	if err != nil {
+		t.Fatalf("parseZoneFile: %v", err)
+	}
+	names := make([]string, len(zones))
+	for i, z := range zones {
+		names[i] = z.Name
+	}
+	if got := strings.Join(names, ","); got != "LZ,Castle,Twin 1,Twin 2" {
+		t.Fatalf("zones = %s", got)
+	}
+	if c := zones[1]; c.Center == nil || c.RadiusKm != 0.5 || c.Center.Latitude != 46.2 {
+		t.Errorf("circle from point+radius: %+v", c)
+	}
+	if _, err := parseZoneFile("x.txt", []byte("hello")); err == nil {
+		t.Error("unknown format should fail")
+	}
+	if _, err := parseZoneFile("empty.geojson", []byte(`{"type":"FeatureCollection","features":[]}`)); err == nil {
+		t.Error("file without zones should fail")
+	}
+}
+
+func TestZoneEvents(t *testing.T) {
+	s := &GroupSession{Zones: []Zone{
+		{Name: "Site", Role: ZoneBoundary, Center: &Coordinates{Latitude: 46.0, Longitude: 7.0}, RadiusKm: 5},
+		{Name: "CTR", Role: ZoneNoFly, Center: &Coordinates{Latitude: 46.1, Longitude: 7.0}, RadiusKm: 2},
+	}}
+	info := &TrackInfo{Name: "Olga"}
+
+	if ev := zoneEventsLocked(s, "AABBCC", info, 46.0, 7.0); len(ev) != 0 {
+		t.Fatalf("first fix inside the site should be silent: %v", ev)
+	}
+	// One fix outside is jitter; the second confirms the exit.
+	if ev := zoneEventsLocked(s, "AABBCC", info, 46.0, 7.1); len(ev) != 0 {
+		t.Errorf("single fix outside should not announce: %v", ev)
+	}
+	ev := zoneEventsLocked(s, "AABBCC", info, 46.0, 7.1)
+	if len(ev) != 1 || !strings.Contains(ev[0], "вылетел за границу «Site»") {
+		t.Errorf("boundary exit: %v", ev)
+	}
+	// Flapping back for a single fix resets the pending count.
+	zoneEventsLocked(s, "AABBCC", info, 46.0, 7.0)
+	if ev := zoneEventsLocked(s, "AABBCC", info, 46.0, 7.1); len(ev) != 0 {
+		t.Errorf("flap should not announce: %v", ev)
+	}
+
+	fresh := &TrackInfo{Name: "Ivan"}
+	ev = zoneEventsLocked(s, "DDEEFF", fresh, 46.1, 7.0)
+	if len(ev) != 1 || !strings.Contains(ev[0], "запретной зоне «CTR»") {
+		t.Errorf("starting inside a no-fly zone must alert: %v", ev)
+	}
+	if ev := zoneEventsLocked(s, "X", &TrackInfo{AutoDiscovered: true}, 46.1, 7.0); ev != nil {
+		t.Errorf("auto-discovered aircraft should not produce zone events: %v", ev)
+	}
+}
+
+func TestZoneDiscovery(t *testing.T) {
+	s := &GroupSession{
+		Tracking: map[string]*TrackInfo{},
+		Zones: []Zone{
+			{Name: "Ridge", Role: ZoneDiscover, Polygon: []Coordinates{
+				{Latitude: 46.0, Longitude: 7.0}, {Latitude: 46.0, Longitude: 7.1}, {Latitude: 46.1, Longitude: 7.1},
+			}},
+			{Name: "CTR", Role: ZoneNoFly, Center: &Coordinates{Latitude: 47, Longitude: 8}, RadiusKm: 5},
+		},
+	}
+	if !s.discoveryOn() {
+		t.Error("a discover zone should enable discovery")
+	}
+	if !s.discoverable(46.01, 7.08) || s.discoverable(46.09, 7.01) || s.discoverable(47, 8) {
+		t.Error("only beacons inside the discover polygon are discoverable")
+	}
+	filter, _, _ := buildFilter(s)
+	if !strings.Contains(filter, "a/46.100000/7.000000/46.000000/7.100000") || strings.Contains(filter, "r/47") {
+		t.Errorf("filter should cover discover zones only: %q", filter)
+	}
+}
+
+func TestZonesPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID:   -100,
+			Tracking: map[string]*TrackInfo{},
+			Zones: []Zone{
+				{Name: "LZ", Role: ZoneBoundary, Center: &Coordinates{Latitude: 46, Longitude: 7}, RadiusKm: 3},
+				{Name: "CTR", Role: ZoneNoFly, Polygon: []Coordinates{{Latitude: 1, Longitude: 2}, {Latitude: 3, Longitude: 4}, {Latitude: 5, Longitude: 6}}},
+			},
+		},
+	}
+	s := saveAndReload(t, tr).session
+	if len(s.Zones) != 2 || s.Zones[0].Center == nil || s.Zones[1].Role != ZoneNoFly || len(s.Zones[1].Polygon) != 3 {
+		t.Errorf("zones not restored: %+v", s.Zones)
+	}
+}
+
+func TestDiscoverLocked(t *testing.T) {
+	s := &GroupSession{
+		Tracking:        map[string]*TrackInfo{"PILOT1": {Name: "Olga"}},
+		TrackArea:       &Coordinates{Latitude: 46, Longitude: 7},
+		TrackAreaRadius: 10,
+		Discovery:       &DiscoveryRules{Types: []int{6, 7}, MaxAltM: 3000, Cap: 2, StaleAfter: 15},
+	}
+	pg := func(lat, alt float64) *parser.PositionMessage {
+		return &parser.PositionMessage{Latitude: lat, Longitude: 7, Altitude: alt, AircraftType: 7}
+	}
+
+	if info := discoverLocked(s, nil, "AAA", nil, pg(46, 1500)); info == nil || !info.AutoDiscovered {
+		t.Fatalf("paraglider in the area should be discovered: %+v", info)
+	}
+	if discoverLocked(s, nil, "TUG", nil, &parser.PositionMessage{Latitude: 46, Longitude: 7, AircraftType: 2}) != nil {
+		t.Error("tow plane should be filtered out by type")
+	}
+	if discoverLocked(s, nil, "HIGH", nil, pg(46, 3500)) != nil {
+		t.Error("aircraft above the ceiling should be ignored")
+	}
+	discoverLocked(s, nil, "BBB", nil, pg(46, 1000))
+	if discoverLocked(s, nil, "CCC", nil, pg(46, 1000)) != nil {
+		t.Error("cap of 2 should stop a third discovery")
+	}
+	if _, ok := s.Tracking["CCC"]; ok {
+		t.Error("capped aircraft must not be added")
+	}
+
+	// Leaving the area drops an auto entry; a tracked pilot stays.
+	if discoverLocked(s, nil, "AAA", s.Tracking["AAA"], pg(47, 1500)) != nil {
+		t.Error("auto-discovered aircraft leaving the area should be dropped")
+	}
+	if _, ok := s.Tracking["AAA"]; ok {
+		t.Error("dropped entry still tracked")
+	}
+	if info := discoverLocked(s, nil, "PILOT1", s.Tracking["PILOT1"], pg(47, 5000)); info == nil || info.Name != "Olga" {
+		t.Errorf("tracked pilot must never be dropped: %+v", info)
+	}
+}
+
+func TestPruneDiscovered(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := &GroupSession{Tracking: map[string]*TrackInfo{
+		"OLD":    {AutoDiscovered: true, LastUpdate: now.Add(-20 * time.Minute)},
+		"FRESH":  {AutoDiscovered: true, LastUpdate: now.Add(-5 * time.Minute)},
+		"PILOT1": {Name: "Olga", LastUpdate: now.Add(-time.Hour)},
+	}}
+	if n := pruneDiscoveredLocked(s, now); n != 1 {
+		t.Errorf("pruned %d, want 1", n)
+	}
+	if _, ok := s.Tracking["OLD"]; ok {
+		t.Error("stale auto entry should be removed")
+	}
+	if len(s.Tracking) != 2 {
+		t.Errorf("fresh and tracked entries must stay: %v", s.Tracking)
+	}
+	s.Discovery = &DiscoveryRules{Cap: 30}
+	s.Tracking["OLD"] = &TrackInfo{AutoDiscovered: true}
+	if n := pruneDiscoveredLocked(s, now); n != 0 {
+		t.Error("stale off should keep everything")
+	}
+}
+
+func TestApplyDiscoveryArgs(t *testing.T) {
+	r := (&GroupSession{}).discovery()
+	if r.Cap != defaultDiscoverCap || r.StaleAfter != defaultDiscoverStale {
+		t.Fatalf("defaults: %+v", r)
+	}
+	for _, args := range [][]string{{"types", "PG", "hg"}, {"alt", "2500"}, {"cap", "10"}, {"stale", "off"}} {
+		if !applyDiscoveryArgs(&r, args) {
+			t.Fatalf("rejected %v", args)
+		}
+	}
+	if len(r.Types) != 2 || r.Types[0] != 6 || r.Types[1] != 7 || r.MaxAltM != 2500 || r.Cap != 10 || r.StaleAfter != 0 {
+		t.Errorf("rules after args: %+v", r)
+	}
+	for _, bad := range [][]string{{"types", "zeppelin"}, {"cap", "0"}, {"cap", "500"}, {"alt"}, {"wat", "1"}} {
+		if applyDiscoveryArgs(&r, bad) {
+			t.Errorf("accepted %v", bad)
+		}
+	}
+	if !applyDiscoveryArgs(&r, []string{"types", "all"}) || r.Types != nil {
+		t.Error("types all should clear the filter")
+	}
+	if !strings.Contains(describeDiscovery(DiscoveryRules{Types: []int{7}, Cap: 5}, 3), "3 из 5") {
+		t.Error("describeDiscovery should show the count and cap")
+	}
+}
+
+func TestDiscoveryPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID:    -100,
+			Tracking:  map[string]*TrackInfo{},
+			Discovery: &DiscoveryRules{Types: []int{7}, MaxAltM: 4000, Cap: 12, StaleAfter: 0},
+		},
+	}
+	s := saveAndReload(t, tr).session
+	if s.Discovery == nil || s.Discovery.Cap != 12 || s.Discovery.MaxAltM != 4000 || len(s.Discovery.Types) != 1 || s.Discovery.StaleAfter != 0 {
+		t.Errorf("discovery rules not restored: %+v", s.Discovery)
+	}
+}
+
+func TestPrivacyFlags(t *testing.T) {
+	devices := map[string]ddb.Device{
+		"OPEN01": {AircraftModel: "Ozone Zeno", Registration: "D-1234", CN: "Z1", Tracked: true, Identified: true},
+		"ANON01": {AircraftModel: "Advance Omega", Registration: "D-5678", CN: "A1", Tracked: true},
+		"NOTRK1": {AircraftModel: "Gin Boomerang", Tracked: false, Identified: true},
+	}
+	beacon := &parser.PositionMessage{Latitude: 46, Longitude: 7, AircraftType: 7}
+
+	if privacyHidden(devices, "OPEN01", beacon) || privacyHidden(devices, "UNKNWN", beacon) {
+		t.Error("opted-in and unknown devices should be visible")
+	}
+	if !privacyHidden(devices, "NOTRK1", beacon) {
+		t.Error("DDB tracked=N must hide the device")
+	}
+	if !privacyHidden(devices, "OPEN01", &parser.PositionMessage{NoTracking: true}) {
+		t.Error("no-tracking beacon must hide the device")
+	}
+
+	if got := publicDDBInfo(devices, "OPEN01", beacon); got != "Ozone Zeno | D-1234 | CN:Z1" {
+		t.Errorf("identified device: %q", got)
+	}
+	if got := publicDDBInfo(devices, "ANON01", beacon); got != "Advance Omega" {
+		t.Errorf("unidentified device should show the model only: %q", got)
+	}
+	if got := publicDDBInfo(devices, "OPEN01", &parser.PositionMessage{Stealth: true}); got != "Ozone Zeno" {
+		t.Errorf("stealth beacon should hide registration: %q", got)
+	}
+
+	s := &GroupSession{
+		Tracking:        map[string]*TrackInfo{"NOTRK1": {Name: "Olga"}},
+		TrackArea:       &Coordinates{Latitude: 46, Longitude: 7},
+		TrackAreaRadius: 10,
+	}
+	if discoverLocked(s, devices, "NOTRK2", nil, &parser.PositionMessage{Latitude: 46, Longitude: 7, NoTracking: true}) != nil {
+		t.Error("no-tracking aircraft must not be auto-discovered")
+	}
+	if info := discoverLocked(s, devices, "NOTRK1", s.Tracking["NOTRK1"], beacon); info == nil {
+		t.Error("explicitly added pilot must stay tracked despite the DDB opt-out")
+	}
+	discoverLocked(s, devices, "OPEN01", nil, beacon)
+	if discoverLocked(s, devices, "OPEN01", s.Tracking["OPEN01"], &parser.PositionMessage{Latitude: 46, Longitude: 7, NoTracking: true}) != nil {
+		t.Error("auto-discovered aircraft switching to no-tracking should be dropped")
+	}
+	text := formatTrackText("ANON01", &TrackInfo{AutoDiscovered: true, Position: beacon}, nil, nil, devices, nil, time.UTC)
+	if strings.Contains(text, "D-5678") || !strings.Contains(text, "Advance Omega") {
+		t.Errorf("dashboard leaks the identity of an unidentified device: %q", text)
+	}
+}
+
+func TestRadarOptionsToggle(t *testing.T) {
+	var o RadarOptions
+	o.toggle("type")
+	if len(o.Types) != 2 || o.typeLabel() != "PG+HG" {
+		t.Errorf("first type press should select PG+HG: %+v", o)
+	}
+	for range len(radarTypePresets) - 1 {
+		o.toggle("type")
+	}
+	if o.Types != nil {
+		t.Errorf("type presets should wrap to all: %v", o.Types)
+	}
+	o.toggle("alt")
+	o.toggle("alt")
+	if o.MinAltM != 1500 || o.MaxAltM != 3000 || o.altLabel() != "1500–3000 м" {
+		t.Errorf("alt band: %+v", o)
+	}
+	o.toggle("sort")
+	if o.Sort != radarSortDist {
+		t.Errorf("sort after one press: %q", o.Sort)
+	}
+	o.toggle("sort")
+	o.toggle("sort")
+	if o.Sort != "" {
+		t.Errorf("sort should wrap to altitude: %q", o.Sort)
+	}
+	o.toggle("climb")
+	if !o.ClimbersOnly || o.toggle("bogus") {
+		t.Error("climb toggle / unknown option")
+	}
+}
+
+func TestFilterRadarLines(t *testing.T) {
+	center := &Coordinates{Latitude: 46, Longitude: 7}
+	mk := func(id string, typ int, lat, alt, climb float64) radarLine {
+		return radarLine{id, RadarEntry{AircraftType: typ, Position: &parser.PositionMessage{
+			Latitude: lat, Longitude: 7, Altitude: alt, ClimbRate: climb, AircraftType: typ,
+		}}}
+	}
+	lines := []radarLine{
+		mk("PG_FAR", 7, 46.2, 2500, 2.0),
+		mk("PG_NEAR", 7, 46.01, 1800, -1.0),
+		mk("HG", 6, 46.05, 3200, 0.8),
+		mk("TUG", 2, 46.0, 900, 4.0),
+	}
+	ids := func(ls []radarLine) string {
+		var out []string
+		for _, l := range ls {
+			out = append(out, l.id)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := ids(filterRadarLines(lines, center, RadarOptions{})); got != "HG,PG_FAR,PG_NEAR,TUG" {
+		t.Errorf("default altitude sort: %s", got)
+	}
+	if got := ids(filterRadarLines(lines, center, RadarOptions{Types: []int{6, 7}, Sort: radarSortDist})); got != "PG_NEAR,HG,PG_FAR" {
+		t.Errorf("PG+HG by distance: %s", got)
+	}
+	if got := ids(filterRadarLines(lines, center, RadarOptions{MinAltM: 1500, MaxAltM: 3000})); got != "PG_FAR,PG_NEAR" {
+		t.Errorf("altitude band: %s", got)
+	}
+	opts := RadarOptions{ClimbersOnly: true, Sort: radarSortClimb}
+	shown := filterRadarLines(lines, center, opts)
+	if got := ids(shown); got != "TUG,PG_FAR,HG" {
+		t.Errorf("climbers by vario: %s", got)
+	}
+	text := buildRadarSummary(shown, len(lines), center, 20, opts, time.UTC)
+	if !strings.Contains(text, "3 из 4 ВС") || !strings.Contains(text, "⬆ TUG +4.0м/с | 900м") {
+		t.Errorf("compact climbers summary:\n%s", text)
+	}
+	kb := radarButtons(shown, opts)
+	last := kb.InlineKeyboard[len(kb.InlineKeyboard)-1]
+	if last[1].CallbackData != "radar_opt:climb" || !strings.HasPrefix(last[1].Text, "✅") {
+		t.Errorf("option buttons missing or stale: %+v", last)
+	}
+}
+
+func TestRadarOptionsPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID:       -100,
+			Tracking:     map[string]*TrackInfo{},
+			RadarOptions: &RadarOptions{Types: []int{6, 7}, MaxAltM: 1500, Sort: radarSortClimb, ClimbersOnly: true},
+		},
+	}
+	o := saveAndReload(t, tr).session.radarOptions()
+	if len(o.Types) != 2 || o.MaxAltM != 1500 || o.Sort != radarSortClimb || !o.ClimbersOnly {
+		t.Errorf("radar options not restored: %+v", o)
+	}
+}
+
+func TestRadarCard(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 30, 0, time.UTC)
+	e := RadarEntry{
+		AircraftType: 7,
+		DDBInfo:      "Ozone Zeno | D-1234",
+		LastSeen:     now.Add(-15 * time.Second),
+		Position: &parser.PositionMessage{
+			Latitude: 46.05, Longitude: 7, Altitude: 2150, ClimbRate: 1.8, GroundSpeed: 34,
+			Course: 270, ReceiverName: "LSGB", SignalQuality: 12.5,
+		},
+	}
+	text := buildRadarCard("DD1234", e, &Coordinates{Latitude: 46, Longitude: 7}, now, time.UTC)
+	for _, want := range []string{"🔍 DD1234 [Paraglider]", "Ozone Zeno | D-1234", "+1.8м/с", "курс 270° W", "5.6км N от центра", "📡 LSGB (12.5 дБ)", "12:00:15 (15 с назад)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("card missing %q:\n%s", want, text)
+		}
+	}
+
+	kb := radarCardButtons("DD1234", e.Position, false)
+	row := kb.InlineKeyboard[0]
+	if len(row) != 2 || row[0].URL == "" || row[1].CallbackData != "radar_track:DD1234" {
+		t.Errorf("card buttons: %+v", row)
+	}
+	if row := radarCardButtons("DD1234", e.Position, true).InlineKeyboard[0]; len(row) != 1 {
+		t.Errorf("tracked aircraft should not offer ➕: %+v", row)
+	}
+	lines := []radarLine{{"DD1234", e}}
+	if got := radarButtons(lines, RadarOptions{}).InlineKeyboard[0][0].CallbackData; got != "radar_card:DD1234" {
+		t.Errorf("radar entry button should open the card: %q", got)
+	}
+}
+
+func TestRadarPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID:       -100,
+			Tracking:     map[string]*TrackInfo{},
+			TrackArea:    &Coordinates{Latitude: 46, Longitude: 7},
+			RadarOn:      true,
+			RadarRadius:  25,
+			RadarMsgID:   777,
+			RadarEntries: map[string]*RadarEntry{"DD1234": {LastSeen: time.Now()}},
+		},
+	}
+	tr2 := saveAndReload(t, tr)
+	s := tr2.session
+	if !tr2.resumeRadar {
+		t.Error("radar should be flagged for resume")
+	}
+	if s.RadarOn || s.RadarRadius != 25 || s.RadarMsgID != 777 {
+		t.Errorf("radar state: on=%v radius=%d msg=%d", s.RadarOn, s.RadarRadius, s.RadarMsgID)
+	}
+	if len(s.RadarEntries) != 0 {
+		t.Error("radar entries are runtime-only")
+	}
+
+	// Radar without an area can't resume.
+	tr.session.TrackArea = nil
+	if saveAndReload(t, tr).resumeRadar {
+		t.Error("radar without an area must not resume")
+	}
+}
+
+func TestPinRenewal(t *testing.T) {
+	now := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	pos := &parser.PositionMessage{Latitude: 46, Longitude: 7}
+	fresh := &TrackInfo{Status: StatusFlying, Position: pos, MessageID: 10, LabelMsgID: 9, MessageSentAt: now.Add(-20 * time.Hour)}
+	if pinNeedsRenewal(fresh, now) {
+		t.Error("a 20h-old pin should be left alone")
+	}
+	old := &TrackInfo{Status: StatusFlying, Position: pos, MessageID: 10, LabelMsgID: 9, MessageSentAt: now.Add(-23*time.Hour - 45*time.Minute)}
+	if !pinNeedsRenewal(old, now) {
+		t.Error("a pin 15 min from expiry should be renewed")
+	}
+	if pinNeedsRenewal(&TrackInfo{Status: StatusLanded, Position: pos, MessageID: 10, LiveLocationDead: true}, now) {
+		t.Error("landed pilots keep their frozen pin")
+	}
+	if !pinNeedsRenewal(&TrackInfo{Status: StatusFlying, Position: pos, MessageID: 10, LiveLocationDead: true}, now) {
+		t.Error("a dead pin of a flying pilot should be renewed")
+	}
+	if pinNeedsRenewal(&TrackInfo{Status: StatusFlying, Position: pos, MessageID: 10}, now) {
+		t.Error("a pin of unknown age is renewed only once it dies")
+	}
+
+	renewPinLocked("AABBCC", old)
+	if old.MessageID != 0 || old.LabelMsgID != 0 || !old.MessageSentAt.IsZero() || len(old.RetiredMsgIDs) != 2 {
+		t.Fatalf("renewal state: %+v", old)
+	}
+	if ids := retiredPinsLocked(old); ids != nil {
+		t.Errorf("old pins must stay until the new one is up: %v", ids)
+	}
+	old.MessageID = 11
+	if ids := retiredPinsLocked(old); len(ids) != 2 || ids[0] != 9 || ids[1] != 10 || old.RetiredMsgIDs != nil {
+		t.Errorf("retired pins: %v, left %v", ids, old.RetiredMsgIDs)
+	}
+}
+
+func TestPinRenewalPersistRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	sent := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	tr := &Tracker{
+		users: make(map[int64]*UserInfo),
+		session: &GroupSession{
+			ChatID: -100,
+			Tracking: map[string]*TrackInfo{
+				"AABBCC": {MessageID: 12, MessageSentAt: sent, RetiredMsgIDs: []int{9, 10}},
+			},
+		},
+	}
+	info := saveAndReload(t, tr).session.Tracking["AABBCC"]
+	if info == nil || !info.MessageSentAt.Equal(sent) || len(info.RetiredMsgIDs) != 2 {
+		t.Errorf("pin bookkeeping not restored: %+v", info)
+	}
+}
+
+func TestWindEstimate(t *testing.T) {
+	if s := courseSpread([]velocitySample{{course: 350}, {course: 10}}); s != 20 {
+		t.Errorf("spread across north = %v, want 20", s)
+	}
+
+	// Circling at 30 km/h airspeed in a 10 km/h wind blowing towards east:
+	// the ground velocity is the air velocity plus the wind.
+	info := &TrackInfo{}
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 12 {
+		heading := float64(i) * 30
+		e, n := velocity(heading, 30)
+		e += 10
+		course := math.Mod(math.Atan2(e, n)*180/math.Pi+360, 360)
+		msg := &parser.PositionMessage{Course: int(math.Round(course)), GroundSpeed: math.Hypot(e, n)}
+		recordVelocity(info, msg, start.Add(time.Duration(i)*3*time.Second))
+	}
+	if info.Wind == nil {
+		t.Fatal("a full circle should yield a wind estimate")
+	}
+	if math.Abs(info.Wind.East-10) > 0.5 || math.Abs(info.Wind.North) > 0.5 {
+		t.Errorf("wind = %+v, want ~10 km/h towards east", *info.Wind)
+	}
+
+	// Old samples fall out of the window.
+	recordVelocity(info, &parser.PositionMessage{Course: 90, GroundSpeed: 40}, start.Add(5*time.Minute))
+	if len(info.Velocity) != 1 {
+		t.Errorf("velocity window not trimmed: %d samples", len(info.Velocity))
+	}
+}
+
+func TestPredictPosition(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	straight := &TrackInfo{
+		Status:     StatusFlying,
+		Position:   &parser.PositionMessage{Latitude: 46, Longitude: 7, Course: 360, GroundSpeed: 36},
+		LastUpdate: now.Add(-100 * time.Second),
+		Velocity:   []velocitySample{{course: 0, speed: 36}, {course: 5, speed: 36}},
+	}
+	p := predictPosition(straight, now)
+	if p == nil {
+		t.Fatal("straight glide should be predicted")
+	}
+	// 36 km/h for 100 s = 1 km north.
+	if d, _ := distanceAndBearing(46, 7, p.Lat, p.Lon); math.Abs(d-1) > 0.02 || p.Lat <= 46 {
+		t.Errorf("predicted %.3f km to %.5f,%.5f", d, p.Lat, p.Lon)
+	}
+	if p.AccuracyM <= predictBaseAccuracyM || p.AccuracyM > maxHorizontalAccuracy {
+		t.Errorf("accuracy = %v", p.AccuracyM)
+	}
+
+	straight.LastUpdate = now.Add(-10 * time.Second)
+	if predictPosition(straight, now) != nil {
+		t.Error("a fresh fix needs no prediction")
+	}
+	straight.LastUpdate = now.Add(-5 * time.Minute)
+	if predictPosition(straight, now) != nil {
+		t.Error("prediction must stop when the fix is too old")
+	}
+
+	circling := &TrackInfo{
+		Status:     StatusFlying,
+		Position:   &parser.PositionMessage{Latitude: 46, Longitude: 7, Course: 200, GroundSpeed: 25},
+		LastUpdate: now.Add(-60 * time.Second),
+		Velocity:   []velocitySample{{course: 0}, {course: 90}, {course: 180}, {course: 270}},
+	}
+	if predictPosition(circling, now) != nil {
+		t.Error("circling without a wind estimate should not be predicted")
+	}
+	circling.Wind = &Wind{East: 12, At: now.Add(-5 * time.Minute)}
+	p = predictPosition(circling, now)
+	if p == nil || p.Lon <= 7 || math.Abs(p.Lat-46) > 1e-6 {
+		t.Errorf("circling pilot should drift east with the wind: %+v", p)
+	}
+
+	text := formatTrackText("AABBCC", &TrackInfo{Status: StatusFlying, Position: straight.Position, LastUpdate: time.Now(), Predicted: &Prediction{Lat: 46.01, Lon: 7, AccuracyM: 350, Age: 90 * time.Second}}, nil, nil, nil, nil, time.UTC)
+	if !strings.Contains(text, "~прогноз: 1.1км N") || !strings.Contains(text, "±350м") {
+		t.Errorf("dashboard should mark the prediction: %q", text)
+	}
+}
+
+func TestCheckBeacon(t *testing.T) {
+	t0 := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	fix := func(ts time.Time, lat, alt float64) *parser.PositionMessage {
+		return &parser.PositionMessage{Timestamp: ts, Latitude: lat, Longitude: 7, Altitude: alt, AircraftType: 7, SignalQuality: 10}
+	}
+	info := &TrackInfo{}
+	accept := func(msg *parser.PositionMessage) string {
+		reason := checkBeacon(info, msg, false)
+		if reason == "" {
+			info.Position = msg
+		}
+		return reason
+	}
+
+	if r := accept(fix(t0, 46, 1000)); r != "" {
+		t.Fatalf("first fix rejected: %s", r)
+	}
+	if r := accept(fix(t0, 46, 1000)); r != rejectDuplicate {
+		t.Errorf("relayed copy: %q", r)
+	}
+	if r := accept(fix(t0.Add(10*time.Second), 46.001, 1005)); r != "" {
+		t.Errorf("plausible fix rejected: %s", r)
+	}
+	if r := accept(fix(t0.Add(5*time.Second), 46.0005, 1002)); r != rejectOutOfOrder {
+		t.Errorf("late fix: %q", r)
+	}
+	// 50 km in 10 s.
+	if r := accept(fix(t0.Add(20*time.Second), 46.45, 1010)); r != rejectJump {
+		t.Errorf("teleport: %q", r)
+	}
+	// 1 km of climb in 10 s.
+	if r := accept(fix(t0.Add(20*time.Second), 46.0015, 2005)); r != rejectJump {
+		t.Errorf("impossible climb: %q", r)
+	}
+	if info.Rejects.Duplicate != 1 || info.Rejects.OutOfOrder != 1 || info.Rejects.Jump != 2 || info.Rejects.Total() != 4 {
+		t.Errorf("reject counters: %+v", info.Rejects)
+	}
+	// The third implausible fix in a row wins: the old position was the bad one.
+	if r := accept(fix(t0.Add(30*time.Second), 46.45, 1010)); r != "" {
+		t.Errorf("streak of jumps should reset to the new position: %q", r)
+	}
+
+	// Weighted mode: a better-received copy of the same fix replaces it, and
+	// a fix with bit errors gets half the speed limit.
+	w := &TrackInfo{}
+	first := fix(t0, 46, 1000)
+	first.SignalQuality = 4
+	checkBeacon(w, first, true)
+	w.Position = first
+	better := fix(t0, 46.0001, 1000)
+	better.SignalQuality = 15
+	if r := checkBeacon(w, better, true); r != "" {
+		t.Errorf("better copy should replace the first: %q", r)
+	}
+	w.Position = better
+	noisy := fix(t0.Add(10*time.Second), 46.01, 1000) // ~1.1 km in 10 s ≈ 400 km/h
+	noisy.ErrorCount = 2
+	if r := checkBeacon(w, noisy, true); r != rejectJump {
+		t.Errorf("noisy fast fix in weighted mode: %q", r)
+	}
+	clean := fix(t0.Add(10*time.Second), 46.01, 1000)
+	if r := checkBeacon(w, clean, true); r != "" {
+		t.Errorf("clean fix at the same speed should pass: %q", r)
+	}
+}
+
+func TestSmoothBeacon(t *testing.T) {
+	t0 := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	info := &TrackInfo{Status: StatusFlying}
+	// Steady 2 m/s climb at 40 km/h; the tracker's vario and speed swing
+	// ±2.5 m/s and ±10 km/h, the altitude ±4 m.
+	var last *parser.PositionMessage
+	for i := 0; i <= 40; i++ {
+		sign := float64(1 - 2*(i%2))
+		ts := t0.Add(time.Duration(i*2) * time.Second)
+		last = &parser.PositionMessage{
+			Timestamp:   ts,
+			Altitude:    1000 + 2*float64(i*2) + 4*sign,
+			ClimbRate:   2 + 2.5*sign,
+			GroundSpeed: 40 + 10*sign,
+		}
+		info.Position = last
+		smoothBeacon(info, last, ts)
+	}
+	sm := info.Smooth
+	if math.Abs(sm.Climb-2) > 0.5 {
+		t.Errorf("smoothed climb = %.2f, want ≈2", sm.Climb)
+	}
+	if math.Abs(sm.Speed-40) > 5 {
+		t.Errorf("smoothed speed = %.1f, want ≈40", sm.Speed)
+	}
+	for _, w := range []time.Duration{30 * time.Second, time.Minute} {
+		v, ok := sm.averageVario(w)
+		if !ok || math.Abs(v-2) > 0.3 {
+			t.Errorf("average vario over %v = %.2f (ok=%v), want ≈2", w, v, ok)
+		}
+	}
+	if info.climb() != sm.Climb || info.speed() != sm.Speed {
+		t.Error("climb/speed accessors should return the smoothed values")
+	}
+	if info.Position != last || last.ClimbRate != 4.5 {
+		t.Error("raw beacon values must be left untouched")
+	}
+
+	// Fresh filter: no history yet, so no averaged vario.
+	fresh := &TrackInfo{}
+	smoothBeacon(fresh, &parser.PositionMessage{Timestamp: t0, Altitude: 500, ClimbRate: 1}, t0)
+	if _, ok := fresh.Smooth.averageVario(30 * time.Second); ok {
+		t.Error("averaged vario reported without history")
+	}
+	// A long gap restarts the filter from the new fix.
+	later := t0.Add(10 * time.Minute)
+	smoothBeacon(fresh, &parser.PositionMessage{Timestamp: later, Altitude: 2500, ClimbRate: -1}, later)
+	if fresh.Smooth.Alt != 2500 || fresh.Smooth.Climb != -1 {
+		t.Errorf("filter not reset after a gap: %+v", fresh.Smooth)
+	}
+}
+
+func TestLandingUsesSmoothedValues(t *testing.T) {
+	t0 := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	info := &TrackInfo{Status: StatusFlying}
+	feed := func(i int, alt, climb, speed float64) *parser.PositionMessage {
+		ts := t0.Add(time.Duration(i*4) * time.Second)
+		msg := &parser.PositionMessage{Timestamp: ts, Altitude: alt, ClimbRate: climb, GroundSpeed: speed}
+		info.Position = msg
+		smoothBeacon(info, msg, ts)
+		return msg
+	}
+	for i := 0; i < 10; i++ {
+		feed(i, 1500, 0, 35)
+	}
+	// One beacon reads zero speed mid-flight: not a landing.
+	msg := feed(10, 1500, 0, 0)
+	updateLandingState(info, msg, t0)
+	if !info.LowSpeedSince.IsZero() {
+		t.Error("a single zero-speed beacon started the landing timer")
+	}
+
+	// On the ground the GPS altitude jitters by a few metres but the
+	// smoothed vario stays under the landing threshold.
+	var landed bool
+	for i := 11; i < 80 && !landed; i++ {
+		jitter := []float64{0, 3, -2, 4, -3, 1}[i%6]
+		msg = feed(i, 800+jitter, 0, 1)
+		landed = updateLandingState(info, msg, t0.Add(time.Duration(i*4)*time.Second))
+	}
+	if !landed {
+		t.Errorf("landing not detected; smoothed climb %.2f speed %.1f", info.Smooth.Climb, info.Smooth.Speed)
+	}
+}
+
+func TestRecordSignal(t *testing.T) {
+	t0 := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := &GroupSession{Tracking: map[string]*TrackInfo{}}
+	info := &TrackInfo{Name: "Вася"}
+	beacon := func(sec int, rx string, snr float64) {
+		at := t0.Add(time.Duration(sec) * time.Second)
+		recordSignalLocked(s, "AABBCC", info, &parser.PositionMessage{
+			Timestamp: at, ReceiverName: rx, SignalQuality: snr, ErrorCount: 1, GPSQuality: "3x5", FreqOffset: -2.1,
+		}, at)
+	}
+	// Strong signal for the first minutes, heard by two stations…
+	for sec := 0; sec <= 240; sec += 10 {
+		beacon(sec, "LFLE", 20)
+		beacon(sec, "LFLG", 12)
+	}
+	// …then a 3-minute hole and a fading signal from one station only.
+	for sec := 420; sec <= 600; sec += 10 {
+		beacon(sec, "LFLE", 6)
+	}
+
+	sig := info.Signal
+	if sig.Fixes != 25+19 {
+		t.Errorf("fixes = %d, want 44 (relayed copies count once)", sig.Fixes)
+	}
+	if sig.Gaps != 1 || sig.MaxGap != 3*time.Minute || !sig.MaxGapAt.Equal(t0.Add(240*time.Second)) {
+		t.Errorf("gaps = %d, max %v at %v", sig.Gaps, sig.MaxGap, sig.MaxGapAt)
+	}
+	if r := sig.Receivers["LFLE"]; r.Beacons != 44 || r.BestSNR != 20 {
+		t.Errorf("LFLE = %+v", r)
+	}
+	if older, newer, ok := sig.snrTrend(); !ok || older <= newer {
+		t.Errorf("snr trend %.1f → %.1f (ok=%v), want falling", older, newer, ok)
+	}
+	if sig.Errors != 69 || sig.GPSQuality != "3x5" {
+		t.Errorf("errors = %d, gps = %q", sig.Errors, sig.GPSQuality)
+	}
+
+	text := formatSignal("AABBCC", info.DisplayName(), sig, t0.Add(610*time.Second), time.UTC)
+	for _, want := range []string{"AABBCC (Вася)", "Фиксов: 44", "самый длинный 3мин в 12:04", "↘ падает", "Станции (2)", "LFLE — 44 биконов", "GPS 3x5"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("signal report lacks %q:\n%s", want, text)
+		}
+	}
+	if text := formatSignal("DDEEFF", "", nil, t0, time.UTC); !strings.Contains(text, "не было") {
+		t.Errorf("no-beacon report: %s", text)
+	}
+
+	// LFLG went quiet at 12:04 while LFLE kept hearing the pilot until 12:10.
+	cov := formatCoverage(s.Receivers, t0.Add(900*time.Second))
+	if !strings.Contains(cov, "2 станций") || !strings.Contains(cov, "Замолчали:\nLFLG — тишина 11мин (было 25 биконов, пилотов 1)") {
+		t.Errorf("coverage summary:\n%s", cov)
+	}
+	if strings.Contains(cov, "LFLE — тишина") {
+		t.Errorf("active station flagged as silent:\n%s", cov)
+	}
+}
+
+func TestParseDeviceIDs(t *testing.T) {
+	ids, errText := parseDeviceIDs("flrAABBCC+ddeeff, AABBCC 112233")
+	if errText != "" || strings.Join(ids, " ") != "AABBCC DDEEFF 112233" {
+		t.Errorf("ids = %v, err %q", ids, errText)
+	}
+	for _, bad := range []string{"", "AABBCC XYZ", "111111 222222 333333 444444 555555"} {
+		if _, errText := parseDeviceIDs(bad); errText == "" {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+
+	cur := []string{"AABBCC"}
+	if ids, _ := editDeviceList(cur, "add ddeeff"); strings.Join(ids, " ") != "AABBCC DDEEFF" {
+		t.Errorf("add: %v", ids)
+	}
+	if ids, _ := editDeviceList([]string{"AABBCC", "DDEEFF"}, "remove AABBCC"); strings.Join(ids, " ") != "DDEEFF" {
+		t.Errorf("remove primary: %v", ids)
+	}
+	if _, errText := editDeviceList(cur, "remove AABBCC"); errText == "" {
+		t.Error("removing the last device accepted")
+	}
+	if ids, _ := editDeviceList(cur, "112233 445566"); strings.Join(ids, " ") != "112233 445566" {
+		t.Errorf("replace: %v", ids)
+	}
+}
+
+func TestLinkDevices(t *testing.T) {
+	s := &GroupSession{Tracking: map[string]*TrackInfo{
+		"AABBCC": {Name: "Olga", OwnerUserID: 1},
+		"DDEEFF": {Name: "phone", MessageID: 10, LabelMsgID: 11},
+		"112233": {Devices: []string{"445566"}},
+	}}
+	orphans := linkDevicesLocked(s, "AABBCC", []string{"DDEEFF", "445566"})
+	if len(orphans) != 2 {
+		t.Errorf("orphans = %v, want the standalone entry's pin and label", orphans)
+	}
+	if _, ok := s.Tracking["DDEEFF"]; ok {
+		t.Error("standalone entry for a linked device kept")
+	}
+	if got := deviceLabel("AABBCC", s.Tracking["AABBCC"]); got != "AABBCC+DDEEFF+445566" {
+		t.Errorf("label = %q", got)
+	}
+	if len(s.Tracking["112233"].Devices) != 0 {
+		t.Error("device still linked to its previous pilot")
+	}
+	for _, dev := range []string{"AABBCC", "DDEEFF", "445566"} {
+		if key, ok := s.pilotKey(dev); !ok || key != "AABBCC" {
+			t.Errorf("pilotKey(%s) = %q, %v", dev, key, ok)
+		}
+	}
+	if _, ok := s.pilotKey("778899"); ok {
+		t.Error("unknown device resolved")
+	}
+
+	_, callsigns, ids := buildFilter(s)
+	if len(ids) != 4 || !slices.Contains(callsigns, "FLRDDEEFF") || !slices.Contains(callsigns, "OGN445566") {
+		t.Errorf("filter ids = %v, callsigns = %v", ids, callsigns)
+	}
+
+	if !rekeyPilotLocked(s, "AABBCC", "DDEEFF", 1) || s.Tracking["DDEEFF"].Name != "Olga" {
+		t.Error("rekey to the new primary failed")
+	}
+	if rekeyPilotLocked(s, "112233", "AABBCC", 1) {
+		t.Error("rekeyed an entry owned by someone else")
+	}
+}
+
+func TestAddDirectLinksUserDevices(t *testing.T) {
+	tr := &Tracker{
+		users: map[int64]*UserInfo{
+			7: {UserID: 7, Username: "olga", OGNIDs: []string{"AABBCC", "DDEEFF"}},
+		},
+		session: &GroupSession{Tracking: map[string]*TrackInfo{}},
+	}
+	tr.aprs = tr.newAPRSFeed("")
+	m := &models.Message{ID: 1, Chat: models.Chat{ID: -100}, From: &models.User{ID: 9}}
+	tr.execAddDirect(context.Background(), nil, m, []string{"AABBCC", "@olga"})
+	info := tr.session.Tracking["AABBCC"]
+	if info == nil || info.OwnerUserID != 7 {
+		t.Fatalf("pilot not added: %+v", info)
+	}
+	if got := deviceLabel("AABBCC", info); got != "AABBCC+DDEEFF" {
+		t.Errorf("label = %q, want the /myid devices linked", got)
+	}
+	if key, ok := tr.session.pilotKey("DDEEFF"); !ok || key != "AABBCC" {
+		t.Errorf("pilotKey(DDEEFF) = %q, %v", key, ok)
+	}
+}
+
+func TestLinkedDeviceAccuracyWins(t *testing.T) {
+	t0 := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	info := &TrackInfo{Devices: []string{"DDEEFF"}}
+	flarm := &parser.PositionMessage{Callsign: "FLRAABBCC", Timestamp: t0, Latitude: 46, Longitude: 7, GPSQuality: "8x10"}
+	if checkBeacon(info, flarm, false) != "" || info.Source != "AABBCC" {
+		t.Fatalf("first fix: source %q", info.Source)
+	}
+	info.Position = flarm
+	phone := &parser.PositionMessage{Callsign: "OGNDDEEFF", Timestamp: t0, Latitude: 46.0001, Longitude: 7, GPSQuality: "3x5"}
+	if r := checkBeacon(info, phone, false); r != "" || info.Source != "DDEEFF" {
+		t.Errorf("more accurate device for the same second: %q, source %q", r, info.Source)
+	}
+	info.Position = phone
+	if r := checkBeacon(info, flarm, false); r != rejectDuplicate {
+		t.Errorf("less accurate copy: %q", r)
+	}
+	stale := &parser.PositionMessage{Callsign: "FLRAABBCC", Timestamp: t0.Add(-2 * time.Second), Latitude: 46, Longitude: 7}
+	if r := checkBeacon(info, stale, false); r != rejectOutOfOrder {
+		t.Errorf("older fix from the other device: %q", r)
+	}
+}
+
+func TestUserDevicesPersist(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	tr := &Tracker{
+		users: map[int64]*UserInfo{1: {UserID: 1, OGNIDs: []string{"AABBCC", "DDEEFF"}}},
+		session: &GroupSession{
+			ChatID:   -100,
+			Tracking: map[string]*TrackInfo{"AABBCC": {Name: "Olga", OwnerUserID: 1, Devices: []string{"DDEEFF"}}},
+		},
+	}
+	tr2 := saveAndReload(t, tr)
+	if got := tr2.users[1].OGNIDs; strings.Join(got, " ") != "AABBCC DDEEFF" {
+		t.Errorf("user devices = %v", got)
+	}
+	if got := tr2.session.Tracking["AABBCC"].Devices; strings.Join(got, " ") != "DDEEFF" {
+		t.Errorf("pilot devices = %v", got)
+	}
+
+	// Files written before multi-device support carry a single ogn_id.
+	const legacy = `{"users": {"2": {"ogn_id": "112233"}}}`
+	if err := os.WriteFile("data/session.json", []byte(legacy), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tr3 := &Tracker{users: make(map[int64]*UserInfo)}
+	tr3.mu.Lock()
+	tr3.loadState()
+	tr3.mu.Unlock()
+	if u := tr3.users[2]; u == nil || u.primaryID() != "112233" {
+		t.Errorf("legacy ogn_id not migrated: %+v", u)
+	}
+}
+
+func TestSearchDDB(t *testing.T) {
+	devices := map[string]ddb.Device{
+		"DD1234": {DeviceID: "DD1234", Registration: "D-1234", CN: "12", AircraftModel: "LS 4", Tracked: true, Identified: true},
+		"DD1235": {DeviceID: "DD1235", Registration: "D-1235", CN: "34", AircraftModel: "LS 8", Tracked: true, Identified: true},
+		"DD5678": {DeviceID: "DD5678", Registration: "D-5678", CN: "1234", AircraftModel: "Discus 2", Tracked: true, Identified: true},
+		"DD9999": {DeviceID: "DD9999", Registration: "D-9999", CN: "99", AircraftModel: "LS 4", Tracked: true, Identified: false},
+	}
+	ids := func(ds []ddb.Device) string {
+		var out []string
+		for _, d := range ds {
+			out = append(out, d.DeviceID)
+		}
+		return strings.Join(out, " ")
+	}
+	for query, want := range map[string]string{
+		"d1234":  "DD1234", // registration beats the CN "1234"
+		"1234":   "DD5678", // CN
+		"D-12":   "DD1234 DD1235",
+		"ls-4":   "DD1234", // DD9999 opted out of identification
+		"discus": "DD5678",
+		"x":      "",
+		" ":      "",
+	} {
+		if got := ids(searchDDB(devices, query)); got != want {
+			t.Errorf("searchDDB(%q) = %q, want %q", query, got, want)
+		}
+	}
+
+	var many []ddb.Device
+	for i := 0; i < maxDevicePicks+3; i++ {
+		many = append(many, ddb.Device{DeviceID: fmt.Sprintf("AA%04d", i), Registration: "D-1"})
+	}
+	rows := devicePickerMarkup(many).InlineKeyboard
+	if len(rows) != maxDevicePicks+1 || rows[len(rows)-1][0].CallbackData != "ddb_pick:cancel" {
+		t.Errorf("picker rows = %d, last %+v", len(rows), rows[len(rows)-1])
+	}
+
+	tr := &Tracker{devices: devices}
+	if id, matches := tr.lookupDeviceLocked(7, "D1234", devicePick{chatID: 1}); id != "DD1234" || matches != nil || tr.devicePicks[7] != nil {
+		t.Errorf("single match: id %q, matches %d", id, len(matches))
+	}
+	if id, matches := tr.lookupDeviceLocked(7, "D12", devicePick{chatID: 1, args: []string{"Olga"}}); id != "" || len(matches) != 2 {
+		t.Errorf("ambiguous: id %q, matches %d", id, len(matches))
+	}
+	if p := tr.devicePicks[7]; p == nil || p.chatID != 1 || p.args[0] != "Olga" || p.expires.IsZero() {
+		t.Errorf("pick = %+v", p)
+	}
+}
+
+func TestInviteReplyResolvesDDB(t *testing.T) {
+	// A stand-in for the Bot API that accepts every call.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":7,"type":"private"}}}`)
+	}))
+	defer srv.Close()
+	b, err := bot.New("1:test", bot.WithServerURL(srv.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Tracker{
+		bot: b,
+		devices: map[string]ddb.Device{
+			"DD1234": {DeviceID: "DD1234", Registration: "D-1234", Tracked: true, Identified: true},
+			"DD1235": {DeviceID: "DD1235", Registration: "D-1235", Tracked: true, Identified: true},
+		},
+		users:   map[int64]*UserInfo{7: {UserID: 7, DisplayName: "Оля", PendingGroup: -100}},
+		session: &GroupSession{ChatID: -100, Tracking: map[string]*TrackInfo{}},
+	}
+	tr.aprs = tr.newAPRSFeed("")
+	from := &models.User{ID: 7, FirstName: "Оля"}
+	dm := models.Chat{ID: 7, Type: models.ChatTypePrivate}
+
+	// Several matches: a picker, nobody added yet.
+	tr.handleDMText(context.Background(), b, &models.Message{Chat: dm, From: from, Text: "D-12"})
+	if p := tr.devicePicks[7]; p == nil || !p.invite || len(tr.session.Tracking) != 0 {
+		t.Fatalf("pick = %+v, tracking = %v", p, tr.session.Tracking)
+	}
+
+	tr.handleDMText(context.Background(), b, &models.Message{Chat: dm, From: from, Text: "d-1234"})
+	if info := tr.session.Tracking["DD1234"]; info == nil || info.OwnerUserID != 7 {
+		t.Fatalf("registration not resolved: %v", tr.session.Tracking)
+	}
+	if u := tr.users[7]; len(u.OGNIDs) != 1 || u.OGNIDs[0] != "DD1234" || u.PendingGroup != 0 {
+		t.Errorf("user = %+v", u)
+	}
+}
+
+func TestDDBCacheAndRefresh(t *testing.T) {
+	dir := t.TempDir()
+	defer chdir(t, dir)()
+
+	devices, err := parseDDBExport(strings.NewReader(`{"devices":[
+		{"device_type":"F","device_id":"DD1234","aircraft_model":"LS 4","registration":"D-1234","cn":"12","tracked":"Y","identified":"N"},
+		{"device_type":"O","device_id":"AABBCC","aircraft_model":"","registration":"","cn":"","tracked":true,"identified":true}]}`))
+	if err != nil || len(devices) != 2 {
+		t.Fatalf("parse: %v, %d devices", err, len(devices))
+	}
+	if d := devices[0]; d.Registration != "D-1234" || !d.Tracked || d.Identified {
+		t.Errorf("export flags: %+v", d)
+	}
+	if d := devices[1]; !d.Tracked || !d.Identified {
+		t.Errorf("cache-format flags: %+v", d)
+	}
+
+	at := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := writeDDBCache("data/ddb.json", ddbCacheFile{FetchedAt: at, Devices: devices}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := readDDBCache("data/ddb.json")
+	if err != nil || !c.FetchedAt.Equal(at) || len(c.Devices) != 2 || c.Devices[0].Identified {
+		t.Errorf("cache round trip: %v, %+v", err, c)
+	}
+
+	// Offline source: DDB_FILE is read and swapped in; the cache isn't
+	// rewritten from it.
+	if err := os.WriteFile("export.json", []byte(`{"devices":[{"device_id":"112233","registration":"F-CABC","tracked":"Y","identified":"Y"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tr := &Tracker{}
+	cfg := ddbConfig{cachePath: "data/ddb.json", file: "export.json", refresh: time.Hour}
+	if err := tr.refreshDevices(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if tr.devices["112233"].Registration != "F-CABC" || tr.ddb.source != "export.json" || tr.ddb.fetchedAt.IsZero() {
+		t.Errorf("after refresh: %d devices, status %+v", len(tr.devices), tr.ddb)
+	}
+	if c, _ := readDDBCache("data/ddb.json"); len(c.Devices) != 2 {
+		t.Errorf("cache overwritten from DDB_FILE: %d devices", len(c.Devices))
+	}
+
+	// A failed or empty refresh keeps the loaded data.
+	for _, body := range []string{`{"devices":[]}`, `not json`} {
+		os.WriteFile("export.json", []byte(body), 0o644)
+		if err := tr.refreshDevices(cfg); err == nil {
+			t.Errorf("%q: no error", body)
+		}
+		if len(tr.devices) != 1 || tr.ddb.lastErr == "" {
+			t.Errorf("%q: %d devices, lastErr %q", body, len(tr.devices), tr.ddb.lastErr)
+		}
+	}
+
+	now := tr.ddb.fetchedAt.Add(3 * time.Hour)
+	text := formatDDBStatus(tr.ddb, len(tr.devices), now)
+	for _, want := range []string{"1 устройств", "3ч 00мин назад", "export.json", "не удалось"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("status %q lacks %q", text, want)
+		}
+	}
+	if text := formatDDBStatus(ddbStatus{}, 0, now); !strings.Contains(text, "не загружена") {
+		t.Errorf("empty status: %q", text)
+	}
+}
+
+func TestTelegramLiveLocationFixes(t *testing.T) {
+	t0 := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	key := userKey(42)
+	info := &TrackInfo{Name: "Оля", OwnerUserID: 42}
+	s := &GroupSession{ChatID: -100, TrackingOn: true, Tracking: map[string]*TrackInfo{key: info}}
+	tr := &Tracker{session: s, users: map[int64]*UserInfo{42: {UserID: 42}}}
+
+	if got, ok := tr.userPilotKeyLocked(s, 42); !ok || got != key {
+		t.Errorf("userPilotKeyLocked = %q, %v", got, ok)
+	}
+	if filter, _, ids := buildFilter(s); filter != "" || len(ids) != 0 {
+		t.Errorf("OGN-less pilot in the APRS filter: %q %v", filter, ids)
+	}
+
+	fix := func(sec int, lat, lon float64) string {
+		_, reason := tr.applyExternalFixLocked(s, key, info, externalFix{
+			Source: sourceTelegram, At: t0.Add(time.Duration(sec) * time.Second),
+			Lat: lat, Lon: lon, Accuracy: 12,
+		})
+		return reason
+	}
+	if r := fix(0, 45.0, 6.0); r != "" {
+		t.Fatalf("first fix rejected: %s", r)
+	}
+	if !info.AltitudeUnknown || info.Source != sourceTelegram || info.LastFixAccuracy != 12 {
+		t.Errorf("after first fix: altUnknown %v source %q acc %.0f", info.AltitudeUnknown, info.Source, info.LastFixAccuracy)
+	}
+	// 1 km north in 60 s: speed and course are derived.
+	if r := fix(60, 45.009, 6.0); r != "" {
+		t.Fatalf("second fix rejected: %s", r)
+	}
+	if sp := info.Position.GroundSpeed; sp < 55 || sp > 65 {
+		t.Errorf("derived speed %.1f km/h, want ~60", sp)
+	}
+	if c := info.Position.Course; c != 360 && c > 2 {
+		t.Errorf("derived course %d, want north", c)
+	}
+	if r := fix(30, 45.1, 6.0); r != rejectOutOfOrder {
+		t.Errorf("older fix: %q", r)
+	}
+	if r := fix(60, 45.1, 6.0); r != rejectDuplicate {
+		t.Errorf("same-second fix: %q", r)
+	}
+
+	// An OGN beacon older than the Telegram fix loses; a newer one wins and
+	// brings the altitude — not a jump from the unknown altitude.
+	old := &parser.PositionMessage{Callsign: "FLRAABBCC", Timestamp: t0.Add(50 * time.Second), Latitude: 45.009, Longitude: 6.0, Altitude: 2100}
+	if r := checkBeacon(info, old, false); r != rejectOutOfOrder {
+		t.Errorf("stale OGN beacon: %q", r)
+	}
+	fresh := &parser.PositionMessage{Callsign: "FLRAABBCC", Timestamp: t0.Add(64 * time.Second), Latitude: 45.0091, Longitude: 6.0, Altitude: 2100}
+	if r := checkBeacon(info, fresh, false); r != "" || info.AltitudeUnknown || info.Source != "AABBCC" {
+		t.Errorf("fresh OGN beacon: %q, altUnknown %v, source %q", r, info.AltitudeUnknown, info.Source)
+	}
+
+	// Landing detection on Telegram fixes alone: a stationary phone.
+	ground := &TrackInfo{Status: StatusFlying}
+	var landed bool
+	for i := 0; i < 20 && !landed; i++ {
+		at := t0.Add(time.Duration(i*15) * time.Second)
+		msg := externalPosition(ground, externalFix{Source: sourceTelegram, At: at, Lat: 45.2 + float64(i%2)*0.00002, Lon: 6.1})
+		ground.Position, ground.LastFixTime = msg, at
+		landed = updateLandingState(ground, msg, at)
+	}
+	if !landed {
+		t.Errorf("landing not detected on telegram fixes; speed %.1f", ground.Position.GroundSpeed)
+	}
+
+	// A stranger's DM live location doesn't add them; the same in the group
+	// does, and a DM then updates the entry.
+	live := func(chat models.Chat) *models.Message {
+		return &models.Message{
+			From: &models.User{ID: 77, FirstName: "Гость"}, Chat: chat, Date: int(time.Now().Unix()),
+			Location: &models.Location{Latitude: 45.3, Longitude: 6.2, LivePeriod: 3600},
+		}
+	}
+	dm := models.Chat{ID: 77, Type: models.ChatTypePrivate}
+	tr.handleLiveLocation(context.Background(), nil, live(dm), false)
+	if _, ok := s.Tracking[userKey(77)]; ok {
+		t.Fatal("stranger added by a DM live location")
+	}
+	tr.handleLiveLocation(context.Background(), nil, live(models.Chat{ID: -100, Type: models.ChatTypeSupergroup}), false)
+	guest := s.Tracking[userKey(77)]
+	if guest == nil {
+		t.Fatal("group live location didn't add the pilot")
+	}
+	edit := live(dm)
+	edit.EditDate, edit.Location.Latitude = edit.Date+30, 45.31
+	tr.handleLiveLocation(context.Background(), nil, edit, true)
+	if guest.Position.Latitude != 45.31 {
+		t.Errorf("DM edit not applied: %.2f", guest.Position.Latitude)
+	}
+
+	// After /track_off live locations change nothing.
+	s.TrackingOn = false
+	edit.EditDate, edit.Location.Latitude = edit.Date+60, 45.32
+	tr.handleLiveLocation(context.Background(), nil, edit, true)
+	if guest.Position.Latitude != 45.31 {
+		t.Errorf("live location applied with tracking off: %.2f", guest.Position.Latitude)
+	}
+}
+
+func TestParseIngest(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// OsmAnd protocol (Traccar Client classic): speed in knots.
+	r := httptest.NewRequest("GET", "/?id=tok&lat=45.1&lon=6.2&timestamp=1748779200&altitude=1500&speed=10&bearing=90&accuracy=8", nil)
+	token, fix, err := parseIngest(r, now)
+	if err != nil || token != "tok" {
+		t.Fatalf("osmand: %q, %v", token, err)
+	}
+	if fix.Lat != 45.1 || fix.Lon != 6.2 || !fix.HasAlt || fix.Alt != 1500 || !fix.HasSpeed ||
+		math.Abs(fix.Speed-18.52) > 0.01 || fix.Course != 90 || fix.Accuracy != 8 || fix.Source != sourceApp ||
+		!fix.At.Equal(time.Unix(1748779200, 0)) {
+		t.Errorf("osmand fix: %+v", fix)
+	}
+
+	// OsmAnd app: millisecond timestamp, hdop, no speed.
+	r = httptest.NewRequest("POST", "/?id=tok&lat=45.1&lon=6.2&timestamp=1748779200500&hdop=12", nil)
+	if _, fix, err = parseIngest(r, now); err != nil || fix.HasSpeed || fix.HasAlt || fix.Accuracy != 12 || fix.At.UnixMilli() != 1748779200500 {
+		t.Errorf("osmand app: %+v, %v", fix, err)
+	}
+
+	// Traccar Client JSON: speed in m/s.
+	body := `{"device_id":"tok2","location":{"timestamp":"2025-06-01T11:59:30Z","coords":{"latitude":45.2,"longitude":6.3,"accuracy":5,"speed":10,"heading":-1,"altitude":900}}}`
+	r = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	token, fix, err = parseIngest(r, now)
+	if err != nil || token != "tok2" || fix.Speed != 36 || fix.Course != 0 || fix.Alt != 900 || !fix.At.Equal(now.Add(-30*time.Second)) {
+		t.Errorf("traccar json: %q %+v %v", token, fix, err)
+	}
+
+	for _, bad := range []string{
+		"/?id=tok&lat=45", "/?id=tok&lat=x&lon=6", "/?id=tok&lat=45&lon=6&timestamp=yesterday",
+		"/?id=tok&lat=NaN&lon=6", "/?id=tok&lat=45&lon=Inf", "/?id=tok&lat=95&lon=400",
+		"/?id=tok&lat=-90.5&lon=6", "/?id=tok&lat=45&lon=6&altitude=NaN",
+	} {
+		if _, _, err := parseIngest(httptest.NewRequest("GET", bad, nil), now); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+	for _, coords := range []string{`"latitude":95,"longitude":6`, `"latitude":45,"longitude":-400`, `"latitude":45`} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"device_id":"tok","location":{"coords":{`+coords+`}}}`))
+		r.Header.Set("Content-Type", "application/json")
+		if _, _, err := parseIngest(r, now); err == nil {
+			t.Errorf("json %s accepted", coords)
+		}
+	}
+}
+
+func TestServeIngest(t *testing.T) {
+	tr := &Tracker{
+		users:   map[int64]*UserInfo{7: {UserID: 7, DisplayName: "Оля", IngestToken: "secret"}},
+		session: &GroupSession{ChatID: -100, TrackingOn: true, Tracking: map[string]*TrackInfo{}},
+	}
+	push := func(query string) int {
+		w := httptest.NewRecorder()
+		tr.serveIngest(w, httptest.NewRequest("GET", "/?"+query, nil))
+		return w.Code
+	}
+	ts := time.Now().Add(-10 * time.Second).Unix()
+	if code := push(fmt.Sprintf("id=wrong&lat=45&lon=6&timestamp=%d", ts)); code != 403 {
+		t.Errorf("unknown token: %d", code)
+	}
+	if code := push(fmt.Sprintf("id=secret&lat=NaN&lon=6&timestamp=%d", ts)); code != http.StatusBadRequest {
+		t.Errorf("NaN latitude: %d", code)
+	}
+	if code := push("id=secret&lat=45"); code != 400 {
+		t.Errorf("no lon: %d", code)
+	}
+	// A user the group hasn't invited is not added by their own pushes.
+	if code := push(fmt.Sprintf("id=secret&lat=45&lon=6&altitude=1200&speed=20&timestamp=%d", ts-30)); code != 200 || len(tr.session.Tracking) != 0 {
+		t.Fatalf("uninvited push: %d, tracking %v", code, tr.session.Tracking)
+	}
+	tr.users[7].PendingGroup = -100
+	if code := push(fmt.Sprintf("id=secret&lat=45&lon=6&altitude=1200&speed=20&timestamp=%d", ts)); code != 200 {
+		t.Fatalf("push: %d", code)
+	}
+	info := tr.session.Tracking[userKey(7)]
+	if info == nil || info.Name != "Оля" || info.OwnerUserID != 7 {
+		t.Fatalf("pilot not added: %+v", tr.session.Tracking)
+	}
+	if info.Source != sourceApp || info.Position.Altitude != 1200 || info.AltitudeUnknown {
+		t.Errorf("after push: source %q alt %.0f unknown %v", info.Source, info.Position.Altitude, info.AltitudeUnknown)
+	}
+	// A stale fix is acknowledged but not applied.
+	if code := push(fmt.Sprintf("id=secret&lat=46&lon=6&timestamp=%d", ts-60)); code != 200 || info.Position.Latitude != 45 {
+		t.Errorf("stale push: %d, lat %.1f", code, info.Position.Latitude)
+	}
+
+	// A queue flushed after a dead spot keeps every fix in the track, each
+	// at its own time.
+	for i := 1; i <= 3; i++ {
+		push(fmt.Sprintf("id=secret&lat=45.0%d&lon=6&timestamp=%d", i, ts+int64(10*i)))
+	}
+	if len(info.Track) != 4 || !info.LastUpdate.Equal(time.Unix(ts+30, 0)) {
+		t.Errorf("after burst: %d track points, last update %v", len(info.Track), info.LastUpdate)
+	}
+
+	// After /track_off pushes are acknowledged but not applied.
+	tr.session.TrackingOn = false
+	if code := push(fmt.Sprintf("id=secret&lat=45.5&lon=6&timestamp=%d", ts+60)); code != http.StatusOK {
+		t.Errorf("push with tracking off: %d", code)
+	}
+	if len(info.Track) != 4 || info.Position.Latitude == 45.5 {
+		t.Errorf("push applied with tracking off: %d track points, lat %.2f", len(info.Track), info.Position.Latitude)
+	}
+
+	// The token survives a restart.
+	defer chdir(t, t.TempDir())()
+	if got := saveAndReload(t, tr).users[7].IngestToken; got != "secret" {
+		t.Errorf("token after reload = %q", got)
+	}
+}
+
+// mapShareKML renders a MapShare-style feed: a track line and one placemark
+// per point.
+func mapShareKML(points ...string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2"><Document><Folder>` + strings.Join(points, "") + `
+<Placemark><name>track</name><LineString><coordinates>6.1,45.1,1500 6.2,45.2,1600</coordinates></LineString></Placemark>
+</Folder></Document></kml>`
+}
+
+func mapSharePoint(at time.Time, lat, lon float64, emergency bool, text string) string {
+	sos := "False"
+	if emergency {
+		sos = "True"
+	}
+	return fmt.Sprintf(`<Placemark><TimeStamp><when>%s</when></TimeStamp><ExtendedData>
+<Data name="Elevation"><value>1423.28 m from MSL</value></Data>
+<Data name="Velocity"><value>18.0 km/h</value></Data>
+<Data name="Course"><value>90.00 ° True</value></Data>
+<Data name="Valid GPS Fix"><value>True</value></Data>
+<Data name="In Emergency"><value>%s</value></Data>
+<Data name="Text"><value>%s</value></Data>
+</ExtendedData><Point><coordinates>%f,%f,1423.28</coordinates></Point></Placemark>`,
+		at.UTC().Format(time.RFC3339), sos, text, lon, lat)
+}
+
+func TestParseFeed(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fixes, err := parseFeed([]byte(mapShareKML(
+		mapSharePoint(at.Add(time.Minute), 45.2, 6.2, true, "нужна помощь"),
+		mapSharePoint(at, 45.1, 6.1, false, ""),
+	)))
+	if err != nil || len(fixes) != 2 {
+		t.Fatalf("kml: %d fixes, err %v", len(fixes), err)
+	}
+	f := fixes[0]
+	if !f.At.Equal(at) || f.Lat != 45.1 || f.Lon != 6.1 || f.Alt != 1423.28 || !f.HasAlt || f.Speed != 18 || f.Course != 90 || f.Source != sourceInReach || f.Emergency {
+		t.Errorf("kml fix = %+v", f)
+	}
+	if !fixes[1].Emergency || fixes[1].Text != "нужна помощь" {
+		t.Errorf("kml sos = %+v", fixes[1])
+	}
+
+	// SPOT: a single message is an object, not an array.
+	fixes, err = parseFeed([]byte(`{"response":{"feedMessageResponse":{"messages":{"message":
+		{"unixTime":1717243200,"messageType":"HELP","latitude":46.5,"longitude":7.5,"altitude":0,"messageContent":"help"}}}}}`))
+	if err != nil || len(fixes) != 1 {
+		t.Fatalf("spot: %d fixes, err %v", len(fixes), err)
+	}
+	if f := fixes[0]; !f.Emergency || f.HasAlt || f.Source != sourceSpot || f.Lat != 46.5 || f.At.Unix() != 1717243200 {
+		t.Errorf("spot fix = %+v", f)
+	}
+	fixes, err = parseFeed([]byte(`{"response":{"errors":{"error":{"code":"E-0195","text":"No Messages to display"}}}}`))
+	if err != nil || len(fixes) != 0 {
+		t.Errorf("empty spot feed: %v, %v", fixes, err)
+	}
+	if _, err := parseFeed([]byte("Not found")); err == nil {
+		t.Error("junk parsed")
+	}
+
+	for in, want := range map[string]string{
+		"https://share.garmin.com/Pilot":            "https://share.garmin.com/Feed/Share/Pilot",
+		"https://share.garmin.com/Feed/Share/Pilot": "https://share.garmin.com/Feed/Share/Pilot",
+		"0onlLopfoM4bG5jXvWRE8H0Obd0oMxMBq":         "https://api.findmespot.com/spot-main-web/consumer/rest-api/2.0/public/feed/0onlLopfoM4bG5jXvWRE8H0Obd0oMxMBq/message.json",
+	} {
+		if got, err := normalizeFeedURL(in); err != nil || got != want {
+			t.Errorf("normalizeFeedURL(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := normalizeFeedURL("ftp://x"); err == nil {
+		t.Error("ftp accepted")
+	}
+	for _, in := range []string{
+		"http://127.0.0.1:8080/feed.json",
+		"http://localhost/Feed/Share/x",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/x",
+		"http://[::1]/x",
+		"http://[fd00::1]/x",
+	} {
+		if _, err := normalizeFeedURL(in); !errors.Is(err, errFeedPrivate) {
+			t.Errorf("normalizeFeedURL(%q) err = %v, want private address refused", in, err)
+		}
+	}
+}
+
+func TestFeedClientRefusesPrivate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer srv.Close()
+	// fetchFeed skips normalizeFeedURL; the dial itself must refuse.
+	if _, err := fetchFeed(context.Background(), srv.URL); !errors.Is(err, errFeedPrivate) {
+		t.Errorf("fetch of %s: err = %v", srv.URL, err)
+	}
+	for ip, want := range map[string]bool{
+		"8.8.8.8": true, "2a00:1450::1": true, "127.0.0.1": false, "192.168.1.1": false,
+		"100.64.1.1": false, "169.254.169.254": false, "::ffff:10.0.0.1": false, "0.0.0.0": false,
+	} {
+		if got := publicIP(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("publicIP(%s) = %v", ip, got)
+		}
+	}
+}
+
+func TestPollFeeds(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var points []string
+	var lastQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.json" {
+			http.NotFound(w, r)
+			return
+		}
+		lastQuery = r.URL.Query().Get("d1")
+		w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+		fmt.Fprint(w, mapShareKML(points...))
+	}))
+	defer srv.Close()
+	defaultClient := feedHTTPClient
+	feedHTTPClient = srv.Client()
+	defer func() { feedHTTPClient = defaultClient }()
+
+	tr := &Tracker{
+		users:   map[int64]*UserInfo{7: {UserID: 7, DisplayName: "Оля", FeedURL: srv.URL + "/Feed/Share/Olya"}},
+		session: &GroupSession{ChatID: -100, TrackingOn: true, Tracking: map[string]*TrackInfo{}},
+	}
+	// A stranger's feed adds nobody and announces nothing, SOS included.
+	tr.mu.Lock()
+	_, strangerAlerts, strangerAdded := tr.applyFeedLocked(tr.session, tr.users[7],
+		mustParseFeed(t, mapShareKML(mapSharePoint(now.Add(-time.Minute), 45.0, 6.0, true, "help"))), now)
+	tr.mu.Unlock()
+	if len(tr.session.Tracking) != 0 || len(strangerAlerts) != 0 || strangerAdded != "" {
+		t.Fatalf("uninvited feed: tracking %+v, alerts %+v", tr.session.Tracking, strangerAlerts)
+	}
+	delete(tr.feeds, 7)
+
+	// Once invited, an old SOS already in the feed is history; the fixes
+	// are applied.
+	tr.users[7].PendingGroup = -100
+	points = []string{
+		mapSharePoint(now.Add(-2*time.Hour), 45.0, 6.0, true, ""),
+		mapSharePoint(now.Add(-4*time.Minute), 45.1, 6.1, false, ""),
+	}
+	tr.pollFeedsOnce(context.Background())
+	if lastQuery == "" {
+		t.Error("MapShare poll without d1")
+	}
+	info := tr.session.Tracking[userKey(7)]
+	if info == nil || info.Source != sourceInReach || info.Position.Latitude != 45.1 || info.Position.Altitude != 1423.28 {
+		t.Fatalf("after first poll: %+v", tr.session.Tracking)
+	}
+	if st := tr.feeds[7]; st == nil || st.lastErr != "" || !st.lastFix.Equal(now.Add(-4*time.Minute)) {
+		t.Fatalf("feed state = %+v", st)
+	}
+
+	// A new SOS is alerted once.
+	points = append(points, mapSharePoint(now.Add(-time.Minute), 45.2, 6.2, true, "сломал ногу"))
+	tr.mu.Lock()
+	_, alerts, _ := tr.applyFeedLocked(tr.session, tr.users[7], mustParseFeed(t, mapShareKML(points...)), now)
+	_, again, _ := tr.applyFeedLocked(tr.session, tr.users[7], mustParseFeed(t, mapShareKML(points...)), now)
+	tr.mu.Unlock()
+	if len(alerts) != 1 || !alerts[0].emergency || alerts[0].name != "Оля" || len(again) != 0 {
+		t.Fatalf("alerts = %+v, again %+v", alerts, again)
+	}
+	if text := alerts[0].text(); !strings.Contains(text, "🆘") || !strings.Contains(text, "сломал ногу") || !strings.Contains(text, "45.20000") {
+		t.Errorf("alert text = %q", text)
+	}
+	if info.Position.Latitude != 45.2 {
+		t.Errorf("SOS fix not applied: %.1f", info.Position.Latitude)
+	}
+
+	// A failing feed is reported in the state.
+	tr.users[7].FeedURL = srv.URL + "/missing.json"
+	tr.pollFeedsOnce(context.Background())
+	if st := tr.feeds[7]; st.lastErr == "" {
+		t.Error("failed poll not recorded")
+	}
+
+	defer chdir(t, t.TempDir())()
+	if got := saveAndReload(t, tr).users[7].FeedURL; got != srv.URL+"/missing.json" {
+		t.Errorf("feed after reload = %q", got)
+	}
+}
+
+func mustParseFeed(t *testing.T, data string) []feedFix {
+	t.Helper()
+	fixes, err := parseFeed([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixes
+}
+
+func TestParseAPRSServers(t *testing.T) {
+	got, err := parseAPRSServers("aprs.glidernet.org, 192.168.1.10:14580 ,[::1]:10152")
+	want := []aprsSource{{"aprs.glidernet.org", 14580}, {"192.168.1.10", 14580}, {"::1", 10152}}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("parseAPRSServers = %v, %v", got, err)
+	}
+	if _, err := parseAPRSServers("host:port"); err == nil {
+		t.Error("bad port accepted")
+	}
+	c := aprsConfig{}.withDefaults()
+	if len(c.sources) != 1 || c.sources[0].addr() != "aprs.glidernet.org:14580" || c.callsign != "N0CALL" || c.passcode != "-1" {
+		t.Errorf("defaults = %+v", c)
+	}
+}
+
+func TestAPRSFeedFailover(t *testing.T) {
+	// The preferred source is down: a port nobody listens on.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().(*net.TCPAddr)
+	dead.Close()
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	loginCh := make(chan string, 1)
+	go func() {
+		conn, err := local.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		login, _ := bufio.NewReader(conn).ReadString('\n')
+		loginCh <- login
+		fmt.Fprint(conn, "# logresp CLUB pass -1 unverified, server LOCAL\r\n")
+		fmt.Fprint(conn, "FLRDDA5BA>OGFLR,qAS,Launch:/120000h4500.00N/00600.00E'090/020/A=001000\r\n")
+		time.Sleep(time.Second)
+	}()
+	localAddr := local.Addr().(*net.TCPAddr)
+
+	tr := &Tracker{aprsCfg: aprsConfig{
+		sources:  []aprsSource{{"127.0.0.1", deadAddr.Port}, {"127.0.0.1", localAddr.Port}},
+		callsign: "CLUB",
+	}}
+	feed := tr.newAPRSFeed("r/45/6/50")
+	var switched int
+	feed.onSwitch = func(aprsSource, int) { switched++ }
+	lines := make(chan string, 4)
+	runErr := make(chan error, 1)
+	go func() { runErr <- feed.Run(func(line string) { lines <- line }) }()
+
+	if login := <-loginCh; login != "user CLUB pass -1 vers telegram-ogn-tracker 1.0 filter r/45/6/50\n" {
+		t.Errorf("login = %q", login)
+	}
+	<-lines
+	if line := <-lines; !strings.HasPrefix(line, "FLRDDA5BA>") {
+		t.Errorf("beacon line = %q", line)
+	}
+	st := tr.aprsStatus.snapshot()
+	if !st.live || st.sourceIdx != 1 || st.server != "LOCAL" || !strings.Contains(st.lastErr, strconv.Itoa(deadAddr.Port)) {
+		t.Errorf("status = %+v", st)
+	}
+	text := formatAPRSStatus(feed.cfg, st, time.Now())
+	if !strings.Contains(text, "✅ "+localAddr.String()+" (резерв)") || !strings.Contains(text, "сервер LOCAL") {
+		t.Errorf("status text = %q", text)
+	}
+	if feed.probePreferred(1) {
+		t.Error("dead source probed as reachable")
+	}
+
+	_ = feed.Disconnect()
+	if err := <-runErr; err != nil {
+		t.Errorf("Run after Disconnect = %v", err)
+	}
+	if tr.aprsStatus.snapshot().live {
+		t.Error("still live after Disconnect")
+	}
+	// Starting on a backup is announced like a switch.
+	if switched != 1 {
+		t.Errorf("switched = %d", switched)
+	}
+	if tr.aprsStatus.connected(aprsSource{"127.0.0.1", localAddr.Port}, 1) {
+		t.Error("reconnect to the same backup announced again")
+	}
+	var fresh aprsStatus
+	if fresh.connected(aprsSource{"127.0.0.1", deadAddr.Port}, 0) {
+		t.Error("first link to the preferred source announced")
+	}
+}
+
+func TestFeedBackfillTrack(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var points []string
+	for i := 9; i >= 0; i-- {
+		points = append(points, mapSharePoint(now.Add(-time.Duration(i)*5*time.Minute-time.Minute), 45+float64(i)/100, 6, false, ""))
+	}
+	tr := &Tracker{
+		users:   map[int64]*UserInfo{7: {UserID: 7, DisplayName: "Оля", PendingGroup: -100}},
+		session: &GroupSession{ChatID: -100, TrackingOn: true, Tracking: map[string]*TrackInfo{}},
+	}
+	tr.mu.Lock()
+	evs, _, _ := tr.applyFeedLocked(tr.session, tr.users[7], mustParseFeed(t, mapShareKML(points...)), now)
+	tr.mu.Unlock()
+	info := tr.session.Tracking[userKey(7)]
+	if len(evs) != 10 || len(info.Track) != 10 {
+		t.Fatalf("applied %d fixes, track has %d points", len(evs), len(info.Track))
+	}
+	for i, p := range info.Track {
+		if want := now.Add(-time.Duration(9-i)*5*time.Minute - time.Minute); !p.Time.Equal(want) {
+			t.Errorf("track[%d] at %v, want %v", i, p.Time, want)
+		}
+	}
+	if newest := now.Add(-time.Minute); !info.LastUpdate.Equal(newest) || info.Position.Latitude != 45 {
+		t.Errorf("last update %v (want %v), lat %.2f", info.LastUpdate, newest, info.Position.Latitude)
+	}
+}
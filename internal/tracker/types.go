@@ -28,6 +28,9 @@ type TrackInfo struct {
 	LowSpeedSince    time.Time // start of the low-speed window used for landing detection
 	AutoDiscovered   bool      // discovered automatically via the area-tracking zone
 	OwnerUserID      int64     // Telegram user ID of the tracker's owner
+	// Devices are the pilot's other OGN IDs, besides the session key; their
+	// beacons update this entry too (see devices.go). Persisted.
+	Devices []string
 	// LastHeading caches the most recent non-zero course in degrees so the
 	// live-location arrow does not jump back to north when OGN reports
 	// Course=0 with non-zero speed (a known limitation of the data feed).
@@ -40,10 +43,17 @@ type TrackInfo struct {
 	// LabelStatus is the PilotStatus reflected by the label's emoji on the
 	// last edit. Used to skip Telegram round-trips when nothing has changed.
 	LabelStatus PilotStatus
+	// MessageSentAt is when the current live-location pin was posted; the
+	// pin is renewed before Telegram's 24h live period ends (see pins.go).
+	MessageSentAt time.Time
+	// RetiredMsgIDs are the label and pin replaced by a renewal, deleted
+	// once the new pin is up. Persisted so a restart neither duplicates the
+	// pin nor orphans the old messages.
+	RetiredMsgIDs []int
 	// LiveLocationDead is set after Telegram permanently refuses to edit the
 	// live-location pin (see isMessageGone). Once true, the ticker stops
-	// editing this pin to avoid log spam — and does not re-send a new pin,
-	// since the pilot already has earlier history in the chat.
+	// editing this pin to avoid log spam; a pilot who is still flying gets a
+	// fresh pin instead (pinNeedsRenewal).
 	LiveLocationDead bool
 	// LabelDead — same for the per-pilot text label (info.LabelMsgID).
 	LabelDead bool
@@ -52,6 +62,55 @@ type TrackInfo struct {
 	// Subsequent cycles skip the pin so the chat doesn't keep getting silent
 	// edits on a stationary message.
 	LandedFinalEditDone bool
+	// Track is the pilot's recorded flight path, sampled every
+	// trackSampleInterval. Used for XC scoring on landing. Runtime-only;
+	// a restart mid-flight scores only the part recorded afterwards.
+	Track []TrackPoint
+	// Launch is the pilot's first fix of the tracking session; distance
+	// milestones are measured from it.
+	Launch *Coordinates
+	// MilestonesHit records milestones already reached this session
+	// ("alt:3000", "dist:25", "pb") so each is announced once.
+	MilestonesHit map[string]bool
+	// Zones is the pilot's inside/outside state per zone name, for entry
+	// and exit announcements. Runtime-only.
+	Zones map[string]*zoneTrack
+	// Velocity is the recent beacon history the wind estimator works on,
+	// Wind the last estimate and Predicted the dead-reckoned position set by
+	// the update ticker between beacons. Runtime-only.
+	Velocity  []velocitySample
+	Wind      *Wind
+	Predicted *Prediction
+	// LastFixTime and LastFixScore describe the last accepted beacon (APRS
+	// timestamp and reception score) for the quality stage in quality.go;
+	// JumpStreak counts consecutive implausible fixes and Rejects the fixes
+	// dropped so far. Runtime-only.
+	LastFixTime  time.Time
+	LastFixScore float64
+	JumpStreak   int
+	Rejects      BeaconRejects
+	// Source is the device the last accepted fix came from (or a non-OGN
+	// source, see fixes.go) and LastFixAccuracy its GPS accuracy (m, 0 =
+	// unknown). AltitudeUnknown is set while the pilot has only had fixes
+	// without altitude. Runtime-only.
+	Source          string
+	LastFixAccuracy float64
+	AltitudeUnknown bool
+	// Smooth is the filtered altitude, vario and ground speed (see
+	// smooth.go) used by the dashboard, milestones and landing detector.
+	// Runtime-only.
+	Smooth *Smoothing
+	// Signal is the pilot's reception history for /signal (see signal.go).
+	// Runtime-only.
+	Signal *SignalStats
+}
+
+// TrackPoint is one recorded fix of a pilot's track.
+type TrackPoint struct {
+	Time time.Time
+	Lat  float64
+	Lon  float64
+	Alt  float64
 }
 
 // StatusEmoji returns an emoji reflecting the pilot's current state.
@@ -110,12 +169,35 @@ type GroupSession struct {
 	TrackAreaRadius int
 	Timezone        *time.Location
 	Drivers         map[int64]*DriverInfo
-	DashboardMsgID    int
+	DashboardMsgID  int
 	// DashboardPinned is true once PinChatMessage succeeded for the current
 	// DashboardMsgID. Persisted so a restart doesn't re-pin (and re-notify) an
 	// already-pinned message.
 	DashboardPinned bool
+	// Task is the competition task set via /task (nil when no race is on).
+	// Race holds per-pilot progress through it, keyed by short OGN ID. Both
+	// persisted so a restart mid-race keeps the standings.
+	Task *RaceTask
+	Race map[string]*RaceProgress
+	// Milestones is the chat's /milestones configuration; nil means the
+	// defaults. Persisted.
+	Milestones *MilestoneConfig
+	// Sunset is the chat's /sunset configuration (nil = defaults).
+	// SunsetNotified records which reminders already went out, keyed by
+	// local date and step ("2026-07-01:30", "2026-07-01:dusk"); only the
+	// current day is kept. Both persisted so a restart near sunset doesn't
+	// repeat them.
+	Sunset         *SunsetConfig
+	SunsetNotified map[string]bool
+	// Zones are the named geofences set with /zone. Persisted.
+	Zones []Zone
+	// Discovery is the chat's /discover rules (nil = defaults). Persisted.
+	Discovery *DiscoveryRules
+	// PredictOff disables dead-reckoned positions between beacons
+	// (/predict off). Persisted.
+	PredictOff bool
 	// Runtime (not persisted):
+	ZoneDraft      *zoneDraft // in-progress /zone add|draw|import
 	StopCh         chan struct{}
 	WaitingLanding bool
 	LandingExpiry  time.Time
@@ -136,12 +218,21 @@ type GroupSession struct {
 	// exactly once before auto-stop. Runtime only — a restart resets it, which
 	// is fine: if silence persists past the threshold, the warning re-fires.
 	InactivityWarnedAt time.Time
+	// Proximity holds per-pair state (together flag, alert cooldowns) keyed
+	// by pairKey. Runtime only — a restart at worst repeats one buddy alert.
+	Proximity map[string]*pairState
+	// Receivers is what each OGN ground station heard of the tracked pilots
+	// this tracking session, for the /signal coverage summary. Runtime only.
+	Receivers map[string]*ReceiverStats
 	// Radar mode (runtime only):
-	RadarOn            bool
-	RadarRadius        int // radar-specific radius (may differ from TrackAreaRadius)
-	RadarEntries       map[string]*RadarEntry
-	RadarMsgID         int
-	RadarStopCh        chan struct{}
+	RadarOn      bool
+	RadarRadius  int // radar-specific radius (may differ from TrackAreaRadius)
+	RadarEntries map[string]*RadarEntry
+	RadarMsgID   int
+	RadarStopCh  chan struct{}
+	// RadarOptions are the radar view filters and sort order (nil =
+	// defaults). Persisted, unlike the rest of radar mode.
+	RadarOptions       *RadarOptions
 	WaitingRadarRadius bool
 	RadarRadiusExpiry  time.Time
 }
@@ -156,10 +247,20 @@ func (s *GroupSession) tz() *time.Location {
 
 // UserInfo represents a known user across sessions.
 type UserInfo struct {
-	UserID       int64
-	Username     string
-	OGNID        string
+	UserID   int64
+	Username string
+	// OGNIDs are the user's OGN devices, primary (the session key) first.
+	OGNIDs       []string
 	DisplayName  string
 	DMChatID     int64
 	PendingGroup int64
+	// BuddyAlerts opts the user in to a DM when another tracked pilot is
+	// flying next to them (/buddy in DM).
+	BuddyAlerts bool
+	// IngestToken authenticates the user's phone tracker app on the HTTP
+	// ingest server (/token in DM, see ingest.go). Empty = none.
+	IngestToken string
+	// FeedURL is the user's inReach MapShare or SPOT feed, polled while
+	// tracking is on (/feed in DM, see satfeed.go). Empty = none.
+	FeedURL string
 }
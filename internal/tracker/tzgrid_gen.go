@@ -0,0 +1,331 @@
+//go:build ignore
+
+// tzgrid_gen rasterises timezone-boundary-builder's zone polygons into
+// tzgrid.bin, the grid guessTimezone looks up. The polygons come from
+// github.com/ringsaturn/tzf-rel-lite, which republishes each
+// timezone-boundary-builder release (with oceans) as simplified protobuf;
+// the module is fetched through the Go module proxy, so no extra dependency
+// ends up in go.mod.
+//
+//	go run tzgrid_gen.go [-version v0.0.2025-b2] [-res 40] [-out tzgrid.bin]
+//
+// tzgrid.bin is gzip over:
+//
+//	"tzgrid <cells per degree> <source version>\n"
+//	zone names, one per line, then an empty line
+//	per row, north to south: uvarint run count, then (uvarint zone index,
+//	uvarint run length) pairs covering all 360° of longitude
+//
+// Cells are sampled at their centre. Cells no polygon covers (gaps left by
+// the simplification) take the zone of the cell to their west.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+const srcModule = "github.com/ringsaturn/tzf-rel-lite"
+
+func main() {
+	version := flag.String("version", "v0.0.2025-b2", srcModule+" version")
+	res := flag.Int("res", 40, "grid cells per degree")
+	out := flag.String("out", "tzgrid.bin", "output file")
+	flag.Parse()
+
+	data, err := fetchSource(*version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	zones, srcVersion, err := decodeTimezones(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].name < zones[j].name })
+
+	grid := rasterise(zones, *res)
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	w := bufio.NewWriter(zw)
+	fmt.Fprintf(w, "tzgrid %d %s\n", *res, srcVersion)
+	for _, z := range zones {
+		fmt.Fprintln(w, z.name)
+	}
+	fmt.Fprintln(w)
+	runs := 0
+	for _, row := range grid {
+		r := encodeRow(row)
+		runs += len(r)
+		putUvarint(w, uint64(len(r)))
+		for _, rn := range r {
+			putUvarint(w, uint64(rn.zone))
+			putUvarint(w, uint64(rn.n))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%s: %d zones, %d runs, %d bytes (timezone-boundary-builder %s)",
+		*out, len(zones), runs, buf.Len(), srcVersion)
+}
+
+// fetchSource downloads the source module through the module proxy and
+// reads its polygon file.
+func fetchSource(version string) ([]byte, error) {
+	cmd := exec.Command("go", "mod", "download", "-json", srcModule+"@"+version)
+	cmd.Dir = os.TempDir() // outside this module, so go.mod stays untouched
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	cmd.Stderr = os.Stderr
+	raw, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go mod download: %w", err)
+	}
+	var mod struct{ Dir string }
+	if err := json.Unmarshal(raw, &mod); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(mod.Dir, "combined-with-oceans.reduce.bin"))
+}
+
+type point struct{ lon, lat float64 }
+
+type polygon struct {
+	rings [][]point // exterior first, then holes
+}
+
+type zone struct {
+	name  string
+	polys []polygon
+}
+
+// decodeTimezones decodes tzf's Timezones message (pb/tzf/v1/tzinfo.proto)
+// with a minimal protobuf wire reader.
+func decodeTimezones(b []byte) ([]zone, string, error) {
+	var zones []zone
+	version := ""
+	err := eachField(b, func(num int, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			z, err := decodeTimezone(v)
+			if err != nil {
+				return err
+			}
+			zones = append(zones, z)
+		case 3:
+			version = string(v)
+		}
+		return nil
+	})
+	return zones, version, err
+}
+
+func decodeTimezone(b []byte) (zone, error) {
+	var z zone
+	err := eachField(b, func(num int, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			var p polygon
+			if err := decodePolygon(v, &p); err != nil {
+				return err
+			}
+			z.polys = append(z.polys, p)
+		case 2:
+			z.name = string(v)
+		}
+		return nil
+	})
+	return z, err
+}
+
+// decodePolygon appends the exterior ring and then each hole's exterior;
+// tzf never nests holes further.
+func decodePolygon(b []byte, p *polygon) error {
+	var ring []point
+	var holes [][]point
+	err := eachField(b, func(num int, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			var pt point
+			err := eachField(v, func(num int, _ []byte, bits uint64) error {
+				f := float64(math.Float32frombits(uint32(bits)))
+				switch num {
+				case 1:
+					pt.lon = f
+				case 2:
+					pt.lat = f
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ring = append(ring, pt)
+		case 2:
+			var h polygon
+			if err := decodePolygon(v, &h); err != nil {
+				return err
+			}
+			if len(h.rings) > 0 {
+				holes = append(holes, h.rings[0])
+			}
+		}
+		return nil
+	})
+	p.rings = append(append(p.rings, ring), holes...)
+	return err
+}
+
+// eachField walks one message: length-delimited fields come as v, varint
+// and fixed32/64 fields as bits.
+func eachField(b []byte, fn func(num int, v []byte, bits uint64) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad field key")
+		}
+		b = b[n:]
+		num := int(key >> 3)
+		var v []byte
+		var bits uint64
+		switch key & 7 {
+		case 0:
+			bits, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("bad varint")
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errors.New("short fixed64")
+			}
+			bits, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.New("bad length")
+			}
+			v, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errors.New("short fixed32")
+			}
+			bits, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+		if err := fn(num, v, bits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rasterise scan-converts every polygon at cell-centre latitudes and
+// returns rows of zone index+1 (0 = uncovered), north to south.
+func rasterise(zones []zone, res int) [][]uint16 {
+	rows, cols := 180*res, 360*res
+	grid := make([][]uint16, rows)
+	for r := range grid {
+		grid[r] = make([]uint16, cols)
+	}
+	for zi, z := range zones {
+		for _, p := range z.polys {
+			// Crossings of every ring with each row's centre line; even-odd
+			// over exterior and holes together.
+			xs := map[int][]float64{}
+			for _, ring := range p.rings {
+				for i := range ring {
+					a, b := ring[i], ring[(i+1)%len(ring)]
+					if a.lat == b.lat {
+						continue
+					}
+					lo, hi := math.Min(a.lat, b.lat), math.Max(a.lat, b.lat)
+					// Rows whose centre lat c satisfies lo <= c < hi.
+					rFirst := int(math.Ceil((90-hi)*float64(res) - 0.5))
+					rLast := int(math.Floor((90-lo)*float64(res) - 0.5))
+					for r := max(rFirst, 0); r <= rLast && r < rows; r++ {
+						c := 90 - (float64(r)+0.5)/float64(res)
+						if c < lo || c >= hi {
+							continue
+						}
+						xs[r] = append(xs[r], a.lon+(c-a.lat)*(b.lon-a.lon)/(b.lat-a.lat))
+					}
+				}
+			}
+			for r, x := range xs {
+				sort.Float64s(x)
+				for i := 0; i+1 < len(x); i += 2 {
+					// Cells whose centre lies in [x[i], x[i+1]).
+					c0 := int(math.Ceil((x[i]+180)*float64(res) - 0.5))
+					c1 := int(math.Ceil((x[i+1]+180)*float64(res)-0.5)) - 1
+					for c := max(c0, 0); c <= c1 && c < cols; c++ {
+						grid[r][c] = uint16(zi + 1)
+					}
+				}
+			}
+		}
+	}
+	for _, row := range grid {
+		fillGaps(row)
+	}
+	return grid
+}
+
+// fillGaps gives uncovered cells the zone to their west, wrapping round the
+// antimeridian.
+func fillGaps(row []uint16) {
+	start := -1
+	for i, v := range row {
+		if v != 0 {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return
+	}
+	for k := 1; k < len(row); k++ {
+		i := (start + k) % len(row)
+		if row[i] == 0 {
+			row[i] = row[(i+len(row)-1)%len(row)]
+		}
+	}
+}
+
+type run struct{ zone, n int }
+
+func encodeRow(row []uint16) []run {
+	var out []run
+	for _, v := range row {
+		z := int(v) - 1
+		if len(out) > 0 && out[len(out)-1].zone == z {
+			out[len(out)-1].n++
+			continue
+		}
+		out = append(out, run{zone: z, n: 1})
+	}
+	return out
+}
+
+func putUvarint(w *bufio.Writer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], v)])
+}
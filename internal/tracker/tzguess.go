@@ -0,0 +1,168 @@
+package tracker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// tzGrid is tzgrid_gen.go's rasterisation of timezone-boundary-builder's
+// zone polygons (ODbL, via github.com/ringsaturn/tzf-rel-lite): 40 cells per
+// degree, about 3 km, sea included. Points within a cell of a border can
+// still land on the neighbour's side; the choice is announced so people can
+// correct it with /tz.
+//
+//go:generate go run tzgrid_gen.go
+//go:embed tzgrid.bin
+var tzGrid []byte
+
+// zoneGrid is the decoded tzgrid.bin.
+type zoneGrid struct {
+	res   int         // cells per degree
+	names []string    // IANA zones, indexed by zoneRun.zone
+	rows  [][]zoneRun // north to south
+}
+
+// zoneRun is a stretch of one row in one zone, ending before column end.
+type zoneRun struct {
+	end  uint32
+	zone uint16
+}
+
+var zoneGridOnce = sync.OnceValue(func() *zoneGrid {
+	g, err := parseZoneGrid(tzGrid)
+	if err != nil {
+		slog.Error("timezone grid unusable", "err", err)
+		return nil
+	}
+	return g
+})
+
+// parseZoneGrid decodes the format described in tzgrid_gen.go.
+func parseZoneGrid(data []byte) (*zoneGrid, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(zr)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	g := &zoneGrid{}
+	if _, err := fmt.Sscanf(header, "tzgrid %d", &g.res); err != nil || g.res <= 0 {
+		return nil, fmt.Errorf("bad header %q", strings.TrimSpace(header))
+	}
+	for {
+		name, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if name = strings.TrimSuffix(name, "\n"); name == "" {
+			break
+		}
+		g.names = append(g.names, name)
+	}
+	cols := uint64(360 * g.res)
+	g.rows = make([][]zoneRun, 180*g.res)
+	for i := range g.rows {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		row := make([]zoneRun, 0, n)
+		var end uint64
+		for range n {
+			z, err1 := binary.ReadUvarint(r)
+			l, err2 := binary.ReadUvarint(r)
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, fmt.Errorf("row %d: %w", i, err)
+			}
+			if end += l; z >= uint64(len(g.names)) || end > cols {
+				return nil, fmt.Errorf("row %d: bad run", i)
+			}
+			row = append(row, zoneRun{end: uint32(end), zone: uint16(z)})
+		}
+		if end != cols {
+			return nil, fmt.Errorf("row %d covers %d of %d columns", i, end, cols)
+		}
+		g.rows[i] = row
+	}
+	return g, nil
+}
+
+// lookup returns the zone of the cell containing (lat, lon).
+func (g *zoneGrid) lookup(lat, lon float64) string {
+	row := min(max(int((90-lat)*float64(g.res)), 0), len(g.rows)-1)
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	col := min(uint32(lon*float64(g.res)), uint32(360*g.res-1))
+	runs := g.rows[row]
+	i := sort.Search(len(runs), func(i int) bool { return runs[i].end > col })
+	return g.names[runs[i].zone]
+}
+
+// guessTimezone returns the IANA zone containing (lat, lon). Over open sea
+// that is one of the Etc/GMT±N nautical zones.
+func guessTimezone(lat, lon float64) (*time.Location, bool) {
+	g := zoneGridOnce()
+	if g == nil || math.IsNaN(lat) || math.IsNaN(lon) {
+		return nil, false
+	}
+	name := g.lookup(lat, lon)
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Warn("guessed timezone not loadable", "tz", name, "err", err)
+		return nil, false
+	}
+	return loc, true
+}
+
+// autoTimezone sets s.Timezone from the first known coordinates of the
+// session (landing, area centre or a pilot fix) unless a zone is already
+// set, either by /tz or by an earlier guess. Returns the announcement to
+// post, or "" when nothing changed. Caller must hold t.mu.
+func autoTimezone(s *GroupSession, lat, lon float64, now time.Time) string {
+	// 0,0 is what a tracker without a fix sometimes reports.
+	if s == nil || s.Timezone != nil || (lat == 0 && lon == 0) {
+		return ""
+	}
+	loc, ok := guessTimezone(lat, lon)
+	if !ok {
+		return ""
+	}
+	s.Timezone = loc
+	slog.Info("timezone inferred", "tz", loc.String(), "lat", lat, "lon", lon)
+	return fmt.Sprintf("🕒 Часовой пояс определён автоматически: %s (сейчас %s). Если неверно — /tz <зона>",
+		loc.String(), now.In(loc).Format("15:04"))
+}
+
+// announceTimezone posts the autoTimezone note to the group. Must be called
+// outside t.mu.
+func (t *Tracker) announceTimezone(chatID int64, text string) {
+	b := t.bot
+	if b == nil || text == "" {
+		return
+	}
+	if _, err := b.SendMessage(context.Background(), &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	}); err != nil {
+		slog.Error("failed to announce timezone", "err", err)
+	}
+}
@@ -31,10 +31,11 @@ var aircraftTypes = map[int]string{
 
 // shortID normalizes an OGN address to its last 6 hex characters.
 // OGN APRS uses full callsigns like "FLR123ABC", but for matching
-// we only need the 6-char device address suffix.
+// we only need the 6-char device address suffix. Keys of pilots without an
+// OGN device ("TG<user id>") are kept whole.
 func shortID(id string) string {
 	id = strings.ToUpper(strings.TrimSpace(id))
-	if len(id) <= 6 {
+	if len(id) <= 6 || isUserKey(id) {
 		return id
 	}
 	return id[len(id)-6:]
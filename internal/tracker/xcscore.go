@@ -0,0 +1,232 @@
+package tracker
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// trackSampleInterval is the minimum spacing between recorded fixes. OGN
+	// delivers the same aircraft every 1–4 s (more with several receivers); a
+	// 5 s grid keeps an eight-hour flight under ~6k points without losing any
+	// turnpoint that matters at XC scale.
+	trackSampleInterval = 5 * time.Second
+
+	// maxFreeScoringPoints / maxTriangleScoringPoints cap the input size of
+	// the optimisers. Free distance is O(n²), triangles are O(n³); the caps
+	// keep a single scoring run well under a second on a small VPS.
+	maxFreeScoringPoints     = 600
+	maxTriangleScoringPoints = 150
+
+	// XContest-style multipliers.
+	xcFreeMultiplier = 1.0
+	xcFlatMultiplier = 1.2
+	xcFAIMultiplier  = 1.4
+
+	// triangleClosingRatio — a triangle counts as closed when the gap between
+	// the flight's start and finish points is at most this share of the
+	// perimeter. The gap is subtracted from the scored distance.
+	triangleClosingRatio = 0.2
+	// faiMinLegRatio — FAI triangles need every leg to be at least 28% of
+	// the perimeter.
+	faiMinLegRatio = 0.28
+)
+
+// xcKind identifies the scoring discipline of a flight.
+type xcKind int
+
+const (
+	xcNone xcKind = iota
+	xcFree
+	xcFlat
+	xcFAI
+)
+
+// String returns the Russian label used in chat messages.
+func (k xcKind) String() string {
+	switch k {
+	case xcFree:
+		return "свободная дистанция"
+	case xcFlat:
+		return "плоский треугольник"
+	case xcFAI:
+		return "FAI треугольник"
+	default:
+		return "—"
+	}
+}
+
+// xcScore is the result of one scoring discipline.
+type xcScore struct {
+	Kind   xcKind
+	DistKm float64 // scored distance (triangles: perimeter minus closing gap)
+	Points float64 // DistKm × multiplier
+}
+
+// xcResult bundles all disciplines for a flight; Best is the highest-scoring
+// one and is what the leaderboard uses.
+type xcResult struct {
+	Free xcScore
+	Flat xcScore
+	FAI  xcScore
+	Best xcScore
+}
+
+// formatXCScore renders a short one-line summary for chat messages, e.g.
+// "42.3 км · FAI треугольник · 59.2 балла". Returns "" for an empty result.
+func formatXCScore(s xcScore) string {
+	if s.Kind == xcNone || s.Points <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f км · %s · %.1f балла", s.DistKm, s.Kind, s.Points)
+}
+
+// recordTrackPoint appends the fix to info.Track unless it is closer than
+// trackSampleInterval to the previous one. Caller must hold the mutex
+// protecting info.
+func recordTrackPoint(info *TrackInfo, lat, lon, alt float64, at time.Time) {
+	if n := len(info.Track); n > 0 && at.Sub(info.Track[n-1].Time) < trackSampleInterval {
+		return
+	}
+	info.Track = append(info.Track, TrackPoint{Time: at, Lat: lat, Lon: lon, Alt: alt})
+}
+
+// thinTrack returns at most limit points picked at an even index stride. The
+// first and last fixes are always kept so take-off and landing stay exact.
+func thinTrack(track []TrackPoint, limit int) []TrackPoint {
+	if len(track) <= limit || limit < 2 {
+		return track
+	}
+	out := make([]TrackPoint, 0, limit)
+	step := float64(len(track)-1) / float64(limit-1)
+	for i := 0; i < limit; i++ {
+		out = append(out, track[int(float64(i)*step+0.5)])
+	}
+	return out
+}
+
+// pointDistKm is distanceAndBearing without the bearing.
+func pointDistKm(a, b TrackPoint) float64 {
+	d, _ := distanceAndBearing(a.Lat, a.Lon, b.Lat, b.Lon)
+	return d
+}
+
+// scoreFlight computes free distance, flat triangle and FAI triangle scores
+// for a recorded track and picks the best one. Pure function.
+func scoreFlight(track []TrackPoint) xcResult {
+	var r xcResult
+	if len(track) < 2 {
+		return r
+	}
+	if d := bestFreeDistance(thinTrack(track, maxFreeScoringPoints)); d > 0 {
+		r.Free = xcScore{Kind: xcFree, DistKm: d, Points: d * xcFreeMultiplier}
+	}
+	flat, fai := bestTriangles(thinTrack(track, maxTriangleScoringPoints))
+	if flat > 0 {
+		r.Flat = xcScore{Kind: xcFlat, DistKm: flat, Points: flat * xcFlatMultiplier}
+	}
+	if fai > 0 {
+		r.FAI = xcScore{Kind: xcFAI, DistKm: fai, Points: fai * xcFAIMultiplier}
+	}
+	for _, s := range []xcScore{r.Free, r.Flat, r.FAI} {
+		if s.Points > r.Best.Points {
+			r.Best = s
+		}
+	}
+	return r
+}
+
+// bestFreeDistance returns the longest path start → up to three turnpoints →
+// finish through the track, visiting fixes in time order. Dynamic programming
+// over "legs used so far": best[l][j] is the longest path with l legs ending
+// at fix j. Skipping a turnpoint is the same as placing it on a neighbour, so
+// four legs cover every 0–3 turnpoint route.
+func bestFreeDistance(track []TrackPoint) float64 {
+	n := len(track)
+	if n < 2 {
+		return 0
+	}
+	const legs = 4
+	prev := make([]float64, n) // zero legs: path of length 0 ending anywhere
+	cur := make([]float64, n)
+	for l := 1; l <= legs; l++ {
+		for j := 0; j < n; j++ {
+			best := 0.0
+			for i := 0; i <= j; i++ {
+				if v := prev[i] + pointDistKm(track[i], track[j]); v > best {
+					best = v
+				}
+			}
+			cur[j] = best
+		}
+		prev, cur = cur, prev
+	}
+	best := 0.0
+	for _, v := range prev {
+		if v > best {
+			best = v
+		}
+	}
+	return best
+}
+
+// bestTriangles searches every ordered vertex triple i < j < k and returns
+// the best scored distance for a flat and for an FAI triangle (0 when none
+// closes). The closing gap for a triple is the smallest distance between any
+// fix at or before i and any fix at or after k; gap[i][k] is precomputed so
+// the search stays O(n³).
+func bestTriangles(track []TrackPoint) (flat, fai float64) {
+	n := len(track)
+	if n < 3 {
+		return 0, 0
+	}
+	dist := make([][]float64, n)
+	for i := range dist {
+		dist[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := pointDistKm(track[i], track[j])
+			dist[i][j] = d
+			dist[j][i] = d
+		}
+	}
+	// gap[i][k] = min over a ≤ i, b ≥ k of dist[a][b].
+	gap := make([][]float64, n)
+	for i := range gap {
+		gap[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for k := n - 1; k >= i; k-- {
+			g := dist[i][k]
+			if i > 0 && gap[i-1][k] < g {
+				g = gap[i-1][k]
+			}
+			if k < n-1 && gap[i][k+1] < g {
+				g = gap[i][k+1]
+			}
+			gap[i][k] = g
+		}
+	}
+	for i := 0; i < n; i++ {
+		for k := i + 2; k < n; k++ {
+			closing := gap[i][k]
+			for j := i + 1; j < k; j++ {
+				a, b, c := dist[i][j], dist[j][k], dist[k][i]
+				p := a + b + c
+				if p <= 0 || closing > triangleClosingRatio*p {
+					continue
+				}
+				scored := p - closing
+				if scored > flat {
+					flat = scored
+				}
+				shortest := min(a, b, c)
+				if shortest >= faiMinLegRatio*p && scored > fai {
+					fai = scored
+				}
+			}
+		}
+	}
+	return flat, fai
+}
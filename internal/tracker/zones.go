@@ -0,0 +1,730 @@
+package tracker
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ogn/client"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// maxZones bounds the zones per session; each discover zone adds a term
+	// to the APRS filter.
+	maxZones = 20
+	// maxZonePoints bounds polygon size, both drawn and imported.
+	maxZonePoints = 2000
+	// maxZoneFileBytes caps KML/GeoJSON downloads.
+	maxZoneFileBytes = 5 << 20
+	// zoneConfirmFixes — a pilot has to be seen on the new side of a zone
+	// edge this many fixes in a row before entry/exit is announced, so GPS
+	// jitter along the boundary doesn't flap.
+	zoneConfirmFixes = 2
+	// Timeouts for the interactive zone flows.
+	zoneCenterTimeout = 2 * time.Minute
+	zoneDrawTimeout   = 10 * time.Minute
+	zoneImportTimeout = 5 * time.Minute
+)
+
+// ZoneRole says what a zone is for.
+type ZoneRole string
+
+const (
+	// ZoneDiscover — aircraft inside are auto-discovered, like /area.
+	ZoneDiscover ZoneRole = "discover"
+	// ZoneBoundary — the flying site; leaving it means a retrieve.
+	ZoneBoundary ZoneRole = "boundary"
+	// ZoneNoFly — airspace pilots must stay out of.
+	ZoneNoFly ZoneRole = "nofly"
+)
+
+// parseZoneRole accepts the role keywords used by /zone.
+func parseZoneRole(s string) (ZoneRole, bool) {
+	switch strings.ToLower(s) {
+	case "discover", "auto":
+		return ZoneDiscover, true
+	case "boundary", "retrieve":
+		return ZoneBoundary, true
+	case "nofly", "no-fly":
+		return ZoneNoFly, true
+	}
+	return "", false
+}
+
+// label is the user-facing name of a role.
+func (r ZoneRole) label() string {
+	switch r {
+	case ZoneDiscover:
+		return "авто-поиск"
+	case ZoneBoundary:
+		return "граница (вылет = ретрив)"
+	case ZoneNoFly:
+		return "запретная"
+	}
+	return string(r)
+}
+
+// Zone is a named geofence: a circle (Center + RadiusKm) or a polygon.
+// Persisted with the session.
+type Zone struct {
+	Name     string        `json:"name"`
+	Role     ZoneRole      `json:"role"`
+	Center   *Coordinates  `json:"center,omitempty"`
+	RadiusKm float64       `json:"radius_km,omitempty"`
+	Polygon  []Coordinates `json:"polygon,omitempty"`
+}
+
+// contains reports whether (lat, lon) is inside the zone.
+func (z *Zone) contains(lat, lon float64) bool {
+	if z.Center != nil {
+		d, _ := distanceAndBearing(z.Center.Latitude, z.Center.Longitude, lat, lon)
+		return d <= z.RadiusKm
+	}
+	return pointInPolygon(z.Polygon, lat, lon)
+}
+
+// pointInPolygon is the even-odd ray casting test on raw lat/lon, which is
+// fine for the few-km polygons a flying site uses.
+func pointInPolygon(poly []Coordinates, lat, lon float64) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Latitude > lat) != (b.Latitude > lat) &&
+			lon < (b.Longitude-a.Longitude)*(lat-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			in = !in
+		}
+	}
+	return in
+}
+
+// filterTerm returns the APRS server-side filter that covers the zone: a
+// range filter for circles, the bounding box for polygons.
+func (z *Zone) filterTerm() string {
+	if z.Center != nil {
+		return client.RangeFilter(z.Center.Latitude, z.Center.Longitude, int(math.Ceil(z.RadiusKm)))
+	}
+	if len(z.Polygon) == 0 {
+		return ""
+	}
+	n, s := -90.0, 90.0
+	w, e := 180.0, -180.0
+	for _, p := range z.Polygon {
+		n, s = math.Max(n, p.Latitude), math.Min(s, p.Latitude)
+		e, w = math.Max(e, p.Longitude), math.Min(w, p.Longitude)
+	}
+	return client.AreaFilter(n, w, s, e)
+}
+
+// describe renders one /zone list line.
+func (z *Zone) describe() string {
+	shape := fmt.Sprintf("полигон, %d точек", len(z.Polygon))
+	if z.Center != nil {
+		shape = fmt.Sprintf("круг %.1fкм", z.RadiusKm)
+	}
+	return fmt.Sprintf("«%s» — %s, %s", z.Name, z.Role.label(), shape)
+}
+
+// validate checks a zone before it's stored.
+func (z *Zone) validate() error {
+	if z.Name == "" {
+		return errors.New("у зоны нет имени")
+	}
+	if z.Center != nil {
+		if z.RadiusKm <= 0 || z.RadiusKm > maxAreaRadius {
+			return fmt.Errorf("радиус должен быть от 0 до %d км", maxAreaRadius)
+		}
+		return nil
+	}
+	if len(z.Polygon) < 3 {
+		return errors.New("в полигоне нужно хотя бы 3 точки")
+	}
+	if len(z.Polygon) > maxZonePoints {
+		return fmt.Errorf("в полигоне больше %d точек", maxZonePoints)
+	}
+	return nil
+}
+
+// zoneIndex returns the index of the zone with the given name
+// (case-insensitive), or -1.
+func zoneIndex(zones []Zone, name string) int {
+	for i := range zones {
+		if strings.EqualFold(zones[i].Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// addZonesLocked stores zones on the session, replacing same-named ones, and
+// refreshes the APRS filter. Caller must hold t.mu.
+func (t *Tracker) addZonesLocked(s *GroupSession, zones []Zone) error {
+	for i := range zones {
+		if err := zones[i].validate(); err != nil {
+			return fmt.Errorf("«%s»: %w", zones[i].Name, err)
+		}
+	}
+	added := 0
+	for _, z := range zones {
+		if zoneIndex(s.Zones, z.Name) < 0 {
+			added++
+		}
+	}
+	if len(s.Zones)+added > maxZones {
+		return fmt.Errorf("не больше %d зон на сессию", maxZones)
+	}
+	for _, z := range zones {
+		if i := zoneIndex(s.Zones, z.Name); i >= 0 {
+			s.Zones[i] = z
+		} else {
+			s.Zones = append(s.Zones, z)
+		}
+		slog.Info("zone set", "name", z.Name, "role", z.Role, "circle", z.Center != nil, "points", len(z.Polygon))
+	}
+	t.updateFilter()
+	t.saveState()
+	return nil
+}
+
+// discoveryOn reports whether anything on the session auto-discovers
+// aircraft: the /area circle or a discover zone.
+func (s *GroupSession) discoveryOn() bool {
+	if s.TrackArea != nil {
+		return true
+	}
+	for i := range s.Zones {
+		if s.Zones[i].Role == ZoneDiscover {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverable reports whether an untracked beacon at (lat, lon) should be
+// auto-discovered: inside the /area circle or a discover zone.
+func (s *GroupSession) discoverable(lat, lon float64) bool {
+	if s.TrackArea != nil {
+		d, _ := distanceAndBearing(s.TrackArea.Latitude, s.TrackArea.Longitude, lat, lon)
+		// The server-side range filter and CheapRuler disagree slightly at the
+		// edge; don't drop beacons the server already decided were inside.
+		if d <= float64(s.TrackAreaRadius)*1.02 {
+			return true
+		}
+	}
+	for i := range s.Zones {
+		if s.Zones[i].Role == ZoneDiscover && s.Zones[i].contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneTrack is a pilot's runtime position relative to one zone.
+type zoneTrack struct {
+	Inside  bool
+	Pending int // consecutive fixes on the other side of the edge
+}
+
+// zoneEventText renders an entry/exit announcement.
+func zoneEventText(z *Zone, label string, entered bool) string {
+	switch {
+	case z.Role == ZoneNoFly && entered:
+		return fmt.Sprintf("⛔ %s в запретной зоне «%s»!", label, z.Name)
+	case z.Role == ZoneNoFly:
+		return fmt.Sprintf("✅ %s покинул запретную зону «%s»", label, z.Name)
+	case z.Role == ZoneBoundary && entered:
+		return fmt.Sprintf("↩️ %s вернулся в «%s»", label, z.Name)
+	case z.Role == ZoneBoundary:
+		return fmt.Sprintf("🚨 %s вылетел за границу «%s» — готовьте ретрив", label, z.Name)
+	case entered:
+		return fmt.Sprintf("📍 %s вошёл в зону «%s»", label, z.Name)
+	default:
+		return fmt.Sprintf("📍 %s вышел из зоны «%s»", label, z.Name)
+	}
+}
+
+// zoneEventsLocked updates the pilot's inside/outside state for every zone
+// and returns the entry/exit announcements due. The first fix only records
+// the state, except that starting inside a no-fly zone is reported right
+// away. Caller must hold t.mu.
+func zoneEventsLocked(s *GroupSession, id string, info *TrackInfo, lat, lon float64) []string {
+	if len(s.Zones) == 0 || info.AutoDiscovered {
+		return nil
+	}
+	if info.Zones == nil {
+		info.Zones = make(map[string]*zoneTrack)
+	}
+	label := info.DisplayName()
+	if label == "" {
+		label = id
+	}
+	var events []string
+	for i := range s.Zones {
+		z := &s.Zones[i]
+		in := z.contains(lat, lon)
+		st, ok := info.Zones[z.Name]
+		if !ok {
+			info.Zones[z.Name] = &zoneTrack{Inside: in}
+			if in && z.Role == ZoneNoFly {
+				events = append(events, zoneEventText(z, label, true))
+			}
+			continue
+		}
+		if in == st.Inside {
+			st.Pending = 0
+			continue
+		}
+		st.Pending++
+		if st.Pending < zoneConfirmFixes {
+			continue
+		}
+		st.Inside, st.Pending = in, 0
+		events = append(events, zoneEventText(z, label, in))
+		slog.Info("zone crossing", "id", id, "zone", z.Name, "role", z.Role, "inside", in)
+	}
+	return events
+}
+
+// sendZoneEvents posts entry/exit announcements. Must be called outside t.mu.
+func (t *Tracker) sendZoneEvents(events []string, chatID int64) {
+	b := t.bot
+	if b == nil {
+		return
+	}
+	for _, text := range events {
+		if _, err := b.SendMessage(context.Background(), &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   text,
+		}); err != nil {
+			slog.Error("failed to send zone event", "err", err)
+		}
+	}
+}
+
+// zoneDraft is an in-progress /zone flow: waiting for a circle centre, a
+// series of polygon points, or an uploaded file. Runtime only.
+type zoneDraft struct {
+	userID    int64
+	name      string
+	role      ZoneRole
+	radiusKm  float64 // >0: circle waiting for its centre
+	points    []Coordinates
+	importing bool
+	expiry    time.Time
+}
+
+// zoneDraftTimeout drops an abandoned zone draft and sweeps its prompt. The
+// draft pointer is the identity: a newer /zone flow owns its own timer.
+// Polygon drafts extend their expiry with every point, so the timer re-arms.
+func (t *Tracker) zoneDraftTimeout(d *zoneDraft, chatID int64) {
+	for {
+		t.mu.Lock()
+		if t.session == nil || t.session.ZoneDraft != d {
+			t.mu.Unlock()
+			return
+		}
+		wait := time.Until(d.expiry)
+		if wait <= 0 {
+			t.session.ZoneDraft = nil
+			t.mu.Unlock()
+			slog.Info("zone draft timed out", "user_id", d.userID, "name", d.name)
+			t.finalizePendingCleanup(d.userID, chatID)
+			return
+		}
+		t.mu.Unlock()
+		time.Sleep(wait + time.Second)
+	}
+}
+
+// handleZoneLocation consumes a location pin for the sender's zone draft.
+// Returns false when there is no matching draft. Caller must hold t.mu;
+// the lock is released before returning true.
+func (t *Tracker) handleZoneLocation(ctx context.Context, b *bot.Bot, m *models.Message) bool {
+	s := t.session
+	d := s.ZoneDraft
+	if d == nil || d.userID != m.From.ID || d.importing || !time.Now().Before(d.expiry) {
+		return false
+	}
+	loc := &Coordinates{Latitude: m.Location.Latitude, Longitude: m.Location.Longitude}
+
+	if d.radiusKm > 0 {
+		s.ZoneDraft = nil
+		z := Zone{Name: d.name, Role: d.role, Center: loc, RadiusKm: d.radiusKm}
+		err := t.addZonesLocked(s, []Zone{z})
+		t.mu.Unlock()
+		text := "🗺 Зона сохранена: " + z.describe()
+		if err != nil {
+			text = "Не удалось сохранить зону: " + err.Error()
+		}
+		ackID := t.sendAck(ctx, &bot.SendMessageParams{ChatID: m.Chat.ID, Text: text}, "failed to confirm zone")
+		t.finalizePendingCleanup(m.From.ID, m.Chat.ID, ackID)
+		t.refreshDashboard(ctx, m.Chat.ID)
+		return true
+	}
+
+	if len(d.points) >= maxZonePoints {
+		t.mu.Unlock()
+		t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+			ChatID: m.Chat.ID,
+			Text:   fmt.Sprintf("В полигоне уже %d точек. /zone done — сохранить", maxZonePoints),
+		}, "failed to send zone point limit")
+		return true
+	}
+	d.points = append(d.points, *loc)
+	d.expiry = time.Now().Add(zoneDrawTimeout)
+	n := len(d.points)
+	t.mu.Unlock()
+	t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+		ChatID: m.Chat.ID,
+		Text:   fmt.Sprintf("📍 Точка %d принята. Ещё точки или /zone done", n),
+	}, "failed to ack zone point")
+	return true
+}
+
+// handleZoneDocument imports zones from a KML, KMZ or GeoJSON file sent to
+// the group, either with a "/zone import <role>" caption or after that
+// command. Non-zone documents are ignored.
+func (t *Tracker) handleZoneDocument(ctx context.Context, b *bot.Bot, m *models.Message) {
+	t.mu.Lock()
+	s := t.session
+	if s == nil || s.ChatID != m.Chat.ID {
+		t.mu.Unlock()
+		return
+	}
+	role := ZoneRole("")
+	if args := strings.Fields(commandArgs(m.Caption)); strings.HasPrefix(m.Caption, "/zone") {
+		if len(args) > 0 && args[0] == "import" {
+			args = args[1:]
+		}
+		role = ZoneDiscover
+		if len(args) > 0 {
+			r, ok := parseZoneRole(args[0])
+			if !ok {
+				t.mu.Unlock()
+				t.scheduleAck(ctx, m.Chat.ID, m.ID, &bot.SendMessageParams{
+					ChatID: m.Chat.ID,
+					Text:   "Роль зоны: discover, boundary или nofly",
+				}, "failed to send zone role error")
+				return
+			}
+			role = r
+		}
+	} else if d := s.ZoneDraft; d != nil && d.importing && d.userID == m.From.ID && time.Now().Before(d.expiry) {
+		role = d.role
+		s.ZoneDraft = nil
+	}
+	t.mu.Unlock()
+	if role == "" {
+		return
+	}
+
+	zones, err := t.downloadZones(ctx, b, m.Document)
+	if err == nil {
+		for i := range zones {
+			zones[i].Role = role
+		}
+		t.mu.Lock()
+		if t.session == nil {
+			err = errors.New("нет активной сессии")
+		} else {
+			err = t.addZonesLocked(t.session, zones)
+		}
+		t.mu.Unlock()
+	}
+	text := ""
+	if err != nil {
+		slog.Warn("zone import failed", "file", m.Document.FileName, "err", err)
+		text = "Не удалось импортировать зоны: " + err.Error()
+	} else {
+		lines := make([]string, len(zones))
+		for i := range zones {
+			lines[i] = "• " + zones[i].describe()
+		}
+		text = fmt.Sprintf("🗺 Импортировано зон: %d\n%s", len(zones), strings.Join(lines, "\n"))
+	}
+	ackID := t.sendAck(ctx, &bot.SendMessageParams{ChatID: m.Chat.ID, Text: text}, "failed to confirm zone import")
+	t.finalizePendingCleanup(m.From.ID, m.Chat.ID, ackID)
+	t.refreshDashboard(ctx, m.Chat.ID)
+}
+
+// downloadZones fetches a Telegram document and parses it as zones.
+func (t *Tracker) downloadZones(ctx context.Context, b *bot.Bot, doc *models.Document) ([]Zone, error) {
+	if doc.FileSize > maxZoneFileBytes {
+		return nil, errors.New("файл слишком большой")
+	}
+	f, err := b.GetFile(ctx, &bot.GetFileParams{FileID: doc.FileID})
+	if err != nil {
+		return nil, fmt.Errorf("getFile: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(f), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxZoneFileBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	if len(data) > maxZoneFileBytes {
+		return nil, errors.New("файл слишком большой")
+	}
+	return parseZoneFile(doc.FileName, data)
+}
+
+// parseZoneFile parses KML, KMZ or GeoJSON, chosen by extension or, failing
+// that, by sniffing the content. Returned zones have no role set.
+func parseZoneFile(name string, data []byte) ([]Zone, error) {
+	var zones []Zone
+	var err error
+	ext := strings.ToLower(filepath.Ext(name))
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case ext == ".kmz" || bytes.HasPrefix(data, []byte("PK")):
+		zones, err = parseKMZ(data)
+	case ext == ".kml" || bytes.HasPrefix(trimmed, []byte("<")):
+		zones, err = parseKML(data)
+	case ext == ".geojson" || ext == ".json" || bytes.HasPrefix(trimmed, []byte("{")):
+		zones, err = parseGeoJSON(data)
+	default:
+		return nil, errors.New("нужен файл .kml, .kmz или .geojson")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, errors.New("в файле нет полигонов или кругов")
+	}
+	// Unnamed features get numbered names; duplicates get a suffix so they
+	// don't overwrite each other.
+	seen := make(map[string]int)
+	for i := range zones {
+		if zones[i].Name == "" {
+			zones[i].Name = fmt.Sprintf("Зона %d", i+1)
+		}
+		key := strings.ToLower(zones[i].Name)
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			zones[i].Name += " " + strconv.Itoa(n)
+		}
+	}
+	return zones, nil
+}
+
+// kmlPlacemark is the subset of KML the importer reads. Polygons may sit
+// directly in the Placemark or inside a MultiGeometry.
+type kmlPlacemark struct {
+	Name     string       `xml:"name"`
+	Polygons []kmlPolygon `xml:"Polygon"`
+	Multi    []kmlPolygon `xml:"MultiGeometry>Polygon"`
+}
+
+type kmlPolygon struct {
+	Outer string `xml:"outerBoundaryIs>LinearRing>coordinates"`
+}
+
+// parseKML extracts polygon placemarks. Each polygon becomes one zone.
+func parseKML(data []byte) ([]Zone, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var zones []Zone
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("KML: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "Placemark" {
+			continue
+		}
+		var pm kmlPlacemark
+		if err := dec.DecodeElement(&pm, &se); err != nil {
+			return nil, fmt.Errorf("KML: %w", err)
+		}
+		polys := append(pm.Polygons, pm.Multi...)
+		for i, p := range polys {
+			ring, err := parseKMLCoordinates(p.Outer)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.TrimSpace(pm.Name)
+			if len(polys) > 1 && name != "" {
+				name += " " + strconv.Itoa(i+1)
+			}
+			zones = append(zones, Zone{Name: name, Polygon: ring})
+		}
+	}
+	return zones, nil
+}
+
+// parseKMLCoordinates parses "lon,lat[,alt] lon,lat[,alt] …" and drops the
+// closing point that repeats the first.
+func parseKMLCoordinates(s string) ([]Coordinates, error) {
+	var ring []Coordinates
+	for _, tuple := range strings.Fields(s) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("KML: bad coordinate %q", tuple)
+		}
+		lon, err1 := strconv.ParseFloat(parts[0], 64)
+		lat, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("KML: bad coordinate %q", tuple)
+		}
+		ring = append(ring, Coordinates{Latitude: lat, Longitude: lon})
+	}
+	return closeRing(ring), nil
+}
+
+// closeRing drops an explicit closing vertex equal to the first one.
+func closeRing(ring []Coordinates) []Coordinates {
+	if n := len(ring); n > 1 && ring[0] == ring[n-1] {
+		return ring[:n-1]
+	}
+	return ring
+}
+
+// parseKMZ unpacks the first .kml file from a KMZ archive.
+func parseKMZ(data []byte) ([]Zone, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("KMZ: %w", err)
+	}
+	for _, f := range zr.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".kml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("KMZ: %w", err)
+		}
+		kml, err := io.ReadAll(io.LimitReader(rc, maxZoneFileBytes))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("KMZ: %w", err)
+		}
+		return parseKML(kml)
+	}
+	return nil, errors.New("KMZ: внутри нет .kml")
+}
+
+// geoJSON covers FeatureCollection, Feature and bare geometry objects.
+type geoJSON struct {
+	Type       string          `json:"type"`
+	Features   []geoJSON       `json:"features"`
+	Geometry   *geoJSON        `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+	Coords     json.RawMessage `json:"coordinates"`
+}
+
+// parseGeoJSON extracts Polygon and MultiPolygon features (outer rings), and
+// Point features with a "radius" property in metres as circles.
+func parseGeoJSON(data []byte) ([]Zone, error) {
+	var root geoJSON
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("GeoJSON: %w", err)
+	}
+	var zones []Zone
+	var walk func(g *geoJSON, props map[string]any) error
+	walk = func(g *geoJSON, props map[string]any) error {
+		name, _ := props["name"].(string)
+		switch g.Type {
+		case "FeatureCollection":
+			for i := range g.Features {
+				if err := walk(&g.Features[i], g.Features[i].Properties); err != nil {
+					return err
+				}
+			}
+		case "Feature":
+			if g.Geometry != nil {
+				return walk(g.Geometry, g.Properties)
+			}
+		case "Polygon":
+			var rings [][][]float64
+			if err := json.Unmarshal(g.Coords, &rings); err != nil {
+				return fmt.Errorf("GeoJSON polygon: %w", err)
+			}
+			if len(rings) > 0 {
+				zones = append(zones, Zone{Name: name, Polygon: geoJSONRing(rings[0])})
+			}
+		case "MultiPolygon":
+			var polys [][][][]float64
+			if err := json.Unmarshal(g.Coords, &polys); err != nil {
+				return fmt.Errorf("GeoJSON multipolygon: %w", err)
+			}
+			for i, rings := range polys {
+				if len(rings) == 0 {
+					continue
+				}
+				n := name
+				if n != "" && len(polys) > 1 {
+					n += " " + strconv.Itoa(i+1)
+				}
+				zones = append(zones, Zone{Name: n, Polygon: geoJSONRing(rings[0])})
+			}
+		case "Point":
+			radius, _ := props["radius"].(float64)
+			if radius <= 0 {
+				return nil
+			}
+			var pt []float64
+			if err := json.Unmarshal(g.Coords, &pt); err != nil || len(pt) < 2 {
+				return fmt.Errorf("GeoJSON point: bad coordinates")
+			}
+			zones = append(zones, Zone{Name: name, Center: &Coordinates{Latitude: pt[1], Longitude: pt[0]}, RadiusKm: radius / 1000})
+		}
+		return nil
+	}
+	if err := walk(&root, root.Properties); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// geoJSONRing converts [lon, lat] pairs.
+func geoJSONRing(pts [][]float64) []Coordinates {
+	ring := make([]Coordinates, 0, len(pts))
+	for _, p := range pts {
+		if len(p) >= 2 {
+			ring = append(ring, Coordinates{Latitude: p[1], Longitude: p[0]})
+		}
+	}
+	return closeRing(ring)
+}
+
+// describeZones renders the /zone list.
+func describeZones(zones []Zone) string {
+	if len(zones) == 0 {
+		return "🗺 Зон нет.\n" + zoneUsage
+	}
+	sorted := append([]Zone(nil), zones...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Role < sorted[j].Role })
+	lines := make([]string, len(sorted))
+	for i := range sorted {
+		lines[i] = "• " + sorted[i].describe()
+	}
+	return "🗺 Зоны:\n" + strings.Join(lines, "\n")
+}